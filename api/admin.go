@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// purge confirmation tokens issued by the dry-run calls are only valid for this amount of time
+const purgeConfirmationTTL = 5 * time.Minute
+
+const (
+	purgeTargetQueue           = "queue"
+	purgeTargetDeadLetterQueue = "dlq"
+)
+
+type purgeConfirmation struct {
+	Target    string
+	Actor     string
+	ExpiresAt time.Time
+}
+
+type PurgeReq struct {
+	DryRun            bool   `json:"dry_run"`
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+type PurgeRes struct {
+	Target            string      `json:"target"`
+	DryRun            bool        `json:"dry_run"`
+	Count             int         `json:"count"` // number of items would be deleted in dry-run mode and number of deleted items otherwise
+	Details           interface{} `json:"details,omitempty"`
+	ConfirmationToken string      `json:"confirmation_token,omitempty"`
+	ExpiresAt         *time.Time  `json:"expires_at,omitempty"`
+	ChangeVersion     int64       `json:"change_version,omitempty"` // version of the change record which can be used to roll back the purge
+}
+
+/*
+issuePurgeConfirmation generates a single use token which should be provided by the same actor to confirm the purge of the target
+*/
+func (api *ApiServer) issuePurgeConfirmation(target string, actor string) (string, time.Time) {
+	api.adminMu.Lock()
+	defer api.adminMu.Unlock()
+
+	// cleaning up the expired confirmations
+	for token, confirmation := range api.purgeConfirmations {
+		if time.Now().After(confirmation.ExpiresAt) {
+			delete(api.purgeConfirmations, token)
+		}
+	}
+
+	token := uuid.New().String()
+	expiresAt := time.Now().Add(purgeConfirmationTTL)
+	api.purgeConfirmations[token] = purgeConfirmation{
+		Target:    target,
+		Actor:     actor,
+		ExpiresAt: expiresAt,
+	}
+	return token, expiresAt
+}
+
+/*
+consumePurgeConfirmation verifies and invalidates the confirmation token
+*/
+func (api *ApiServer) consumePurgeConfirmation(token string, target string, actor string) bool {
+	api.adminMu.Lock()
+	defer api.adminMu.Unlock()
+
+	confirmation, found := api.purgeConfirmations[token]
+	if !found {
+		return false
+	}
+	delete(api.purgeConfirmations, token)
+	return confirmation.Target == target && confirmation.Actor == actor && time.Now().Before(confirmation.ExpiresAt)
+}
+
+/*
+purgeHandler creates a handler which purges the target only if a valid confirmation token issued by a prior dry-run call is provided.
+preview reports what would be deleted and purge does the actual deletion, both returning the number of items.
+purge returns the deleted items as well which are soft deleted in the change record of the purge to be able to roll it back.
+*/
+func (api *ApiServer) purgeHandler(target string, preview func(ctx context.Context) (int, interface{}), purge func(ctx context.Context) (int, interface{})) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("purgeHandler.Tracer").Start(r.Context(), "purgeHandler.Span")
+		defer span.End()
+		span.SetAttributes(attribute.String("purge.target", target))
+
+		nReq, err := helpers.ReadRequest[PurgeReq](ctx, w, r)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+
+		nVal := helpers.NewValidator()
+		nVal.Check(nReq.DryRun || nReq.ConfirmationToken != "", "confirmation_token", "should be provided, use dry_run to get one")
+		nVal.Check(!(nReq.DryRun && nReq.ConfirmationToken != ""), "dry_run", "shouldn't be used along with confirmation_token")
+		if !nVal.Valid() {
+			span.SetStatus(codes.Error, "invalid input")
+			api.failedValidationResponse(w, r, nVal.Errors)
+			return
+		}
+
+		actor := ""
+		if claims := api.getClaimsContext(r); claims != nil {
+			actor = claims.Subject
+		}
+
+		nRes := &PurgeRes{Target: target, DryRun: nReq.DryRun}
+		if nReq.DryRun {
+			nRes.Count, nRes.Details = preview(ctx)
+			token, expiresAt := api.issuePurgeConfirmation(target, actor)
+			nRes.ConfirmationToken = token
+			nRes.ExpiresAt = &expiresAt
+			api.auditLog(r, actor, target+".purge.dry_run").Int("count", nRes.Count).Send()
+		} else {
+			if !api.consumePurgeConfirmation(nReq.ConfirmationToken, target, actor) {
+				err := errors.New("invalid or expired confirmation token")
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "invalid confirmation token")
+				api.failedValidationResponse(w, r, map[string]string{"confirmation_token": "invalid or expired"})
+				return
+			}
+			var purged interface{}
+			nRes.Count, purged = purge(ctx)
+			nRes.ChangeVersion = api.recordChange(ctx, r, target, "purge", actor, nRes.Count, purged)
+			api.auditLog(r, actor, target+".purge").Int("count", nRes.Count).Int64("change_version", nRes.ChangeVersion).Send()
+		}
+
+		err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to write the response for the client")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+}
+
+func (api *ApiServer) purgeEventQueueHandler() http.HandlerFunc {
+	return api.purgeHandler(purgeTargetQueue,
+		func(ctx context.Context) (int, interface{}) {
+			return api.models.EventQueue.Size(ctx) + api.models.EventQueue.Scheduled(), nil
+		},
+		func(ctx context.Context) (int, interface{}) {
+			purged := api.models.EventQueue.Purge(ctx)
+			snapshots := make([]*data.EventSnapshot, 0, len(purged))
+			for _, event := range purged {
+				api.models.EventQueue.NotifyProcessed(&data.ProcessedEvent{
+					Event:  event,
+					Status: data.EventProcessStatusSkipped,
+					Err:    errors.New("event is purged from the queue"),
+				})
+				snapshot, err := data.NewEventSnapshot(event)
+				if err != nil {
+					api.Logger.Error().Err(err).Str("event_id", event.GetEventID()).Msg("failed to take a snapshot of the purged event, it can't be restored")
+					continue
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+			return len(purged), snapshots
+		})
+}
+
+func (api *ApiServer) purgeDeadLetterQueueHandler() http.HandlerFunc {
+	return api.purgeHandler(purgeTargetDeadLetterQueue,
+		func(ctx context.Context) (int, interface{}) {
+			stats := api.models.DeadLetterQueue.Stats(ctx)
+			return stats.Total, stats
+		},
+		func(ctx context.Context) (int, interface{}) {
+			purged := api.models.DeadLetterQueue.Purge(ctx)
+			snapshots := make([]*data.DeadLetterSnapshot, 0, len(purged))
+			for _, letter := range purged {
+				snapshot, err := data.NewDeadLetterSnapshot(letter)
+				if err != nil {
+					api.Logger.Error().Err(err).Str("event_id", letter.Event.GetEventID()).Msg("failed to take a snapshot of the purged dead letter, it can't be restored")
+					continue
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+			return len(purged), snapshots
+		})
+}
+
+type WorkerStateRes struct {
+	Paused    bool       `json:"paused"`
+	PausedAt  *time.Time `json:"paused_at,omitempty"`
+	Changed   bool       `json:"changed"` // false if the worker was already in the requested state
+	QueueSize int        `json:"queue_size"`
+	InFlight  int64      `json:"in_flight"`
+}
+
+/*
+workerStateHandler creates a handler which pauses or resumes the worker. change reports whether the state of the worker is changed,
+so repeating the request is harmless.
+*/
+func (api *ApiServer) workerStateHandler(action string, change func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("workerStateHandler.Tracer").Start(r.Context(), "workerStateHandler.Span")
+		defer span.End()
+		span.SetAttributes(attribute.String("worker.action", action))
+
+		actor := ""
+		if claims := api.getClaimsContext(r); claims != nil {
+			actor = claims.Subject
+		}
+
+		nRes := &WorkerStateRes{Changed: change()}
+		if pausedAt := api.worker.PausedAt(); !pausedAt.IsZero() {
+			nRes.Paused, nRes.PausedAt = true, &pausedAt
+		}
+		nRes.QueueSize = api.models.EventQueue.Size(ctx)
+		nRes.InFlight = api.worker.InFlight()
+		if nRes.Changed {
+			api.auditLog(r, actor, "worker."+action).Int("queue_size", nRes.QueueSize).Int64("in_flight", nRes.InFlight).Send()
+		}
+
+		err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to write the response for the client")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+}
+
+// target of the change records of the worker throttling
+const changeTargetWorkerThrottle = "worker_throttle"
+
+type WorkerInFlightRes struct {
+	Count    int                    `json:"count"`
+	InFlight []worker.InFlightEvent `json:"in_flight"` // from the longest running event
+}
+
+/*
+getWorkerInFlightHandler lists the events currently being processed by the worker, so the operators can see what a slow worker is stuck on
+*/
+func (api *ApiServer) getWorkerInFlightHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getWorkerInFlightHandler.Tracer").Start(r.Context(), "getWorkerInFlightHandler.Span")
+	defer span.End()
+
+	events := api.worker.InFlightEvents()
+	span.SetAttributes(attribute.Int("worker.in_flight", len(events)))
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": &WorkerInFlightRes{Count: len(events), InFlight: events}}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type WorkerThrottleReq struct {
+	MaxEventsPerSecond float64 `json:"max_events_per_second"` // zero disables the throttling
+	Burst              int     `json:"burst"`                 // defaults to 1
+}
+
+type WorkerThrottleRes struct {
+	Throttle      worker.ThrottleConfig  `json:"throttle"`
+	Previous      *worker.ThrottleConfig `json:"previous,omitempty"`
+	ChangeVersion int64                  `json:"change_version,omitempty"` // version of the change record which can be used to roll back the change
+}
+
+/*
+getWorkerThrottleHandler returns the current limit of the events processed by the worker per second
+*/
+func (api *ApiServer) getWorkerThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getWorkerThrottleHandler.Tracer").Start(r.Context(), "getWorkerThrottleHandler.Span")
+	defer span.End()
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": &WorkerThrottleRes{Throttle: api.worker.Throttle()}}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+setWorkerThrottleHandler changes the limit of the events processed by the worker per second without restarting it,
+e.g. to slow the processing down while the sink is under pressure. The change is recorded, so it can be rolled back.
+*/
+func (api *ApiServer) setWorkerThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("setWorkerThrottleHandler.Tracer").Start(r.Context(), "setWorkerThrottleHandler.Span")
+	defer span.End()
+
+	nReq, err := helpers.ReadRequest[WorkerThrottleReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	nVal.Check(nReq.MaxEventsPerSecond >= 0, "max_events_per_second", "shouldn't be negative")
+	nVal.Check(nReq.Burst >= 0, "burst", "shouldn't be negative")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+	span.SetAttributes(attribute.Float64("throttle.max_events_per_second", nReq.MaxEventsPerSecond), attribute.Int("throttle.burst", nReq.Burst))
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+
+	previous := api.worker.SetThrottle(worker.ThrottleConfig{MaxEventsPerSecond: nReq.MaxEventsPerSecond, Burst: nReq.Burst})
+	nRes := &WorkerThrottleRes{Throttle: api.worker.Throttle(), Previous: &previous}
+	nRes.ChangeVersion = api.recordChange(ctx, r, changeTargetWorkerThrottle, "update", actor, 1, previous)
+	api.auditLog(r, actor, "worker.throttle").
+		Float64("max_events_per_second", nRes.Throttle.MaxEventsPerSecond).
+		Int("burst", nRes.Throttle.Burst).
+		Int64("change_version", nRes.ChangeVersion).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
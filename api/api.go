@@ -5,11 +5,14 @@ import (
 	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	helpers "github.com/cybrarymin/behavox/internal"
 	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -28,11 +31,14 @@ type ApiServerCfg struct {
 		GlobalRateLimit    int64
 		perClientRateLimit int64
 		Enabled            bool
+		DryRun             bool // only record the would-be rejections without blocking the requests
 	}
+	Middlewares []string // ordered list of cross-cutting http middlewares, the first one is the outermost
 }
 
-func NewApiServerCfg(listenAddr *url.URL, tlsCertFile string, tlsKeyFile string, rateLimitEnabled bool, globalRateLimit int64, perCleintRateLimit int64, srvReadTimeout, srvIdleTimeout, srvWriteTimeout time.Duration) *ApiServerCfg {
+func NewApiServerCfg(listenAddr *url.URL, tlsCertFile string, tlsKeyFile string, rateLimitEnabled bool, rateLimitDryRun bool, globalRateLimit int64, perCleintRateLimit int64, srvReadTimeout, srvIdleTimeout, srvWriteTimeout time.Duration, middlewares []string) *ApiServerCfg {
 	return &ApiServerCfg{
+		Middlewares:        middlewares,
 		ListenAddr:         listenAddr,
 		ServerReadTimeout:  srvReadTimeout,
 		ServerWriteTimeout: srvWriteTimeout,
@@ -43,9 +49,11 @@ func NewApiServerCfg(listenAddr *url.URL, tlsCertFile string, tlsKeyFile string,
 			GlobalRateLimit    int64
 			perClientRateLimit int64
 			Enabled            bool
+			DryRun             bool
 		}{
 			GlobalRateLimit:    globalRateLimit,
 			Enabled:            rateLimitEnabled,
+			DryRun:             rateLimitDryRun,
 			perClientRateLimit: perCleintRateLimit,
 		},
 	}
@@ -59,21 +67,71 @@ func (cfg *ApiServerCfg) validation(nVal helpers.Validator) *helpers.Validator {
 		_, err = os.Stat(cfg.TlsKeyFile)
 		nVal.Check(err == nil, "tls-key", fmt.Sprintf("%s doesn't exists", cfg.TlsKeyFile))
 	}
+	for _, middleware := range cfg.Middlewares {
+		nVal.Check(helpers.In(middleware, validMiddlewares...), "middlewares", fmt.Sprintf("unknown middleware %s", middleware))
+	}
+	nVal.Check(helpers.Unique(cfg.Middlewares), "middlewares", "shouldn't contain duplicate values")
+	nVal.Check(!cfg.RateLimit.Enabled || helpers.In(MiddlewareRateLimit, cfg.Middlewares...), "enable-rate-limit", fmt.Sprintf("requires the %s middleware in middlewares", MiddlewareRateLimit))
 	return &nVal
 }
 
+/*
+activeMiddlewares returns the middlewares wrapping the routes, the ratelimit middleware passes the requests through unless the rate limiting is enabled
+*/
+func (cfg *ApiServerCfg) activeMiddlewares() []string {
+	active := make([]string, 0, len(cfg.Middlewares))
+	for _, middleware := range cfg.Middlewares {
+		if middleware == MiddlewareRateLimit && !cfg.RateLimit.Enabled {
+			continue
+		}
+		active = append(active, middleware)
+	}
+	return active
+}
+
+/*
+rateLimited reports whether the requests are rate limited, which requires both the rate limiting and the ratelimit middleware to be enabled
+*/
+func (cfg *ApiServerCfg) rateLimited() bool {
+	return helpers.In(MiddlewareRateLimit, cfg.activeMiddlewares()...)
+}
+
 type ApiServer struct {
-	Cfg    *ApiServerCfg
-	Logger *zerolog.Logger
-	Wg     sync.WaitGroup
-	mu     sync.RWMutex
-	models *data.Models
+	Cfg                *ApiServerCfg
+	Logger             *zerolog.Logger
+	auditLogger        *zerolog.Logger
+	Wg                 sync.WaitGroup
+	mu                 sync.RWMutex
+	models             *data.Models
+	worker             *worker.Worker
+	adminMu            sync.Mutex
+	purgeConfirmations map[string]purgeConfirmation
+	unready            atomic.Bool // set when the shutdown begins to fail the readiness checks
+	draining           atomic.Bool // set after the drain grace period to stop accepting new events
+	// start time and number of the pending events of the drain started by the admins, guarded by adminMu
+	drainStartedAt      time.Time
+	drainInitialPending int64
+	refreshTokens       *data.RefreshTokenStore // used refresh tokens and the revoked families
+	oidc                *oidcVerifier           // nil if the oidc tokens aren't accepted
+	hmac                *hmacVerifier           // nil if the hmac signed requests aren't accepted
+	ldap                *ldapAuthenticator      // nil if only the static admin credentials are accepted
+	ipFilter            *ipFilter               // nil if the clients aren't filtered by their network
+	signer              *jwtSigner              // nil if the access tokens are signed by the jwkey
+	lockout             *authLockout            // nil if the failed basic authentications aren't locked out
+	grpc                *grpc.Server            // nil if the grpc listener isn't enabled
 }
 
-func NewApiServer(cfg *ApiServerCfg, logger *zerolog.Logger, models *data.Models) *ApiServer {
+func NewApiServer(cfg *ApiServerCfg, logger *zerolog.Logger, models *data.Models, nWorker *worker.Worker) *ApiServer {
 	return &ApiServer{
-		Cfg:    cfg,
-		Logger: logger,
-		models: models,
+		Cfg:                cfg,
+		Logger:             logger,
+		auditLogger:        logger,
+		models:             models,
+		worker:             nWorker,
+		purgeConfirmations: make(map[string]purgeConfirmation),
+		oidc:               newOIDCVerifier(CmdOIDCIssuer, CmdOIDCJWKSURL, CmdOIDCAudience, CmdOIDCAdminGroup),
+		hmac:               newHMACVerifier(CmdHMACKeys, CmdHMACReplayWindow),
+		ipFilter:           newIPFilter(CmdIPAllow, CmdIPDeny, CmdIPFilterPaths),
+		lockout:            newAuthLockout(),
 	}
 }
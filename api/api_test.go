@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+)
+
+func TestRateLimitMiddlewareValidation(t *testing.T) {
+	listenAddr := &url.URL{Scheme: "http", Host: "127.0.0.1:8080"}
+	tests := []struct {
+		name           string
+		enabled        bool
+		middlewares    []string
+		wantErr        bool
+		wantLimited    bool
+		wantMiddleware []string
+	}{
+		{name: "rate limiting enabled", enabled: true, middlewares: []string{MiddlewareCORS, MiddlewareRateLimit},
+			wantLimited: true, wantMiddleware: []string{MiddlewareCORS, MiddlewareRateLimit}},
+		{name: "rate limiting without the middleware", enabled: true, middlewares: []string{MiddlewareCORS}, wantErr: true},
+		{name: "middleware without rate limiting", middlewares: []string{MiddlewareCORS, MiddlewareRateLimit, MiddlewarePrometheus},
+			wantMiddleware: []string{MiddlewareCORS, MiddlewarePrometheus}},
+		{name: "neither of them", middlewares: []string{MiddlewareTracing}, wantMiddleware: []string{MiddlewareTracing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewApiServerCfg(listenAddr, "", "", tt.enabled, false, 25, 2, time.Second, time.Second, time.Second, tt.middlewares)
+			nVal := cfg.validation(*helpers.NewValidator())
+			if valid := nVal.Valid(); valid == tt.wantErr {
+				t.Fatalf("validation errors = %v, want error %v", nVal.Errors, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := cfg.rateLimited(); got != tt.wantLimited {
+				t.Errorf("rateLimited() = %v, want %v", got, tt.wantLimited)
+			}
+			if got := cfg.activeMiddlewares(); !slices.Equal(got, tt.wantMiddleware) {
+				t.Errorf("activeMiddlewares() = %v, want %v", got, tt.wantMiddleware)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+var (
+	CmdAuditLogFile string
+)
+
+/*
+newAuditLogger returns the logger of the audit records. They're appended to the audit log file if it's configured and written to the server logs otherwise.
+Audit records are kept regardless of --log-level.
+*/
+func newAuditLogger(logger zerolog.Logger) (*zerolog.Logger, error) {
+	if CmdAuditLogFile == "" {
+		auditLogger := logger.Level(zerolog.InfoLevel)
+		return &auditLogger, nil
+	}
+	file, err := os.OpenFile(CmdAuditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	auditLogger := zerolog.New(file).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+	return &auditLogger, nil
+}
+
+/*
+clientIP returns the ip address of the client without its port
+*/
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+claimedSubject returns the subject of the token without verifying it, so the rejected tokens can be attributed in the audit records
+*/
+func claimedSubject(token string) string {
+	claims := &jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+/*
+auditLog returns a log event for recording the sensitive actions along with the actor and request information
+*/
+func (api *ApiServer) auditLog(r *http.Request, actor string, action string) *zerolog.Event {
+	return api.auditLogger.Info().
+		Str("log_type", "audit").
+		Str("request_id", api.getReqIDContext(r)).
+		Str("remote_addr", r.RemoteAddr).
+		Str("client_ip", clientIP(r)).
+		Str("actor", actor).
+		Str("action", action)
+}
+
+/*
+auditAuthFailure records the rejected credentials, actor is the claimed identity if it's known, e.g. the username of the basic authentication
+*/
+func (api *ApiServer) auditAuthFailure(r *http.Request, actor string, action string, reason error) {
+	api.auditLog(r, actor, action).
+		Str("outcome", "failure").
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Str("reason", reason.Error()).
+		Send()
+}
@@ -1,9 +1,11 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	helpers "github.com/cybrarymin/behavox/internal"
@@ -12,16 +14,57 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	CmdJwtKey       string
+	CmdJwtTTL       time.Duration
+	CmdJwtIssuer    string
+	CmdJwtAudience  string
 	CmdApiAdmin     string
 	CmdApiAdminPass string
 )
 
+// defaults of the secrets, they're only meant for the local development and rejected in the production mode
+const (
+	DefaultJwtKey       = "defaultJWTToken"
+	DefaultApiAdminPass = "behavox-pass"
+)
+
+/*
+jwtParserOptions are the options of verifying the locally signed tokens, the issuer and audience of the token should be the configured ones
+*/
+func jwtParserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(CmdJwtIssuer),
+		jwt.WithAudience(CmdJwtAudience),
+	}
+}
+
+/*
+TokenCreateReq is the scope of the issued tokens, the tokens are only granted the default scopes if no scope is requested
+*/
+type TokenCreateReq struct {
+	Scope string `json:"scope"` // space delimited scopes, e.g. "events:write events:read"
+}
+
+/*
+TokenCreateRes is the access token along with the refresh token used to get the next access token without the basic authentication credentials
+*/
+type TokenCreateRes struct {
+	Token                 string    `json:"token"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+	Scope                 string    `json:"scope,omitempty"`
+}
+
 type customClaims struct {
-	Email string `json:"email"`
+	Email string      `json:"email"`
+	Scope string      `json:"scope,omitempty"` // space delimited scopes granted to the token, only the default scopes are granted if empty
+	Act   *actorClaim `json:"act,omitempty"`   // set on the tokens issued by the token exchange, identifies the delegation chain
 	jwt.RegisteredClaims
 }
 
@@ -37,7 +80,7 @@ func (c *customClaims) Validate() error {
 }
 
 /*
-Authenticating user using basic authentication method. If user is valid it's gonna issue a JWT Token to the user
+Authenticating user using basic authentication method. If user is valid it's gonna issue a JWT Token to the user along with a refresh token
 */
 func (api *ApiServer) createJWTTokenHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("createJWTToken.handler.tracer").Start(r.Context(), "createJWTToken.handler.span")
@@ -47,15 +90,70 @@ func (api *ApiServer) createJWTTokenHandler(w http.ResponseWriter, r *http.Reque
 	if !ok {
 		return
 	}
+	// body is optional for issuing the tokens of the default scopes
+	var nReq TokenCreateReq
+	if r.ContentLength != 0 {
+		var err error
+		nReq, err = helpers.ReadRequest[TokenCreateReq](ctx, w, r)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	scopes := strings.Fields(nReq.Scope)
+	if len(scopes) == 0 {
+		scopes = defaultTokenScopes
+	}
+	allowed := api.allowedScopes(ctx, nUser)
+	nVal := helpers.NewValidator()
+	for _, scope := range scopes {
+		nVal.Check(helpers.In(scope, tokenScopes...), "scope", fmt.Sprintf("unknown scope %s, should be one of %s", scope, strings.Join(tokenScopes, ", ")))
+		nVal.Check(!helpers.In(scope, tokenScopes...) || helpers.In(scope, allowed...), "scope", fmt.Sprintf("scope %s isn't allowed for the user", scope))
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	// each login starts a new family of the refresh tokens
+	family := uuid.New().String()
+	nRes, err := api.issueTokens(ctx, nUser, family, strings.Join(scopes, " "))
+	if err != nil {
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, nUser, "token.issue").
+		Str("outcome", "success").
+		Str("family", family).
+		Str("scope", nRes.Scope).
+		Time("expires_at", nRes.ExpiresAt).
+		Send()
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+issueTokens signs the access token of the user along with a refresh token of the family, both granted the scope
+*/
+func (api *ApiServer) issueTokens(ctx context.Context, nUser string, family string, scope string) (*TokenCreateRes, error) {
+	span := trace.SpanFromContext(ctx)
+	now := time.Now()
 	claims := customClaims{
 		Email: nUser + "@behavox.com",
+		Scope: scope,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "behavox.example.com",
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 3)),
+			Issuer:    CmdJwtIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(CmdJwtTTL)),
 			Subject:   nUser,
-			Audience:  []string{"behavox.example.com"},
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			Audience:  []string{CmdJwtAudience},
+			NotBefore: jwt.NewNumericDate(now),
 			ID:        uuid.New().String(),
 		},
 	}
@@ -64,35 +162,51 @@ func (api *ApiServer) createJWTTokenHandler(w http.ResponseWriter, r *http.Reque
 	span.SetAttributes(attribute.String("claims.subject", claims.Subject))
 	span.SetAttributes(attribute.StringSlice("claims.audience", claims.Audience))
 	span.SetAttributes(attribute.String("claims.id", claims.ID))
+	span.SetAttributes(attribute.String("claims.scope", claims.Scope))
 
-	jToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims, func(t *jwt.Token) {})
-
-	signedToken, err := jToken.SignedString([]byte(CmdJwtKey))
+	signedToken, err := api.signAccessToken(claims)
 	if err != nil {
-		api.serverErrorResponse(w, r, err)
-		return
+		return nil, err
 	}
-	err = helpers.WriteJson(ctx, w, http.StatusOK, helpers.Envelope{"result": map[string]string{"token": signedToken}}, nil)
+	refreshToken, refreshClaims, err := signRefreshToken(nUser, family, scope, api.refreshTokens.Generation(), now)
 	if err != nil {
-		api.serverErrorResponse(w, r, err)
-		return
+		return nil, err
 	}
+	span.SetAttributes(attribute.String("refresh_token.family", family))
+	return &TokenCreateRes{
+		Token:                 signedToken,
+		ExpiresAt:             claims.ExpiresAt.Time,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshClaims.ExpiresAt.Time,
+		Scope:                 scope,
+	}, nil
 }
 
 /*
-Authenticates the user using basic authentication method.
+Authenticates the user using basic authentication method against the static admin credentials and the ldap server if it's configured.
 in case of successfull authentication it returns ok plus userinfo
 */
 func (api *ApiServer) BasicAuth(w http.ResponseWriter, r *http.Request) (bool, string) {
-	_, span := otel.Tracer("basicAuth.handler.Tracer").Start(r.Context(), "basicAuth.handler.Span")
+	ctx, span := otel.Tracer("basicAuth.handler.Tracer").Start(r.Context(), "basicAuth.handler.Span")
 	defer span.End()
 
 	user, pass, ok := r.BasicAuth()
 	if !ok {
 		span.SetStatus(codes.Error, "failed authentication")
+		api.auditAuthFailure(r, "", "auth.basic", errors.New("missing basic authentication credentials"))
 		api.authenticationRequiredResposne(w, r)
 		return false, ""
 	}
+	ip := clientIP(r)
+	if api.lockout != nil {
+		retryAfter := api.lockout.lockedFor(user, ip, time.Now())
+		if retryAfter > 0 {
+			span.SetStatus(codes.Error, "failed authentication due to the lockout")
+			api.auditAuthFailure(r, user, "auth.basic", errors.New("locked out after too many failed attempts"))
+			api.authLockedOutResponse(w, r, retryAfter)
+			return false, ""
+		}
+	}
 	nVal := helpers.NewValidator()
 	nVal.Check(user != "", "name", "must be provided")
 	nVal.Check(len(user) <= 500, "name", "must not be more than 500 bytes long")
@@ -105,15 +219,34 @@ func (api *ApiServer) BasicAuth(w http.ResponseWriter, r *http.Request) (bool, s
 			span.RecordError(fmt.Errorf("%s : %s", k, v))
 		}
 		span.SetStatus(codes.Error, "failed authentication")
+		api.auditAuthFailure(r, user, "auth.basic", errors.New("malformed basic authentication credentials"))
+		api.failAuthentication(r, user, ip)
 		api.invalidAuthenticationCredResponse(w, r)
 		return false, ""
 	}
 
-	if user != CmdApiAdmin || pass != CmdApiAdminPass {
-		span.SetStatus(codes.Error, "failed authentication due to invalid username or password")
-		api.invalidAuthenticationCredResponse(w, r)
-		return false, ""
+	if user == CmdApiAdmin && pass == CmdApiAdminPass {
+		api.succeedAuthentication(user, ip)
+		return true, user
+	}
+	err := errors.New("invalid username or password")
+	// the users of the directory become the subjects of the tokens, so they should be valid identities as well.
+	// the directory users can't take over the name of the static admin, since its tokens are entitled to the admin scope
+	if api.ldap != nil && user != CmdApiAdmin && helpers.EmailRX.MatchString(user+"@behavox.com") {
+		_, err = api.ldap.authenticate(ctx, user, pass)
+		if err == nil {
+			api.succeedAuthentication(user, ip)
+			return true, user
+		}
+		span.RecordError(err)
+		if !errors.Is(err, ErrLdapInvalidCredentials) && !errors.Is(err, ErrLdapUserNotFound) {
+			api.Logger.Error().Err(err).Str("user", user).Msg("failed to authenticate the user against the ldap server")
+		}
 	}
 
-	return true, user
+	span.SetStatus(codes.Error, "failed authentication due to invalid username or password")
+	api.auditAuthFailure(r, user, "auth.basic", err)
+	api.failAuthentication(r, user, ip)
+	api.invalidAuthenticationCredResponse(w, r)
+	return false, ""
 }
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+var (
+	CmdEventQueueHighWaterMark float64
+	CmdBackpressureRetryAfter  time.Duration
+)
+
+/*
+backpressured reports whether the new events should be rejected, since the worker is paused and the queue is filled up to the high water mark.
+The room left above the mark is kept for the events restored into the queue, e.g. the replayed dead letters and the nacked leases.
+*/
+func (api *ApiServer) backpressured(ctx context.Context) bool {
+	if CmdEventQueueHighWaterMark <= 0 || api.worker.PausedAt().IsZero() {
+		return false
+	}
+	eq := api.models.EventQueue
+	highWater := int(math.Ceil(float64(eq.Capacity) * CmdEventQueueHighWaterMark / 100))
+	return eq.Size(ctx)+eq.Scheduled() >= highWater
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/cybrarymin/behavox/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+/*
+CallbackSecretRes is the secret verifying the signatures of the callbacks posted for the events of the producer
+*/
+type CallbackSecretRes struct {
+	Producer string `json:"producer"`
+	Secret   string `json:"secret"`
+}
+
+/*
+getCallbackSecretHandler reports the callback secret of the producer authenticated by the token.
+Secrets are derived per producer, so a producer can only verify its own callbacks.
+*/
+func (api *ApiServer) getCallbackSecretHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getCallbackSecretHandler.Tracer").Start(r.Context(), "getCallbackSecretHandler.Span")
+	defer span.End()
+
+	if worker.CmdCallbackSecret == "" {
+		span.SetStatus(codes.Error, "callbacks are disabled")
+		api.callbacksDisabledResponse(w, r)
+		return
+	}
+	producer := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		producer = claims.Subject
+	}
+	nRes := &CallbackSecretRes{Producer: producer, Secret: worker.CallbackSecret(producer)}
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cybrarymin/behavox/generator"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type EventTypeCapability struct {
+	Name          string   `json:"name"`
+	SchemaVersion int      `json:"schema_version"`
+	Fields        []string `json:"fields"`
+	MaxBodyBytes  int64    `json:"max_body_bytes,omitempty"` // only set if the event type has its own limit
+}
+
+type SinkCapability struct {
+	Type   string `json:"type"`
+	Format string `json:"format,omitempty"`
+}
+
+type CapabilitiesLimits struct {
+	MaxBodyBytes        int64  `json:"max_body_bytes"`
+	EventBatchMaxSize   int    `json:"event_batch_max_size"`
+	EventBulkMaxBytes   int64  `json:"event_bulk_max_bytes"`
+	EventImportMaxBytes int64  `json:"event_import_max_bytes"`
+	EventQueueCapacity  int64  `json:"event_queue_capacity"`
+	DeadLetterQueueSize int64  `json:"dead_letter_queue_size"`
+	EventPriorityMin    int    `json:"event_priority_min"`
+	EventPriorityMax    int    `json:"event_priority_max"`
+	EventDedupWindow    string `json:"event_dedup_window"`
+	EventMaxDelay       string `json:"event_max_delay"`
+	EventIndexSize      int    `json:"event_index_size"`
+	GlobalRateLimit     int64  `json:"global_rate_limit,omitempty"` // only set if rate limiting is enabled
+	PerClientRateLimit  int64  `json:"per_client_rate_limit,omitempty"`
+}
+
+/*
+CapabilitiesRes lists the features enabled on the server so the clients can adapt their behavior without out-of-band coordination
+*/
+type CapabilitiesRes struct {
+	Version     string                `json:"version"`
+	APIVersions []string              `json:"api_versions"` // versions serving the event routes
+	ConfigHash  string                `json:"config_hash"`  // hash of the effective configuration, also exposed by the application_config_info metric
+	Features    map[string]bool       `json:"features"`
+	EventTypes  []EventTypeCapability `json:"event_types"`
+	Formats     []string              `json:"formats"`
+	AckModes    []string              `json:"ack_modes"`
+	AuthModes   []string              `json:"auth_modes"`
+	Scopes      []string              `json:"scopes"` // scopes which can be requested for the tokens
+	Backends    map[string]string     `json:"backends"`
+	Sinks       []SinkCapability      `json:"sinks"`
+	Middlewares []string              `json:"middlewares"` // middlewares wrapping the routes, the first one is the outermost
+	Limits      CapabilitiesLimits    `json:"limits"`
+	Resources   *ResourceTuning       `json:"resources,omitempty"` // only set if the resource auto tuning is enabled
+}
+
+/*
+authModes lists the accepted authentication methods
+*/
+func authModes() []string {
+	modes := []string{"basic", "jwt"}
+	if CmdOIDCIssuer != "" {
+		modes = append(modes, "oidc")
+	}
+	if CmdLdapURL != "" {
+		modes = append(modes, "ldap")
+	}
+	if len(CmdHMACKeys) > 0 {
+		modes = append(modes, "hmac")
+	}
+	return modes
+}
+
+/*
+sinkCapabilities lists the configured sinks of the process results, the http, database and sqlite sinks always write json and the s3 sink may have a format of its own
+*/
+func sinkCapabilities() []SinkCapability {
+	sinks := make([]SinkCapability, 0, len(worker.CmdSinks))
+	for _, sink := range worker.CmdSinks {
+		format := worker.CmdProcessedEventFormat
+		if sink == worker.SinkHTTP || sink == worker.SinkDatabase || sink == worker.SinkSQLite {
+			format = worker.OutputFormatJson
+		}
+		if sink == worker.SinkS3 && worker.CmdSinkS3Format != "" {
+			format = worker.CmdSinkS3Format
+		}
+		sinks = append(sinks, SinkCapability{Type: sink, Format: format})
+	}
+	return sinks
+}
+
+/*
+capabilities collects the capabilities of the server out of its configuration
+*/
+func (api *ApiServer) capabilities() *CapabilitiesRes {
+	nRes := &CapabilitiesRes{
+		Version:     Version,
+		APIVersions: apiVersions,
+		ConfigHash:  effectiveConfigHash,
+		Features: map[string]bool{
+			"batch":              CmdEventBatchMaxSize > 0,
+			"bulk":               true,
+			"cancellation":       true,
+			"checkpointing":      worker.CmdWorkerCheckpointFile != "",
+			"idempotency":        worker.CmdWorkerIdempotencySize > 0,
+			"circuit_breaker":    worker.CmdCircuitBreakerFailureRate > 0,
+			"poison_detection":   worker.CmdEventPoisonThreshold > 0,
+			"worker_throttle":    true,
+			"cloudevents":        true,
+			"deduplication":      data.CmdEventDedupWindow > 0 && data.CmdEventDedupSize > 0,
+			"delayed_delivery":   data.CmdEventMaxDelay > 0,
+			"event_listing":      api.models.EventQueue.Index != nil,
+			"generators":         len(generator.CmdGeneratorRates) > 0,
+			"graphql":            true,
+			"import":             true,
+			"lifetime_stats":     data.CmdLifetimeCountersFile != "",
+			"pull":               true,
+			"schema_inference":   true,
+			"schedules":          data.CmdSchedulesMaxLen > 0,
+			"scopes":             true,
+			"ip_filter":          api.ipFilter != nil,
+			"jwks":               api.signer != nil,
+			"auth_lockout":       api.lockout != nil,
+			"admin_socket":       CmdAdminSocket != "",
+			"debug_endpoints":    CmdDebugEndpoints,
+			"grpc_ingest":        CmdGRPCListenAddr != "",
+			"openlineage":        worker.CmdOpenLineageURL != "",
+			"worker_autoscale":   worker.CmdWorkerAutoscale,
+			"rate_limit":         api.Cfg.rateLimited(),
+			"token_exchange":     len(CmdTokenExchangeTrustedActors) > 0,
+			"webhooks":           data.CmdWebhookMaxSubscriptions > 0,
+			"callbacks":          worker.CmdCallbackSecret != "",
+			"results_encryption": worker.CmdOutputEncryptionKeyFile != "" || worker.CmdOutputKMSCiphertextFile != "",
+			"read_only":          CmdReadOnly,
+			"refresh_tokens":     true,
+		},
+		Formats:   append(helpers.ContentTypes(), cloudEventsStructuredContentType),
+		AckModes:  []string{ackModeEnqueue, ackModeProcessed},
+		AuthModes: authModes(),
+		Scopes:    tokenScopes,
+		Backends: map[string]string{
+			"event_queue":       "memory",
+			"dead_letter_queue": "memory",
+			"queue_compression": data.CmdEventQueueCompression,
+		},
+		Sinks:       sinkCapabilities(),
+		Middlewares: api.Cfg.activeMiddlewares(),
+		Resources:   resourceTuning,
+		Limits: CapabilitiesLimits{
+			MaxBodyBytes:        helpers.CmdMaxBodyBytes,
+			EventBatchMaxSize:   CmdEventBatchMaxSize,
+			EventBulkMaxBytes:   CmdEventBulkMaxBytes,
+			EventImportMaxBytes: CmdEventImportMaxBytes,
+			EventQueueCapacity:  api.models.EventQueue.Capacity,
+			DeadLetterQueueSize: data.CmdDeadLetterQueueSize,
+			EventPriorityMin:    data.EventPriorityMin,
+			EventPriorityMax:    data.EventPriorityMax,
+			EventDedupWindow:    data.CmdEventDedupWindow.String(),
+			EventMaxDelay:       data.CmdEventMaxDelay.String(),
+			EventIndexSize:      data.CmdEventIndexSize,
+		},
+	}
+	if worker.CmdOpenLineageURL != "" {
+		nRes.Sinks = append(nRes.Sinks, SinkCapability{Type: "openlineage"})
+	}
+	if api.Cfg.rateLimited() {
+		nRes.Limits.GlobalRateLimit = api.Cfg.RateLimit.GlobalRateLimit
+		nRes.Limits.PerClientRateLimit = api.Cfg.RateLimit.perClientRateLimit
+	}
+	for _, name := range data.EventTypes() {
+		spec, _ := data.LookupEventType(name)
+		nRes.EventTypes = append(nRes.EventTypes, EventTypeCapability{
+			Name:          spec.Name,
+			SchemaVersion: spec.SchemaVersion,
+			Fields:        spec.Fields,
+			MaxBodyBytes:  spec.BodyLimit(),
+		})
+	}
+	return nRes
+}
+
+func (api *ApiServer) getCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getCapabilitiesHandler.Tracer").Start(r.Context(), "getCapabilitiesHandler.Span")
+	defer span.End()
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": api.capabilities()}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
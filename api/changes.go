@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+/*
+recordChange persists the change record of an admin mutation along with the previous value required to roll it back.
+It returns the version of the record or 0 if the record couldn't be persisted, which doesn't fail the already applied mutation.
+*/
+func (api *ApiServer) recordChange(ctx context.Context, r *http.Request, target string, action string, actor string, count int, previous interface{}) int64 {
+	jPrevious, err := json.Marshal(previous)
+	if err != nil {
+		api.Logger.Error().Err(err).Str("target", target).Msg("failed to serialize the previous value of the admin change, it can't be rolled back")
+		jPrevious = nil
+	}
+	record := &data.ChangeRecord{
+		Target:   target,
+		Action:   action,
+		Actor:    actor,
+		Count:    count,
+		Previous: jPrevious,
+	}
+	err = api.models.ChangeLog.Record(ctx, record)
+	if err != nil {
+		api.Logger.Error().Err(err).Str("request_id", api.getReqIDContext(r)).Str("target", target).Msg("failed to record the admin change")
+		return 0
+	}
+	if len(jPrevious) > 0 && record.Previous == nil {
+		api.Logger.Warn().Str("request_id", api.getReqIDContext(r)).Str("target", target).Int64("change_version", record.Version).Int("previous_bytes", len(jPrevious)).
+			Msg("previous value of the admin change is larger than admin-change-log-max-previous-bytes, it can't be rolled back")
+	}
+	return record.Version
+}
+
+type ChangeListRes struct {
+	Changes []data.ChangeRecord `json:"changes"`
+}
+
+/*
+listChangesHandler lists the change records of the admin mutations from the newest to the oldest, optionally filtered by the target query parameter
+*/
+func (api *ApiServer) listChangesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("listChangesHandler.Tracer").Start(r.Context(), "listChangesHandler.Span")
+	defer span.End()
+
+	target := r.URL.Query().Get("target")
+	nRes := &ChangeListRes{Changes: api.models.ChangeLog.List(target)}
+	span.SetAttributes(attribute.String("change.target", target), attribute.Int("change.count", len(nRes.Changes)))
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+changeVersionParam reads the version of the change record from the url
+*/
+func changeVersionParam(r *http.Request) (int64, error) {
+	version, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("version"), 10, 64)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid change version parameter")
+	}
+	return version, nil
+}
+
+/*
+getChangeHandler returns the change record including its previous value
+*/
+func (api *ApiServer) getChangeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getChangeHandler.Tracer").Start(r.Context(), "getChangeHandler.Span")
+	defer span.End()
+
+	version, err := changeVersionParam(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.notFoundResponse(w, r)
+		return
+	}
+	span.SetAttributes(attribute.Int64("change.version", version))
+
+	record, found := api.models.ChangeLog.Get(version)
+	if !found {
+		span.SetStatus(codes.Error, "change record not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": record}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+rollbackChangeHandler restores the previous value of the change and records the rollback as a new change.
+Each change can only be rolled back once and rollbacks themselves can't be rolled back.
+*/
+func (api *ApiServer) rollbackChangeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("rollbackChangeHandler.Tracer").Start(r.Context(), "rollbackChangeHandler.Span")
+	defer span.End()
+
+	version, err := changeVersionParam(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.notFoundResponse(w, r)
+		return
+	}
+	span.SetAttributes(attribute.Int64("change.version", version))
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+
+	// rollbacks are serialized so the same change can't be restored twice by concurrent requests
+	api.adminMu.Lock()
+	defer api.adminMu.Unlock()
+
+	record, found := api.models.ChangeLog.Get(version)
+	if !found {
+		span.SetStatus(codes.Error, "change record not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	nVal.Check(record.Action != data.ChangeActionRollback, "version", "rollback changes can't be rolled back")
+	nVal.Check(record.RolledBackBy == 0, "version", fmt.Sprintf("is already rolled back by change %d", record.RolledBackBy))
+	nVal.Check(len(record.Previous) > 0, "version", "change doesn't have a previous value to restore")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	count, err := api.restoreChange(ctx, record)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restore the previous value of the change")
+		if errors.Is(err, data.ErrEventQueueFull) {
+			api.eventQueueFullResponse(w, r)
+			return
+		}
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rollback := &data.ChangeRecord{
+		Target:     record.Target,
+		Action:     data.ChangeActionRollback,
+		Actor:      actor,
+		Count:      count,
+		RollbackOf: record.Version,
+	}
+	err = api.models.ChangeLog.Record(ctx, rollback)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to record the rollback")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, actor, record.Target+".rollback").
+		Int64("change_version", rollback.Version).
+		Int64("rollback_of", record.Version).
+		Int("count", count).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": rollback}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+restoreChange puts the previous value of the change back in its target and returns the number of restored items
+*/
+func (api *ApiServer) restoreChange(ctx context.Context, record *data.ChangeRecord) (int, error) {
+	switch record.Target {
+	case purgeTargetQueue:
+		var snapshots []*data.EventSnapshot
+		err := json.Unmarshal(record.Previous, &snapshots)
+		if err != nil {
+			return 0, err
+		}
+		events := make([]data.Event, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			event, err := snapshot.Restore()
+			if err != nil {
+				return 0, err
+			}
+			// status of the batch is already finalized when its events are purged
+			event.SetBatchID("")
+			events = append(events, event)
+		}
+		err = api.models.EventQueue.Restore(ctx, events)
+		if err != nil {
+			return 0, err
+		}
+		return len(events), nil
+	case purgeTargetDeadLetterQueue:
+		var snapshots []*data.DeadLetterSnapshot
+		err := json.Unmarshal(record.Previous, &snapshots)
+		if err != nil {
+			return 0, err
+		}
+		letters := make([]*data.DeadLetter, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			letter, err := snapshot.Restore()
+			if err != nil {
+				return 0, err
+			}
+			letters = append(letters, letter)
+		}
+		return api.models.DeadLetterQueue.Restore(ctx, letters), nil
+	case changeTargetWorkerThrottle:
+		var previous worker.ThrottleConfig
+		err := json.Unmarshal(record.Previous, &previous)
+		if err != nil {
+			return 0, err
+		}
+		api.worker.SetThrottle(previous)
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("rolling back the changes of %s isn't supported", record.Target)
+	}
+}
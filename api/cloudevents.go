@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	cloudEventsSpecVersion           = "1.0"
+	cloudEventsStructuredContentType = "application/cloudevents+json"
+	cloudEventsHeaderPrefix          = "Ce-"
+)
+
+/*
+CloudEvent holds the cloudevents context attributes we map into the events along with the event data
+*/
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Time            string
+	DataContentType string
+	Data            []byte
+}
+
+/*
+isCloudEvent reports whether the request is a cloudevents request either in structured or binary http content mode
+*/
+func isCloudEvent(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == cloudEventsStructuredContentType || r.Header.Get(cloudEventsHeaderPrefix+"Specversion") != ""
+}
+
+/*
+readCloudEvent reads a cloudevents request and maps it into the EventCreateReq.
+ce-id will be used as the event_id, ce-type as the event_type and data as the type specific fields of the event.
+ce-type could be either the event type itself like "log" or a reverse-dns name ending in the event type like "com.example.log".
+*/
+func (api *ApiServer) readCloudEvent(ctx context.Context, w http.ResponseWriter, r *http.Request) (EventCreateReq, error) {
+	ctx, span := otel.Tracer("readCloudEvent.Tracer").Start(ctx, "readCloudEvent.Span")
+	defer span.End()
+
+	var nReq EventCreateReq
+	var cEvent *CloudEvent
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == cloudEventsStructuredContentType {
+		span.SetAttributes(attribute.String("cloudevents.mode", "structured"))
+		cEvent, err = readStructuredCloudEvent(ctx, w, r)
+	} else {
+		span.SetAttributes(attribute.String("cloudevents.mode", "binary"))
+		cEvent, err = readBinaryCloudEvent(ctx, w, r)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to read the cloudevent")
+		return nReq, err
+	}
+
+	switch {
+	case cEvent.SpecVersion != cloudEventsSpecVersion:
+		err = fmt.Errorf("unsupported cloudevents specversion %s", cEvent.SpecVersion)
+	case cEvent.ID == "":
+		err = errors.New("cloudevents id attribute must be provided")
+	case cEvent.Source == "":
+		err = errors.New("cloudevents source attribute must be provided")
+	case cEvent.Type == "":
+		err = errors.New("cloudevents type attribute must be provided")
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid cloudevent")
+		return nReq, err
+	}
+
+	if cEvent.DataContentType != "" {
+		dataMediaType, _, _ := mime.ParseMediaType(cEvent.DataContentType)
+		if dataMediaType != "application/json" && !strings.HasSuffix(dataMediaType, "+json") {
+			err = fmt.Errorf("unsupported cloudevents datacontenttype %s", cEvent.DataContentType)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid cloudevent")
+			return nReq, err
+		}
+	}
+
+	if cEvent.Time != "" {
+		_, err := time.Parse(time.RFC3339, cEvent.Time)
+		if err != nil {
+			err = errors.New("cloudevents time attribute should be in RFC3339 format")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid cloudevent")
+			return nReq, err
+		}
+		nReq.Event.Timestamp = &cEvent.Time
+	}
+
+	if len(bytes.TrimSpace(cEvent.Data)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(cEvent.Data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&nReq.Event.EventFields)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "json: unknown field") {
+				err = fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+			} else {
+				err = fmt.Errorf("cloudevents data contains badly-formed json: %w", err)
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid cloudevent")
+			return nReq, err
+		}
+	}
+
+	nReq.Event.EventID = cEvent.ID
+	nReq.Event.EventType = cEvent.Type[strings.LastIndex(cEvent.Type, ".")+1:]
+	span.SetAttributes(attribute.String("cloudevents.type", cEvent.Type), attribute.String("cloudevents.source", cEvent.Source))
+	return nReq, nil
+}
+
+/*
+readStructuredCloudEvent reads the whole cloudevent from the json body of the request.
+Unknown top level attributes are accepted since they are cloudevents extension attributes.
+*/
+func readStructuredCloudEvent(ctx context.Context, w http.ResponseWriter, r *http.Request) (*CloudEvent, error) {
+	attrs, err := helpers.ReadJson[map[string]json.RawMessage](ctx, w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	cEvent := &CloudEvent{Data: attrs["data"]}
+	for name, dst := range map[string]*string{
+		"specversion":     &cEvent.SpecVersion,
+		"id":              &cEvent.ID,
+		"source":          &cEvent.Source,
+		"type":            &cEvent.Type,
+		"time":            &cEvent.Time,
+		"datacontenttype": &cEvent.DataContentType,
+	} {
+		value, found := attrs[name]
+		if !found {
+			continue
+		}
+		err = json.Unmarshal(value, dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type used for the key %s", name)
+		}
+	}
+	return cEvent, nil
+}
+
+/*
+readBinaryCloudEvent reads the cloudevent attributes from ce- prefixed headers and the request body as the event data
+*/
+func readBinaryCloudEvent(ctx context.Context, w http.ResponseWriter, r *http.Request) (*CloudEvent, error) {
+	body, err := helpers.ReadJson[json.RawMessage](ctx, w, r)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudEvent{
+		SpecVersion:     r.Header.Get(cloudEventsHeaderPrefix + "Specversion"),
+		ID:              r.Header.Get(cloudEventsHeaderPrefix + "Id"),
+		Source:          r.Header.Get(cloudEventsHeaderPrefix + "Source"),
+		Type:            r.Header.Get(cloudEventsHeaderPrefix + "Type"),
+		Time:            r.Header.Get(cloudEventsHeaderPrefix + "Time"),
+		DataContentType: r.Header.Get("Content-Type"),
+		Data:            body,
+	}, nil
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	CmdConfigSnapshotFile string
+)
+
+/*
+ConfigSnapshot is the effective configuration persisted on each startup to detect the configuration drift on the next one
+*/
+type ConfigSnapshot struct {
+	Hash    string            `json:"hash"`
+	Version string            `json:"version"`
+	SavedAt time.Time         `json:"saved_at"`
+	Config  map[string]string `json:"config"`
+}
+
+type ConfigChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// effectiveConfig is the flag values of the server after applying the defaults and the auto tuning, sensitive values are redacted
+var effectiveConfig map[string]string
+
+var effectiveConfigHash string
+
+/*
+SetEffectiveConfig sets the effective configuration of the server. It should be called before Main.
+*/
+func SetEffectiveConfig(config map[string]string) {
+	effectiveConfig = config
+}
+
+/*
+configHash returns the hash of the effective configuration. map keys are sorted by the json encoding so the hash is stable.
+*/
+func configHash(config map[string]string) string {
+	jConfig, _ := json.Marshal(config)
+	sum := sha256.Sum256(jConfig)
+	return hex.EncodeToString(sum[:])
+}
+
+// value of the sensitive configuration values which are set
+const configValueSet = "set"
+
+/*
+RedactConfigValue replaces a sensitive configuration value with whether it's set. Hashes of the values aren't recorded, since the snapshot
+and the config hash are exposed and a hash of a low entropy value like a password can be brute forced. Rotating a secret isn't reported as drift.
+*/
+func RedactConfigValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return configValueSet
+}
+
+/*
+diffConfig compares the effective configuration with the snapshot of the previous startup and logs the changes,
+then persists the effective configuration as the new snapshot. It returns the hash of the effective configuration.
+*/
+func diffConfig(logger *zerolog.Logger) string {
+	hash := configHash(effectiveConfig)
+	if CmdConfigSnapshotFile == "" {
+		return hash
+	}
+
+	var previous ConfigSnapshot
+	content, err := os.ReadFile(CmdConfigSnapshotFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info().Str("config_hash", hash).Msg("no previous configuration snapshot found")
+	case err != nil:
+		logger.Warn().Err(err).Msg("failed to read the previous configuration snapshot")
+	default:
+		err = json.Unmarshal(content, &previous)
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to parse the previous configuration snapshot")
+			break
+		}
+		if previous.Hash == hash {
+			logger.Info().Str("config_hash", hash).Msg("effective configuration is unchanged since the last startup")
+			break
+		}
+
+		added := make(map[string]string)
+		removed := make(map[string]string)
+		changed := make(map[string]ConfigChange)
+		for key, value := range effectiveConfig {
+			previousValue, found := previous.Config[key]
+			switch {
+			case !found:
+				added[key] = value
+			case previousValue != value:
+				changed[key] = ConfigChange{From: previousValue, To: value}
+			}
+		}
+		for key, value := range previous.Config {
+			if _, found := effectiveConfig[key]; !found {
+				removed[key] = value
+			}
+		}
+		logger.Warn().
+			Str("config_hash", hash).
+			Str("previous_config_hash", previous.Hash).
+			Str("previous_version", previous.Version).
+			Time("previous_saved_at", previous.SavedAt).
+			Interface("added", added).
+			Interface("removed", removed).
+			Interface("changed", changed).
+			Msg("effective configuration changed since the last startup")
+	}
+
+	jSnapshot, err := json.MarshalIndent(&ConfigSnapshot{
+		Hash:    hash,
+		Version: Version,
+		SavedAt: time.Now(),
+		Config:  effectiveConfig,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(CmdConfigSnapshotFile, jSnapshot, 0600)
+	}
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to persist the configuration snapshot")
+	}
+	return hash
+}
@@ -3,13 +3,20 @@ package api
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 type contextKey string
 
-const RequestContextKey = contextKey("request_id")
+const (
+	RequestContextKey    = contextKey("request_id")
+	ClaimsContextKey     = contextKey("claims")
+	APIVersionContextKey = contextKey("api_version")
+	// http request of the graphql query, used by the resolvers to authorize the fields
+	GraphQLRequestContextKey = contextKey("graphql_request")
+)
 
 /*
 setReqIDContext is used to generate a unique request id and set it on http.request context.
@@ -28,3 +35,46 @@ func (api *ApiServer) getReqIDContext(r *http.Request) string {
 	reqID := r.Context().Value(RequestContextKey)
 	return reqID.(string)
 }
+
+/*
+setClaimsContext is used to set the verified jwt token claims on http.request context.
+*/
+func (api *ApiServer) setClaimsContext(r *http.Request, claims *customClaims) *http.Request {
+	nCtx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+	return r.WithContext(nCtx)
+}
+
+/*
+getClaimsContext is used to get the verified jwt token claims from http.request context. It returns nil if the request is not authenticated.
+*/
+func (api *ApiServer) getClaimsContext(r *http.Request) *customClaims {
+	claims, ok := r.Context().Value(ClaimsContextKey).(*customClaims)
+	if !ok {
+		return nil
+	}
+	return claims
+}
+
+/*
+setAPIVersionContext is used to set the api version of the route on http.request context.
+*/
+func (api *ApiServer) setAPIVersionContext(r *http.Request, version string) *http.Request {
+	nCtx := context.WithValue(r.Context(), APIVersionContextKey, version)
+	return r.WithContext(nCtx)
+}
+
+/*
+getAPIVersionContext is used to get the api version of the request from http.request context.
+Requests which haven't reached a versioned route yet, e.g. the unknown paths, get the version of their path prefix and v1 if there's none.
+*/
+func (api *ApiServer) getAPIVersionContext(r *http.Request) string {
+	if version, ok := r.Context().Value(APIVersionContextKey).(string); ok {
+		return version
+	}
+	for _, version := range apiVersions {
+		if strings.HasPrefix(r.URL.Path, "/"+version+"/") {
+			return version
+		}
+	}
+	return apiVersion1
+}
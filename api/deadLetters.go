@@ -0,0 +1,384 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (api *ApiServer) getDeadLetterStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getDeadLetterStatsHandler.Tracer").Start(r.Context(), "getDeadLetterStatsHandler.Span")
+	defer span.End()
+
+	stats := api.models.DeadLetterQueue.Stats(ctx)
+
+	api.Logger.Info().
+		Int("dlq_size", stats.Total).
+		Str("remote_addr", r.RemoteAddr).
+		Msg("fetched the dead letter queue stats")
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": stats}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type DeadLetterListRes struct {
+	DeadLetters []*data.DeadLetterSnapshot `json:"dead_letters"`
+	NextCursor  string                     `json:"next_cursor,omitempty"` // empty if there are no more dead letters
+}
+
+/*
+listDeadLettersHandler lists the dead letters from the newest to the oldest along with their failure reason and number of attempts.
+Dead letters can be filtered by reason and event_type, and paginated using limit and cursor.
+*/
+func (api *ApiServer) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("listDeadLettersHandler.Tracer").Start(r.Context(), "listDeadLettersHandler.Span")
+	defer span.End()
+
+	query := r.URL.Query()
+	nVal := helpers.NewValidator()
+	filter := data.DeadLetterFilter{
+		Reason:    query.Get("reason"),
+		EventType: query.Get("event_type"),
+		Limit:     eventListDefaultLimit,
+	}
+	if query.Get("poison") != "" {
+		poison, err := strconv.ParseBool(query.Get("poison"))
+		nVal.Check(err == nil, "poison", "should be a boolean")
+		filter.Poison = poison
+	}
+	if filter.EventType != "" {
+		_, found := data.LookupEventType(filter.EventType)
+		nVal.Check(found, "event_type", "unknown event type")
+	}
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		nVal.Check(err == nil && limit > 0 && limit <= eventListMaxLimit, "limit", "should be a number between 1 and "+strconv.Itoa(eventListMaxLimit))
+		filter.Limit = limit
+	}
+	if query.Get("cursor") != "" {
+		cursor, ok := decodeEventListCursor(query.Get("cursor"))
+		nVal.Check(ok, "cursor", "invalid cursor")
+		filter.Cursor = cursor
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	letters, next := api.models.DeadLetterQueue.List(ctx, filter)
+	nRes := &DeadLetterListRes{DeadLetters: make([]*data.DeadLetterSnapshot, 0, len(letters)), NextCursor: encodeEventListCursor(next)}
+	for _, letter := range letters {
+		snapshot, err := data.NewDeadLetterSnapshot(letter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to take a snapshot of the dead letter")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+		nRes.DeadLetters = append(nRes.DeadLetters, snapshot)
+	}
+	span.SetAttributes(attribute.Int("dlq.count", len(letters)), attribute.Bool("dlq.has_more", next != 0))
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+getDeadLetterHandler returns the dead letter of the event.
+httprouter doesn't allow a wildcard next to the static stats route, so the stats requests are dispatched from here.
+*/
+func (api *ApiServer) getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	if eventID == "stats" {
+		api.getDeadLetterStatsHandler(w, r)
+		return
+	}
+
+	ctx, span := otel.Tracer("getDeadLetterHandler.Tracer").Start(r.Context(), "getDeadLetterHandler.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	letter, found := api.models.DeadLetterQueue.Get(ctx, eventID)
+	if !found {
+		span.SetStatus(codes.Error, "dead letter not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+	snapshot, err := data.NewDeadLetterSnapshot(letter)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to take a snapshot of the dead letter")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": snapshot}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type DeadLetterDeleteRes struct {
+	*data.DeadLetterSnapshot
+	ChangeVersion int64 `json:"change_version,omitempty"` // version of the change record which can be used to roll back the deletion
+}
+
+/*
+deleteDeadLetterHandler removes the triaged dead letter of the event. The deleted dead letter is soft deleted in the change record to be able to roll it back.
+*/
+func (api *ApiServer) deleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("deleteDeadLetterHandler.Tracer").Start(r.Context(), "deleteDeadLetterHandler.Span")
+	defer span.End()
+
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	letter, found := api.models.DeadLetterQueue.Delete(ctx, eventID)
+	if !found {
+		span.SetStatus(codes.Error, "dead letter not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	nRes := &DeadLetterDeleteRes{}
+	snapshot, err := data.NewDeadLetterSnapshot(letter)
+	if err != nil {
+		api.Logger.Error().Err(err).Str("event_id", eventID).Msg("failed to take a snapshot of the deleted dead letter, it can't be restored")
+		snapshot = &data.DeadLetterSnapshot{Seq: letter.Seq, Reason: letter.Reason, Error: letter.Error, Attempts: letter.Attempts, FailedAt: letter.FailedAt}
+	} else {
+		nRes.ChangeVersion = api.recordChange(ctx, r, purgeTargetDeadLetterQueue, "delete", actor, 1, []*data.DeadLetterSnapshot{snapshot})
+	}
+	nRes.DeadLetterSnapshot = snapshot
+	api.auditLog(r, actor, purgeTargetDeadLetterQueue+".delete").
+		Str("event_id", eventID).
+		Str("reason", letter.Reason).
+		Int64("change_version", nRes.ChangeVersion).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type DeadLetterReplayRes struct {
+	Replayed  int      `json:"replayed"`
+	EventIDs  []string `json:"event_ids"`
+	Skipped   int      `json:"skipped"`   // dead letters kept in the queue since their event can't be restored
+	Remaining int      `json:"remaining"` // matching dead letters left in the queue when the event queue doesn't have enough room for them
+}
+
+/*
+replayDeadLetters re-enqueues the events of the dead letters, getting a fresh retry budget from the worker.
+Dead letters which their event can't be decompressed are put back and counted as skipped. All the dead letters are put back if the queue rejects the events.
+*/
+func (api *ApiServer) replayDeadLetters(ctx context.Context, letters []*data.DeadLetter) (*DeadLetterReplayRes, error) {
+	nRes := &DeadLetterReplayRes{EventIDs: make([]string, 0, len(letters))}
+	replayed := make([]*data.DeadLetter, 0, len(letters))
+	skipped := make([]*data.DeadLetter, 0)
+	events := make([]data.Event, 0, len(letters))
+	for _, letter := range letters {
+		// events failed by the decompression are dead lettered as they're inside the queue
+		event, err := data.DecompressEvent(letter.Event)
+		if err != nil {
+			api.Logger.Error().Err(err).Str("event_id", letter.Event.GetEventID()).Msg("failed to decompress the dead lettered event, it can't be replayed")
+			skipped = append(skipped, letter)
+			continue
+		}
+		// status of the batch is already finalized when its events are dead lettered
+		event.SetBatchID("")
+		event.SetEnqueueTime(time.Now())
+		events = append(events, event)
+		replayed = append(replayed, letter)
+	}
+	if len(skipped) > 0 {
+		api.models.DeadLetterQueue.Restore(ctx, skipped)
+	}
+	nRes.Skipped = len(skipped)
+	if len(events) == 0 {
+		return nRes, nil
+	}
+
+	// ids of the replayed events are already seen by the deduplication so they're restored bypassing it
+	err := api.models.EventQueue.Restore(ctx, events)
+	if err != nil {
+		api.models.DeadLetterQueue.Restore(ctx, replayed)
+		return nil, err
+	}
+	for _, letter := range replayed {
+		nRes.EventIDs = append(nRes.EventIDs, letter.Event.GetEventID())
+		observ.PromEventDeadLetterReplayed.WithLabelValues(letter.Reason, letter.Event.GetEventType()).Inc()
+	}
+	nRes.Replayed = len(replayed)
+	return nRes, nil
+}
+
+/*
+replayDeadLetterHandler re-enqueues the dead lettered event to be processed again with a fresh retry budget
+*/
+func (api *ApiServer) replayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("replayDeadLetterHandler.Tracer").Start(r.Context(), "replayDeadLetterHandler.Span")
+	defer span.End()
+
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	// replayed events would be lost after the queue is drained
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+
+	letter, found := api.models.DeadLetterQueue.Delete(ctx, eventID)
+	if !found {
+		span.SetStatus(codes.Error, "dead letter not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+	api.writeReplayResponse(ctx, w, r, []*data.DeadLetter{letter}, 0)
+}
+
+/*
+replayAllDeadLettersHandler re-enqueues the dead lettered events matching the reason and event_type query parameters from the oldest to the newest.
+Up to limit events are replayed as long as the event queue has room for them, the rest stay in the dead letter queue.
+*/
+func (api *ApiServer) replayAllDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("replayAllDeadLettersHandler.Tracer").Start(r.Context(), "replayAllDeadLettersHandler.Span")
+	defer span.End()
+
+	query := r.URL.Query()
+	nVal := helpers.NewValidator()
+	filter := data.DeadLetterFilter{
+		Reason:    query.Get("reason"),
+		EventType: query.Get("event_type"),
+	}
+	if query.Get("poison") != "" {
+		poison, err := strconv.ParseBool(query.Get("poison"))
+		nVal.Check(err == nil, "poison", "should be a boolean")
+		filter.Poison = poison
+	}
+	if filter.EventType != "" {
+		_, found := data.LookupEventType(filter.EventType)
+		nVal.Check(found, "event_type", "unknown event type")
+	}
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		nVal.Check(err == nil && limit > 0, "limit", "should be a positive number")
+		filter.Limit = limit
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+
+	room := int(api.models.EventQueue.Capacity) - api.models.EventQueue.Size(ctx)
+	if room <= 0 {
+		span.SetStatus(codes.Error, "event queue is full")
+		api.eventQueueFullResponse(w, r)
+		return
+	}
+	if filter.Limit == 0 || filter.Limit > room {
+		filter.Limit = room
+	}
+	letters, remaining := api.models.DeadLetterQueue.Take(ctx, filter)
+	api.writeReplayResponse(ctx, w, r, letters, remaining)
+}
+
+/*
+writeReplayResponse replays the dead letters taken out of the dead letter queue and writes the result of the replay
+*/
+func (api *ApiServer) writeReplayResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, letters []*data.DeadLetter, remaining int) {
+	span := trace.SpanFromContext(ctx)
+
+	nRes, err := api.replayDeadLetters(ctx, letters)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, data.ErrEventQueueFull) {
+			span.SetStatus(codes.Error, "event queue is full")
+			api.eventQueueFullResponse(w, r)
+			return
+		}
+		span.SetStatus(codes.Error, "failed to replay the dead letters")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	nRes.Remaining = remaining
+	span.SetAttributes(attribute.Int("dlq.replayed", nRes.Replayed), attribute.Int("dlq.skipped", nRes.Skipped))
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	api.auditLog(r, actor, purgeTargetDeadLetterQueue+".replay").
+		Int("replayed", nRes.Replayed).
+		Int("skipped", nRes.Skipped).
+		Int("remaining", nRes.Remaining).
+		Strs("event_ids", nRes.EventIDs).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+deadLetterActionHandler creates the handler of the POST requests on the dead letter queue.
+httprouter doesn't allow the static routes next to the wildcard of the replay route, so purge and replay-all are dispatched from here.
+*/
+func (api *ApiServer) deadLetterActionHandler() http.HandlerFunc {
+	purge := api.purgeDeadLetterQueueHandler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch httprouter.ParamsFromContext(r.Context()).ByName("event_id") {
+		case "purge":
+			purge(w, r)
+		case "replay-all":
+			api.replayAllDeadLettersHandler(w, r)
+		default:
+			api.notFoundResponse(w, r)
+		}
+	}
+}
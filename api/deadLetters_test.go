@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestDeadLetterQueueRoutes(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	api.models.DeadLetterQueue = data.NewDeadLetterQueue()
+	nRes, err := api.issueTokens(t.Context(), CmdApiAdmin, uuid.NewString(), scopeDeadLettersRead+" "+scopeDeadLettersWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.routes()
+
+	// the actions on the whole queue are dispatched from the routes of the event ids
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		wantCode int
+	}{
+		{name: "stats", method: http.MethodGet, target: "/v1/dlq/stats", wantCode: http.StatusOK},
+		{name: "purge", method: http.MethodPost, target: "/v1/dlq/purge", body: `{"dry_run": true}`, wantCode: http.StatusOK},
+		{name: "replay-all", method: http.MethodPost, target: "/v1/dlq/replay-all", body: `{}`, wantCode: http.StatusOK},
+		{name: "unknown event id", method: http.MethodGet, target: "/v1/dlq/f47ac10b-58cc-4372-a567-0e02b2c3d479", wantCode: http.StatusNotFound},
+		{name: "unknown action", method: http.MethodPost, target: "/v1/dlq/unknown", body: `{}`, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, tt.method, tt.target, tt.body)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+nRes.Token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headers used by the clients to propagate their deadline, the earliest one is used if both are specified
+const (
+	requestTimeoutHeader  = "Request-Timeout"    // seconds (e.g. 2.5) or go duration (e.g. 2500ms) relative to the receipt of the request
+	requestDeadlineHeader = "X-Request-Deadline" // absolute time in RFC3339 format
+)
+
+// time reserved out of the server write timeout to write the deadline exceeded error before the connection is cut
+const deadlineWriteMargin = 100 * time.Millisecond
+
+// stages of the request in which the client deadline is exceeded
+const (
+	deadlineStageEnqueue    = "enqueue"
+	deadlineStageProcessing = "processing"
+)
+
+/*
+DeadlineExceededRes is the error of the requests which didn't finish before the deadline of the client.
+event_status tells the client whether the event is still going to be processed, so it can decide about retrying it.
+*/
+type DeadlineExceededRes struct {
+	Message     string    `json:"message"`
+	Stage       string    `json:"stage"`
+	Deadline    time.Time `json:"deadline"`
+	EventID     string    `json:"event_id,omitempty"`
+	EventStatus string    `json:"event_status,omitempty"`
+}
+
+/*
+requestDeadline returns the deadline propagated by the client through the Request-Timeout or X-Request-Deadline headers.
+The deadline is capped by the server write timeout so the error can be written before the server cuts the connection.
+It returns the zero time if the client didn't specify any deadline.
+*/
+func (api *ApiServer) requestDeadline(r *http.Request, receivedAt time.Time) (time.Time, error) {
+	var deadline time.Time
+	if value := r.Header.Get(requestTimeoutHeader); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, fErr := strconv.ParseFloat(value, 64)
+			if fErr != nil {
+				return time.Time{}, fmt.Errorf("%s header should be the number of seconds or a duration", requestTimeoutHeader)
+			}
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		if timeout <= 0 {
+			return time.Time{}, fmt.Errorf("%s header should be positive", requestTimeoutHeader)
+		}
+		deadline = receivedAt.Add(timeout)
+	}
+	if value := r.Header.Get(requestDeadlineHeader); value != "" {
+		headerDeadline, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s header should be in RFC3339 format", requestDeadlineHeader)
+		}
+		if deadline.IsZero() || headerDeadline.Before(deadline) {
+			deadline = headerDeadline
+		}
+	}
+
+	if !deadline.IsZero() && api.Cfg.ServerWriteTimeout > deadlineWriteMargin {
+		if limit := receivedAt.Add(api.Cfg.ServerWriteTimeout - deadlineWriteMargin); limit.Before(deadline) {
+			deadline = limit
+		}
+	}
+	return deadline, nil
+}
+
+/*
+withRequestDeadline attaches the deadline of the client to the context. The returned context is only cancelled along with the parent if there's no deadline.
+*/
+func withRequestDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+/*
+deadlineExceeded reports whether the context is done because of the client deadline rather than the client going away
+*/
+func deadlineExceeded(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	CmdDebugEndpoints  bool
+	CmdDebugListenAddr string
+)
+
+/*
+debugRoutes registers the pprof profiles and the expvar variables for the admins, so the profiles can be captured from production.
+The command line of the server is left out of both, since it may carry the secrets passed by the flags.
+*/
+func (api *ApiServer) debugRoutes(router *httprouter.Router) {
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/*profile", api.JWTAuth(api.requireScope(scopeAdmin, api.pprofHandler)))
+	router.HandlerFunc(http.MethodPost, "/debug/pprof/*profile", api.JWTAuth(api.requireScope(scopeAdmin, api.pprofHandler)))
+	router.HandlerFunc(http.MethodGet, "/debug/vars", api.JWTAuth(api.requireScope(scopeAdmin, api.expvarHandler)))
+}
+
+/*
+debugServerRoutes returns the handler of the separate debug listener, it doesn't have the write timeout of the main listener,
+so the cpu profiles and the execution traces can be captured for longer than it. The clients are filtered by the ip filter like the main listener.
+*/
+func (api *ApiServer) debugServerRoutes() http.Handler {
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(api.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(api.methodNotAllowedResponse)
+	api.debugRoutes(router)
+
+	return api.panicRecovery(
+		api.setContextHandler(
+			api.ipFilterHandler(router)))
+}
+
+/*
+loopbackHost reports whether the host of a listen address only accepts the local connections
+*/
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+func (api *ApiServer) pprofHandler(w http.ResponseWriter, r *http.Request) {
+	profile := httprouter.ParamsFromContext(r.Context()).ByName("profile")
+	switch profile {
+	case "/":
+		pprof.Index(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	case "/cmdline":
+		api.notFoundResponse(w, r)
+	default:
+		if r.Method != http.MethodGet {
+			api.methodNotAllowedResponse(w, r)
+			return
+		}
+		// pprof index serves the named profiles like heap, goroutine and allocs and responds not found to the unknown ones
+		pprof.Index(w, r)
+	}
+}
+
+func (api *ApiServer) expvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoopbackHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "localhost", want: true},
+		{host: "127.0.0.1", want: true},
+		{host: "::1", want: true},
+		{host: "", want: false},
+		{host: "0.0.0.0", want: false},
+		{host: "10.0.0.1", want: false},
+		{host: "debug.internal", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := loopbackHost(tt.host); got != tt.want {
+				t.Errorf("loopbackHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugServerIPFilter(t *testing.T) {
+	api := newTestApiServer(t)
+	api.ipFilter = newIPFilter([]string{"10.0.0.0/8"}, nil, []string{"/debug/"})
+	handler := api.debugServerRoutes()
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{name: "client outside of the allowed networks", remoteAddr: "192.0.2.1:40000", want: http.StatusForbidden},
+		{name: "allowed client still requires the token", remoteAddr: "10.1.2.3:40000", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// interval of checking the drain progress while the drain request waits for the queue to become empty
+const drainPollInterval = 100 * time.Millisecond
+
+/*
+DrainRes is the progress of draining the queue. The queue is drained once all the queued, in flight and leased events are processed.
+*/
+type DrainRes struct {
+	Draining        bool       `json:"draining"`
+	Drained         bool       `json:"drained"`
+	StartedAt       *time.Time `json:"started_at,omitempty"` // not set if the drain is started by the graceful shutdown
+	InitialPending  int64      `json:"initial_pending"`      // queued and in flight events when the drain started
+	QueueSize       int        `json:"queue_size"`
+	InFlight        int64      `json:"in_flight"`
+	Leased          int        `json:"leased"` // events pulled by the external consumers and not acknowledged yet
+	ProgressPercent float64    `json:"progress_percent"`
+	WorkerPaused    bool       `json:"worker_paused"` // the queue isn't drained until the worker is resumed
+}
+
+/*
+startDrain stops accepting new events and fails the readiness checks. It returns false if the server is already draining.
+*/
+func (api *ApiServer) startDrain(ctx context.Context) bool {
+	api.adminMu.Lock()
+	defer api.adminMu.Unlock()
+	if api.draining.Swap(true) {
+		return false
+	}
+	api.unready.Store(true)
+	api.drainStartedAt = time.Now()
+	api.drainInitialPending = int64(api.models.EventQueue.Size(ctx)) + api.worker.InFlight() + int64(api.models.EventQueue.Leased())
+	return true
+}
+
+/*
+drainProgress reports the progress of the drain
+*/
+func (api *ApiServer) drainProgress(ctx context.Context) *DrainRes {
+	api.adminMu.Lock()
+	startedAt, initialPending := api.drainStartedAt, api.drainInitialPending
+	api.adminMu.Unlock()
+
+	nRes := &DrainRes{
+		Draining:       api.draining.Load(),
+		InitialPending: initialPending,
+		QueueSize:      api.models.EventQueue.Size(ctx),
+		InFlight:       api.worker.InFlight(),
+		Leased:         api.models.EventQueue.Leased(),
+		WorkerPaused:   !api.worker.PausedAt().IsZero(),
+	}
+	if !startedAt.IsZero() {
+		nRes.StartedAt = &startedAt
+	}
+	nRes.Drained = nRes.Draining && nRes.QueueSize == 0 && nRes.InFlight == 0 && nRes.Leased == 0
+	pending := int64(nRes.QueueSize) + nRes.InFlight + int64(nRes.Leased)
+	switch {
+	case nRes.Drained || initialPending == 0:
+		nRes.ProgressPercent = 100
+	case pending < initialPending:
+		nRes.ProgressPercent = float64(initialPending-pending) * 100 / float64(initialPending)
+	}
+	return nRes
+}
+
+/*
+drainQueueHandler stops accepting new events and lets the worker empty the queue, so the server can be restarted without losing any event.
+The request waits up to the wait query parameter for the queue to be drained, calling it again only reports the progress of the drain.
+It responds with 200 once the queue is drained and 202 while the worker is still processing the remaining events.
+*/
+func (api *ApiServer) drainQueueHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("drainQueueHandler.Tracer").Start(r.Context(), "drainQueueHandler.Span")
+	defer span.End()
+
+	var wait time.Duration
+	var err error
+	nVal := helpers.NewValidator()
+	if value := r.URL.Query().Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		nVal.Check(err == nil, "wait", "should be a duration, e.g. 2s")
+		nVal.Check(wait >= 0, "wait", "shouldn't be negative")
+		// the response should be written before the server write timeout cuts the connection
+		if api.Cfg.ServerWriteTimeout > 0 {
+			nVal.Check(wait <= api.Cfg.ServerWriteTimeout-deadlineWriteMargin, "wait", "should be less than the server write timeout")
+		}
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	if api.startDrain(ctx) {
+		progress := api.drainProgress(ctx)
+		api.auditLog(r, actor, "queue.drain").Int("queue_size", progress.QueueSize).Int64("in_flight", progress.InFlight).Msg("started draining the server")
+	}
+
+	nRes := api.drainProgress(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !nRes.Drained && waitCtx.Err() == nil {
+		select {
+		case <-waitCtx.Done():
+		case <-ticker.C:
+			nRes = api.drainProgress(ctx)
+		}
+	}
+	if ctx.Err() != nil {
+		span.RecordError(ctx.Err())
+		span.SetStatus(codes.Error, "request cancelled while waiting for the drain")
+		return
+	}
+	span.SetAttributes(attribute.Bool("drain.drained", nRes.Drained), attribute.Int("drain.queue_size", nRes.QueueSize))
+
+	status := http.StatusAccepted
+	if nRes.Drained {
+		status = http.StatusOK
+	}
+	err = helpers.WriteResponse(ctx, w, r, status, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+drainQueue lets the worker process the events remaining inside the queue during the shutdown instead of abandoning them, it waits until the queue
+is empty and the worker is idle or ctx is done. A paused worker is resumed, otherwise the queue would never be drained. Events leased by the consumers
+aren't waited for since they can't be acknowledged anymore once the http server is shut down.
+*/
+func (api *ApiServer) drainQueue(ctx context.Context, logger *zerolog.Logger) error {
+	if api.worker.Resume() {
+		logger.Info().Msg("resumed the paused worker to drain the queue")
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		queueSize, inFlight := api.models.EventQueue.Size(ctx), api.worker.InFlight()
+		if queueSize == 0 && inFlight == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			logger.Warn().Int("queue_size", queueSize).Int64("in_flight", inFlight).Msg("queue drain deadline is exceeded, the remaining events are abandoned")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	observ "github.com/cybrarymin/behavox/api/observability"
 	helpers "github.com/cybrarymin/behavox/internal"
 )
 
@@ -14,12 +19,19 @@ func (api *ApiServer) logError(err error) {
 
 // errorResponse is the method we use to send a json formatted error to the client in case of any error
 func (api *ApiServer) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	api.codedErrorResponse(w, r, status, "", message)
+}
 
+// codedErrorResponse sends the error with the machine readable code reported by the v2 api, the code of the status is used if code is empty
+func (api *ApiServer) codedErrorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
 	e := helpers.Envelope{
 		"error":      message,
 		"request_id": api.getReqIDContext(r),
 	}
-	err := helpers.WriteJson(r.Context(), w, status, e, nil)
+	if api.getAPIVersionContext(r) != apiVersion1 {
+		e["error"] = newErrorDetailsRes(status, code, message)
+	}
+	err := helpers.WriteResponse(r.Context(), w, r, status, e, nil)
 
 	if err != nil {
 		api.logError(err)
@@ -42,6 +54,14 @@ func (api *ApiServer) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 
 // badRequestResponse method will be used to send notFound 400 status error json response to the client
 func (api *ApiServer) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLargeError *helpers.BodyTooLargeError
+	if errors.As(err, &tooLargeError) {
+		eventType := tooLargeError.EventType
+		if eventType == "" {
+			eventType = "unknown"
+		}
+		observ.PromHttpOversizedBodyRejections.WithLabelValues(eventType).Inc()
+	}
 	api.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
@@ -58,12 +78,92 @@ func (api *ApiServer) failedValidationResponse(w http.ResponseWriter, r *http.Re
 
 func (api *ApiServer) rateLimitExceedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "request rate limit reached, please try again later"
-	api.errorResponse(w, r, http.StatusTooManyRequests, message)
+	api.codedErrorResponse(w, r, http.StatusTooManyRequests, errorCodeRateLimited, message)
+}
+
+func (api *ApiServer) authLockedOutResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed authentication attempts, please try again later"
+	api.codedErrorResponse(w, r, http.StatusTooManyRequests, errorCodeRateLimited, message)
 }
 
 func (api *ApiServer) eventQueueFullResponse(w http.ResponseWriter, r *http.Request) {
 	message := "service unavailable, event queue is already full"
-	api.errorResponse(w, r, http.StatusServiceUnavailable, message)
+	api.codedErrorResponse(w, r, http.StatusServiceUnavailable, errorCodeQueueFull, message)
+}
+
+func (api *ApiServer) backpressureResponse(w http.ResponseWriter, r *http.Request) {
+	observ.PromHttpBackpressureRejections.WithLabelValues().Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(CmdBackpressureRetryAfter.Seconds()))))
+	message := "service unavailable, worker is paused and the event queue reached its high water mark"
+	api.codedErrorResponse(w, r, http.StatusServiceUnavailable, errorCodeBackpressure, message)
+}
+
+func (api *ApiServer) deadlineExceededResponse(w http.ResponseWriter, r *http.Request, res *DeadlineExceededRes) {
+	observ.PromHttpDeadlineExceeded.WithLabelValues(res.Stage).Inc()
+	res.Message = fmt.Sprintf("the request deadline exceeded in the %s stage of the event", res.Stage)
+	api.codedErrorResponse(w, r, http.StatusGatewayTimeout, errorCodeDeadlineExceeded, res)
+}
+
+func (api *ApiServer) shuttingDownResponse(w http.ResponseWriter, r *http.Request) {
+	message := "service unavailable, server is draining and doesn't accept new events"
+	api.codedErrorResponse(w, r, http.StatusServiceUnavailable, errorCodeDraining, message)
+}
+
+func (api *ApiServer) duplicateEventResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an event with the same event_id is already received"
+	api.codedErrorResponse(w, r, http.StatusConflict, errorCodeDuplicateEvent, message)
+}
+
+func (api *ApiServer) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, contentType string) {
+	message := fmt.Sprintf("unsupported content type, the body should be %s", contentType)
+	api.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (api *ApiServer) subscriptionLimitResponse(w http.ResponseWriter, r *http.Request) {
+	message := "maximum number of the webhook subscriptions is reached, delete the unused ones first"
+	api.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (api *ApiServer) callbacksDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "callbacks are disabled since --callback-secret isn't set"
+	api.errorResponse(w, r, http.StatusNotFound, message)
+}
+
+func (api *ApiServer) scheduleLimitResponse(w http.ResponseWriter, r *http.Request) {
+	message := "maximum number of the schedules is reached, delete the unused ones first"
+	api.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (api *ApiServer) leaseNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the lease couldn't be found, it's either already acknowledged or expired and the event is delivered again"
+	api.errorResponse(w, r, http.StatusNotFound, message)
+}
+
+func (api *ApiServer) eventProcessingResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the event is already being processed and can't be cancelled"
+	api.codedErrorResponse(w, r, http.StatusConflict, errorCodeEventProcessing, message)
+}
+
+func (api *ApiServer) readOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the server is running in read-only mode and doesn't accept any writes"
+	api.codedErrorResponse(w, r, http.StatusForbidden, errorCodeReadOnly, message)
+}
+
+func (api *ApiServer) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+	message := fmt.Sprintf("the token isn't granted the %s scope required by this endpoint", scope)
+	api.codedErrorResponse(w, r, http.StatusForbidden, errorCodeInsufficientScope, message)
+}
+
+func (api *ApiServer) ipForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requests of your network aren't allowed to this endpoint"
+	api.codedErrorResponse(w, r, http.StatusForbidden, errorCodeForbidden, message)
+}
+
+func (api *ApiServer) untrustedActorResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the token isn't allowed to act on behalf of other producers"
+	api.errorResponse(w, r, http.StatusForbidden, message)
 }
 
 func (api *ApiServer) invalidAuthenticationCredResponse(w http.ResponseWriter, r *http.Request) {
@@ -72,6 +172,17 @@ func (api *ApiServer) invalidAuthenticationCredResponse(w http.ResponseWriter, r
 	api.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (api *ApiServer) invalidSignatureResponse(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", "HMAC-SHA256")
+	message := fmt.Sprintf("invalid request signature: %s", err)
+	api.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (api *ApiServer) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or already used refresh token, the basic authentication credentials should be used to get new tokens"
+	api.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (api *ApiServer) invalidJWTTokenSignatureResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer Jwt")
 	message := "invalid jwt token signature."
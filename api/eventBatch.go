@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdEventBatchMaxSize int
+)
+
+// status of the items of the batch creation response
+const (
+	batchItemStatusAccepted  = "accepted"
+	batchItemStatusRejected  = "rejected"
+	batchItemStatusDuplicate = "duplicate"
+)
+
+/*
+EventBatchCreateReq carries multiple events with the same shape as the event of EventCreateReq
+*/
+type EventBatchCreateReq struct {
+	Events []map[string]interface{} `json:"events"`
+}
+
+type EventBatchItemRes struct {
+	Index       int               `json:"index"`
+	EventID     string            `json:"event_id,omitempty"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`       // validation errors of the event
+	ReceivedAt  *time.Time        `json:"received_at,omitempty"`  // receipt time of the original event if it's a duplicate of a recently received event
+	DuplicateOf *int              `json:"duplicate_of,omitempty"` // index of the original event if it's a duplicate inside the same batch
+}
+
+type EventBatchCreateRes struct {
+	BatchID    string              `json:"batch_id,omitempty"` // empty if none of the events are accepted
+	Accepted   int                 `json:"accepted"`
+	Rejected   int                 `json:"rejected"`
+	Duplicates []EventBatchItemRes `json:"duplicates,omitempty"` // deduplication report of the batch, a subset of results
+	Results    []EventBatchItemRes `json:"results"`
+}
+
+/*
+createEventBatchHandler validates each event of the batch and enqueues the valid ones together under a new batch id.
+Invalid and duplicate events are reported in the per item results without failing the whole request,
+so the producers can amortize the http overhead and only resend the rejected events.
+*/
+func (api *ApiServer) createEventBatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("createEventBatchHandler.Tracer").Start(r.Context(), "createEventBatchHandler.Span")
+	defer span.End()
+
+	// new events are not accepted anymore when the shutdown begins since they would be lost
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+	// producers are pushed back while the paused worker lets the queue fill up
+	if api.backpressured(ctx) {
+		span.SetStatus(codes.Error, "event queue reached its high water mark")
+		api.backpressureResponse(w, r)
+		return
+	}
+
+	deadline, err := api.requestDeadline(r, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+	deadlineCtx, cancel := withRequestDeadline(ctx, deadline)
+	defer cancel()
+
+	nReq, err := helpers.ReadRequest[EventBatchCreateReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	nVal.Check(len(nReq.Events) > 0, "events", "should contain at least one event")
+	nVal.Check(len(nReq.Events) <= CmdEventBatchMaxSize, "events", fmt.Sprintf("must not contain more than %d events", CmdEventBatchMaxSize))
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(nReq.Events)))
+
+	nRes := &EventBatchCreateRes{Results: make([]EventBatchItemRes, len(nReq.Events))}
+	// index of the valid events inside the request, used to report the result of the enqueued events
+	eventIndexes := make(map[string]int, len(nReq.Events))
+	events := make([]data.Event, 0, len(nReq.Events))
+	for i, item := range nReq.Events {
+		itemRes := &nRes.Results[i]
+		itemRes.Index = i
+		itemRes.Status = batchItemStatusRejected
+
+		itemReq, err := decodeEventPayload(ctx, item)
+		if err != nil {
+			itemRes.Error = err.Error()
+			continue
+		}
+		itemRes.EventID = itemReq.Event.EventID
+
+		// size of the item is used for the body size limit of the event type
+		jItem, _ := json.Marshal(item)
+		itemVal := helpers.NewValidator()
+		nEvent, err := api.newEvent(r, itemVal, &itemReq, int64(len(jItem)))
+		switch {
+		case err != nil:
+			itemRes.Error = err.Error()
+			continue
+		case !itemVal.Valid():
+			itemRes.Errors = itemVal.Errors
+			continue
+		}
+		if original, found := eventIndexes[nEvent.GetEventID()]; found {
+			itemRes.Status = batchItemStatusDuplicate
+			itemRes.Error = data.ErrDuplicateEvent.Error()
+			itemRes.DuplicateOf = &original
+			continue
+		}
+		eventIndexes[nEvent.GetEventID()] = i
+		events = append(events, nEvent)
+	}
+
+	// duplicates of the recently received events are removed from the batch one by one since the batch is enqueued atomically
+	batchID := uuid.NewString()
+	for len(events) > 0 {
+		err = api.models.EventQueue.PutBatch(deadlineCtx, data.NewEventBatch(batchID, events))
+		var duplicateError *data.DuplicateEventError
+		if !errors.As(err, &duplicateError) {
+			break
+		}
+		itemRes := &nRes.Results[eventIndexes[duplicateError.EventID]]
+		itemRes.Status = batchItemStatusDuplicate
+		itemRes.Error = data.ErrDuplicateEvent.Error()
+		if !duplicateError.ReceivedAt.IsZero() {
+			itemRes.ReceivedAt = &duplicateError.ReceivedAt
+		}
+		for i := range events {
+			if events[i].GetEventID() == duplicateError.EventID {
+				events = append(events[:i], events[i+1:]...)
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(events) == 0:
+	case errors.Is(err, context.DeadlineExceeded):
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "deadline exceeded before adding the batch into the queue")
+		api.deadlineExceededResponse(w, r, &DeadlineExceededRes{Stage: deadlineStageEnqueue, Deadline: deadline})
+		return
+	case errors.Is(err, context.Canceled):
+		span.RecordError(err)
+		return
+	case errors.Is(err, data.ErrEventQueueFull):
+		span.RecordError(err)
+		for _, event := range events {
+			nRes.Results[eventIndexes[event.GetEventID()]].Error = err.Error()
+		}
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to add the batch into the queue")
+		api.serverErrorResponse(w, r, err)
+		return
+	default:
+		nRes.BatchID = batchID
+		for _, event := range events {
+			nRes.Results[eventIndexes[event.GetEventID()]].Status = batchItemStatusAccepted
+		}
+	}
+
+	for _, itemRes := range nRes.Results {
+		if itemRes.Status == batchItemStatusAccepted {
+			nRes.Accepted++
+		} else {
+			nRes.Rejected++
+		}
+		if itemRes.Status == batchItemStatusDuplicate {
+			nRes.Duplicates = append(nRes.Duplicates, itemRes)
+		}
+	}
+	span.SetAttributes(attribute.String("batch.id", nRes.BatchID), attribute.Int("batch.accepted", nRes.Accepted))
+	api.Logger.Info().
+		Str("batch_id", nRes.BatchID).
+		Int("accepted", nRes.Accepted).
+		Int("rejected", nRes.Rejected).
+		Msg("creating new event batch")
+
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type EventBatchGetRes struct {
+	*data.BatchStatus
+	Pending int `json:"pending"`
+}
+
+/*
+getEventBatchHandler returns the processing progress of a batch. Status of the completed batches is only kept for a limited time.
+*/
+func (api *ApiServer) getEventBatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getEventBatchHandler.Tracer").Start(r.Context(), "getEventBatchHandler.Span")
+	defer span.End()
+
+	batchID := httprouter.ParamsFromContext(r.Context()).ByName("batch_id")
+	span.SetAttributes(attribute.String("batch.id", batchID))
+	status, found := api.models.EventQueue.BatchStatus(batchID)
+	if !found {
+		span.SetStatus(codes.Error, "batch not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	nRes := &EventBatchGetRes{BatchStatus: status, Pending: status.Pending()}
+	err := api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdEventBulkMaxBytes int64
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// maximum number of the rejected lines reported in the bulk creation response, the rest are only counted
+const eventBulkMaxReportedErrors = 1000
+
+// reasons of stopping the bulk ingestion before the end of the body
+const (
+	bulkAbortQueueFull        = "queue_full"
+	bulkAbortDeadlineExceeded = "deadline_exceeded"
+	bulkAbortDraining         = "draining"
+	bulkAbortBackpressure     = "backpressure"
+	bulkAbortBodyTooLarge     = "body_too_large"
+	bulkAbortReadError        = "read_error"
+)
+
+type EventBulkLineRes struct {
+	Line       int               `json:"line"`
+	EventID    string            `json:"event_id,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`      // validation errors of the event
+	ReceivedAt *time.Time        `json:"received_at,omitempty"` // receipt time of the original event if it's a duplicate of a recently received event
+}
+
+/*
+EventBulkCreateRes is the summary of the bulk ingestion. Only the rejected lines are reported since the bodies might carry a large number of events.
+*/
+type EventBulkCreateRes struct {
+	Lines           int                `json:"lines"` // number of the lines read including the empty ones
+	Accepted        int                `json:"accepted"`
+	Rejected        int                `json:"rejected"`
+	Aborted         bool               `json:"aborted"` // the lines after the aborted line aren't read and should be sent again
+	AbortReason     string             `json:"abort_reason,omitempty"`
+	AbortedAtLine   int                `json:"aborted_at_line,omitempty"`
+	Duplicates      []EventBulkLineRes `json:"duplicates,omitempty"` // deduplication report of the body, a subset of errors
+	Errors          []EventBulkLineRes `json:"errors"`
+	ErrorsTruncated bool               `json:"errors_truncated,omitempty"` // only the first rejected lines are reported
+}
+
+func (nRes *EventBulkCreateRes) reject(lineRes EventBulkLineRes) {
+	nRes.Rejected++
+	if len(nRes.Errors) >= eventBulkMaxReportedErrors {
+		nRes.ErrorsTruncated = true
+		return
+	}
+	nRes.Errors = append(nRes.Errors, lineRes)
+	if lineRes.Status == batchItemStatusDuplicate {
+		nRes.Duplicates = append(nRes.Duplicates, lineRes)
+	}
+}
+
+func (nRes *EventBulkCreateRes) abort(line int, reason string) {
+	nRes.Aborted = true
+	nRes.AbortReason = reason
+	nRes.AbortedAtLine = line
+}
+
+/*
+readBulkLine reads the next line of the body without its line ending. Lines longer than the limit are skipped and reported by tooLong.
+The last line is returned without a line ending only at the end of the body, the partially read lines of the failed reads are dropped with the error.
+*/
+func readBulkLine(reader *bufio.Reader, limit int64) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			// the line ending isn't counted towards the limit
+			if int64(len(bytes.TrimRight(line, "\r\n"))) > limit {
+				line, tooLong = nil, true
+			}
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && (len(line) > 0 || tooLong):
+		case err != nil:
+			return nil, false, err
+		}
+		return bytes.TrimRight(line, "\r\n"), tooLong, nil
+	}
+}
+
+/*
+createEventBulkHandler ingests the newline delimited json events of the body one by one while the body is being streamed,
+so the log shippers can send large payloads. Each line has the same shape as the items of the batch creation request.
+Ingestion is aborted once the queue is full or the deadline of the client is exceeded, the summary reports the line to resume from.
+*/
+func (api *ApiServer) createEventBulkHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("createEventBulkHandler.Tracer").Start(r.Context(), "createEventBulkHandler.Span")
+	defer span.End()
+
+	// new events are not accepted anymore when the shutdown begins since they would be lost
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+	// producers are pushed back while the paused worker lets the queue fill up
+	if api.backpressured(ctx) {
+		span.SetStatus(codes.Error, "event queue reached its high water mark")
+		api.backpressureResponse(w, r)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeNDJSON {
+		span.SetStatus(codes.Error, "unsupported content type")
+		api.unsupportedMediaTypeResponse(w, r, contentTypeNDJSON)
+		return
+	}
+
+	deadline, err := api.requestDeadline(r, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+	deadlineCtx, cancel := withRequestDeadline(ctx, deadline)
+	defer cancel()
+
+	reader := bufio.NewReader(http.MaxBytesReader(w, r.Body, CmdEventBulkMaxBytes))
+	nRes := &EventBulkCreateRes{Errors: make([]EventBulkLineRes, 0)}
+	err = api.ingestNDJSON(ctx, deadlineCtx, r, reader, nRes)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		span.SetStatus(codes.Error, "failed to add the event into the queue")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("bulk.lines", nRes.Lines),
+		attribute.Int("bulk.accepted", nRes.Accepted),
+		attribute.Int("bulk.rejected", nRes.Rejected),
+		attribute.String("bulk.abort_reason", nRes.AbortReason))
+	api.Logger.Info().
+		Int("lines", nRes.Lines).
+		Int("accepted", nRes.Accepted).
+		Int("rejected", nRes.Rejected).
+		Str("abort_reason", nRes.AbortReason).
+		Msg("ingested the bulk events")
+
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+ingestNDJSON ingests the newline delimited json events of the reader one by one until the end of the reader or the ingestion is aborted.
+The errors are only returned for the failures of the server or the cancelled requests.
+*/
+func (api *ApiServer) ingestNDJSON(ctx context.Context, deadlineCtx context.Context, r *http.Request, reader *bufio.Reader, nRes *EventBulkCreateRes) error {
+	for {
+		line, tooLong, err := readBulkLine(reader, helpers.CmdMaxBodyBytes)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.Is(err, io.EOF):
+			case errors.As(err, &maxBytesError):
+				nRes.abort(nRes.Lines+1, bulkAbortBodyTooLarge)
+			case ctx.Err() != nil:
+				return ctx.Err()
+			default:
+				nRes.abort(nRes.Lines+1, bulkAbortReadError)
+			}
+			return nil
+		}
+		nRes.Lines++
+		lineRes := EventBulkLineRes{Line: nRes.Lines, Status: batchItemStatusRejected}
+		switch {
+		case tooLong:
+			lineRes.Error = fmt.Sprintf("line is larger than %d bytes", helpers.CmdMaxBodyBytes)
+			nRes.reject(lineRes)
+			continue
+		case len(bytes.TrimSpace(line)) == 0:
+			continue
+		}
+
+		abortReason, err := api.ingestBulkItem(ctx, deadlineCtx, r, nRes, lineRes, line)
+		if err != nil {
+			return err
+		}
+		if abortReason != "" {
+			nRes.abort(nRes.Lines, abortReason)
+			return nil
+		}
+	}
+}
+
+/*
+ingestBulkItem validates and enqueues an event of the bulk bodies, the rejected events are reported on the summary.
+It returns the reason of aborting the ingestion if the rest of the events shouldn't be read, the errors are only returned for the failures of the server or the cancelled requests.
+*/
+func (api *ApiServer) ingestBulkItem(ctx context.Context, deadlineCtx context.Context, r *http.Request, nRes *EventBulkCreateRes, lineRes EventBulkLineRes, body []byte) (string, error) {
+	jsonCodec, _ := helpers.LookupCodec(helpers.ContentTypeJson)
+	var item map[string]interface{}
+	err := jsonCodec.Decode(bytes.NewReader(body), &item)
+	if err != nil {
+		lineRes.Error = err.Error()
+		nRes.reject(lineRes)
+		return "", nil
+	}
+	itemReq, err := decodeEventPayload(ctx, item)
+	if err != nil {
+		lineRes.Error = err.Error()
+		nRes.reject(lineRes)
+		return "", nil
+	}
+	lineRes.EventID = itemReq.Event.EventID
+
+	itemVal := helpers.NewValidator()
+	nEvent, err := api.newEvent(r, itemVal, &itemReq, int64(len(body)))
+	switch {
+	case err != nil:
+		lineRes.Error = err.Error()
+		nRes.reject(lineRes)
+		return "", nil
+	case !itemVal.Valid():
+		lineRes.Errors = itemVal.Errors
+		nRes.reject(lineRes)
+		return "", nil
+	}
+
+	if api.draining.Load() {
+		return bulkAbortDraining, nil
+	}
+	if api.backpressured(ctx) {
+		return bulkAbortBackpressure, nil
+	}
+	err = api.models.EventQueue.PutEvent(deadlineCtx, nEvent)
+	var duplicateError *data.DuplicateEventError
+	switch {
+	case err == nil:
+		nRes.Accepted++
+		return "", nil
+	case errors.As(err, &duplicateError):
+		lineRes.Status = batchItemStatusDuplicate
+		lineRes.Error = data.ErrDuplicateEvent.Error()
+		if !duplicateError.ReceivedAt.IsZero() {
+			lineRes.ReceivedAt = &duplicateError.ReceivedAt
+		}
+		nRes.reject(lineRes)
+		return "", nil
+	case errors.Is(err, data.ErrEventQueueFull):
+		return bulkAbortQueueFull, nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return bulkAbortDeadlineExceeded, nil
+	default:
+		return "", err
+	}
+}
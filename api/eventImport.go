@@ -0,0 +1,271 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdEventImportMaxBytes int64
+)
+
+const contentTypeMultipart = "multipart/form-data"
+
+// formats of the imported files
+const (
+	importFormatJson   = "json"   // array of events
+	importFormatNDJSON = "ndjson" // one event per line
+)
+
+/*
+EventImportFileRes is the ingestion progress of an uploaded file. line is the line of the ndjson files and the position of the event inside the array of the json files.
+*/
+type EventImportFileRes struct {
+	Field    string `json:"field"`
+	FileName string `json:"file_name"`
+	Format   string `json:"format,omitempty"`
+	Bytes    int64  `json:"bytes"` // bytes of the file read before finishing or aborting the ingestion
+	Error    string `json:"error,omitempty"`
+	EventBulkCreateRes
+}
+
+/*
+EventImportRes is the summary of the import. Files after the aborted file aren't read and should be uploaded again.
+*/
+type EventImportRes struct {
+	Files       []*EventImportFileRes `json:"files"`
+	Accepted    int                   `json:"accepted"`
+	Rejected    int                   `json:"rejected"`
+	Aborted     bool                  `json:"aborted"`
+	AbortReason string                `json:"abort_reason,omitempty"`
+}
+
+/*
+importFormat detects the format of the uploaded file by its content type and falls back to its extension
+*/
+func importFormat(contentType string, fileName string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case contentTypeNDJSON, "application/jsonl":
+		return importFormatNDJSON
+	case helpers.ContentTypeJson:
+		return importFormatJson
+	}
+	switch filepath.Ext(fileName) {
+	case ".ndjson", ".jsonl":
+		return importFormatNDJSON
+	case ".json":
+		return importFormatJson
+	}
+	return ""
+}
+
+/*
+importEventsHandler ingests the json or ndjson files uploaded as multipart/form-data for the backfills, streaming each file into the queue while it's being uploaded.
+The events have the same shape as the items of the batch creation request and each file reports its own progress.
+Ingestion is aborted once the queue is full or the deadline of the client is exceeded, the file and line to resume from are reported.
+*/
+func (api *ApiServer) importEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("importEventsHandler.Tracer").Start(r.Context(), "importEventsHandler.Span")
+	defer span.End()
+
+	// new events are not accepted anymore when the shutdown begins since they would be lost
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+	// producers are pushed back while the paused worker lets the queue fill up
+	if api.backpressured(ctx) {
+		span.SetStatus(codes.Error, "event queue reached its high water mark")
+		api.backpressureResponse(w, r)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeMultipart {
+		span.SetStatus(codes.Error, "unsupported content type")
+		api.unsupportedMediaTypeResponse(w, r, contentTypeMultipart)
+		return
+	}
+
+	deadline, err := api.requestDeadline(r, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+	deadlineCtx, cancel := withRequestDeadline(ctx, deadline)
+	defer cancel()
+
+	r.Body = http.MaxBytesReader(w, r.Body, CmdEventImportMaxBytes)
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nRes := &EventImportRes{Files: make([]*EventImportFileRes, 0)}
+	for !nRes.Aborted {
+		part, err := multipartReader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case ctx.Err() != nil:
+				span.RecordError(ctx.Err())
+				return
+			case errors.As(err, &maxBytesError) && len(nRes.Files) > 0:
+				// the events already ingested are reported since the limit is reached between the files
+				nRes.Aborted, nRes.AbortReason = true, bulkAbortBodyTooLarge
+				continue
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+		// form fields other than the files are ignored
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		fileRes, err := api.importFile(ctx, deadlineCtx, r, part.FormName(), part.FileName(), part.Header.Get("Content-Type"), part)
+		part.Close()
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			span.SetStatus(codes.Error, "failed to add the event into the queue")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+		nRes.Files = append(nRes.Files, fileRes)
+		nRes.Accepted += fileRes.Accepted
+		nRes.Rejected += fileRes.Rejected
+		nRes.Aborted, nRes.AbortReason = fileRes.Aborted, fileRes.AbortReason
+		api.Logger.Info().
+			Str("file_name", fileRes.FileName).
+			Int64("bytes", fileRes.Bytes).
+			Int("accepted", fileRes.Accepted).
+			Int("rejected", fileRes.Rejected).
+			Str("abort_reason", fileRes.AbortReason).
+			Msg("imported the events of the file")
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.files", len(nRes.Files)),
+		attribute.Int("import.accepted", nRes.Accepted),
+		attribute.Int("import.rejected", nRes.Rejected),
+		attribute.Bool("import.aborted", nRes.Aborted))
+
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+importFile ingests the events of an uploaded file with respect to its format. Files with unknown formats are reported without reading them.
+*/
+func (api *ApiServer) importFile(ctx context.Context, deadlineCtx context.Context, r *http.Request, field string, fileName string, contentType string, file io.Reader) (*EventImportFileRes, error) {
+	fileRes := &EventImportFileRes{
+		Field:              field,
+		FileName:           fileName,
+		Format:             importFormat(contentType, fileName),
+		EventBulkCreateRes: EventBulkCreateRes{Errors: make([]EventBulkLineRes, 0)},
+	}
+	counter := &countingReadCloser{ReadCloser: io.NopCloser(file)}
+	defer func() { fileRes.Bytes = counter.n }()
+
+	switch fileRes.Format {
+	case importFormatNDJSON:
+		return fileRes, api.ingestNDJSON(ctx, deadlineCtx, r, bufio.NewReader(counter), &fileRes.EventBulkCreateRes)
+	case importFormatJson:
+		return fileRes, api.ingestJsonArray(ctx, deadlineCtx, r, counter, fileRes)
+	default:
+		fileRes.Error = "unsupported file format, the file should be json or ndjson"
+		return fileRes, nil
+	}
+}
+
+/*
+ingestJsonArray ingests the events of a json array one by one while the array is being read, so the whole file isn't kept in memory.
+Malformed files are aborted at the malformed event. The errors are only returned for the failures of the server or the cancelled requests.
+*/
+func (api *ApiServer) ingestJsonArray(ctx context.Context, deadlineCtx context.Context, r *http.Request, reader io.Reader, fileRes *EventImportFileRes) error {
+	nRes := &fileRes.EventBulkCreateRes
+	decoder := json.NewDecoder(reader)
+	abortRead := func(err error) error {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &maxBytesError):
+			nRes.abort(nRes.Lines+1, bulkAbortBodyTooLarge)
+		default:
+			nRes.abort(nRes.Lines+1, bulkAbortReadError)
+			fileRes.Error = err.Error()
+		}
+		return nil
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return abortRead(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return abortRead(errors.New("file should contain an array of events"))
+	}
+	for decoder.More() {
+		var item json.RawMessage
+		err := decoder.Decode(&item)
+		if err != nil {
+			return abortRead(err)
+		}
+		nRes.Lines++
+		lineRes := EventBulkLineRes{Line: nRes.Lines, Status: batchItemStatusRejected}
+		if int64(len(item)) > helpers.CmdMaxBodyBytes {
+			lineRes.Error = fmt.Sprintf("event is larger than %d bytes", helpers.CmdMaxBodyBytes)
+			nRes.reject(lineRes)
+			continue
+		}
+
+		abortReason, err := api.ingestBulkItem(ctx, deadlineCtx, r, nRes, lineRes, item)
+		if err != nil {
+			return err
+		}
+		if abortReason != "" {
+			nRes.abort(nRes.Lines, abortReason)
+			return nil
+		}
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return abortRead(err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	eventListDefaultLimit = 100
+	eventListMaxLimit     = 1000
+)
+
+type EventListRes struct {
+	Events     []data.EventIndexEntry `json:"events"`
+	NextCursor string                 `json:"next_cursor,omitempty"` // empty if there are no more events
+}
+
+/*
+encodeEventListCursor makes the cursor opaque for the clients since the index sequence isn't part of the api
+*/
+func encodeEventListCursor(seq uint64) string {
+	if seq == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+func decodeEventListCursor(cursor string) (uint64, bool) {
+	bSeq, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(bSeq), 10, 64)
+	if err != nil || seq == 0 {
+		return 0, false
+	}
+	return seq, true
+}
+
+/*
+eventListFilter validates the filter of listing the events, get returns the value of a filter parameter or empty if it's not specified
+*/
+func eventListFilter(get func(key string) string) (data.EventIndexFilter, *helpers.Validator) {
+	nVal := helpers.NewValidator()
+	filter := data.EventIndexFilter{
+		EventType: get("event_type"),
+		Status:    get("status"),
+		Limit:     eventListDefaultLimit,
+	}
+	if filter.EventType != "" {
+		_, found := data.LookupEventType(filter.EventType)
+		nVal.Check(found, "event_type", "unknown event type")
+	}
+	if filter.Status != "" {
+		nVal.Check(helpers.In(filter.Status,
+			data.EventIndexStatusQueued,
+			data.EventIndexStatusProcessing,
+			data.EventProcessStatusSuccess,
+			data.EventProcessStatusFailed,
+			data.EventProcessStatusSkipped,
+			data.EventProcessStatusCancelled), "status", "invalid status")
+	}
+	for key, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if get(key) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, get(key))
+		nVal.Check(err == nil, key, "should be a RFC3339 timestamp")
+		*value = parsed
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() {
+		nVal.Check(filter.Since.Before(filter.Until), "since", "should be before until")
+	}
+	if get("limit") != "" {
+		limit, err := strconv.Atoi(get("limit"))
+		nVal.Check(err == nil && limit > 0 && limit <= eventListMaxLimit, "limit", "should be a number between 1 and "+strconv.Itoa(eventListMaxLimit))
+		filter.Limit = limit
+	}
+	if get("cursor") != "" {
+		cursor, ok := decodeEventListCursor(get("cursor"))
+		nVal.Check(ok, "cursor", "invalid cursor")
+		filter.Cursor = cursor
+	}
+	return filter, nVal
+}
+
+/*
+listEventsHandler lists the queued and recently processed events from the newest to the oldest enqueued one.
+Events can be filtered by event_type, status and enqueue time range using since and until, and paginated using limit and cursor.
+*/
+func (api *ApiServer) listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("listEventsHandler.Tracer").Start(r.Context(), "listEventsHandler.Span")
+	defer span.End()
+
+	if api.models.EventQueue.Index == nil {
+		span.SetStatus(codes.Error, "event index is disabled")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	filter, nVal := eventListFilter(r.URL.Query().Get)
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	events, next := api.models.EventQueue.Index.List(filter)
+	nRes := &EventListRes{Events: events, NextCursor: encodeEventListCursor(next)}
+	span.SetAttributes(attribute.Int("events.count", len(events)), attribute.Bool("events.has_more", next != 0))
+
+	err := api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
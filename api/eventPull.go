@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdEventPullMaxWait time.Duration
+)
+
+// statuses of the leased events reported by the acknowledgements
+const (
+	leaseStatusRequeued = "requeued"
+)
+
+/*
+EventLeaseRes is the event pulled by the consumer along with its lease
+*/
+type EventLeaseRes struct {
+	LeaseID   string              `json:"lease_id"`
+	Consumer  string              `json:"consumer"`
+	Delivery  int                 `json:"delivery"` // greater than 1 if the event is redelivered after a nack or the expiry of its previous lease
+	LeasedAt  time.Time           `json:"leased_at"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	Event     *data.EventSnapshot `json:"event"`
+}
+
+type EventAckReq struct {
+	Status string `json:"status"` // success or failed, defaults to success
+	Error  string `json:"error"`  // reason of the failure recorded in the dead letter
+}
+
+type EventAckRes struct {
+	LeaseID string `json:"lease_id"`
+	EventID string `json:"event_id"`
+	Status  string `json:"status"`
+}
+
+/*
+pullEventHandler leases the next event of the queue to an external consumer, so behavox can be used as a lightweight broker instead of the built-in worker.
+The request waits up to the wait query parameter for an event and responds with 204 if none is available meanwhile.
+The consumer should ack or nack the lease before the --event-lease-timeout, otherwise the event is delivered again.
+*/
+func (api *ApiServer) pullEventHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("pullEventHandler.Tracer").Start(r.Context(), "pullEventHandler.Span")
+	defer span.End()
+
+	var wait time.Duration
+	var err error
+	nVal := helpers.NewValidator()
+	if value := r.URL.Query().Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		nVal.Check(err == nil, "wait", "should be a duration, e.g. 30s")
+		nVal.Check(wait >= 0, "wait", "shouldn't be negative")
+		nVal.Check(wait <= CmdEventPullMaxWait, "wait", "shouldn't be greater than "+CmdEventPullMaxWait.String())
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+	// the write deadline of the long polls is extended, so the response is written before the server write timeout cuts the connection
+	if api.Cfg.ServerWriteTimeout > 0 {
+		err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + api.Cfg.ServerWriteTimeout))
+		if err != nil {
+			span.RecordError(err)
+			wait = min(wait, max(api.Cfg.ServerWriteTimeout-deadlineWriteMargin, 0))
+		}
+	}
+	// long polls shouldn't hold the graceful shutdown back
+	if api.draining.Load() {
+		wait = 0
+	}
+
+	consumer := r.URL.Query().Get("consumer")
+	if claims := api.getClaimsContext(r); claims != nil && consumer == "" {
+		consumer = claims.Subject
+	}
+
+	lease := api.worker.Pull(ctx, consumer, wait)
+	if ctx.Err() != nil {
+		span.RecordError(ctx.Err())
+		span.SetStatus(codes.Error, "request cancelled while waiting for an event")
+		return
+	}
+	if lease == nil {
+		span.AddEvent("no event available")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	span.SetAttributes(attribute.String("lease.id", lease.ID), attribute.String("event.id", lease.Event.GetEventID()))
+
+	snapshot, err := data.NewEventSnapshot(lease.Event)
+	if err != nil {
+		// the consumer doesn't know about the lease, so the event is given back to the queue
+		api.worker.Nack(ctx, lease.ID)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to take the snapshot of the event")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	nRes := &EventLeaseRes{
+		LeaseID:   lease.ID,
+		Consumer:  lease.Consumer,
+		Delivery:  lease.Delivery,
+		LeasedAt:  lease.LeasedAt,
+		ExpiresAt: lease.ExpiresAt,
+		Event:     snapshot,
+	}
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+ackEventHandler finishes processing of the leased event with the outcome reported by the consumer. Failed events are moved to the dead letter queue.
+*/
+func (api *ApiServer) ackEventHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("ackEventHandler.Tracer").Start(r.Context(), "ackEventHandler.Span")
+	defer span.End()
+
+	leaseID := httprouter.ParamsFromContext(r.Context()).ByName("lease_id")
+	span.SetAttributes(attribute.String("lease.id", leaseID))
+
+	// body is optional for acknowledging the successfully processed events
+	nReq := EventAckReq{Status: data.EventProcessStatusSuccess}
+	if r.ContentLength != 0 {
+		var err error
+		nReq, err = helpers.ReadRequest[EventAckReq](ctx, w, r)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+		if nReq.Status == "" {
+			nReq.Status = data.EventProcessStatusSuccess
+		}
+	}
+	nVal := helpers.NewValidator()
+	nVal.Check(helpers.In(nReq.Status, data.EventProcessStatusSuccess, data.EventProcessStatusFailed), "status", "should be either success or failed")
+	nVal.Check(nReq.Error == "" || nReq.Status == data.EventProcessStatusFailed, "error", "should only be set for the failed events")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	var processErr error
+	if nReq.Status == data.EventProcessStatusFailed {
+		processErr = errors.New(nReq.Error)
+		if nReq.Error == "" {
+			processErr = errors.New("rejected by the consumer")
+		}
+	}
+	lease, err := api.worker.Ack(ctx, leaseID, processErr)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "lease not found")
+		api.leaseNotFoundResponse(w, r)
+		return
+	}
+
+	nRes := &EventAckRes{LeaseID: lease.ID, EventID: lease.Event.GetEventID(), Status: nReq.Status}
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+nackEventHandler gives the leased event back to the queue to be delivered again
+*/
+func (api *ApiServer) nackEventHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("nackEventHandler.Tracer").Start(r.Context(), "nackEventHandler.Span")
+	defer span.End()
+
+	leaseID := httprouter.ParamsFromContext(r.Context()).ByName("lease_id")
+	span.SetAttributes(attribute.String("lease.id", leaseID))
+
+	lease, err := api.worker.Nack(ctx, leaseID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "lease not found")
+		api.leaseNotFoundResponse(w, r)
+		return
+	}
+
+	nRes := &EventAckRes{LeaseID: lease.ID, EventID: lease.Event.GetEventID(), Status: leaseStatusRequeued}
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -1,120 +1,221 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	helpers "github.com/cybrarymin/behavox/internal"
 	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
 	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
 
 type EventCreateReq struct {
 	Event struct {
-		EventType string   `json:"event_type"`
-		EventID   string   `json:"event_id"`
-		Value     *float64 `json:"value,omitempty"`
-		Level     *string  `json:"level,omitempty"`
-		Message   *string  `json:"message,omitempty"`
+		EventType string `json:"event_type"`
+		EventID   string `json:"event_id"`
+		Priority  *int   `json:"priority,omitempty"`
+		// schema version of the payload, payloads of older versions are migrated to the current version while decoding
+		SchemaVersion *int `json:"schema_version,omitempty"`
+		// ids used by the downstream consumers to stitch the related events together
+		CorrelationID *string `json:"correlation_id,omitempty"`
+		ParentEventID *string `json:"parent_event_id,omitempty"`
+		// time event is happened on the client side in RFC3339 format, server time is used if it's not specified
+		Timestamp *string `json:"timestamp,omitempty"`
+		// delivery of the event to the worker is delayed until deliver_at in RFC3339 format or for the delay duration, e.g. 10m
+		DeliverAt *string `json:"deliver_at,omitempty"`
+		Delay     *string `json:"delay,omitempty"`
+		// the worker posts the signed processing outcome of the event to the callback url
+		CallbackURL *string `json:"callback_url,omitempty"`
+		// results of the event are kept for the retention duration, e.g. 2160h, instead of the retention of the results store
+		// and regardless of both while the event is on legal hold
+		Retention *string `json:"retention,omitempty"`
+		LegalHold *bool   `json:"legal_hold,omitempty"`
+		data.EventFields
 	} `json:"event"`
 }
 
-func NewEventCreateReq(eventType string, eventID string, value *float64, level *string, message *string) *EventCreateReq {
-	return &EventCreateReq{
-		Event: struct {
-			EventType string   "json:\"event_type\""
-			EventID   string   "json:\"event_id\""
-			Value     *float64 "json:\"value,omitempty\""
-			Level     *string  "json:\"level,omitempty\""
-			Message   *string  "json:\"message,omitempty\""
-		}{
-
-			EventType: eventType,
-			EventID:   eventID,
-			Value:     value,
-			Level:     level,
-			Message:   message,
-		},
-	}
+func NewEventCreateReq(eventType string, eventID string, fields data.EventFields) *EventCreateReq {
+	nReq := &EventCreateReq{}
+	nReq.Event.EventType = eventType
+	nReq.Event.EventID = eventID
+	nReq.Event.EventFields = fields
+	return nReq
+}
+
+type EventCreateResEvent struct {
+	EventType     string     `json:"event_type"`
+	EventID       string     `json:"event_id"`
+	Priority      int        `json:"priority"`
+	SchemaVersion int        `json:"schema_version"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	ParentEventID string     `json:"parent_event_id,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+	DeliverAt     *time.Time `json:"deliver_at,omitempty"`
+	CallbackURL   string     `json:"callback_url,omitempty"`
+	Retention     string     `json:"retention,omitempty"`
+	LegalHold     bool       `json:"legal_hold,omitempty"`
+	data.EventFields
 }
 
 type EventCreateRes struct {
-	Event struct {
-		EventType string   `json:"event_type"`
-		EventID   string   `json:"event_id"`
-		Value     *float64 `json:"value,omitempty"`
-		Level     *string  `json:"level,omitempty"`
-		Message   *string  `json:"message,omitempty"`
-	} `json:"event"`
+	Event EventCreateResEvent `json:"event"`
 }
 
-func NewEventCreateRes(eventType string, eventID string, value *float64, level *string, message *string) *EventCreateRes {
-	return &EventCreateRes{
-		Event: struct {
-			EventType string   "json:\"event_type\""
-			EventID   string   "json:\"event_id\""
-			Value     *float64 "json:\"value,omitempty\""
-			Level     *string  "json:\"level,omitempty\""
-			Message   *string  "json:\"message,omitempty\""
-		}{
-			EventType: eventType,
-			EventID:   eventID,
-			Value:     value,
-			Level:     level,
-			Message:   message,
-		},
+func NewEventCreateRes(event data.Event, fields data.EventFields) *EventCreateRes {
+	nRes := &EventCreateRes{}
+	nRes.Event.EventType = event.GetEventType()
+	nRes.Event.EventID = event.GetEventID()
+	nRes.Event.Priority = event.GetPriority()
+	nRes.Event.SchemaVersion = event.GetSchemaVersion()
+	nRes.Event.CorrelationID = event.GetCorrelationID()
+	nRes.Event.ParentEventID = event.GetParentEventID()
+	nRes.Event.Timestamp = event.GetTimestamp()
+	if deliverAt := event.GetDeliverAt(); !deliverAt.IsZero() {
+		nRes.Event.DeliverAt = &deliverAt
+	}
+	nRes.Event.CallbackURL = event.GetCallbackURL()
+	if retention := event.GetRetention(); retention > 0 {
+		nRes.Event.Retention = retention.String()
 	}
+	nRes.Event.LegalHold = event.GetLegalHold()
+	nRes.Event.EventFields = fields
+	return nRes
+}
+
+// header used by the clients to propagate the correlation id when it's not specified in the event body
+const correlationIDHeader = "X-Correlation-ID"
+
+const (
+	ackModeEnqueue   = "enqueue"   // respond as soon as the event is added to the queue
+	ackModeProcessed = "processed" // hold the request until the worker finishes processing the event
+)
+
+const eventProcessStatusPending = "pending"
+
+type EventProcessRes struct {
+	Status          string     `json:"status"`
+	Md5             string     `json:"md5,omitempty"` // kept for the existing clients, only set if the digest algorithm is md5
+	Digest          string     `json:"digest,omitempty"`
+	DigestAlgorithm string     `json:"digest_algorithm,omitempty"`
+	Length          int        `json:"length,omitempty"`
+	ProcessingTime  string     `json:"processing_time,omitempty"`
+	ProcessedAt     *time.Time `json:"processed_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+func NewEventProcessRes(processed *data.ProcessedEvent) *EventProcessRes {
+	nRes := &EventProcessRes{
+		Status: processed.Status,
+	}
+	if processed.Result != nil {
+		nRes.Digest = processed.Result.Digest
+		nRes.DigestAlgorithm = processed.Result.DigestAlgorithm
+		if processed.Result.DigestAlgorithm == worker.DigestMd5 {
+			nRes.Md5 = processed.Result.Digest
+		}
+		nRes.Length = processed.Result.Length
+		nRes.ProcessingTime = processed.Result.ProcessingTime
+		nRes.ProcessedAt = &processed.Result.ProcessedAt
+	}
+	if processed.Err != nil {
+		nRes.Error = processed.Err.Error()
+	}
+	return nRes
+}
+
+/*
+EventCreateResEnvelope is the body of the event creation response.
+process_result is only set when the client asked for the processing acknowledgement.
+*/
+type EventCreateResEnvelope struct {
+	Event         *EventCreateRes  `json:"event"`
+	ProcessResult *EventProcessRes `json:"process_result,omitempty"`
+}
+
+/*
+EventCreateResV2 is the body of the v2 event creation response, the flat event along with its process_result
+*/
+type EventCreateResV2 struct {
+	EventCreateResEvent
+	ProcessResult *EventProcessRes `json:"process_result,omitempty"`
 }
 
 func (api *ApiServer) createEventHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("createEventHandler.Tracer").Start(r.Context(), "createEventHandler.Span")
 	defer span.End()
 
-	// Reading the request body
-	nReq, err := helpers.ReadJson[EventCreateReq](ctx, w, r)
+	// new events are not accepted anymore when the shutdown begins since they would be lost
+	if api.draining.Load() {
+		span.SetStatus(codes.Error, "server is shutting down")
+		api.shuttingDownResponse(w, r)
+		return
+	}
+	// producers are pushed back while the paused worker lets the queue fill up
+	if api.backpressured(ctx) {
+		span.SetStatus(codes.Error, "event queue reached its high water mark")
+		api.backpressureResponse(w, r)
+		return
+	}
+
+	deadline, err := api.requestDeadline(r, time.Now())
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "invalid input")
 		api.badRequestResponse(w, r, err)
 		return
 	}
+	// deadline of the client only applies to enqueuing and processing the event, so the response can still be written once it's exceeded
+	deadlineCtx, cancel := withRequestDeadline(ctx, deadline)
+	defer cancel()
+	if !deadline.IsZero() {
+		span.SetAttributes(attribute.String("request.deadline", deadline.Format(time.RFC3339Nano)))
+	}
 
-	// Input validation
-	nVal := helpers.NewValidator()
-	_, err = uuid.Parse(nReq.Event.EventID)
+	// Reading the request body. bytes read are counted to apply the body size limit of the event type once it's known
+	body := &countingReadCloser{ReadCloser: r.Body}
+	r.Body = body
+	var nReq EventCreateReq
+	switch {
+	case isCloudEvent(r):
+		nReq, err = api.readCloudEvent(ctx, w, r)
+	default:
+		nReq, err = readEventCreateReq(ctx, w, r)
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "invalid input")
-		api.badRequestResponse(w, r, fmt.Errorf("event_id should be a valid uuid"))
+		api.badRequestResponse(w, r, err)
 		return
 	}
-	nVal.Check(nReq.Event.EventType != "", "event_type", "shouldn't be nil")
-	validEventTypes := []string{data.EventTypeLog, data.EventTypeMetric}
-	nVal.Check(helpers.In(nReq.Event.EventType, validEventTypes...), "event_type", "invalid")
-
-	switch nReq.Event.EventType {
-	case data.EventTypeLog:
-		if nReq.Event.Value != nil {
-			api.badRequestResponse(w, r, fmt.Errorf("body contains unknown field \"value\""))
-			return
-		}
-		nVal.Check(nReq.Event.Level != nil, "level", "shouldn't be nil")
-		nVal.Check(nReq.Event.Message != nil, "message", "shouldn't be nil")
 
-	case data.EventTypeMetric:
-		switch {
-		case nReq.Event.Level != nil:
-			api.badRequestResponse(w, r, fmt.Errorf("body contains unknown field \"level\""))
-			return
-		case nReq.Event.Message != nil:
-			api.badRequestResponse(w, r, fmt.Errorf("body contains unknown field \"message\""))
-			return
-		}
-		nVal.Check(nReq.Event.Value != nil, "value", "shouldn't be nil")
+	// Input validation
+	ackMode := r.URL.Query().Get("ack")
+	if ackMode == "" {
+		ackMode = ackModeEnqueue
 	}
+	nVal := helpers.NewValidator()
+	nVal.Check(helpers.In(ackMode, ackModeEnqueue, ackModeProcessed), "ack", "invalid")
 
+	nEvent, err := api.newEvent(r, nVal, &nReq, body.n)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
 	if !nVal.Valid() {
 		for key, errString := range nVal.Errors {
 			err := fmt.Errorf("%s message %s", key, errString)
@@ -125,39 +226,86 @@ func (api *ApiServer) createEventHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var nEvent data.Event
-	switch nReq.Event.EventType {
-	case data.EventTypeLog:
-		api.Logger.Info().
-			Str("event_id", nReq.Event.EventID).
-			Str("event_type", nReq.Event.EventType).
-			Str("message", *nReq.Event.Message).
-			Str("level", *nReq.Event.Level).
-			Msg("creating new event")
-
-		nEvent = data.NewEventLog(nReq.Event.EventID, *nReq.Event.Level, *nReq.Event.Message)
-		span.AddEvent("new log event created")
-
-	case data.EventTypeMetric:
-		api.Logger.Info().
-			Str("event_id", nReq.Event.EventID).
-			Str("event_type", nReq.Event.EventType).
-			Float64("value", *nReq.Event.Value).
-			Msg("creating new event")
+	span.SetAttributes(
+		attribute.String("event.id", nEvent.GetEventID()),
+		attribute.String("event.correlation_id", nEvent.GetCorrelationID()),
+		attribute.String("event.parent_event_id", nEvent.GetParentEventID()))
+	api.Logger.Info().
+		Str("event_id", nReq.Event.EventID).
+		Str("event_type", nReq.Event.EventType).
+		Str("correlation_id", nEvent.GetCorrelationID()).
+		Str("parent_event_id", nEvent.GetParentEventID()).
+		Int("priority", nEvent.GetPriority()).
+		Interface("event_fields", nReq.Event.EventFields).
+		Msg("creating new event")
+	span.AddEvent(fmt.Sprintf("new %s event created", nEvent.GetEventType()))
 
-		nEvent = data.NewEventMetric(nReq.Event.EventID, *nReq.Event.Value)
-		span.AddEvent("new metric event created")
+	// waiter should be registered before adding the event to the queue to not miss the processing outcome
+	var processWaiter chan *data.ProcessedEvent
+	if ackMode == ackModeProcessed {
+		processWaiter = api.models.EventQueue.RegisterProcessWaiter(nEvent.GetEventID())
+		defer api.models.EventQueue.RemoveProcessWaiter(nEvent.GetEventID(), processWaiter)
 	}
 
-	err = api.models.EventQueue.PutEvent(ctx, nEvent)
+	err = api.models.EventQueue.PutEvent(deadlineCtx, nEvent)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to add new event into the queue")
-		api.eventQueueFullResponse(w, r)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			api.deadlineExceededResponse(w, r, &DeadlineExceededRes{Stage: deadlineStageEnqueue, Deadline: deadline, EventID: nEvent.GetEventID()})
+		case errors.Is(err, context.Canceled):
+			// client is already gone
+		case errors.Is(err, data.ErrDuplicateEvent):
+			api.duplicateEventResponse(w, r)
+		case errors.Is(err, data.ErrEventQueueFull):
+			api.eventQueueFullResponse(w, r)
+		default:
+			api.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	nRes := NewEventCreateRes(nEvent, nReq.Event.EventFields)
+	status := http.StatusCreated
+	var processRes *EventProcessRes
+
+	if ackMode == ackModeProcessed {
+		select {
+		case processed := <-processWaiter:
+			span.AddEvent("event processing finished")
+			processRes = NewEventProcessRes(processed)
+		case <-time.After(CmdEventAckTimeout):
+			// event is still in the queue or being processed, so we only acknowledge the acceptance of the event
+			span.AddEvent("timed out waiting for the event processing")
+			status = http.StatusAccepted
+			processRes = &EventProcessRes{Status: eventProcessStatusPending}
+		case <-deadlineCtx.Done():
+			span.RecordError(deadlineCtx.Err())
+			if !deadlineExceeded(deadlineCtx) {
+				span.SetStatus(codes.Error, "request cancelled while waiting for the event processing")
+				return
+			}
+			span.SetStatus(codes.Error, "deadline exceeded while waiting for the event processing")
+			api.deadlineExceededResponse(w, r, &DeadlineExceededRes{
+				Stage:       deadlineStageProcessing,
+				Deadline:    deadline,
+				EventID:     nEvent.GetEventID(),
+				EventStatus: api.abandonEvent(nEvent.GetEventID()),
+			})
+			return
+		}
 	}
 
-	nRes := NewEventCreateRes(nReq.Event.EventType, nReq.Event.EventID, nReq.Event.Value, nReq.Event.Level, nReq.Event.Message)
-	err = helpers.WriteJson(ctx, w, http.StatusCreated, helpers.Envelope{"event": nRes}, nil)
+	headers := make(http.Header)
+	if nEvent.GetCorrelationID() != "" {
+		headers.Set(correlationIDHeader, nEvent.GetCorrelationID())
+	}
+	var resEnvelope interface{} = &EventCreateResEnvelope{Event: nRes, ProcessResult: processRes}
+	if api.getAPIVersionContext(r) != apiVersion1 {
+		resEnvelope = &EventCreateResV2{EventCreateResEvent: nRes.Event, ProcessResult: processRes}
+	}
+	err = helpers.WriteResponse(ctx, w, r, status, resEnvelope, headers)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to write the response for the client")
@@ -166,14 +314,320 @@ func (api *ApiServer) createEventHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+/*
+abandonEvent cancels the queued event whose client isn't waiting for its processing anymore, so the client can safely retry it.
+It returns the status of the event, which is processing if it's already taken out of the queue and can't be cancelled.
+*/
+func (api *ApiServer) abandonEvent(eventID string) string {
+	// the deadline of the request is already exceeded, so the background context is used to cancel the event
+	err := api.models.EventQueue.Cancel(context.Background(), eventID, "")
+	if err == nil {
+		return data.EventProcessStatusCancelled
+	}
+	// either ErrEventProcessing or ErrEventNotQueued if the processing is finished right after the deadline
+	return data.EventIndexStatusProcessing
+}
+
+/*
+newEvent validates the event creation request and constructs the event out of it.
+bodySize is the size of the request body carrying the event, used to apply the body size limit of the event type.
+It returns an error for the malformed requests and reports the invalid inputs on the validator, in which case the returned event is nil.
+*/
+func (api *ApiServer) newEvent(r *http.Request, nVal *helpers.Validator, nReq *EventCreateReq, bodySize int64) (data.Event, error) {
+	_, err := uuid.Parse(nReq.Event.EventID)
+	if err != nil {
+		return nil, errors.New("event_id should be a valid uuid")
+	}
+	nVal.Check(nReq.Event.EventType != "", "event_type", "shouldn't be nil")
+	eventSpec, found := data.LookupEventType(nReq.Event.EventType)
+	nVal.Check(found, "event_type", "invalid")
+	if found {
+		if limit := eventSpec.BodyLimit(); limit > 0 && bodySize > limit {
+			return nil, &helpers.BodyTooLargeError{Limit: limit, EventType: eventSpec.Name}
+		}
+		err = eventSpec.CheckFields(&nReq.Event.EventFields)
+		if err != nil {
+			return nil, err
+		}
+		eventSpec.ValidateFields(nVal, &nReq.Event.EventFields)
+	}
+	if nReq.Event.Priority != nil {
+		nVal.Check(*nReq.Event.Priority >= data.EventPriorityMin && *nReq.Event.Priority <= data.EventPriorityMax,
+			"priority", fmt.Sprintf("should be between %d and %d", data.EventPriorityMin, data.EventPriorityMax))
+	}
+	if nReq.Event.CorrelationID == nil && r.Header.Get(correlationIDHeader) != "" {
+		correlationID := r.Header.Get(correlationIDHeader)
+		nReq.Event.CorrelationID = &correlationID
+	}
+	if nReq.Event.CorrelationID != nil {
+		nVal.Check(*nReq.Event.CorrelationID != "", "correlation_id", "shouldn't be empty")
+		nVal.Check(len(*nReq.Event.CorrelationID) <= 255, "correlation_id", "must not be more than 255 bytes long")
+	}
+	if nReq.Event.ParentEventID != nil {
+		_, err = uuid.Parse(*nReq.Event.ParentEventID)
+		nVal.Check(err == nil, "parent_event_id", "should be a valid uuid")
+		nVal.Check(*nReq.Event.ParentEventID != nReq.Event.EventID, "parent_event_id", "shouldn't be the same as event_id")
+	}
+	var eventTime time.Time
+	if nReq.Event.Timestamp != nil {
+		eventTime, err = time.Parse(time.RFC3339, *nReq.Event.Timestamp)
+		nVal.Check(err == nil, "timestamp", "should be in RFC3339 format")
+		if err == nil {
+			validateEventTimestamp(nVal, eventTime)
+		}
+	}
+	deliverAt := eventDeliverAt(nVal, nReq, time.Now())
+	if nReq.Event.CallbackURL != nil {
+		callbackURL, err := url.Parse(*nReq.Event.CallbackURL)
+		nVal.Check(worker.CmdCallbackSecret != "", "callback_url", "callbacks are disabled since --callback-secret isn't set")
+		if err == nil {
+			err = worker.CheckWebhookURL(r.Context(), callbackURL)
+		}
+		nVal.Check(err == nil, "callback_url", webhookURLError(err))
+	}
+	var retention time.Duration
+	if nReq.Event.Retention != nil {
+		retention, err = time.ParseDuration(*nReq.Event.Retention)
+		nVal.Check(err == nil && retention > 0, "retention", "should be a positive duration, e.g. 2160h")
+	}
+	if found && nReq.Event.SchemaVersion != nil {
+		nVal.Check(*nReq.Event.SchemaVersion == eventSpec.SchemaVersion, "schema_version", fmt.Sprintf("should be %d", eventSpec.SchemaVersion))
+	}
+	if !nVal.Valid() {
+		return nil, nil
+	}
+
+	nEvent := eventSpec.New(nReq.Event.EventID, &nReq.Event.EventFields)
+	if claims := api.getClaimsContext(r); claims != nil {
+		nEvent.SetProducer(claims.Subject)
+	}
+	if !eventTime.IsZero() {
+		nEvent.SetTimestamp(eventTime)
+	}
+	if nReq.Event.Priority != nil {
+		nEvent.SetPriority(*nReq.Event.Priority)
+	}
+	if nReq.Event.CorrelationID != nil {
+		nEvent.SetCorrelationID(*nReq.Event.CorrelationID)
+	}
+	if nReq.Event.ParentEventID != nil {
+		nEvent.SetParentEventID(*nReq.Event.ParentEventID)
+	}
+	if !deliverAt.IsZero() {
+		nEvent.SetDeliverAt(deliverAt)
+	}
+	if nReq.Event.CallbackURL != nil {
+		nEvent.SetCallbackURL(*nReq.Event.CallbackURL)
+	}
+	if retention > 0 {
+		nEvent.SetRetention(retention)
+	}
+	if nReq.Event.LegalHold != nil {
+		nEvent.SetLegalHold(*nReq.Event.LegalHold)
+	}
+	return nEvent, nil
+}
+
+/*
+eventDeliverAt validates the delivery time of the delayed event, it returns zero if the event isn't delayed.
+deliver_at in the past delivers the event as soon as it's enqueued, neither of them can be further than --event-max-delay in the future.
+*/
+func eventDeliverAt(nVal *helpers.Validator, nReq *EventCreateReq, now time.Time) time.Time {
+	var deliverAt time.Time
+	switch {
+	case nReq.Event.DeliverAt != nil && nReq.Event.Delay != nil:
+		nVal.AddError("delay", "shouldn't be used along with deliver_at")
+	case nReq.Event.DeliverAt != nil:
+		t, err := time.Parse(time.RFC3339, *nReq.Event.DeliverAt)
+		if err != nil {
+			nVal.AddError("deliver_at", "should be in RFC3339 format")
+			break
+		}
+		deliverAt = t
+		nVal.Check(t.Sub(now) <= data.CmdEventMaxDelay, "deliver_at", fmt.Sprintf("must not be more than %s in the future", data.CmdEventMaxDelay))
+	case nReq.Event.Delay != nil:
+		delay, err := time.ParseDuration(*nReq.Event.Delay)
+		if err != nil {
+			nVal.AddError("delay", "should be a valid duration, e.g. 10m")
+			break
+		}
+		nVal.Check(delay > 0, "delay", "should be greater than zero")
+		nVal.Check(delay <= data.CmdEventMaxDelay, "delay", fmt.Sprintf("must not be more than %s", data.CmdEventMaxDelay))
+		deliverAt = now.Add(delay)
+	}
+	if !deliverAt.After(now) {
+		return time.Time{}
+	}
+	return deliverAt
+}
+
+/*
+countingReadCloser counts the number of bytes read from the request body
+*/
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+/*
+validateEventTimestamp checks the client supplied timestamp is not too far in the future or past with respect to the server clock
+*/
+func validateEventTimestamp(v *helpers.Validator, t time.Time) {
+	now := time.Now()
+	if CmdEventTimestampMaxFutureSkew > 0 {
+		v.Check(!t.After(now.Add(CmdEventTimestampMaxFutureSkew)), "timestamp", fmt.Sprintf("must not be more than %s in the future", CmdEventTimestampMaxFutureSkew))
+	}
+	if CmdEventTimestampMaxAge > 0 {
+		v.Check(!t.Before(now.Add(-CmdEventTimestampMaxAge)), "timestamp", fmt.Sprintf("must not be more than %s in the past", CmdEventTimestampMaxAge))
+	}
+}
+
+/*
+readEventCreateReq reads the body of the event creation request with the codec of its content type.
+Payload of the event is migrated to the current schema version of its event type before decoding it into the EventCreateReq,
+so the producers which still send the older versions keep working.
+protobuf messages are decoded directly since their fields are numbered and not renamed between the schema versions.
+*/
+func readEventCreateReq(ctx context.Context, w http.ResponseWriter, r *http.Request) (EventCreateReq, error) {
+	ctx, span := otel.Tracer("readEventCreateReq.Tracer").Start(ctx, "readEventCreateReq.Span")
+	defer span.End()
+
+	if helpers.RequestCodec(r).ContentType() == protobufContentType {
+		return helpers.ReadRequest[EventCreateReq](ctx, w, r)
+	}
+
+	decodedReq, err := helpers.ReadRequest[struct {
+		Event map[string]interface{} `json:"event"`
+	}](ctx, w, r)
+	if err != nil {
+		return EventCreateReq{}, err
+	}
+	return decodeEventPayload(ctx, decodedReq.Event)
+}
+
+/*
+decodeEventPayload migrates the decoded payload of an event to the current schema version of its event type and decodes it into the EventCreateReq
+*/
+func decodeEventPayload(ctx context.Context, event map[string]interface{}) (EventCreateReq, error) {
+	_, span := otel.Tracer("decodeEventPayload.Tracer").Start(ctx, "decodeEventPayload.Span")
+	defer span.End()
+
+	var nReq EventCreateReq
+	var err error
+	// payload is converted to json regardless of the codec used by the client so the migrations only deal with one format
+	rawEvent := make(map[string]json.RawMessage, len(event))
+	for key, value := range event {
+		rawEvent[key], err = json.Marshal(value)
+		if err != nil {
+			return nReq, fmt.Errorf("invalid type used for the key %s", key)
+		}
+	}
+
+	var eventType string
+	if rawEventType, found := rawEvent["event_type"]; found {
+		err = json.Unmarshal(rawEventType, &eventType)
+		if err != nil {
+			return nReq, errors.New("invalid type used for the key event_type")
+		}
+	}
+	// unknown event types are left untouched to be reported by the input validation
+	if eventSpec, found := data.LookupEventType(eventType); found {
+		err = eventSpec.MigratePayload(rawEvent)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to migrate the event payload")
+			return nReq, err
+		}
+	}
+
+	jEvent, err := json.Marshal(rawEvent)
+	if err != nil {
+		return nReq, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(jEvent))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&nReq.Event)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			err = fmt.Errorf("invalid type used for the key %s", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field"):
+			err = fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to decode the event payload")
+		return nReq, err
+	}
+	return nReq, nil
+}
+
 type EventStatsGetRes struct {
-	Queue_size uint64 `json:"queue_size"`
+	Queue_size           uint64                           `json:"queue_size"`
+	QueueCapacity        int64                            `json:"queue_capacity"`
+	QueueUtilization     float64                          `json:"queue_utilization_percent"`
+	QueuedByEventType    map[string]int                   `json:"queued_by_event_type"`
+	Scheduled            int                              `json:"scheduled"` // delayed events which aren't due yet, they count towards the capacity
+	NextDeliveryAt       *time.Time                       `json:"next_delivery_at,omitempty"`
+	Processed            map[string]int64                 `json:"processed"` // number of processed events by the process status
+	ProcessedTotal       int64                            `json:"processed_total"`
+	FailedTotal          int64                            `json:"failed_total"`
+	AvgProcessingSeconds float64                          `json:"avg_processing_seconds"`
+	ByEventType          map[string]worker.EventTypeStats `json:"by_event_type"`
+	Worker               EventStatsWorker                 `json:"worker"`
+}
+
+type EventStatsWorker struct {
+	MaxThreads        int                   `json:"max_threads"`
+	PoolSize          int                   `json:"pool_size"`
+	CircuitBreaker    string                `json:"circuit_breaker,omitempty"`
+	Throttle          worker.ThrottleConfig `json:"throttle"`
+	InFlight          int64                 `json:"in_flight"`
+	InFlightByType    map[string]int        `json:"in_flight_by_type"`
+	ConcurrencyLimits map[string]int        `json:"concurrency_limits,omitempty"`
+	Utilization       float64               `json:"utilization_percent"`
+	Paused            bool                  `json:"paused"`
 }
 
-func NewEventStatsGetRes(qSize uint64) *EventStatsGetRes {
-	return &EventStatsGetRes{
-		Queue_size: qSize,
+/*
+NewEventStatsGetRes combines the snapshot of the queue with the stats collected by the worker
+*/
+func NewEventStatsGetRes(inspection *data.QueueInspection, workerStats *worker.WorkerStats) *EventStatsGetRes {
+	nRes := &EventStatsGetRes{
+		Queue_size:           uint64(inspection.Size),
+		QueueCapacity:        inspection.Capacity,
+		QueuedByEventType:    inspection.ByEventType,
+		Scheduled:            inspection.Scheduled,
+		NextDeliveryAt:       inspection.NextDeliveryAt,
+		Processed:            workerStats.Processed,
+		FailedTotal:          workerStats.Processed[data.EventProcessStatusFailed],
+		AvgProcessingSeconds: workerStats.AvgProcessingSeconds,
+		ByEventType:          workerStats.ByEventType,
+		Worker: EventStatsWorker{
+			MaxThreads:        workerStats.MaxThreads,
+			PoolSize:          workerStats.PoolSize,
+			CircuitBreaker:    workerStats.CircuitBreaker,
+			Throttle:          workerStats.Throttle,
+			InFlight:          workerStats.InFlight,
+			InFlightByType:    workerStats.InFlightByType,
+			ConcurrencyLimits: workerStats.ConcurrencyLimits,
+			Utilization:       workerStats.Utilization,
+			Paused:            workerStats.Paused,
+		},
+	}
+	if inspection.Capacity > 0 {
+		nRes.QueueUtilization = float64(inspection.Size+inspection.Scheduled) * 100 / float64(inspection.Capacity)
 	}
+	for _, count := range workerStats.Processed {
+		nRes.ProcessedTotal += count
+	}
+	return nRes
 }
 
 func (api *ApiServer) GetEventStatsHandler(w http.ResponseWriter, r *http.Request) {
@@ -182,15 +636,71 @@ func (api *ApiServer) GetEventStatsHandler(w http.ResponseWriter, r *http.Reques
 
 	// Send a request to the Queue service to get response
 
-	queueCurrentSize := api.models.EventQueue.Size(ctx)
+	inspection := api.models.EventQueue.Inspect(ctx)
 
 	api.Logger.Info().
-		Int64("queue_size", int64(queueCurrentSize)).
+		Int64("queue_size", int64(inspection.Size)).
 		Str("remote_addr", r.RemoteAddr).
 		Msg("fetched the event queue size")
 
-	nRes := NewEventStatsGetRes(uint64(queueCurrentSize))
-	err := helpers.WriteJson(ctx, w, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	nRes := NewEventStatsGetRes(inspection, api.worker.Stats())
+	err := helpers.WriteConditionalResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+type EventCancelRes struct {
+	EventID string `json:"event_id"`
+	Status  string `json:"status"`
+}
+
+/*
+cancelEventHandler cancels an event which is still inside the queue, so the worker skips it instead of processing it.
+Events which are already taken by the worker can't be cancelled anymore. Producers can only cancel their own events unless they're granted the admin scope.
+*/
+func (api *ApiServer) cancelEventHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("cancelEventHandler.Tracer").Start(r.Context(), "cancelEventHandler.Span")
+	defer span.End()
+
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	span.SetAttributes(attribute.String("event.id", eventID))
+	if _, err := uuid.Parse(eventID); err != nil {
+		span.SetStatus(codes.Error, "invalid event id")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	producer := ""
+	if claims := api.getClaimsContext(r); claims != nil && !claims.hasScope(scopeAdmin) {
+		producer = claims.Subject
+	}
+	err := api.models.EventQueue.Cancel(ctx, eventID, producer)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrEventProcessing):
+			span.SetStatus(codes.Error, "event is already being processed")
+			api.eventProcessingResponse(w, r)
+		case errors.Is(err, data.ErrEventNotQueued):
+			span.SetStatus(codes.Error, "event not found inside the queue")
+			api.notFoundResponse(w, r)
+		default:
+			span.SetStatus(codes.Error, "failed to cancel the event")
+			api.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	api.Logger.Info().
+		Str("event_id", eventID).
+		Str("request_id", api.getReqIDContext(r)).
+		Msg("cancelled the queued event")
+
+	nRes := &EventCancelRes{EventID: eventID, Status: data.EventProcessStatusCancelled}
+	err = api.writeResult(ctx, w, r, http.StatusOK, nRes, nil)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to write the response for the client")
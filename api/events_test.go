@@ -0,0 +1,164 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestCancelEventOwnership(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	api.models.DeadLetterQueue = data.NewDeadLetterQueue()
+	tokens := make(map[string]string)
+	for user, scope := range map[string]string{"alice": scopeEventsWrite, "bob": scopeEventsWrite, CmdApiAdmin: scopeEventsWrite + " " + scopeAdmin} {
+		nRes, err := api.issueTokens(t.Context(), user, uuid.NewString(), scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[user] = nRes.Token
+	}
+	events := make(map[string]string)
+	for _, name := range []string{"first", "second"} {
+		event := data.NewEventLog(uuid.NewString(), "info", "message")
+		event.SetProducer("alice")
+		err := api.models.EventQueue.PutEvent(t.Context(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events[name] = event.GetEventID()
+	}
+	handler := api.routes()
+
+	// steps are run in order on the same queue
+	tests := []struct {
+		name     string
+		user     string
+		event    string
+		wantCode int
+	}{
+		{name: "other producers can't cancel the event", user: "bob", event: "first", wantCode: http.StatusNotFound},
+		{name: "producer cancels its own event", user: "alice", event: "first", wantCode: http.StatusOK},
+		{name: "admin cancels the events of the other producers", user: CmdApiAdmin, event: "second", wantCode: http.StatusOK},
+		{name: "cancelled event isn't disclosed to the other producers", user: "bob", event: "second", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodDelete, "/v1/events/"+events[tt.event], "")
+			r.Header.Set("Authorization", "Bearer "+tokens[tt.user])
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestEventActionRoutes(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	api.models.DeadLetterQueue = data.NewDeadLetterQueue()
+	nRes, err := api.issueTokens(t.Context(), "alice", uuid.NewString(), scopeEventsWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.routes()
+	eventID := uuid.NewString()
+
+	// the static routes and the leases are dispatched from the routes of the event ids, each action requires its own scope
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{
+		{name: "batch", target: "/v1/events/batch", wantCode: http.StatusBadRequest},
+		{name: "bulk of v2", target: "/v2/events/bulk", wantCode: http.StatusUnsupportedMediaType},
+		{name: "unknown action", target: "/v1/events/unknown", wantCode: http.StatusNotFound},
+		{name: "verify", target: "/v1/events/" + eventID + "/verify", wantCode: http.StatusForbidden},
+		{name: "verify isn't served by v2", target: "/v2/events/" + eventID + "/verify", wantCode: http.StatusNotFound},
+		{name: "unknown action of an event", target: "/v1/events/" + eventID + "/replay", wantCode: http.StatusNotFound},
+		{name: "ack", target: "/v1/events/leases/" + eventID + "/ack", wantCode: http.StatusForbidden},
+		{name: "nack of v2", target: "/v2/events/leases/" + eventID + "/nack", wantCode: http.StatusForbidden},
+		{name: "unknown action of a lease", target: "/v1/events/leases/" + eventID + "/renew", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodPost, tt.target, "")
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+nRes.Token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateEventRetention(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	api.models.DeadLetterQueue = data.NewDeadLetterQueue()
+	nRes, err := api.issueTokens(t.Context(), "alice", uuid.NewString(), scopeEventsWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.routes()
+
+	tests := []struct {
+		name          string
+		fields        string
+		wantCode      int
+		wantRetention time.Duration
+		wantLegalHold bool
+	}{
+		{name: "retention of the results store", wantCode: http.StatusCreated},
+		{name: "retention of the event", fields: `, "retention": "2160h"`, wantCode: http.StatusCreated, wantRetention: 2160 * time.Hour},
+		{name: "legal hold", fields: `, "retention": "1h", "legal_hold": true`, wantCode: http.StatusCreated, wantRetention: time.Hour, wantLegalHold: true},
+		{name: "negative retention", fields: `, "retention": "-1h"`, wantCode: http.StatusUnprocessableEntity},
+		{name: "invalid retention", fields: `, "retention": "90 days"`, wantCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventID := uuid.NewString()
+			body := fmt.Sprintf(`{"event": {"event_type": "log", "event_id": "%s", "level": "info", "message": "retained"%s}}`, eventID, tt.fields)
+			r := newTestRequest(api, http.MethodPost, "/v1/events", body)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+nRes.Token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+			// each case takes its own event out of the queue
+			event := api.models.EventQueue.GetEvent(t.Context())
+			if event == nil || event.GetEventID() != eventID {
+				t.Fatalf("event %s isn't enqueued", eventID)
+			}
+			if event.GetRetention() != tt.wantRetention || event.GetLegalHold() != tt.wantLegalHold {
+				t.Errorf("retention, legal hold = %s, %t, want %s, %t", event.GetRetention(), event.GetLegalHold(), tt.wantRetention, tt.wantLegalHold)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/graph-gophers/graphql-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// queries nested deeper than this are rejected before being executed
+const graphQLMaxDepth = 8
+
+/*
+graphQLSchema exposes the events, their results and the queue stats over graphql.
+Fields are named after the json fields of the rest api, events and stats which have a dynamic shape are returned as JSON.
+*/
+const graphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar JSON
+
+type Query {
+	stats: JSON!
+	events(event_type: String, status: String, since: String, until: String, limit: Int, cursor: String): EventList!
+	results(event_id: ID!): [JSON!]!
+}
+
+type Mutation {
+	createEvent(event: JSON!): CreatedEvent!
+}
+
+type EventList {
+	events: [Event!]!
+	next_cursor: String
+}
+
+type Event {
+	event_id: ID!
+	event_type: String!
+	producer: String
+	priority: Int!
+	batch_id: String
+	correlation_id: String
+	status: String!
+	error: String
+	enqueued_at: String!
+	started_at: String
+	processed_at: String
+}
+
+type CreatedEvent {
+	event_id: ID!
+	event_type: String!
+	priority: Int!
+	schema_version: Int!
+	correlation_id: String
+	parent_event_id: String
+	timestamp: String!
+	deliver_at: String
+	callback_url: String
+	retention: String
+	legal_hold: Boolean!
+	fields: JSON!
+}
+`
+
+type GraphQLReq struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+/*
+graphqlHandler executes the graphql queries and mutations. The errors of the fields are reported in the errors of the response
+along with the machine readable code of the v2 errors in their extensions, so the response status is 200 unless the request itself is malformed.
+*/
+func (api *ApiServer) graphqlHandler() http.HandlerFunc {
+	schema := graphql.MustParseSchema(graphQLSchema, &graphQLResolver{api: api}, graphql.UseFieldResolvers(), graphql.MaxDepth(graphQLMaxDepth))
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("graphqlHandler.Tracer").Start(r.Context(), "graphqlHandler.Span")
+		defer span.End()
+
+		nReq, err := helpers.ReadJson[GraphQLReq](ctx, w, r)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+		nVal := helpers.NewValidator()
+		nVal.Check(nReq.Query != "", "query", "shouldn't be empty")
+		if !nVal.Valid() {
+			span.SetStatus(codes.Error, "invalid input")
+			api.failedValidationResponse(w, r, nVal.Errors)
+			return
+		}
+
+		span.SetAttributes(attribute.String("graphql.operation_name", nReq.OperationName))
+		nRes := schema.Exec(context.WithValue(ctx, GraphQLRequestContextKey, r), nReq.Query, nReq.OperationName, nReq.Variables)
+		span.SetAttributes(attribute.Int("graphql.errors", len(nRes.Errors)))
+		e := helpers.Envelope{"data": nRes.Data}
+		if len(nRes.Errors) > 0 {
+			span.SetStatus(codes.Error, "graphql request has errors")
+			e["errors"] = nRes.Errors
+		}
+		err = helpers.WriteJson(ctx, w, http.StatusOK, e, nil)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to write the response for the client")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+}
+
+/*
+graphQLError is the error of a graphql field, code is one of the machine readable codes of the v2 errors
+*/
+type graphQLError struct {
+	code    string
+	message string
+	details interface{}
+}
+
+func (e *graphQLError) Error() string { return e.message }
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code}
+	if e.details != nil {
+		extensions["details"] = e.details
+	}
+	return extensions
+}
+
+/*
+graphQLJSON is the JSON scalar of the schema, it carries any json value
+*/
+type graphQLJSON struct {
+	value interface{}
+}
+
+func (graphQLJSON) ImplementsGraphQLType(name string) bool { return name == "JSON" }
+
+func (j *graphQLJSON) UnmarshalGraphQL(input interface{}) error {
+	j.value = input
+	return nil
+}
+
+func (j graphQLJSON) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.value)
+}
+
+type graphQLEventList struct {
+	Events     []*graphQLEvent
+	NextCursor *string
+}
+
+type graphQLEvent struct {
+	EventID       graphql.ID
+	EventType     string
+	Producer      *string
+	Priority      int32
+	BatchID       *string
+	CorrelationID *string
+	Status        string
+	Error         *string
+	EnqueuedAt    string
+	StartedAt     *string
+	ProcessedAt   *string
+}
+
+func newGraphQLEvent(entry data.EventIndexEntry) *graphQLEvent {
+	return &graphQLEvent{
+		EventID:       graphql.ID(entry.EventID),
+		EventType:     entry.EventType,
+		Producer:      graphQLString(entry.Producer),
+		Priority:      int32(entry.Priority),
+		BatchID:       graphQLString(entry.BatchID),
+		CorrelationID: graphQLString(entry.CorrelationID),
+		Status:        entry.Status,
+		Error:         graphQLString(entry.Error),
+		EnqueuedAt:    entry.EnqueuedAt.Format(time.RFC3339Nano),
+		StartedAt:     graphQLTime(entry.StartedAt),
+		ProcessedAt:   graphQLTime(entry.ProcessedAt),
+	}
+}
+
+type graphQLCreatedEvent struct {
+	EventID       graphql.ID
+	EventType     string
+	Priority      int32
+	SchemaVersion int32
+	CorrelationID *string
+	ParentEventID *string
+	Timestamp     string
+	DeliverAt     *string
+	CallbackURL   *string
+	Retention     *string
+	LegalHold     bool
+	Fields        graphQLJSON
+}
+
+func newGraphQLCreatedEvent(nRes *EventCreateRes) *graphQLCreatedEvent {
+	return &graphQLCreatedEvent{
+		EventID:       graphql.ID(nRes.Event.EventID),
+		EventType:     nRes.Event.EventType,
+		Priority:      int32(nRes.Event.Priority),
+		SchemaVersion: int32(nRes.Event.SchemaVersion),
+		CorrelationID: graphQLString(nRes.Event.CorrelationID),
+		ParentEventID: graphQLString(nRes.Event.ParentEventID),
+		Timestamp:     nRes.Event.Timestamp.Format(time.RFC3339Nano),
+		DeliverAt:     graphQLTime(nRes.Event.DeliverAt),
+		CallbackURL:   graphQLString(nRes.Event.CallbackURL),
+		Retention:     graphQLString(nRes.Event.Retention),
+		LegalHold:     nRes.Event.LegalHold,
+		Fields:        graphQLJSON{value: nRes.Event.EventFields},
+	}
+}
+
+// graphQLString returns nil for the empty strings, so they're null like the omitted fields of the rest api
+func graphQLString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func graphQLTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(time.RFC3339Nano)
+	return &s
+}
+
+/*
+graphQLResolver resolves the fields of the root query and mutation types
+*/
+type graphQLResolver struct {
+	api *ApiServer
+}
+
+/*
+authorize returns the http request of the graphql query if its token is granted the scope required by the field
+*/
+func (res *graphQLResolver) authorize(ctx context.Context, scope string) (*http.Request, error) {
+	r := ctx.Value(GraphQLRequestContextKey).(*http.Request)
+	claims := res.api.getClaimsContext(r)
+	if claims == nil || !claims.hasScope(scope) {
+		return nil, &graphQLError{code: errorCodeInsufficientScope, message: fmt.Sprintf("the token isn't granted the %s scope required by this field", scope)}
+	}
+	return r, nil
+}
+
+/*
+Stats returns the stats of the queue and the worker like GET /v1/stats, so it doesn't require any scope
+*/
+func (res *graphQLResolver) Stats(ctx context.Context) graphQLJSON {
+	return graphQLJSON{value: NewEventStatsGetRes(res.api.models.EventQueue.Inspect(ctx), res.api.worker.Stats())}
+}
+
+func (res *graphQLResolver) Events(ctx context.Context, args struct {
+	EventType *string
+	Status    *string
+	Since     *string
+	Until     *string
+	Limit     *int32
+	Cursor    *string
+}) (*graphQLEventList, error) {
+	_, err := res.authorize(ctx, scopeEventsRead)
+	if err != nil {
+		return nil, err
+	}
+	if res.api.models.EventQueue.Index == nil {
+		return nil, &graphQLError{code: errorCodeNotFound, message: "event index is disabled"}
+	}
+
+	params := map[string]*string{"event_type": args.EventType, "status": args.Status, "since": args.Since, "until": args.Until, "cursor": args.Cursor}
+	filter, nVal := eventListFilter(func(key string) string {
+		if key == "limit" && args.Limit != nil {
+			return strconv.Itoa(int(*args.Limit))
+		}
+		if value := params[key]; value != nil {
+			return *value
+		}
+		return ""
+	})
+	if !nVal.Valid() {
+		return nil, &graphQLError{code: errorCodeValidationFailed, message: "the request contains invalid fields", details: nVal.Errors}
+	}
+
+	entries, next := res.api.models.EventQueue.Index.List(filter)
+	nRes := &graphQLEventList{Events: make([]*graphQLEvent, 0, len(entries)), NextCursor: graphQLString(encodeEventListCursor(next))}
+	for _, entry := range entries {
+		nRes.Events = append(nRes.Events, newGraphQLEvent(entry))
+	}
+	return nRes, nil
+}
+
+/*
+Results returns the results of the event stored by the result sinks, it's empty if the event isn't processed yet
+*/
+func (res *graphQLResolver) Results(ctx context.Context, args struct{ EventID graphql.ID }) ([]graphQLJSON, error) {
+	_, err := res.authorize(ctx, scopeResultsRead)
+	if err != nil {
+		return nil, err
+	}
+	results, err := worker.LookupResults(ctx, string(args.EventID))
+	if err != nil {
+		if errors.Is(err, worker.ErrResultNotFound) {
+			return []graphQLJSON{}, nil
+		}
+		return nil, &graphQLError{code: errorCodeInternal, message: "the server encountered an error to process the request"}
+	}
+	nRes := make([]graphQLJSON, 0, len(results))
+	for _, result := range results {
+		nRes = append(nRes, graphQLJSON{value: result})
+	}
+	return nRes, nil
+}
+
+/*
+CreateEvent enqueues the event like POST /v1/events, the event is the same object as the event of its body
+*/
+func (res *graphQLResolver) CreateEvent(ctx context.Context, args struct{ Event graphQLJSON }) (*graphQLCreatedEvent, error) {
+	ctx, span := otel.Tracer("graphQLCreateEvent.Tracer").Start(ctx, "graphQLCreateEvent.Span")
+	defer span.End()
+
+	r, err := res.authorize(ctx, scopeEventsWrite)
+	if err != nil {
+		span.SetStatus(codes.Error, "insufficient scope")
+		return nil, err
+	}
+	switch {
+	case CmdReadOnly:
+		return nil, &graphQLError{code: errorCodeReadOnly, message: "the server is running in read-only mode and doesn't accept any writes"}
+	case res.api.draining.Load():
+		return nil, &graphQLError{code: errorCodeDraining, message: "service unavailable, server is draining and doesn't accept new events"}
+	case res.api.backpressured(ctx):
+		return nil, &graphQLError{code: errorCodeBackpressure, message: "service unavailable, worker is paused and the event queue reached its high water mark"}
+	}
+
+	event, ok := args.Event.value.(map[string]interface{})
+	if !ok {
+		return nil, &graphQLError{code: errorCodeBadRequest, message: "event should be an object"}
+	}
+	// size of the event is the size of its json encoding since it's not sent as a body of its own
+	bEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, &graphQLError{code: errorCodeBadRequest, message: err.Error()}
+	}
+	nReq, err := decodeEventPayload(ctx, event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		return nil, &graphQLError{code: errorCodeBadRequest, message: err.Error()}
+	}
+	nVal := helpers.NewValidator()
+	nEvent, err := res.api.newEvent(r, nVal, &nReq, int64(len(bEvent)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		return nil, &graphQLError{code: errorCodeBadRequest, message: err.Error()}
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		return nil, &graphQLError{code: errorCodeValidationFailed, message: "the request contains invalid fields", details: nVal.Errors}
+	}
+	span.SetAttributes(attribute.String("event.id", nEvent.GetEventID()))
+
+	err = res.api.models.EventQueue.PutEvent(ctx, nEvent)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to add new event into the queue")
+		switch {
+		case errors.Is(err, data.ErrDuplicateEvent):
+			return nil, &graphQLError{code: errorCodeDuplicateEvent, message: "an event with the same event_id is already received"}
+		case errors.Is(err, data.ErrEventQueueFull):
+			return nil, &graphQLError{code: errorCodeQueueFull, message: "service unavailable, event queue is already full"}
+		default:
+			res.api.Logger.Error().Err(err).Str("event_id", nEvent.GetEventID()).Msg("failed to add the graphql event into the queue")
+			return nil, &graphQLError{code: errorCodeInternal, message: "the server encountered an error to process the request"}
+		}
+	}
+	return newGraphQLCreatedEvent(NewEventCreateRes(nEvent, nReq.Event.EventFields)), nil
+}
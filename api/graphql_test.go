@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+func TestGraphqlHandler(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	data.CmdEventDedupWindow, data.CmdEventDedupSize = time.Minute, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+		data.CmdEventDedupWindow, data.CmdEventDedupSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	handler := api.graphqlHandler()
+
+	createEvent := `mutation($event: JSON!) { createEvent(event: $event) { event_id event_type priority fields } }`
+	// cases are run in order, so the events created by the earlier cases are listed by the later ones
+	tests := []struct {
+		name      string
+		scope     string
+		body      string
+		wantCode  int
+		wantError string // code in the extensions of the graphql error
+		wantData  string
+	}{
+		{name: "event is created", scope: "events:write", wantCode: http.StatusOK,
+			body:     `{"query": "` + createEvent + `", "variables": {"event": {"event_type": "metric", "event_id": "f47ac10b-58cc-4372-a567-0e02b2c3d479", "priority": 7, "value": 1.5}}}`,
+			wantData: `{"createEvent":{"event_id":"f47ac10b-58cc-4372-a567-0e02b2c3d479","event_type":"metric","priority":7,"fields":{"value":1.5}}}`},
+		{name: "duplicate event is rejected", scope: "events:write", wantCode: http.StatusOK, wantError: errorCodeDuplicateEvent,
+			body: `{"query": "` + createEvent + `", "variables": {"event": {"event_type": "metric", "event_id": "f47ac10b-58cc-4372-a567-0e02b2c3d479", "value": 1.5}}}`},
+		{name: "invalid event fails the validation", scope: "events:write", wantCode: http.StatusOK, wantError: errorCodeValidationFailed,
+			body: `{"query": "` + createEvent + `", "variables": {"event": {"event_type": "metric", "event_id": "0d9c1a52-5d2a-4a8e-b8a7-5f5c0e8e2f11"}}}`},
+		{name: "unknown field of the event is rejected", scope: "events:write", wantCode: http.StatusOK, wantError: errorCodeBadRequest,
+			body: `{"query": "` + createEvent + `", "variables": {"event": {"event_type": "metric", "event_id": "0d9c1a52-5d2a-4a8e-b8a7-5f5c0e8e2f11", "value": 1, "unknown": 1}}}`},
+		{name: "events are created by the events:write scope", scope: "events:read", wantCode: http.StatusOK, wantError: errorCodeInsufficientScope,
+			body: `{"query": "` + createEvent + `", "variables": {"event": {"event_type": "metric", "event_id": "0d9c1a52-5d2a-4a8e-b8a7-5f5c0e8e2f11", "value": 1}}}`},
+		{name: "events are listed", scope: "events:read", wantCode: http.StatusOK,
+			body:     `{"query": "{ events(event_type: \"metric\", limit: 10) { events { event_id status batch_id } next_cursor } }"}`,
+			wantData: `{"events":{"events":[{"event_id":"f47ac10b-58cc-4372-a567-0e02b2c3d479","status":"queued","batch_id":null}],"next_cursor":null}}`},
+		{name: "invalid filter fails the validation", scope: "events:read", wantCode: http.StatusOK, wantError: errorCodeValidationFailed,
+			body: `{"query": "{ events(limit: 0) { next_cursor } }"}`},
+		{name: "events are listed by the events:read scope", scope: "results:read", wantCode: http.StatusOK, wantError: errorCodeInsufficientScope,
+			body: `{"query": "{ events { next_cursor } }"}`},
+		{name: "results are looked up by the results:read scope", scope: "events:read", wantCode: http.StatusOK, wantError: errorCodeInsufficientScope,
+			body: `{"query": "{ results(event_id: \"f47ac10b-58cc-4372-a567-0e02b2c3d479\") }"}`},
+		{name: "malformed query is reported in the errors", scope: "events:read", wantCode: http.StatusOK,
+			body: `{"query": "{ events {"}`},
+		{name: "empty query is rejected", scope: "events:read", wantCode: http.StatusUnprocessableEntity,
+			body: `{"query": ""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodPost, "/v1/graphql", tt.body)
+			r.Header.Set("Content-Type", "application/json")
+			r = api.setClaimsContext(r, &customClaims{Scope: tt.scope})
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var res struct {
+				Data   json.RawMessage `json:"data"`
+				Errors []struct {
+					Message    string `json:"message"`
+					Extensions struct {
+						Code string `json:"code"`
+					} `json:"extensions"`
+				} `json:"errors"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantError != "":
+				if len(res.Errors) != 1 || res.Errors[0].Extensions.Code != tt.wantError {
+					t.Errorf("errors = %s, want the %s error", w.Body.String(), tt.wantError)
+				}
+			case tt.wantData != "":
+				if len(res.Errors) > 0 || string(res.Data) != tt.wantData {
+					t.Errorf("response = %s, want the data %s", w.Body.String(), tt.wantData)
+				}
+			default:
+				if len(res.Errors) == 0 || !strings.Contains(res.Errors[0].Message, "syntax error") {
+					t.Errorf("response = %s, want a syntax error", w.Body.String())
+				}
+			}
+		})
+	}
+}
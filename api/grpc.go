@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	eventpb "github.com/cybrarymin/behavox/api/proto"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	CmdGRPCListenAddr        string
+	CmdGRPCIngestAckInterval time.Duration
+)
+
+/*
+grpcIngestServer serves the EventIngest service of proto/events.proto, the producers stream their events and the server acks them periodically
+with the utilization of the queue, so they can slow down before the queue is full instead of discovering it by the rejections.
+*/
+type grpcIngestServer struct {
+	eventpb.UnimplementedEventIngestServer
+	api *ApiServer
+}
+
+/*
+newGRPCServer returns the server of the grpc listener, it's served over the tls of the main listener if listen-addr is https.
+The messages are limited to max-body-bytes like the bodies of the http requests.
+*/
+func (api *ApiServer) newGRPCServer() (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(int(helpers.CmdMaxBodyBytes))}
+	if api.Cfg.ListenAddr.Scheme == "https" {
+		creds, err := credentials.NewServerTLSFromFile(api.Cfg.TlsCertFile, api.Cfg.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	eventpb.RegisterEventIngestServer(srv, &grpcIngestServer{api: api})
+	return srv, nil
+}
+
+/*
+stopGRPCServer waits for the streams to be finished by the producers, they're cut once the context is done
+*/
+func stopGRPCServer(ctx context.Context, srv *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
+
+/*
+grpcRequest authenticates the stream like JWTAuth and returns the request carrying its claims, so the events are created by the same validation
+as the http requests. The clients are filtered by the ip filter on the full method name of the stream.
+*/
+func (api *ApiServer) grpcRequest(ctx context.Context) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, eventpb.EventIngest_Ingest_FullMethodName, nil)
+	if err != nil {
+		return nil, status.Error(grpcCodes.Internal, "the server encountered an error to process the request")
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range []string{"Authorization", correlationIDHeader} {
+		if values := md.Get(header); len(values) > 0 {
+			r.Header.Set(header, values[0])
+		}
+	}
+	r = api.setReqIDContext(r)
+
+	if api.ipFilter != nil && api.ipFilter.filters(r.URL.Path) {
+		addr, err := netip.ParseAddr(clientIP(r))
+		if err != nil || !api.ipFilter.allowed(addr) {
+			if err == nil {
+				err = errors.New("client network isn't allowed")
+			}
+			api.auditAuthFailure(r, "", "ip_filter", err)
+			return nil, status.Error(grpcCodes.PermissionDenied, "the client network isn't allowed to access this resource")
+		}
+	}
+
+	jToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || jToken == "" {
+		err := errors.New("invalid auth header format")
+		api.auditAuthFailure(r, "", "auth.jwt", err)
+		return nil, status.Error(grpcCodes.Unauthenticated, "invalid authentication credentials")
+	}
+	claims, err := api.verifyBearerToken(r, jToken)
+	if err != nil {
+		return nil, status.Error(grpcCodes.Unauthenticated, "invalid authentication credentials")
+	}
+	if !claims.hasScope(scopeEventsWrite) {
+		return nil, status.Errorf(grpcCodes.PermissionDenied, "the token isn't granted the %s scope required by this resource", scopeEventsWrite)
+	}
+	return api.setClaimsContext(r, claims), nil
+}
+
+/*
+Ingest enqueues the events of the stream and sends an ack every grpc-ingest-ack-interval and once the producer closes its side of the stream.
+The stream is ended with the unavailable status when the server begins draining, the events it received afterwards are rejected in the last ack.
+*/
+func (s *grpcIngestServer) Ingest(stream eventpb.EventIngest_IngestServer) error {
+	ctx, span := otel.Tracer("grpcIngest.Tracer").Start(stream.Context(), "grpcIngest.Span")
+	defer span.End()
+
+	r, err := s.api.grpcRequest(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed grpc authentication")
+		return err
+	}
+	if CmdReadOnly {
+		span.SetStatus(codes.Error, "server is read-only")
+		return status.Error(grpcCodes.FailedPrecondition, "the server is running in read-only mode and doesn't accept any writes")
+	}
+	span.SetAttributes(attribute.String("claims.subject", s.api.getClaimsContext(r).Subject))
+
+	// messages are received apart from the acks so the acks are still sent while the producer is idle
+	msgs := make(chan *eventpb.EventCreateRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ack := &eventpb.IngestAck{}
+	ticker := time.NewTicker(CmdGRPCIngestAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			if s.api.draining.Load() {
+				ack.Rejected++
+				ack.Rejections = append(ack.Rejections, &eventpb.IngestRejection{EventId: msg.GetEvent().GetEventId(), Code: errorCodeDraining,
+					Message: "service unavailable, server is draining and doesn't accept new events"})
+				return s.api.endDrainingStream(ctx, stream, ack)
+			}
+			rejection := s.api.ingestEvent(ctx, r, msg)
+			if rejection != nil {
+				ack.Rejected++
+				ack.Rejections = append(ack.Rejections, rejection)
+				continue
+			}
+			ack.Accepted++
+		case <-ticker.C:
+			if s.api.draining.Load() {
+				return s.api.endDrainingStream(ctx, stream, ack)
+			}
+			err := s.api.sendIngestAck(ctx, stream, ack)
+			if err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			return s.api.sendIngestAck(ctx, stream, ack)
+		}
+	}
+}
+
+func (api *ApiServer) endDrainingStream(ctx context.Context, stream eventpb.EventIngest_IngestServer, ack *eventpb.IngestAck) error {
+	err := api.sendIngestAck(ctx, stream, ack)
+	if err != nil {
+		return err
+	}
+	return status.Error(grpcCodes.Unavailable, "service unavailable, server is draining and doesn't accept new events")
+}
+
+/*
+sendIngestAck sends the ack with the current utilization of the queue, the rejections are only sent once
+*/
+func (api *ApiServer) sendIngestAck(ctx context.Context, stream eventpb.EventIngest_IngestServer, ack *eventpb.IngestAck) error {
+	eq := api.models.EventQueue
+	ack.QueueSize = int64(eq.Size(ctx) + eq.Scheduled())
+	ack.QueueCapacity = eq.Capacity
+	ack.QueueUtilization = 0
+	if eq.Capacity > 0 {
+		ack.QueueUtilization = float64(ack.QueueSize) / float64(eq.Capacity)
+	}
+	ack.Backpressure = api.backpressured(ctx)
+	err := stream.Send(ack)
+	ack.Rejections = nil
+	return err
+}
+
+/*
+ingestEvent enqueues the event of the message like POST /v1/events and returns the rejection if it isn't accepted.
+The rejections carry the error codes of the v2 api.
+*/
+func (api *ApiServer) ingestEvent(ctx context.Context, r *http.Request, msg *eventpb.EventCreateRequest) *eventpb.IngestRejection {
+	ctx, span := otel.Tracer("grpcIngestEvent.Tracer").Start(ctx, "grpcIngestEvent.Span")
+	defer span.End()
+
+	rejection := &eventpb.IngestRejection{EventId: msg.GetEvent().GetEventId()}
+	reject := func(code string, message string) *eventpb.IngestRejection {
+		span.SetStatus(codes.Error, message)
+		rejection.Code, rejection.Message = code, message
+		return rejection
+	}
+	if api.backpressured(ctx) {
+		return reject(errorCodeBackpressure, "service unavailable, worker is paused and the event queue reached its high water mark")
+	}
+
+	var nReq EventCreateReq
+	err := nReq.fromProto(msg)
+	if err != nil {
+		span.RecordError(err)
+		return reject(errorCodeBadRequest, err.Error())
+	}
+	nVal := helpers.NewValidator()
+	nEvent, err := api.newEvent(r, nVal, &nReq, int64(proto.Size(msg)))
+	if err != nil {
+		span.RecordError(err)
+		return reject(errorCodeBadRequest, err.Error())
+	}
+	if !nVal.Valid() {
+		rejection.Details = nVal.Errors
+		return reject(errorCodeValidationFailed, "the request contains invalid fields")
+	}
+	span.SetAttributes(attribute.String("event.id", nEvent.GetEventID()))
+	api.Logger.Info().
+		Str("event_id", nReq.Event.EventID).
+		Str("event_type", nReq.Event.EventType).
+		Str("correlation_id", nEvent.GetCorrelationID()).
+		Msg("creating new event from the grpc stream")
+
+	err = api.models.EventQueue.PutEvent(ctx, nEvent)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateEvent):
+			return reject(errorCodeDuplicateEvent, "an event with the same event_id is already received")
+		case errors.Is(err, data.ErrEventQueueFull):
+			return reject(errorCodeQueueFull, "service unavailable, event queue is already full")
+		default:
+			api.Logger.Error().Err(err).Str("event_id", nEvent.GetEventID()).Msg("failed to add the grpc event into the queue")
+			return reject(errorCodeInternal, "the server encountered an error to process the request")
+		}
+	}
+	return nil
+}
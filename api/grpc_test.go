@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	eventpb "github.com/cybrarymin/behavox/api/proto"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+newTestGRPCClient serves the grpc server of the api on an in-memory listener and returns a client connected to it
+*/
+func newTestGRPCClient(t *testing.T, api *ApiServer) eventpb.EventIngestClient {
+	api.Cfg.ListenAddr = &url.URL{Scheme: "http", Host: "localhost"}
+	srv, err := api.newGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return eventpb.NewEventIngestClient(conn)
+}
+
+func newTestLogEvent(eventID string) *eventpb.EventCreateRequest {
+	return &eventpb.EventCreateRequest{Event: &eventpb.Event{EventType: "log", EventId: eventID, Level: proto.String("info"), Message: proto.String("streamed")}}
+}
+
+func TestGRPCIngestAuth(t *testing.T) {
+	api := newTestApiServer(t)
+	client := newTestGRPCClient(t, api)
+	nRes, err := api.issueTokens(t.Context(), "alice", uuid.NewString(), scopeEventsRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      grpcCodes.Code
+	}{
+		{name: "missing token", wantCode: grpcCodes.Unauthenticated},
+		{name: "invalid token", authorization: "Bearer invalid", wantCode: grpcCodes.Unauthenticated},
+		{name: "token without the events:write scope", authorization: "Bearer " + nRes.Token, wantCode: grpcCodes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			if tt.authorization != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.authorization)
+			}
+			stream, err := client.Ingest(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = stream.Recv()
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Recv() = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGRPCIngest(t *testing.T) {
+	api := newTestApiServer(t)
+	data.CmdEventQueueSize, data.CmdEventIndexSize, CmdGRPCIngestAckInterval = 10, 10, time.Hour
+	data.CmdEventDedupWindow, data.CmdEventDedupSize = time.Hour, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize, CmdGRPCIngestAckInterval = 0, 0, 0
+		data.CmdEventDedupWindow, data.CmdEventDedupSize = 0, 0
+	})
+	api.models.EventQueue = data.NewEventQueue()
+	client := newTestGRPCClient(t, api)
+	nRes, err := api.issueTokens(t.Context(), "alice", uuid.NewString(), scopeEventsWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.Ingest(metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer "+nRes.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventID := uuid.NewString()
+	invalid := newTestLogEvent(uuid.NewString())
+	invalid.Event.Message = nil
+	for _, msg := range []*eventpb.EventCreateRequest{newTestLogEvent(eventID), newTestLogEvent(uuid.NewString()), newTestLogEvent(eventID),
+		invalid, newTestLogEvent("not-a-uuid")} {
+		err := stream.Send(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last ack is sent once the stream is closed by the producer
+	ack, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.GetAccepted() != 2 || ack.GetRejected() != 3 {
+		t.Errorf("accepted, rejected = %d, %d, want 2, 3", ack.GetAccepted(), ack.GetRejected())
+	}
+	wantCodes := []string{errorCodeDuplicateEvent, errorCodeValidationFailed, errorCodeBadRequest}
+	if len(ack.GetRejections()) != len(wantCodes) {
+		t.Fatalf("rejections = %v, want the codes %v", ack.GetRejections(), wantCodes)
+	}
+	for i, rejection := range ack.GetRejections() {
+		if rejection.GetCode() != wantCodes[i] {
+			t.Errorf("rejection %d = %s, want %s", i, rejection.GetCode(), wantCodes[i])
+		}
+	}
+	if ack.GetRejections()[0].GetEventId() != eventID || ack.GetRejections()[1].GetDetails()["message"] == "" {
+		t.Errorf("rejections don't carry the event id and the invalid fields: %v", ack.GetRejections())
+	}
+	if ack.GetQueueSize() != 2 || ack.GetQueueCapacity() != 10 || ack.GetQueueUtilization() != 0.2 || ack.GetBackpressure() {
+		t.Errorf("queue size, capacity, utilization, backpressure = %d, %d, %f, %t, want 2, 10, 0.2, false",
+			ack.GetQueueSize(), ack.GetQueueCapacity(), ack.GetQueueUtilization(), ack.GetBackpressure())
+	}
+	_, err = stream.Recv()
+	if err != io.EOF {
+		t.Errorf("Recv() = %v, want the end of the stream", err)
+	}
+}
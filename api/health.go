@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	healthStatusOk       = "ok"
+	healthStatusDraining = "draining"
+)
+
+/*
+healthzHandler reports the liveness of the server
+*/
+func (api *ApiServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("healthzHandler.Tracer").Start(r.Context(), "healthzHandler.Span")
+	defer span.End()
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"status": healthStatusOk}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+readyzHandler reports whether the server is ready to receive new traffic.
+It starts failing as soon as the shutdown begins so the load balancers stop routing new requests to the server
+while the in progress ones are still served during the drain grace period.
+*/
+func (api *ApiServer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("readyzHandler.Tracer").Start(r.Context(), "readyzHandler.Span")
+	defer span.End()
+
+	status, res := http.StatusOK, healthStatusOk
+	if api.unready.Load() {
+		status, res = http.StatusServiceUnavailable, healthStatusDraining
+	}
+
+	err := helpers.WriteResponse(ctx, w, r, status, helpers.Envelope{"status": res}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+drainConnections asks the clients to close their keep-alive connections during the drain grace period,
+so they reconnect through the load balancer to the other servers instead of facing connection resets after the listener closes.
+*/
+func (api *ApiServer) drainConnections(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.unready.Load() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdHMACKeys         map[string]string
+	CmdHMACReplayWindow time.Duration
+)
+
+// headers of the hmac signed requests
+const (
+	hmacKeyIDHeader     = "X-Behavox-Key-Id"
+	hmacTimestampHeader = "X-Behavox-Timestamp" // unix time of signing the request in seconds
+	hmacSignatureHeader = "X-Behavox-Signature" // sha256=<hex encoded hmac-sha256>
+	hmacSignaturePrefix = "sha256="
+)
+
+var (
+	ErrHMACUnknownKey       = errors.New("unknown hmac key id")
+	ErrHMACInvalidSignature = errors.New("invalid hmac signature")
+	ErrHMACStaleTimestamp   = errors.New("timestamp of the signed request is outside of the replay window")
+	ErrHMACReplayed         = errors.New("signed request is already received")
+)
+
+/*
+hmacVerifier verifies the requests signed by the shared secrets of the producers which can't store the jwt tokens securely, e.g. the webhooks of the third parties.
+The signature is the hmac-sha256 of "<timestamp>.<method>.<request uri>.<body>", the request uri is the path along with the query string,
+so a signed request can't be sent to another endpoint or with other query parameters.
+Requests are only accepted within the replay window of their timestamp and each signature is accepted once.
+*/
+type hmacVerifier struct {
+	keys     map[string][]byte
+	window   time.Duration
+	mu       sync.Mutex
+	seen     map[string]time.Time // signatures of the accepted requests until they're out of the replay window
+	expiries seenSignatureHeap    // signatures of the seen map ordered by their expiry
+}
+
+type seenSignature struct {
+	signature string
+	expiresAt time.Time
+}
+
+/*
+seenSignatureHeap implements heap.Interface ordering the accepted signatures by the time they're out of the replay window
+*/
+type seenSignatureHeap []seenSignature
+
+func (h seenSignatureHeap) Len() int           { return len(h) }
+func (h seenSignatureHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h seenSignatureHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *seenSignatureHeap) Push(x any)        { *h = append(*h, x.(seenSignature)) }
+func (h *seenSignatureHeap) Pop() any {
+	old := *h
+	seen := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return seen
+}
+
+/*
+newHMACVerifier returns nil if no keys are configured, which disables the hmac signed requests
+*/
+func newHMACVerifier(keys map[string]string, window time.Duration) *hmacVerifier {
+	if len(keys) == 0 {
+		return nil
+	}
+	hv := &hmacVerifier{
+		keys:   make(map[string][]byte, len(keys)),
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+	for keyID, secret := range keys {
+		hv.keys[keyID] = []byte(secret)
+	}
+	return hv
+}
+
+/*
+hmacSignature signs the request with the secret, it's also the reference implementation for the producers
+*/
+func hmacSignature(secret []byte, timestamp string, method string, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%s.%s.", timestamp, method, requestURI)
+	mac.Write(body)
+	return hmacSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+verify checks the signature and the timestamp of the signed request, body is the raw body sent by the producer
+*/
+func (hv *hmacVerifier) verify(r *http.Request, body []byte, now time.Time) error {
+	keyID := r.Header.Get(hmacKeyIDHeader)
+	secret, found := hv.keys[keyID]
+	if !found {
+		return ErrHMACUnknownKey
+	}
+	timestamp := r.Header.Get(hmacTimestampHeader)
+	unixTime, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrHMACStaleTimestamp
+	}
+	signedAt := time.Unix(unixTime, 0)
+	if signedAt.Before(now.Add(-hv.window)) || signedAt.After(now.Add(hv.window)) {
+		return ErrHMACStaleTimestamp
+	}
+	signature := r.Header.Get(hmacSignatureHeader)
+	expected := hmacSignature(secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrHMACInvalidSignature
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	for hv.expiries.Len() > 0 && now.After(hv.expiries[0].expiresAt) {
+		delete(hv.seen, heap.Pop(&hv.expiries).(seenSignature).signature)
+	}
+	if _, replayed := hv.seen[signature]; replayed {
+		return ErrHMACReplayed
+	}
+	// the signature can't be accepted after its timestamp is out of the window anyway
+	expiresAt := signedAt.Add(hv.window)
+	hv.seen[signature] = expiresAt
+	heap.Push(&hv.expiries, seenSignature{signature: signature, expiresAt: expiresAt})
+	return nil
+}
+
+/*
+hmacAuth authenticates the requests carrying the hmac signature headers as the producer of the key id.
+The producers are only granted the events:write scope and JWTAuth accepts the authenticated requests without a token.
+Signed bodies are buffered to be verified before they're read by the handlers, so they're limited to --max-body-bytes.
+*/
+func (api *ApiServer) hmacAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.hmac == nil || r.Header.Get(hmacSignatureHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := otel.Tracer("hmacAuth.Tracer").Start(r.Context(), "hmacAuth.Span")
+		defer span.End()
+		r = r.WithContext(ctx)
+		keyID := r.Header.Get(hmacKeyIDHeader)
+		span.SetAttributes(attribute.String("hmac.key_id", keyID))
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, helpers.CmdMaxBodyBytes))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to read the signed body")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+		err = api.hmac.verify(r, body, time.Now())
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed hmac authentication")
+			api.auditAuthFailure(r, keyID, "auth.hmac", err)
+			api.invalidSignatureResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		claims := &customClaims{
+			Scope:            scopeEventsWrite,
+			RegisteredClaims: jwt.RegisteredClaims{Subject: keyID},
+		}
+		next.ServeHTTP(w, api.setClaimsContext(r, claims))
+	})
+}
+
+/*
+hmacAuthenticated reports whether the request is already authenticated by its hmac signature
+*/
+func (api *ApiServer) hmacAuthenticated(r *http.Request) bool {
+	return api.hmac != nil && r.Header.Get(hmacSignatureHeader) != "" && api.getClaimsContext(r) != nil
+}
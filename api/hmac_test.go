@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+newTestSignedRequest returns the request signed by the secret at the given time
+*/
+func newTestSignedRequest(api *ApiServer, keyID string, secret string, signedAt time.Time, target string, body string) *http.Request {
+	r := newTestRequest(api, http.MethodPost, target, body)
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	r.Header.Set(hmacKeyIDHeader, keyID)
+	r.Header.Set(hmacTimestampHeader, timestamp)
+	r.Header.Set(hmacSignatureHeader, hmacSignature([]byte(secret), timestamp, http.MethodPost, r.URL.RequestURI(), []byte(body)))
+	return r
+}
+
+func TestHMACAuth(t *testing.T) {
+	api := newTestApiServer(t)
+	api.hmac = newHMACVerifier(map[string]string{"partner": "partner-secret"}, time.Minute)
+	body := `{"event_type": "log", "level": "info", "message": "signed"}`
+	handler := api.hmacAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := api.getClaimsContext(r)
+		read, _ := io.ReadAll(r.Body)
+		if claims == nil || claims.Subject != "partner" || !claims.hasScope(scopeEventsWrite) || claims.hasScope(scopeAdmin) {
+			t.Errorf("signed request is authenticated by the claims %+v", claims)
+		}
+		if string(read) != body {
+			t.Errorf("body = %s, want %s", read, body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	replayed := newTestSignedRequest(api, "partner", "partner-secret", time.Now(), "/v1/events", body)
+
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		wantCode int
+	}{
+		{name: "signed request", request: func() *http.Request {
+			return newTestSignedRequest(api, "partner", "partner-secret", time.Now(), "/v1/events?dry_run=true", body)
+		}, wantCode: http.StatusNoContent},
+		{name: "first request of the replayed signature", request: func() *http.Request { return replayed.Clone(replayed.Context()) },
+			wantCode: http.StatusNoContent},
+		{name: "replayed signature", request: func() *http.Request { return replayed.Clone(replayed.Context()) },
+			wantCode: http.StatusUnauthorized},
+		{name: "unknown key id", request: func() *http.Request {
+			return newTestSignedRequest(api, "unknown", "partner-secret", time.Now(), "/v1/events", body)
+		}, wantCode: http.StatusUnauthorized},
+		{name: "another secret", request: func() *http.Request {
+			return newTestSignedRequest(api, "partner", "other-secret", time.Now(), "/v1/events", body)
+		}, wantCode: http.StatusUnauthorized},
+		{name: "stale timestamp", request: func() *http.Request {
+			return newTestSignedRequest(api, "partner", "partner-secret", time.Now().Add(-2*time.Minute), "/v1/events", body)
+		}, wantCode: http.StatusUnauthorized},
+		{name: "tampered query string", request: func() *http.Request {
+			r := newTestSignedRequest(api, "partner", "partner-secret", time.Now(), "/v1/events?dry_run=true", body)
+			r.URL.RawQuery = "dry_run=false"
+			return r
+		}, wantCode: http.StatusUnauthorized},
+		{name: "tampered path", request: func() *http.Request {
+			r := newTestSignedRequest(api, "partner", "partner-secret", time.Now(), "/v1/events", body)
+			r.URL.Path = "/v1/subscriptions"
+			return r
+		}, wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.request()
+			r.Body = io.NopCloser(strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHMACVerifySeenExpiry(t *testing.T) {
+	api := newTestApiServer(t)
+	hv := newHMACVerifier(map[string]string{"partner": "partner-secret"}, time.Minute)
+	start := time.Now()
+
+	// steps are run in order on the same verifier
+	tests := []struct {
+		name     string
+		signedAt time.Time
+		now      time.Time
+		wantErr  error
+		wantSeen int
+	}{
+		{name: "first signature", signedAt: start, now: start, wantSeen: 1},
+		{name: "second signature", signedAt: start.Add(time.Second), now: start.Add(time.Second), wantSeen: 2},
+		{name: "replay within the window", signedAt: start, now: start.Add(30 * time.Second), wantErr: ErrHMACReplayed, wantSeen: 2},
+		{name: "signatures out of the window are forgotten", signedAt: start.Add(90 * time.Second), now: start.Add(90 * time.Second), wantSeen: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestSignedRequest(api, "partner", "partner-secret", tt.signedAt, "/v1/events", "")
+			err := hv.verify(r, nil, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verify() = %v, want %v", err, tt.wantErr)
+			}
+			if len(hv.seen) != tt.wantSeen || hv.expiries.Len() != tt.wantSeen {
+				t.Errorf("seen signatures = %d, %d, want %d", len(hv.seen), hv.expiries.Len(), tt.wantSeen)
+			}
+		})
+	}
+}
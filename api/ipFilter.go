@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdIPAllow       []string
+	CmdIPDeny        []string
+	CmdIPFilterPaths []string
+)
+
+/*
+ipFilter restricts the clients calling the filtered paths by their network. Denied networks are rejected first,
+then the clients outside of the allowed networks are rejected unless no allowed network is configured.
+*/
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	paths []string // path prefixes the filter applies to, all the paths are filtered if empty
+}
+
+/*
+parseIPPrefixes parses the CIDRs, single addresses are accepted as the networks of their own
+*/
+func parseIPPrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip address or cidr %s", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip address or cidr %s", value)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked())
+	}
+	return prefixes, nil
+}
+
+/*
+newIPFilter returns nil if neither allowed nor denied networks are configured, the networks should be validated by parseIPPrefixes beforehand
+*/
+func newIPFilter(allow []string, deny []string, paths []string) *ipFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	allowPrefixes, _ := parseIPPrefixes(allow)
+	denyPrefixes, _ := parseIPPrefixes(deny)
+	return &ipFilter{allow: allowPrefixes, deny: denyPrefixes, paths: paths}
+}
+
+func (f *ipFilter) filters(path string) bool {
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, prefix := range f.paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+allowed reports whether the client address may call the filtered paths
+*/
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range f.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ipFilterHandler rejects the clients which aren't allowed by the ip filter before they're authenticated, e.g. to restrict the admin and token endpoints to the internal networks.
+The client address is the remote address of the connection.
+*/
+func (api *ApiServer) ipFilterHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.ipFilter == nil || !api.ipFilter.filters(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, span := otel.Tracer("ipFilter.Tracer").Start(r.Context(), "ipFilter.Span")
+		ip := clientIP(r)
+		span.SetAttributes(attribute.String("http.client_ip", ip))
+
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !api.ipFilter.allowed(addr) {
+			if err == nil {
+				err = errors.New("client network isn't allowed")
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "client network isn't allowed")
+			span.End()
+			api.auditAuthFailure(r, "", "ip_filter", err)
+			api.ipForbiddenResponse(w, r)
+			return
+		}
+		span.End()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIPPrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "cidrs are masked", values: []string{"10.1.2.3/8", "2001:db8::1/32"}, want: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{name: "single addresses are their own networks", values: []string{" 192.0.2.1", "::1"}, want: []string{"192.0.2.1/32", "::1/128"}},
+		{name: "ipv4 mapped addresses are unmapped", values: []string{"::ffff:192.0.2.1"}, want: []string{"192.0.2.1/32"}},
+		{name: "invalid address", values: []string{"192.0.2"}, wantErr: true},
+		{name: "invalid cidr", values: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIPPrefixes(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIPPrefixes() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseIPPrefixes() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("parseIPPrefixes() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPFilterHandler(t *testing.T) {
+	api := newTestApiServer(t)
+	handler := api.ipFilterHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name       string
+		filter     *ipFilter
+		path       string
+		remoteAddr string
+		wantCode   int
+	}{
+		{name: "clients aren't filtered without the networks", path: "/v1/tokens", remoteAddr: "192.0.2.1:40000", wantCode: http.StatusNoContent},
+		{name: "allowed network", filter: newIPFilter([]string{"10.0.0.0/8"}, nil, nil), path: "/v1/tokens",
+			remoteAddr: "10.1.2.3:40000", wantCode: http.StatusNoContent},
+		{name: "outside of the allowed networks", filter: newIPFilter([]string{"10.0.0.0/8"}, nil, nil), path: "/v1/tokens",
+			remoteAddr: "192.0.2.1:40000", wantCode: http.StatusForbidden},
+		{name: "denied network is rejected first", filter: newIPFilter([]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, nil), path: "/v1/tokens",
+			remoteAddr: "10.1.2.3:40000", wantCode: http.StatusForbidden},
+		{name: "denied network only", filter: newIPFilter(nil, []string{"192.0.2.0/24"}, nil), path: "/v1/tokens",
+			remoteAddr: "198.51.100.1:40000", wantCode: http.StatusNoContent},
+		{name: "ipv4 mapped client address", filter: newIPFilter([]string{"10.0.0.0/8"}, nil, nil), path: "/v1/tokens",
+			remoteAddr: "[::ffff:10.1.2.3]:40000", wantCode: http.StatusNoContent},
+		{name: "invalid client address", filter: newIPFilter(nil, []string{"192.0.2.0/24"}, nil), path: "/v1/tokens",
+			remoteAddr: "pipe", wantCode: http.StatusForbidden},
+		{name: "filtered path", filter: newIPFilter([]string{"10.0.0.0/8"}, nil, []string{"/v1/admin/", "/v1/tokens"}), path: "/v1/admin/config",
+			remoteAddr: "192.0.2.1:40000", wantCode: http.StatusForbidden},
+		{name: "unfiltered path", filter: newIPFilter([]string{"10.0.0.0/8"}, nil, []string{"/v1/admin/", "/v1/tokens"}), path: "/v1/events",
+			remoteAddr: "192.0.2.1:40000", wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.ipFilter = tt.filter
+			r := newTestRequest(api, http.MethodGet, tt.path, "")
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
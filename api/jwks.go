@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdJwtSigningKeyFile   string
+	CmdJwtPublicKeyFiles   []string
+	CmdJwtHS256AcceptUntil string // RFC3339 time until the tokens signed by the jwkey are still accepted along with the signing key
+)
+
+// seconds the verifiers may cache the published keys, the previous keys should be kept published at least as long after the rotation
+const jwksCacheMaxAge = 300
+
+var (
+	ErrUnknownJwtSigningKey = errors.New("signing key of the token isn't known")
+)
+
+/*
+jwtSigner signs the access tokens by an RSA or EC private key instead of the shared jwkey, so the other services can verify them by the public keys of /.well-known/jwks.json.
+The public keys of the previous signing keys are still accepted and published while the tokens signed by them are alive.
+*/
+type jwtSigner struct {
+	method     jwt.SigningMethod
+	key        crypto.Signer
+	kid        string
+	publicKeys map[string]crypto.PublicKey // by kid, including the current signing key
+	jwks       []jsonWebKey
+}
+
+/*
+newJwtSigner returns nil if no signing key is configured, which keeps signing the access tokens by the jwkey
+*/
+func newJwtSigner() (*jwtSigner, error) {
+	if CmdJwtSigningKeyFile == "" {
+		return nil, nil
+	}
+	key, err := readPrivateKey(CmdJwtSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	method, err := signingMethodOf(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	signer := &jwtSigner{method: method, key: key, publicKeys: make(map[string]crypto.PublicKey)}
+	signer.kid, err = signer.addPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range CmdJwtPublicKeyFiles {
+		publicKey, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		_, err = signer.addPublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", file, err)
+		}
+	}
+	return signer, nil
+}
+
+/*
+addPublicKey publishes the public key under its RFC 7638 thumbprint and returns the thumbprint as its kid
+*/
+func (s *jwtSigner) addPublicKey(key crypto.PublicKey) (string, error) {
+	method, err := signingMethodOf(key)
+	if err != nil {
+		return "", err
+	}
+	jwk := jsonWebKey{Use: "sig", Alg: method.Alg()}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	}
+	// members of the thumbprint are the required members of the key in the lexicographic order
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	thumbprint := sha256.Sum256(encoded)
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	if _, found := s.publicKeys[jwk.Kid]; !found {
+		s.publicKeys[jwk.Kid] = key
+		s.jwks = append(s.jwks, jwk)
+	}
+	return jwk.Kid, nil
+}
+
+/*
+signingMethodOf returns the algorithm of signing by the key, RS256 for the RSA keys and the ECDSA algorithm of the curve for the EC keys
+*/
+func signingMethodOf(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("rsa signing keys should be at least 2048 bits long")
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only the rsa and ec keys are supported", key)
+	}
+}
+
+/*
+readPrivateKey reads the PEM encoded PKCS#1, SEC 1 or PKCS#8 private key
+*/
+func readPrivateKey(file string) (crypto.Signer, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no pem encoded key is found in %s", file)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %s", file)
+	}
+	return signer, nil
+}
+
+/*
+readPublicKey reads the PEM encoded PKIX public key
+*/
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no pem encoded key is found in %s", file)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", file, err)
+	}
+	return key, nil
+}
+
+/*
+signAccessToken signs the access token by the signing key if it's configured and by the jwkey otherwise
+*/
+func (api *ApiServer) signAccessToken(claims jwt.Claims) (string, error) {
+	if api.signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(CmdJwtKey))
+	}
+	token := jwt.NewWithClaims(api.signer.method, claims)
+	token.Header["kid"] = api.signer.kid
+	return token.SignedString(api.signer.key)
+}
+
+/*
+jwkeyAccepted reports whether the tokens signed by the jwkey are accepted. Once the signing key is configured they're rejected,
+unless jwt-hs256-accept-until is set to migrate the clients holding the tokens issued before the signing key until then.
+*/
+func (api *ApiServer) jwkeyAccepted(now time.Time) bool {
+	if api.signer == nil {
+		return true
+	}
+	if CmdJwtHS256AcceptUntil == "" {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339, CmdJwtHS256AcceptUntil)
+	return err == nil && now.Before(until)
+}
+
+/*
+accessTokenKey returns the key verifying the access token, by the jwkey if it's accepted or the published keys of the signing key
+*/
+func (api *ApiServer) accessTokenKey(t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if !api.jwkeyAccepted(time.Now()) {
+			return nil, ErrUnknownJwtSigningKey
+		}
+		return []byte(CmdJwtKey), nil
+	}
+	if api.signer == nil {
+		return nil, ErrUnknownJwtSigningKey
+	}
+	kid, _ := t.Header["kid"].(string)
+	key, found := api.signer.publicKeys[kid]
+	if !found {
+		return nil, ErrUnknownJwtSigningKey
+	}
+	return key, nil
+}
+
+/*
+accessTokenParserOptions are the options of verifying the access tokens, which accepts the algorithms of the published keys and HS256 if the jwkey is accepted
+*/
+func (api *ApiServer) accessTokenParserOptions() []jwt.ParserOption {
+	methods := make([]string, 0)
+	if api.jwkeyAccepted(time.Now()) {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if api.signer != nil {
+		for _, jwk := range api.signer.jwks {
+			if !helpers.In(jwk.Alg, methods...) {
+				methods = append(methods, jwk.Alg)
+			}
+		}
+	}
+	return append(jwtParserOptions(), jwt.WithValidMethods(methods))
+}
+
+/*
+jwksHandler publishes the public keys verifying the access tokens, it's only served when the tokens are signed by a private key
+*/
+func (api *ApiServer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("jwksHandler.Tracer").Start(r.Context(), "jwksHandler.Span")
+	defer span.End()
+
+	if api.signer == nil {
+		api.notFoundResponse(w, r)
+		return
+	}
+	span.SetAttributes(attribute.Int("jwks.keys", len(api.signer.jwks)))
+	headers := make(http.Header)
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksCacheMaxAge))
+	err := helpers.WriteJson(ctx, w, http.StatusOK, helpers.Envelope{"keys": api.signer.jwks}, headers)
+	if err != nil {
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+/*
+setTestSigningKey configures an ec signing key of the access tokens and restores the jwkey signing at the end of the test
+*/
+func setTestSigningKey(t *testing.T, api *ApiServer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CmdJwtSigningKeyFile = filepath.Join(t.TempDir(), "signing.pem")
+	t.Cleanup(func() {
+		CmdJwtSigningKeyFile, CmdJwtHS256AcceptUntil = "", ""
+	})
+	err = os.WriteFile(CmdJwtSigningKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.signer, err = newJwtSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTAuthSigningKey(t *testing.T) {
+	api := newTestApiServer(t)
+	jwkeyToken, err := api.issueTokens(t.Context(), CmdApiAdmin, uuid.NewString(), scopeEventsRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestSigningKey(t, api)
+	signedToken, err := api.issueTokens(t.Context(), CmdApiAdmin, uuid.NewString(), scopeEventsRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method, _, _ := jwt.NewParser().ParseUnverified(signedToken.Token, &customClaims{}); method.Method.Alg() != "ES256" {
+		t.Fatalf("access token is signed by %s, want ES256", method.Method.Alg())
+	}
+	handler := api.JWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		acceptUntil string
+		wantCode    int
+	}{
+		{name: "token of the signing key", token: signedToken.Token, wantCode: http.StatusNoContent},
+		{name: "jwkey token is rejected", token: jwkeyToken.Token, wantCode: http.StatusUnauthorized},
+		{name: "jwkey token is accepted during the migration", token: jwkeyToken.Token,
+			acceptUntil: time.Now().Add(time.Hour).Format(time.RFC3339), wantCode: http.StatusNoContent},
+		{name: "jwkey token is rejected after the migration", token: jwkeyToken.Token,
+			acceptUntil: time.Now().Add(-time.Minute).Format(time.RFC3339), wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CmdJwtHS256AcceptUntil = tt.acceptUntil
+			r := newTestRequest(api, http.MethodGet, "/v1/events", "")
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdLdapURL            string
+	CmdLdapBindDN         string
+	CmdLdapBindPassword   string
+	CmdLdapSearchBase     string
+	CmdLdapUserAttribute  string
+	CmdLdapUserFilter     string
+	CmdLdapRequiredGroup  string
+	CmdLdapAdminGroup     string
+	CmdLdapStartTLS       bool
+	CmdLdapCAFile         string
+	CmdLdapRequestTimeout time.Duration
+)
+
+var (
+	ErrLdapInvalidCredentials = errors.New("invalid ldap credentials")
+	ErrLdapUserNotFound       = errors.New("ldap user isn't found or isn't a member of the required group")
+	ErrLdapCleartextBind      = errors.New("ldap:// urls require starttls, the binds would be sent in cleartext")
+)
+
+// attribute listing the groups of the users on active directory and on openldap of the memberof overlay
+const ldapGroupAttribute = "memberOf"
+
+/*
+ldapUser is the directory entry of an authenticated or looked up user
+*/
+type ldapUser struct {
+	dn     string
+	groups []string
+}
+
+/*
+memberOf reports whether the user is a direct member of the group, the dns are compared case insensitively like the directory does
+*/
+func (u *ldapUser) memberOf(group string) bool {
+	for _, g := range u.groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ldapAuthenticator validates the basic authentication credentials against an LDAP or Active Directory server.
+The user is searched by the service account under the search base, restricted to the entries matching the user filter and
+the members of the required group, and its own dn is bound with the password.
+Binds are only sent over ldaps:// or the ldap:// connections upgraded by StartTLS.
+*/
+type ldapAuthenticator struct {
+	url            *url.URL
+	bindDN         string
+	bindPassword   string
+	searchBase     string
+	userAttribute  string
+	userFilter     string
+	requiredGroup  string
+	adminGroup     string
+	startTLS       bool
+	tlsConfig      *tls.Config
+	requestTimeout time.Duration
+}
+
+/*
+newLdapAuthenticator returns nil if no ldap url is configured, which only accepts the static admin credentials
+*/
+func newLdapAuthenticator() (*ldapAuthenticator, error) {
+	if CmdLdapURL == "" {
+		return nil, nil
+	}
+	ldapURL, err := url.Parse(CmdLdapURL)
+	if err != nil {
+		return nil, err
+	}
+	if ldapURL.Scheme != "ldaps" && !CmdLdapStartTLS {
+		return nil, ErrLdapCleartextBind
+	}
+	tlsConfig := &tls.Config{ServerName: ldapURL.Hostname(), MinVersion: tls.VersionTLS12}
+	if CmdLdapCAFile != "" {
+		pem, err := os.ReadFile(CmdLdapCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in %s", CmdLdapCAFile)
+		}
+	}
+	return &ldapAuthenticator{
+		url:            ldapURL,
+		bindDN:         CmdLdapBindDN,
+		bindPassword:   CmdLdapBindPassword,
+		searchBase:     CmdLdapSearchBase,
+		userAttribute:  CmdLdapUserAttribute,
+		userFilter:     CmdLdapUserFilter,
+		requiredGroup:  CmdLdapRequiredGroup,
+		adminGroup:     CmdLdapAdminGroup,
+		startTLS:       CmdLdapStartTLS,
+		tlsConfig:      tlsConfig,
+		requestTimeout: CmdLdapRequestTimeout,
+	}, nil
+}
+
+/*
+authenticate validates the password of the user. It returns ErrLdapInvalidCredentials or ErrLdapUserNotFound for the rejected credentials.
+*/
+func (la *ldapAuthenticator) authenticate(ctx context.Context, user string, password string) (*ldapUser, error) {
+	ctx, span := otel.Tracer("ldapAuthenticator.Authenticate.Tracer").Start(ctx, "ldapAuthenticator.Authenticate.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("ldap.user", user))
+	// binding with an empty password is an unauthenticated bind which always succeeds
+	if password == "" {
+		return nil, ErrLdapInvalidCredentials
+	}
+
+	conn, err := la.dial(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to connect to the ldap server")
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := la.findUser(conn, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find the ldap user")
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("ldap.user_dn", entry.dn))
+	err = conn.Bind(entry.dn, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			err = ErrLdapInvalidCredentials
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed ldap authentication")
+		return nil, err
+	}
+	return entry, nil
+}
+
+/*
+lookup returns the user if it still exists and matches the user filter and the required group, e.g. before refreshing its tokens
+*/
+func (la *ldapAuthenticator) lookup(ctx context.Context, user string) (*ldapUser, error) {
+	ctx, span := otel.Tracer("ldapAuthenticator.Lookup.Tracer").Start(ctx, "ldapAuthenticator.Lookup.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("ldap.user", user))
+
+	conn, err := la.dial(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to connect to the ldap server")
+		return nil, err
+	}
+	defer conn.Close()
+	entry, err := la.findUser(conn, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find the ldap user")
+		return nil, err
+	}
+	return entry, nil
+}
+
+/*
+isAdmin reports whether the user is a member of the admin group, which entitles it to the admin scope
+*/
+func (la *ldapAuthenticator) isAdmin(ctx context.Context, user string) (bool, error) {
+	if la.adminGroup == "" {
+		return false, nil
+	}
+	entry, err := la.lookup(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	return entry.memberOf(la.adminGroup), nil
+}
+
+/*
+dial connects to the ldap server over tls for the ldaps urls or upgrades the connection by StartTLS.
+Each request of the connection is bounded by the request timeout.
+*/
+func (la *ldapAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: la.requestTimeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(la.url.String(), ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(la.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(la.requestTimeout)
+	if la.url.Scheme != "ldaps" {
+		if !la.startTLS {
+			conn.Close()
+			return nil, ErrLdapCleartextBind
+		}
+		err = conn.StartTLS(la.tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+/*
+filter returns the search filter of the user, the equality match of the user attribute restricted by the user filter and the required group
+*/
+func (la *ldapAuthenticator) filter(user string) string {
+	filter := fmt.Sprintf("(%s=%s)", la.userAttribute, ldap.EscapeFilter(user))
+	if la.userFilter != "" {
+		filter += la.userFilter
+	}
+	if la.requiredGroup != "" {
+		filter += fmt.Sprintf("(%s=%s)", ldapGroupAttribute, ldap.EscapeFilter(la.requiredGroup))
+	}
+	return "(&" + filter + ")"
+}
+
+/*
+findUser searches the dn and the groups of the user by the service account or anonymously if no bind dn is configured.
+Ambiguous usernames matching more than one entry are treated as not found.
+*/
+func (la *ldapAuthenticator) findUser(conn *ldap.Conn, user string) (*ldapUser, error) {
+	var err error
+	if la.bindDN != "" {
+		err = conn.Bind(la.bindDN, la.bindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap service account bind failed: %w", err)
+	}
+	req := ldap.NewSearchRequest(la.searchBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(la.requestTimeout.Seconds()), false,
+		la.filter(user), []string{ldapGroupAttribute}, nil)
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrLdapUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap search failed: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrLdapUserNotFound
+	}
+	entry := res.Entries[0]
+	return &ldapUser{dn: entry.DN, groups: entry.GetAttributeValues(ldapGroupAttribute)}, nil
+}
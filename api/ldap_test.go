@@ -0,0 +1,347 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// a directory entry served by the fake ldap server
+type fakeLdapEntry struct {
+	dn       string
+	password string
+	groups   []string
+}
+
+/*
+fakeLdapServer speaks the subset of LDAPv3 used by the authenticator: simple binds, searches, StartTLS and unbinds.
+Searches are answered by the entries registered for the exact filter the authenticator sends.
+*/
+type fakeLdapServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	bindDN    string
+	bindPass  string
+	entries   []fakeLdapEntry
+	filters   map[string][]string // search filters to the dns of the matching entries
+
+	mu             sync.Mutex
+	cleartextBinds int
+	searched       []string
+}
+
+func newFakeLdapServer(t *testing.T, ldaps bool) (*fakeLdapServer, string) {
+	t.Helper()
+	certPEM, tlsCert := newTestCertificate(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeLdapServer{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{tlsCert}},
+		bindDN:    "cn=svc,dc=example,dc=com",
+		bindPass:  "svc-pass",
+		filters:   make(map[string][]string),
+	}
+	if ldaps {
+		srv.listener, err = tls.Listen("tcp", "127.0.0.1:0", srv.tlsConfig)
+	} else {
+		srv.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.listener.Close() })
+	go func() {
+		for {
+			conn, err := srv.listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn, ldaps)
+		}
+	}()
+	return srv, caFile
+}
+
+func (s *fakeLdapServer) url(scheme string) string {
+	return scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *fakeLdapServer) serve(conn net.Conn, isTLS bool) {
+	defer func() { conn.Close() }()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		msgID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			if !isTLS {
+				s.cleartextBinds++
+			}
+			s.mu.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if s.validBind(dn, password) {
+				code = ldap.LDAPResultSuccess
+			}
+			s.respond(conn, msgID, fakeLdapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			s.mu.Lock()
+			s.searched = append(s.searched, filter)
+			s.mu.Unlock()
+			for _, dn := range s.filters[filter] {
+				s.respond(conn, msgID, s.searchEntry(dn))
+			}
+			s.respond(conn, msgID, fakeLdapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			s.respond(conn, msgID, fakeLdapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+		default:
+			// unbind
+			return
+		}
+	}
+}
+
+func (s *fakeLdapServer) validBind(dn string, password string) bool {
+	if dn == s.bindDN && password == s.bindPass {
+		return true
+	}
+	for _, entry := range s.entries {
+		if entry.dn == dn && entry.password == password {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeLdapServer) searchEntry(dn string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for _, entry := range s.entries {
+		if entry.dn != dn {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldapGroupAttribute, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, group := range entry.groups {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, group, ""))
+		}
+		attr.AppendChild(values)
+		attributes.AppendChild(attr)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func (s *fakeLdapServer) respond(conn net.Conn, msgID int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+	packet.AppendChild(op)
+	conn.Write(packet.Bytes())
+}
+
+func fakeLdapResult(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+/*
+newTestCertificate returns a self signed certificate of 127.0.0.1 and its pem encoding
+*/
+func newTestCertificate(t *testing.T) ([]byte, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+/*
+setTestLdapFlags configures the ldap flags of the fake server and restores them at the end of the test
+*/
+func setTestLdapFlags(t *testing.T, srv *fakeLdapServer, url string, caFile string, startTLS bool) {
+	t.Helper()
+	CmdLdapURL, CmdLdapBindDN, CmdLdapBindPassword = url, srv.bindDN, srv.bindPass
+	CmdLdapSearchBase, CmdLdapUserAttribute = "dc=example,dc=com", "uid"
+	CmdLdapUserFilter, CmdLdapRequiredGroup, CmdLdapAdminGroup = "", "", ""
+	CmdLdapStartTLS, CmdLdapCAFile, CmdLdapRequestTimeout = startTLS, caFile, 5*time.Second
+	t.Cleanup(func() {
+		CmdLdapURL, CmdLdapBindDN, CmdLdapBindPassword, CmdLdapCAFile = "", "", "", ""
+		CmdLdapUserFilter, CmdLdapRequiredGroup, CmdLdapAdminGroup = "", "", ""
+		CmdLdapStartTLS = false
+	})
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	srv, caFile := newFakeLdapServer(t, true)
+	srv.entries = []fakeLdapEntry{
+		{dn: "uid=alice,dc=example,dc=com", password: "alice-pass"},
+		{dn: "uid=bob,ou=a,dc=example,dc=com", password: "bob-pass"},
+		{dn: "uid=bob,ou=b,dc=example,dc=com", password: "bob-pass"},
+	}
+	srv.filters["(&(uid=alice))"] = []string{"uid=alice,dc=example,dc=com"}
+	srv.filters["(&(uid=bob))"] = []string{"uid=bob,ou=a,dc=example,dc=com", "uid=bob,ou=b,dc=example,dc=com"}
+	setTestLdapFlags(t, srv, srv.url("ldaps"), caFile, false)
+	la, err := newLdapAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		wantErr  error
+	}{
+		{name: "valid credentials", user: "alice", password: "alice-pass"},
+		{name: "wrong password", user: "alice", password: "wrong", wantErr: ErrLdapInvalidCredentials},
+		{name: "empty password", user: "alice", password: "", wantErr: ErrLdapInvalidCredentials},
+		{name: "unknown user", user: "mallory", password: "alice-pass", wantErr: ErrLdapUserNotFound},
+		{name: "ambiguous user", user: "bob", password: "bob-pass", wantErr: ErrLdapUserNotFound},
+		{name: "filter injection is escaped", user: "*", password: "alice-pass", wantErr: ErrLdapUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := la.authenticate(t.Context(), tt.user, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("authenticate(%q) error = %v, want %v", tt.user, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && entry.dn != "uid=alice,dc=example,dc=com" {
+				t.Errorf("dn = %q, want the dn of alice", entry.dn)
+			}
+		})
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !slices.Contains(srv.searched, `(&(uid=\2a))`) {
+		t.Errorf("wildcard username should be searched escaped, searched %v", srv.searched)
+	}
+}
+
+func TestLdapAuthorisationFilter(t *testing.T) {
+	srv, caFile := newFakeLdapServer(t, true)
+	srv.entries = []fakeLdapEntry{
+		{dn: "uid=alice,dc=example,dc=com", password: "alice-pass", groups: []string{"cn=staff,dc=example,dc=com", "CN=Admins,DC=example,DC=com"}},
+		{dn: "uid=carol,dc=example,dc=com", password: "carol-pass", groups: []string{"cn=staff,dc=example,dc=com"}},
+	}
+	srv.filters["(&(uid=alice)(objectClass=person)(memberOf=cn=staff,dc=example,dc=com))"] = []string{"uid=alice,dc=example,dc=com"}
+	srv.filters["(&(uid=carol)(objectClass=person)(memberOf=cn=staff,dc=example,dc=com))"] = []string{"uid=carol,dc=example,dc=com"}
+	setTestLdapFlags(t, srv, srv.url("ldaps"), caFile, false)
+	CmdLdapUserFilter = "(objectClass=person)"
+	CmdLdapRequiredGroup = "cn=staff,dc=example,dc=com"
+	CmdLdapAdminGroup = "cn=admins,dc=example,dc=com"
+	api := newTestApiServer(t)
+	var err error
+	api.ldap, err = newLdapAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		user      string
+		password  string
+		wantErr   error
+		wantAdmin bool
+	}{
+		{name: "member of the admin group is allowed the admin scope", user: "alice", password: "alice-pass", wantAdmin: true},
+		{name: "member of the required group isn't allowed the admin scope", user: "carol", password: "carol-pass"},
+		{name: "user out of the filter and the group is rejected", user: "dave", password: "dave-pass", wantErr: ErrLdapUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := api.ldap.authenticate(t.Context(), tt.user, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("authenticate(%q) error = %v, want %v", tt.user, err, tt.wantErr)
+			}
+			admin := slices.Contains(api.allowedScopes(t.Context(), tt.user), scopeAdmin)
+			if admin != tt.wantAdmin {
+				t.Errorf("admin scope allowed = %v, want %v", admin, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestLdapStartTLS(t *testing.T) {
+	srv, caFile := newFakeLdapServer(t, false)
+	srv.entries = []fakeLdapEntry{{dn: "uid=alice,dc=example,dc=com", password: "alice-pass"}}
+	srv.filters["(&(uid=alice))"] = []string{"uid=alice,dc=example,dc=com"}
+
+	setTestLdapFlags(t, srv, srv.url("ldap"), caFile, false)
+	_, err := newLdapAuthenticator()
+	if !errors.Is(err, ErrLdapCleartextBind) {
+		t.Fatalf("ldap:// url without starttls error = %v, want %v", err, ErrLdapCleartextBind)
+	}
+
+	CmdLdapStartTLS = true
+	la, err := newLdapAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = la.authenticate(t.Context(), "alice", "alice-pass")
+	if err != nil {
+		t.Fatalf("authenticate over starttls: %v", err)
+	}
+
+	// the authenticator refuses the cleartext connections even if it's built without the validation of the flags
+	la.startTLS = false
+	_, err = la.authenticate(t.Context(), "alice", "alice-pass")
+	if !errors.Is(err, ErrLdapCleartextBind) {
+		t.Fatalf("authenticate without starttls error = %v, want %v", err, ErrLdapCleartextBind)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.cleartextBinds != 0 {
+		t.Errorf("%d binds were sent in cleartext", srv.cleartextBinds)
+	}
+}
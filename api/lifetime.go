@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+/*
+LifetimeStatsRes is the cumulative counters of the events since the lifetime counters file is created
+*/
+type LifetimeStatsRes struct {
+	*data.LifetimeStats
+	ProcessedTotal int64 `json:"processed_total"`
+	FailedTotal    int64 `json:"failed_total"`
+}
+
+/*
+flushLifetimeCounters persists the lifetime counters periodically until the context is cancelled. The last flush is done by the graceful shutdown.
+*/
+func flushLifetimeCounters(ctx context.Context, logger *zerolog.Logger, lifetime *data.LifetimeCounters) {
+	ticker := time.NewTicker(data.CmdLifetimeCountersFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := lifetime.Flush()
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to persist the lifetime counters")
+			}
+		}
+	}
+}
+
+/*
+getLifetimeStatsHandler returns the cumulative counters of the ingested and processed events surviving the restarts,
+used for the business reporting which needs the absolute totals unlike the prometheus counters resetting on every deploy.
+*/
+func (api *ApiServer) getLifetimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getLifetimeStatsHandler.Tracer").Start(r.Context(), "getLifetimeStatsHandler.Span")
+	defer span.End()
+
+	nRes := &LifetimeStatsRes{LifetimeStats: api.models.EventQueue.Lifetime.Snapshot()}
+	for _, count := range nRes.Totals.Processed {
+		nRes.ProcessedTotal += count
+	}
+	nRes.FailedTotal = nRes.Totals.Processed[data.EventProcessStatusFailed]
+
+	err := helpers.WriteConditionalResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdAdminSocket string
+)
+
+// actor name recorded on the audit logs of the operations done through the admin socket
+const localAdminActor = "local-admin"
+
+/*
+localRoutes returns the handler of the admin unix socket used by the operational cli subcommands.
+It bypasses rate limiting and authentication, access to it is protected by the file permissions of the socket,
+so the tooling keeps working even when the public listener is saturated or rate limited.
+*/
+func (api *ApiServer) localRoutes() http.Handler {
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(api.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(api.methodNotAllowedResponse)
+
+	router.HandlerFunc(http.MethodGet, "/local/queue", api.localQueueInspectHandler)
+	router.HandlerFunc(http.MethodGet, "/local/worker", api.localWorkerStatsHandler)
+	router.HandlerFunc(http.MethodPost, "/local/drain", api.localDrainHandler)
+	router.HandlerFunc(http.MethodGet, "/local/support-bundle", api.supportBundleHandler(localAdminActor))
+
+	return api.panicRecovery(
+		api.setContextHandler(router))
+}
+
+/*
+listenAdminSocket listens on the unix socket which is only accessible by the user running the server.
+Stale socket file left by a previous run is removed before listening.
+*/
+func listenAdminSocket(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, errors.New("admin socket path exists and it's not a socket")
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return listenPrivateUnix(path)
+}
+
+func (api *ApiServer) localQueueInspectHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("localQueueInspectHandler.Tracer").Start(r.Context(), "localQueueInspectHandler.Span")
+	defer span.End()
+
+	nRes := api.models.EventQueue.Inspect(ctx)
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (api *ApiServer) localWorkerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("localWorkerStatsHandler.Tracer").Start(r.Context(), "localWorkerStatsHandler.Span")
+	defer span.End()
+
+	nRes := api.worker.Stats()
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+localDrainHandler stops accepting new events and fails the readiness checks, so the queue can be drained by the worker before stopping the server.
+Calling it again only reports the progress of the drain.
+*/
+func (api *ApiServer) localDrainHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("localDrainHandler.Tracer").Start(r.Context(), "localDrainHandler.Span")
+	defer span.End()
+
+	if api.startDrain(ctx) {
+		api.auditLog(r, localAdminActor, "drain").Msg("started draining the server")
+	}
+
+	nRes := api.drainProgress(ctx)
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+localDo sends a request to the admin unix socket of a running server
+*/
+func localDo(ctx context.Context, socket string, method string, path string) (*http.Response, error) {
+	if socket == "" {
+		return nil, errors.New("admin-socket should be specified")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// host is ignored since the connection is always made to the unix socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://local"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the admin socket %s: %w", socket, err)
+	}
+	return res, nil
+}
+
+/*
+LocalRequest sends a request to the admin unix socket of a running server and returns the result of the response
+*/
+func LocalRequest(ctx context.Context, socket string, method string, path string) (json.RawMessage, error) {
+	res, err := localDo(ctx, socket, method, path)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  interface{}     `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the admin socket response: %w", err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("admin socket responded with status %d: %v", res.StatusCode, envelope.Error)
+	}
+	return envelope.Result, nil
+}
+
+/*
+LocalDownload gets a non json response of the admin unix socket, e.g. the support bundle, and copies its body into dst
+*/
+func LocalDownload(ctx context.Context, socket string, path string, dst io.Writer) error {
+	res, err := localDo(ctx, socket, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		var envelope struct {
+			Error interface{} `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&envelope)
+		return fmt.Errorf("admin socket responded with status %d: %v", res.StatusCode, envelope.Error)
+	}
+	_, err = io.Copy(dst, res.Body)
+	return err
+}
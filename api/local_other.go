@@ -0,0 +1,24 @@
+//go:build !unix
+
+package api
+
+import (
+	"net"
+	"os"
+)
+
+/*
+listenPrivateUnix listens on the unix socket and restricts its permissions afterwards since umask is only available on unix
+*/
+func listenPrivateUnix(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAdminSocketPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	// a stale socket of a previous run is replaced
+	for i := 0; i < 2; i++ {
+		listener, err := listenAdminSocket(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("permissions of the admin socket = %o, want 600", perm)
+		}
+		// closing the listener removes the socket file, so it's left behind like a crashed run
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+	}
+
+	err := os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte("not a socket"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = listenAdminSocket(path)
+	if err == nil {
+		t.Error("admin socket replaced a file which is not a socket")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package api
+
+import (
+	"net"
+	"syscall"
+)
+
+/*
+listenPrivateUnix listens on the unix socket which is created with the 0600 permissions.
+The umask is narrowed while the socket file is created, so it's never accessible by the other users even for a moment.
+umask is process wide, so it's only narrowed for the creation of the socket file.
+*/
+func listenPrivateUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	CmdAuthLockoutThreshold   int
+	CmdAuthLockoutIPThreshold int
+	CmdAuthLockoutWindow      time.Duration
+	CmdAuthLockoutDuration    time.Duration
+)
+
+type authFailures struct {
+	count       int
+	firstAt     time.Time // failures are counted within the lockout window since the first one
+	lockedUntil time.Time
+}
+
+/*
+authLockout locks out the basic authentication of a user from a client ip after too many failed attempts within the window, so /v1/tokens can't be used to guess the passwords.
+The failures of each client ip are counted as well with a higher threshold against guessing the passwords of many users. The users themselves aren't locked out,
+otherwise anyone could lock the legitimate users out from their own networks.
+*/
+type authLockout struct {
+	mu       sync.Mutex
+	failures map[string]*authFailures // by user and client ip, or by client ip alone
+}
+
+/*
+newAuthLockout returns nil if the lockout is disabled by a zero threshold
+*/
+func newAuthLockout() *authLockout {
+	if CmdAuthLockoutThreshold <= 0 {
+		return nil
+	}
+	return &authLockout{failures: make(map[string]*authFailures)}
+}
+
+func lockoutUserKey(user string, ip string) string {
+	return "user:" + user + "@" + ip
+}
+
+func lockoutIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+/*
+lockedFor returns the remaining time of the lockout of the user from the client ip, zero if it isn't locked out
+*/
+func (l *authLockout) lockedFor(user string, ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var remaining time.Duration
+	for _, key := range []string{lockoutUserKey(user, ip), lockoutIPKey(ip)} {
+		if failures, found := l.failures[key]; found && failures.lockedUntil.After(now) {
+			remaining = max(remaining, failures.lockedUntil.Sub(now))
+		}
+	}
+	return remaining
+}
+
+/*
+fail counts a failed attempt of the user from the client ip and reports whether it locked them out
+*/
+func (l *authLockout) fail(user string, ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// cleaning up the failures which neither lock out nor count anymore
+	for key, failures := range l.failures {
+		if now.After(failures.lockedUntil) && now.Sub(failures.firstAt) > CmdAuthLockoutWindow {
+			delete(l.failures, key)
+		}
+	}
+
+	lockedOut := false
+	thresholds := map[string]int{lockoutUserKey(user, ip): CmdAuthLockoutThreshold}
+	if CmdAuthLockoutIPThreshold > 0 {
+		thresholds[lockoutIPKey(ip)] = CmdAuthLockoutIPThreshold
+	}
+	for key, threshold := range thresholds {
+		failures, found := l.failures[key]
+		if !found || now.Sub(failures.firstAt) > CmdAuthLockoutWindow {
+			failures = &authFailures{firstAt: now}
+			l.failures[key] = failures
+		}
+		failures.count++
+		if failures.count >= threshold {
+			failures.lockedUntil = now.Add(CmdAuthLockoutDuration)
+			// the next failures after the lockout are counted from scratch
+			failures.count = 0
+			failures.firstAt = now
+			lockedOut = true
+		}
+	}
+	return lockedOut
+}
+
+/*
+succeed forgets the failed attempts of the user from the client ip, the failures of the client ip keep counting
+*/
+func (l *authLockout) succeed(user string, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, lockoutUserKey(user, ip))
+}
+
+/*
+failAuthentication counts the failed basic authentication and audit logs the lockout if it's reached
+*/
+func (api *ApiServer) failAuthentication(r *http.Request, user string, ip string) {
+	if api.lockout == nil {
+		return
+	}
+	if api.lockout.fail(user, ip, time.Now()) {
+		api.auditLog(r, user, "auth.lockout").
+			Str("outcome", "failure").
+			Dur("duration", CmdAuthLockoutDuration).
+			Send()
+	}
+}
+
+func (api *ApiServer) succeedAuthentication(user string, ip string) {
+	if api.lockout != nil {
+		api.lockout.succeed(user, ip)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthLockout(t *testing.T) {
+	CmdAuthLockoutThreshold, CmdAuthLockoutIPThreshold = 3, 5
+	CmdAuthLockoutWindow, CmdAuthLockoutDuration = time.Minute, 5*time.Minute
+	t.Cleanup(func() {
+		CmdAuthLockoutThreshold, CmdAuthLockoutIPThreshold = 0, 0
+		CmdAuthLockoutWindow, CmdAuthLockoutDuration = 0, 0
+	})
+	l := newAuthLockout()
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	// steps are run in order on the same lockout
+	tests := []struct {
+		name       string
+		action     string // fail, succeed or check
+		user, ip   string
+		at         time.Duration
+		wantLocked bool          // returned by fail
+		wantFor    time.Duration // returned by check
+	}{
+		{name: "first failure", action: "fail", user: "alice", ip: "10.0.0.1"},
+		{name: "second failure", action: "fail", user: "alice", ip: "10.0.0.1", at: time.Second},
+		{name: "not locked out below the threshold", action: "check", user: "alice", ip: "10.0.0.1", at: time.Second},
+		{name: "threshold locks the user out", action: "fail", user: "alice", ip: "10.0.0.1", at: 2 * time.Second, wantLocked: true},
+		{name: "user is locked out from the ip", action: "check", user: "alice", ip: "10.0.0.1", at: 2 * time.Second, wantFor: 5 * time.Minute},
+		{name: "user isn't locked out from the other ips", action: "check", user: "alice", ip: "10.0.0.2", at: 2 * time.Second},
+		{name: "other users aren't locked out from the ip", action: "check", user: "bob", ip: "10.0.0.1", at: 2 * time.Second},
+		{name: "failures of the ip keep counting", action: "fail", user: "bob", ip: "10.0.0.1", at: 3 * time.Second},
+		{name: "ip threshold locks the ip out", action: "fail", user: "carol", ip: "10.0.0.1", at: 4 * time.Second, wantLocked: true},
+		{name: "all users are locked out from the ip", action: "check", user: "dave", ip: "10.0.0.1", at: 4 * time.Second, wantFor: 5 * time.Minute},
+		{name: "lockout expires", action: "check", user: "alice", ip: "10.0.0.1", at: 10 * time.Minute},
+
+		{name: "failure before the success", action: "fail", user: "erin", ip: "10.0.0.3"},
+		{name: "another failure before the success", action: "fail", user: "erin", ip: "10.0.0.3"},
+		{name: "success forgets the failures of the user", action: "succeed", user: "erin", ip: "10.0.0.3"},
+		{name: "failures are counted from scratch", action: "fail", user: "erin", ip: "10.0.0.3"},
+		{name: "so the threshold isn't reached", action: "fail", user: "erin", ip: "10.0.0.3"},
+
+		{name: "failure of a window", action: "fail", user: "frank", ip: "10.0.0.4"},
+		{name: "another failure of the window", action: "fail", user: "frank", ip: "10.0.0.4", at: time.Second},
+		{name: "failures out of the window don't count", action: "fail", user: "frank", ip: "10.0.0.4", at: 2 * time.Minute},
+		{name: "nor lock out", action: "check", user: "frank", ip: "10.0.0.4", at: 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start.Add(tt.at)
+			switch tt.action {
+			case "fail":
+				if got := l.fail(tt.user, tt.ip, now); got != tt.wantLocked {
+					t.Errorf("fail() = %v, want %v", got, tt.wantLocked)
+				}
+			case "succeed":
+				l.succeed(tt.user, tt.ip)
+			case "check":
+				if got := l.lockedFor(tt.user, tt.ip, now); got != tt.wantFor {
+					t.Errorf("lockedFor() = %s, want %s", got, tt.wantFor)
+				}
+			}
+		})
+	}
+}
+
+func TestNewAuthLockoutDisabled(t *testing.T) {
+	CmdAuthLockoutThreshold = 0
+	if l := newAuthLockout(); l != nil {
+		t.Error("lockout is enabled by a zero threshold")
+	}
+}
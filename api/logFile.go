@@ -0,0 +1,234 @@
+package api
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	CmdLogFile               string
+	CmdLogFileMaxSize        int
+	CmdLogFileRotateInterval time.Duration
+	CmdLogFileMaxBackups     int
+	CmdLogFileMaxAge         time.Duration
+	CmdLogFileCompress       bool
+)
+
+// layout of the rotation time in the names of the rotated log files
+const logFileBackupTimeLayout = "20060102T150405.000"
+
+/*
+rotatingLogFile appends the log lines to the log file and rotates it once it exceeds the max size or gets older than the rotate interval.
+Rotated files are renamed to <name>-<rotation time><ext>, gzipped if the compression is enabled and removed once they're
+beyond the max backups or older than the max age, zero keeps them. Each write is a whole log line, so the lines aren't split across the files.
+*/
+type rotatingLogFile struct {
+	path           string
+	maxSize        int64
+	rotateInterval time.Duration
+	maxBackups     int
+	maxAge         time.Duration
+	compress       bool
+	mu             sync.Mutex
+	file           *os.File
+	size           int64
+	openedAt       time.Time
+	mill           chan struct{} // wakes up the compression and removal of the rotated files
+	done           chan struct{}
+}
+
+func newRotatingLogFile(path string, maxSizeMB int, rotateInterval time.Duration, maxBackups int, maxAge time.Duration, compress bool) (*rotatingLogFile, error) {
+	lf := &rotatingLogFile{
+		path:           path,
+		maxSize:        int64(maxSizeMB) * 1024 * 1024,
+		rotateInterval: rotateInterval,
+		maxBackups:     maxBackups,
+		maxAge:         maxAge,
+		compress:       compress,
+		mill:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+	err := lf.open()
+	if err != nil {
+		return nil, err
+	}
+	go lf.millRun()
+	// backups left by the previous runs are compressed and removed as well
+	lf.mill <- struct{}{}
+	return lf, nil
+}
+
+func (lf *rotatingLogFile) open() error {
+	file, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	lf.file, lf.size, lf.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+func (lf *rotatingLogFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		return 0, os.ErrClosed
+	}
+	exceeded := lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize
+	expired := lf.rotateInterval > 0 && time.Since(lf.openedAt) >= lf.rotateInterval
+	if exceeded || expired {
+		err := lf.rotate()
+		if lf.file == nil {
+			return 0, err
+		}
+	}
+	n, err := lf.file.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+/*
+rotate renames the current log file to its backup name and opens a new one. lf.mu should be held by the caller.
+*/
+func (lf *rotatingLogFile) rotate() error {
+	lf.file.Close()
+	lf.file = nil
+	renameErr := os.Rename(lf.path, lf.backupName(time.Now()))
+	// the log file is reopened even if it can't be renamed, so the logging goes on
+	err := lf.open()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	select {
+	case lf.mill <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (lf *rotatingLogFile) backupName(rotatedAt time.Time) string {
+	ext := filepath.Ext(lf.path)
+	return strings.TrimSuffix(lf.path, ext) + "-" + rotatedAt.UTC().Format(logFileBackupTimeLayout) + ext
+}
+
+/*
+millRun compresses and removes the rotated files in the background, so the logging isn't blocked by them
+*/
+func (lf *rotatingLogFile) millRun() {
+	for {
+		select {
+		case <-lf.mill:
+			lf.millOnce()
+		case <-lf.done:
+			return
+		}
+	}
+}
+
+type logFileBackup struct {
+	path      string
+	rotatedAt time.Time
+}
+
+func (lf *rotatingLogFile) millOnce() {
+	backups := lf.backups()
+	if lf.compress {
+		for i, backup := range backups {
+			if strings.HasSuffix(backup.path, ".gz") {
+				continue
+			}
+			if compressLogFile(backup.path) == nil {
+				backups[i].path = backup.path + ".gz"
+			}
+		}
+	}
+	// backups are sorted from the newest, so the oldest ones are removed beyond the max backups
+	for i, backup := range backups {
+		tooMany := lf.maxBackups > 0 && i >= lf.maxBackups
+		tooOld := lf.maxAge > 0 && time.Since(backup.rotatedAt) > lf.maxAge
+		if tooMany || tooOld {
+			os.Remove(backup.path)
+		}
+	}
+}
+
+/*
+backups lists the rotated log files from the newest to the oldest
+*/
+func (lf *rotatingLogFile) backups() []logFileBackup {
+	ext := filepath.Ext(lf.path)
+	prefix := strings.TrimSuffix(filepath.Base(lf.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(lf.path))
+	if err != nil {
+		return nil
+	}
+	backups := make([]logFileBackup, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		rotatedAt, err := time.Parse(logFileBackupTimeLayout, timestamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logFileBackup{path: filepath.Join(filepath.Dir(lf.path), name), rotatedAt: rotatedAt})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotatedAt.After(backups[j].rotatedAt) })
+	return backups
+}
+
+/*
+compressLogFile gzips the rotated log file and removes the original once the compressed one is completely written
+*/
+func compressLogFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	gzipWriter := gzip.NewWriter(dst)
+	_, err = io.Copy(gzipWriter, src)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if cErr := dst.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (lf *rotatingLogFile) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		return errors.New("log file is already closed")
+	}
+	close(lf.done)
+	err := lf.file.Close()
+	lf.file = nil
+	return err
+}
@@ -0,0 +1,339 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+)
+
+var (
+	CmdLogSyslogAddr            string
+	CmdLogSyslogAppName         string
+	CmdLogLokiURL               string
+	CmdLogLokiLabels            map[string]string
+	CmdLogLokiTenantID          string
+	CmdLogShippingBufferSize    int
+	CmdLogShippingFlushInterval time.Duration
+)
+
+// sinks the logs are shipped to
+const (
+	logSinkSyslog = "syslog"
+	logSinkLoki   = "loki"
+	logSinkOTLP   = "otlp"
+)
+
+// maximum number of the log lines sent at once
+const logShippingBatchSize = 500
+
+// timeout of sending a batch and of flushing the buffered lines on the shutdown
+const logShippingTimeout = 5 * time.Second
+
+type shippedLogLine struct {
+	line []byte
+	at   time.Time
+}
+
+/*
+logShipper forwards the log lines to a log sink in the background, so a slow or unavailable sink doesn't block the logging.
+Lines are buffered up to the buffer size and sent in batches every flush interval, the lines which don't fit into the buffer
+or can't be sent are dropped and counted by log_shipping_dropped_lines_total.
+*/
+type logShipper struct {
+	sink          string
+	lines         chan shippedLogLine
+	send          func(lines []shippedLogLine) error
+	flushInterval time.Duration
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+func newLogShipper(sink string, bufferSize int, flushInterval time.Duration, send func(lines []shippedLogLine) error) *logShipper {
+	ls := &logShipper{
+		sink:          sink,
+		lines:         make(chan shippedLogLine, bufferSize),
+		send:          send,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go ls.run()
+	return ls
+}
+
+func (ls *logShipper) Write(p []byte) (int, error) {
+	// zerolog reuses the buffer of the line once the write returns
+	select {
+	case ls.lines <- shippedLogLine{line: bytes.TrimRight(bytes.Clone(p), "\n"), at: time.Now()}:
+	default:
+		observ.PromLogShippingDropped.WithLabelValues(ls.sink, "buffer_full").Inc()
+	}
+	return len(p), nil
+}
+
+func (ls *logShipper) run() {
+	defer close(ls.stopped)
+	ticker := time.NewTicker(ls.flushInterval)
+	defer ticker.Stop()
+	batch := make([]shippedLogLine, 0, logShippingBatchSize)
+	for {
+		select {
+		case line := <-ls.lines:
+			batch = append(batch, line)
+			if len(batch) >= logShippingBatchSize {
+				batch = ls.flush(batch)
+			}
+		case <-ticker.C:
+			batch = ls.flush(batch)
+		case <-ls.done:
+			// lines buffered before the shutdown are still sent
+			for {
+				select {
+				case line := <-ls.lines:
+					batch = append(batch, line)
+					if len(batch) >= logShippingBatchSize {
+						batch = ls.flush(batch)
+					}
+				default:
+					ls.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ls *logShipper) flush(batch []shippedLogLine) []shippedLogLine {
+	if len(batch) == 0 {
+		return batch
+	}
+	err := ls.send(batch)
+	if err != nil {
+		observ.PromLogShippingDropped.WithLabelValues(ls.sink, "send_error").Add(float64(len(batch)))
+	} else {
+		observ.PromLogShippingSent.WithLabelValues(ls.sink).Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+/*
+Close sends the buffered lines and stops the shipper, it waits up to the shipping timeout
+*/
+func (ls *logShipper) Close() error {
+	close(ls.done)
+	select {
+	case <-ls.stopped:
+		return nil
+	case <-time.After(logShippingTimeout):
+		return fmt.Errorf("timed out flushing the logs to %s", ls.sink)
+	}
+}
+
+/*
+logLineLevel returns the zerolog level of the json log line
+*/
+func logLineLevel(line []byte) string {
+	var fields struct {
+		Level string `json:"level"`
+	}
+	if json.Unmarshal(line, &fields) != nil || fields.Level == "" {
+		return "info"
+	}
+	return fields.Level
+}
+
+// severities of the zerolog levels defined by rfc 5424
+var syslogSeverities = map[string]int{"panic": 0, "fatal": 2, "error": 3, "warn": 4, "info": 6, "debug": 7, "trace": 7}
+
+// facility of the syslog messages, user-level messages
+const syslogFacility = 1
+
+/*
+syslogSender sends the log lines as rfc 5424 messages to the syslog server over udp or tcp. Messages are framed by the octet counting
+of rfc 6587 over tcp and the connection is dialed again after a failed write.
+*/
+type syslogSender struct {
+	network  string
+	addr     string
+	appName  string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogSender(rawAddr string, appName string) (*syslogSender, error) {
+	addrURL, err := url.Parse(rawAddr)
+	if err != nil || (addrURL.Scheme != "udp" && addrURL.Scheme != "tcp") || addrURL.Host == "" {
+		return nil, fmt.Errorf("syslog address %s should be like udp://host:514 or tcp://host:601", rawAddr)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &syslogSender{network: addrURL.Scheme, addr: addrURL.Host, appName: appName, hostname: hostname}, nil
+}
+
+func (ss *syslogSender) send(lines []shippedLogLine) error {
+	if ss.conn == nil {
+		conn, err := net.DialTimeout(ss.network, ss.addr, logShippingTimeout)
+		if err != nil {
+			return err
+		}
+		ss.conn = conn
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		severity, found := syslogSeverities[logLineLevel(line.line)]
+		if !found {
+			severity = syslogSeverities["info"]
+		}
+		message := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", syslogFacility*8+severity, line.at.UTC().Format(time.RFC3339Nano), ss.hostname, ss.appName, os.Getpid(), line.line)
+		if ss.network == "udp" {
+			// every udp datagram carries a single message
+			buf.WriteString(message)
+			err := ss.write(buf.Bytes())
+			if err != nil {
+				return err
+			}
+			buf.Reset()
+			continue
+		}
+		buf.WriteString(strconv.Itoa(len(message)) + " " + message)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return ss.write(buf.Bytes())
+}
+
+func (ss *syslogSender) write(message []byte) error {
+	ss.conn.SetWriteDeadline(time.Now().Add(logShippingTimeout))
+	_, err := ss.conn.Write(message)
+	if err != nil {
+		ss.conn.Close()
+		ss.conn = nil
+	}
+	return err
+}
+
+/*
+lokiSender pushes the log lines to the push api of grafana loki, a stream per level labelled by the configured labels
+*/
+type lokiSender struct {
+	pushURL  string
+	labels   map[string]string
+	tenantID string
+	client   *http.Client
+}
+
+func newLokiSender(rawURL string, labels map[string]string, tenantID string) (*lokiSender, error) {
+	lokiURL, err := url.Parse(rawURL)
+	if err != nil || (lokiURL.Scheme != "http" && lokiURL.Scheme != "https") || lokiURL.Host == "" {
+		return nil, fmt.Errorf("loki url %s should be a http or https url", rawURL)
+	}
+	return &lokiSender{
+		pushURL:  strings.TrimSuffix(rawURL, "/") + "/loki/api/v1/push",
+		labels:   labels,
+		tenantID: tenantID,
+		client:   &http.Client{Timeout: logShippingTimeout},
+	}, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (lks *lokiSender) send(lines []shippedLogLine) error {
+	streams := make(map[string]*lokiStream)
+	order := make([]string, 0)
+	for _, line := range lines {
+		level := logLineLevel(line.line)
+		stream, found := streams[level]
+		if !found {
+			labels := make(map[string]string, len(lks.labels)+1)
+			for name, value := range lks.labels {
+				labels[name] = value
+			}
+			labels["level"] = level
+			stream = &lokiStream{Stream: labels}
+			streams[level] = stream
+			order = append(order, level)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(line.at.UnixNano(), 10), string(line.line)})
+	}
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{Streams: make([]*lokiStream, 0, len(order))}
+	for _, level := range order {
+		push.Streams = append(push.Streams, streams[level])
+	}
+	body, err := json.Marshal(&push)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, lks.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if lks.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", lks.tenantID)
+	}
+	res, err := lks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("loki responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+/*
+newLogShippers returns the shippers of the configured log sinks, the otlp endpoint is a log sink as well
+*/
+func newLogShippers() ([]*logShipper, error) {
+	shippers := make([]*logShipper, 0, 2)
+	if (CmdLogSyslogAddr != "" || CmdLogLokiURL != "" || observ.CmdOTelLogsEndpoint != "") && (CmdLogShippingBufferSize <= 0 || CmdLogShippingFlushInterval <= 0) {
+		return nil, errors.New("log-shipping-buffer-size and log-shipping-flush-interval should be greater than zero")
+	}
+	if CmdLogSyslogAddr != "" {
+		sender, err := newSyslogSender(CmdLogSyslogAddr, CmdLogSyslogAppName)
+		if err != nil {
+			return nil, err
+		}
+		shippers = append(shippers, newLogShipper(logSinkSyslog, CmdLogShippingBufferSize, CmdLogShippingFlushInterval, sender.send))
+	}
+	if CmdLogLokiURL != "" {
+		sender, err := newLokiSender(CmdLogLokiURL, CmdLogLokiLabels, CmdLogLokiTenantID)
+		if err != nil {
+			return nil, err
+		}
+		shippers = append(shippers, newLogShipper(logSinkLoki, CmdLogShippingBufferSize, CmdLogShippingFlushInterval, sender.send))
+	}
+	if observ.CmdOTelLogsEndpoint != "" {
+		exporter, err := observ.NewOTLPLogExporter(observ.CmdOTelLogsEndpoint, logShippingTimeout, observ.CmdOTelExporterProtocol, observ.CmdOTelExporterProxy)
+		if err != nil {
+			return nil, err
+		}
+		shippers = append(shippers, newLogShipper(logSinkOTLP, CmdLogShippingBufferSize, CmdLogShippingFlushInterval, func(lines []shippedLogLine) error {
+			otlpLines := make([]observ.OTLPLogLine, 0, len(lines))
+			for _, line := range lines {
+				otlpLines = append(otlpLines, observ.OTLPLogLine{Line: line.line, At: line.at})
+			}
+			return exporter.Export(otlpLines)
+		}))
+	}
+	return shippers, nil
+}
@@ -3,50 +3,90 @@ package api
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	observ "github.com/cybrarymin/behavox/api/observability"
+	"github.com/cybrarymin/behavox/generator"
 	helpers "github.com/cybrarymin/behavox/internal"
 	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/scheduler"
 	"github.com/cybrarymin/behavox/worker"
+	"github.com/go-ldap/ldap/v3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
+	"google.golang.org/grpc"
 )
 
 var (
-	CmdLogLevelFlag        string
-	CmdHTTPSrvListenAddr   string
-	CmdHTTPSrvReadTimeout  time.Duration
-	CmdHTTPSrvWriteTimeout time.Duration
-	CmdHTTPSrvIdleTimeout  time.Duration
-	CmdTlsCertFile         string
-	CmdTlsKeyFile          string
-	CmdGlobalRateLimit     int64
-	CmdPerClientRateLimit  int64
-	CmdEnableRateLimit     bool
+	CmdLogLevelFlag                string
+	CmdHTTPSrvListenAddr           string
+	CmdHTTPSrvReadTimeout          time.Duration
+	CmdHTTPSrvWriteTimeout         time.Duration
+	CmdHTTPSrvIdleTimeout          time.Duration
+	CmdTlsCertFile                 string
+	CmdTlsKeyFile                  string
+	CmdGlobalRateLimit             int64
+	CmdPerClientRateLimit          int64
+	CmdEnableRateLimit             bool
+	CmdRateLimitDryRun             bool
+	CmdMiddlewares                 []string
+	CmdEventAckTimeout             time.Duration
+	CmdEventTimestampMaxFutureSkew time.Duration
+	CmdEventTimestampMaxAge        time.Duration
+	CmdReadOnly                    bool
+	CmdProduction                  bool
 )
 
 func Main() {
 	// initializing the logger with respect to the specified loglevel option
 	var nlogger zerolog.Logger
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	// recent logs are kept in memory as well to be attached to the support bundles
+	recentLogs = newLogBuffer(CmdSupportBundleLogLines)
+	logWriters := []io.Writer{os.Stdout, recentLogs}
+	var logFileErr error
+	if CmdLogFile != "" {
+		logFile, err := newRotatingLogFile(CmdLogFile, CmdLogFileMaxSize, CmdLogFileRotateInterval, CmdLogFileMaxBackups, CmdLogFileMaxAge, CmdLogFileCompress)
+		if err == nil {
+			defer logFile.Close()
+			logWriters = append(logWriters, logFile)
+		}
+		logFileErr = err
+	}
+	logShippers, logShippingErr := newLogShippers()
+	for _, shipper := range logShippers {
+		// the logs of the shutdown are flushed to the sinks before exiting
+		defer shipper.Close()
+		logWriters = append(logWriters, shipper)
+	}
+	logWriter := io.MultiWriter(logWriters...)
 	if zerolog.LevelTraceValue == CmdLogLevelFlag {
-		nlogger = zerolog.New(os.Stdout).With().Stack().Timestamp().Logger().Level(zerolog.TraceLevel)
+		nlogger = zerolog.New(logWriter).Hook(observ.TraceContextHook{}).With().Stack().Timestamp().Logger().Level(zerolog.TraceLevel)
 	} else {
 		loglvl, _ := zerolog.ParseLevel(CmdLogLevelFlag)
-		nlogger = zerolog.New(os.Stdout).With().Timestamp().Logger().Level(loglvl)
+		nlogger = zerolog.New(logWriter).Hook(observ.TraceContextHook{}).With().Timestamp().Logger().Level(loglvl)
+	}
+
+	if logFileErr != nil {
+		nlogger.Error().Err(logFileErr).Msg("failed to open the log file")
+		return
+	}
+	if logShippingErr != nil {
+		nlogger.Error().Err(logShippingErr).Msg("failed to initialize the log shipping")
+		return
 	}
 
 	ctx := context.Background()
 
 	// initialize opentelemetry
-	otelShut, err := observ.SetupOTelSDK(ctx, observ.CmdJaegerHostFlag, observ.CmdJaegerPortFlag, observ.CmdJaegerConnectionTimeout, observ.CmdSpanExportInterval)
+	otelShut, err := observ.SetupOTelSDK(ctx, observ.CmdJaegerHostFlag, observ.CmdJaegerPortFlag, observ.CmdJaegerConnectionTimeout, observ.CmdSpanExportInterval, observ.CmdOTelMetricsEndpoint, observ.CmdOTelMetricsExportInterval, observ.CmdOTelExporterProtocol, observ.CmdOTelExporterProxy)
 	if err != nil {
 		nlogger.Error().Err(err).Msg("failed to initialize the opentelemetry sdk")
 		return
@@ -54,19 +94,204 @@ func Main() {
 
 	// initialize the models so apiServer can have access to the models and eventQueue system
 	eq := data.NewEventQueue()
-	nModel := data.NewModels(eq, nil, nil)
+	dlq := data.NewDeadLetterQueue()
+	changeLog, err := data.NewChangeLog(data.CmdChangeLogFile)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the admin change log")
+		return
+	}
+	eq.Lifetime, err = data.NewLifetimeCounters(data.CmdLifetimeCountersFile)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the lifetime counters")
+		return
+	}
+	subscriptions := data.NewSubscriptionStore()
+	schedules, err := data.NewScheduleStore(data.CmdSchedulesFile)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the schedules of the recurring events")
+		return
+	}
+	nModel := data.NewModels(eq, dlq, changeLog, subscriptions, schedules, nil, nil)
+
+	err = worker.LoadOutputKey(ctx)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the encryption key of the processed events file")
+		return
+	}
 
 	// initialize and run worker node
-	nWorker := worker.NewWorker(&nlogger, eq, ctx)
+	nWorker := worker.NewWorker(&nlogger, eq, dlq, subscriptions, ctx)
+	err = nWorker.LoadProcessed(ctx)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the ids of the processed events")
+		return
+	}
+	// events taken out of the queue but not completed before a crash are processed again
+	err = nWorker.Recover(ctx)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to recover the events of the worker checkpoint")
+		return
+	}
+	// events are only consumed by the external consumers through the pull api until the worker is resumed
+	if worker.CmdWorkerStartPaused {
+		nWorker.Pause()
+	}
 	helpers.BackgroundJob(func() {
 		nWorker.Run(ctx)
 	}, &nlogger, "new worker paniced during consuming events")
 
 	// initialize the prometheus
-	observ.PromInit(eq, Version)
+	effectiveConfigHash = diffConfig(&nlogger)
+	observ.PromInit(eq, dlq, Version, effectiveConfigHash)
+	err = observ.OTelInit(eq, dlq)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to initialize the opentelemetry metrics")
+		return
+	}
 
 	// initializing new validator to be used for input validation of cmdOptions
 	nVal := helpers.NewValidator()
+	nVal.Check(helpers.In(worker.CmdProcessedEventFormat, worker.OutputFormats...), "event-processor-format", "invalid output format")
+	nVal.Check(helpers.In(observ.CmdOTelExporterProtocol, observ.OTLPProtocols...), "otel-exporter-protocol", "should be either grpc or http")
+	nVal.Check(helpers.In(worker.CmdDigestAlgorithm, worker.DigestAlgorithms...), "event-digest-algorithm", "should be one of md5, sha256, xxhash and blake3")
+	err = worker.ValidatePipeline(worker.CmdProcessorStages)
+	nVal.Check(err == nil, "event-processor-stages", fmt.Sprint(err))
+	err = worker.ValidateSinks(worker.CmdSinks)
+	nVal.Check(err == nil, "event-sinks", fmt.Sprint(err))
+	nVal.Check(worker.CmdEventPoisonThreshold >= 0, "event-poison-threshold", "shouldn't be negative")
+	nVal.Check(worker.CmdEventProcessingTimeout >= 0, "event-processing-timeout", "shouldn't be negative")
+	err = worker.ValidateOutputEncoding()
+	nVal.Check(err == nil, "event-processor-compression", fmt.Sprint(err))
+	nVal.Check(helpers.CmdMaxBodyBytes > 0, "max-body-bytes", "should be greater than zero")
+	nVal.Check(CmdEventBulkMaxBytes > 0, "event-bulk-max-bytes", "should be greater than zero")
+	nVal.Check(CmdEventImportMaxBytes > 0, "event-import-max-bytes", "should be greater than zero")
+	nVal.Check(CmdLogFileMaxSize >= 0, "log-file-max-size", "shouldn't be negative")
+	nVal.Check(CmdLogFileMaxBackups >= 0, "log-file-max-backups", "shouldn't be negative")
+	nVal.Check(CmdEventQueueHighWaterMark >= 0 && CmdEventQueueHighWaterMark <= 100, "event-queue-high-water-mark", "should be between 0 and 100")
+	nVal.Check(CmdBackpressureRetryAfter > 0, "event-queue-backpressure-retry-after", "should be greater than zero")
+	nVal.Check(CmdShutdownQueueDrainTimeout >= 0, "shutdown-queue-drain-timeout", "shouldn't be negative")
+	nVal.Check(CmdJwtTTL > 0, "jwt-ttl", "should be greater than zero")
+	nVal.Check(CmdJwtIssuer != "", "jwt-issuer", "must be provided")
+	nVal.Check(CmdJwtIssuer != CmdOIDCIssuer, "jwt-issuer", "should be different from the oidc-issuer")
+	nVal.Check(CmdJwtAudience != "", "jwt-audience", "must be provided")
+	nVal.Check(CmdJwtRefreshKey != "" && CmdJwtRefreshKey != CmdJwtKey, "jwt-refresh-key", "should be provided and be different from the jwkey")
+	if CmdProduction {
+		nVal.Check(CmdJwtKey != DefaultJwtKey, "jwkey", "must be changed from the default in the production mode")
+		nVal.Check(CmdJwtRefreshKey != DefaultJwtRefreshKey, "jwt-refresh-key", "must be changed from the default in the production mode")
+		nVal.Check(CmdApiAdminPass != DefaultApiAdminPass, "api-admin-pass", "must be changed from the default in the production mode")
+	}
+	nVal.Check(len(CmdJwtPublicKeyFiles) == 0 || CmdJwtSigningKeyFile != "", "jwt-public-key-files", "requires jwt-signing-key-file")
+	if CmdJwtHS256AcceptUntil != "" {
+		_, err := time.Parse(time.RFC3339, CmdJwtHS256AcceptUntil)
+		nVal.Check(err == nil, "jwt-hs256-accept-until", "should be an RFC3339 time")
+		nVal.Check(CmdJwtSigningKeyFile != "", "jwt-hs256-accept-until", "requires jwt-signing-key-file")
+	}
+	nVal.Check(CmdJwtRefreshTTL > 0, "jwt-refresh-ttl", "should be greater than zero")
+	if CmdOIDCIssuer != "" {
+		issuerURL, err := url.Parse(CmdOIDCIssuer)
+		nVal.Check(err == nil && issuerURL.Scheme != "" && issuerURL.Host != "", "oidc-issuer", "should be a valid url")
+		nVal.Check(CmdOIDCAudience != "", "oidc-audience", "must be provided when oidc-issuer is set")
+	}
+	if CmdOIDCJWKSURL != "" {
+		jwksURL, err := url.Parse(CmdOIDCJWKSURL)
+		nVal.Check(err == nil && jwksURL.Scheme != "" && jwksURL.Host != "", "oidc-jwks-url", "should be a valid url")
+		nVal.Check(CmdOIDCIssuer != "", "oidc-jwks-url", "requires oidc-issuer")
+	}
+	for keyID, secret := range CmdHMACKeys {
+		nVal.Check(keyID != "" && len(keyID) <= 200, "hmac-keys", "key ids should be between 1 and 200 bytes long")
+		nVal.Check(len(secret) >= 32, "hmac-keys", fmt.Sprintf("secret of %s should be at least 32 bytes long", keyID))
+	}
+	_, err = parseIPPrefixes(CmdIPAllow)
+	nVal.Check(err == nil, "ip-allow", fmt.Sprint(err))
+	_, err = parseIPPrefixes(CmdIPDeny)
+	nVal.Check(err == nil, "ip-deny", fmt.Sprint(err))
+	if CmdDebugListenAddr != "" {
+		host, _, err := net.SplitHostPort(CmdDebugListenAddr)
+		nVal.Check(err == nil, "debug-listen-addr", "should be like host:port")
+		// the debug listener reuses the tls of the main listener, without it the profiles are only served to the local clients
+		nVal.Check(err != nil || strings.HasPrefix(CmdHTTPSrvListenAddr, "https://") || loopbackHost(host),
+			"debug-listen-addr", "should be a loopback address unless listen-addr is https")
+	}
+	if CmdGRPCListenAddr != "" {
+		_, _, err := net.SplitHostPort(CmdGRPCListenAddr)
+		nVal.Check(err == nil, "grpc-listen-addr", "should be like host:port")
+		nVal.Check(CmdGRPCIngestAckInterval > 0, "grpc-ingest-ack-interval", "should be greater than zero")
+	}
+	for _, path := range CmdIPFilterPaths {
+		nVal.Check(strings.HasPrefix(path, "/"), "ip-filter-paths", fmt.Sprintf("path %s should start with /", path))
+	}
+	nVal.Check(CmdAuthLockoutThreshold >= 0, "auth-lockout-threshold", "shouldn't be negative")
+	nVal.Check(CmdAuthLockoutIPThreshold >= 0, "auth-lockout-ip-threshold", "shouldn't be negative")
+	if CmdAuthLockoutThreshold > 0 {
+		nVal.Check(CmdAuthLockoutWindow > 0, "auth-lockout-window", "should be greater than zero")
+		nVal.Check(CmdAuthLockoutDuration > 0, "auth-lockout-duration", "should be greater than zero")
+	}
+	nVal.Check(data.CmdChangeLogMaxRecords >= 0, "admin-change-log-max-records", "shouldn't be negative")
+	nVal.Check(data.CmdChangeLogMaxPreviousBytes >= 0, "admin-change-log-max-previous-bytes", "shouldn't be negative")
+	nVal.Check(CmdHMACReplayWindow > 0, "hmac-replay-window", "should be greater than zero")
+	if CmdLdapURL != "" {
+		ldapURL, err := url.Parse(CmdLdapURL)
+		nVal.Check(err == nil && helpers.In(ldapURL.Scheme, "ldap", "ldaps") && ldapURL.Host != "", "ldap-url", "should be a ldap:// or ldaps:// url")
+		nVal.Check(err == nil && !(CmdLdapStartTLS && ldapURL.Scheme == "ldaps"), "ldap-start-tls", "shouldn't be enabled for the ldaps urls")
+		nVal.Check(err == nil && (CmdLdapStartTLS || ldapURL.Scheme != "ldap"), "ldap-start-tls", "must be enabled for the ldap:// urls, the binds would be sent in cleartext otherwise")
+		if CmdLdapUserFilter != "" {
+			_, err := ldap.CompileFilter(CmdLdapUserFilter)
+			nVal.Check(err == nil, "ldap-user-filter", "should be a valid ldap filter, e.g. (objectClass=person)")
+		}
+		nVal.Check(CmdLdapSearchBase != "", "ldap-search-base", "must be provided when ldap-url is set")
+		nVal.Check(CmdLdapUserAttribute != "", "ldap-user-attribute", "must be provided when ldap-url is set")
+		nVal.Check(CmdLdapRequestTimeout > 0, "ldap-request-timeout", "should be greater than zero")
+	}
+	nVal.Check(data.CmdSchedulesMaxLen >= 0, "schedules-max", "shouldn't be negative")
+	nVal.Check(data.CmdEventDelayTick > 0, "event-delay-tick", "should be greater than zero")
+	nVal.Check(data.CmdEventMaxDelay >= 0, "event-max-delay", "shouldn't be negative")
+	nVal.Check(CmdEventPullMaxWait >= 0, "event-pull-max-wait", "shouldn't be negative")
+	nVal.Check(data.CmdEventLeaseTimeout > 0, "event-lease-timeout", "should be greater than zero")
+	if worker.CmdWorkerAutoscale {
+		nVal.Check(worker.CmdMinWorkerGoroutines > 0 && worker.CmdMinWorkerGoroutines <= worker.CmdmaxWorkerGoroutines, "event-queue-min-worker-threads", "should be between 1 and event-queue-max-worker-threads")
+		nVal.Check(worker.CmdWorkerAutoscaleInterval > 0, "worker-autoscale-interval", "should be greater than zero")
+		nVal.Check(worker.CmdWorkerAutoscaleCooldown >= 0, "worker-autoscale-cooldown", "shouldn't be negative")
+		nVal.Check(worker.CmdWorkerAutoscaleTargetLatency > 0, "worker-autoscale-target-latency", "should be greater than zero")
+	}
+	nVal.Check(worker.CmdWorkerMaxEventsPerSecond >= 0, "worker-max-events-per-second", "shouldn't be negative")
+	nVal.Check(worker.CmdWorkerThrottleBurst > 0, "worker-throttle-burst", "should be greater than zero")
+	if worker.CmdCircuitBreakerFailureRate > 0 {
+		nVal.Check(worker.CmdCircuitBreakerFailureRate <= 100, "circuit-breaker-failure-rate", "should be a percentage between 0 and 100")
+		nVal.Check(worker.CmdCircuitBreakerMinEvents > 0, "circuit-breaker-min-events", "should be greater than zero")
+		nVal.Check(worker.CmdCircuitBreakerWindow > 0, "circuit-breaker-window", "should be greater than zero")
+		nVal.Check(worker.CmdCircuitBreakerOpenDuration > 0, "circuit-breaker-open-duration", "should be greater than zero")
+		nVal.Check(worker.CmdCircuitBreakerHalfOpenProbes > 0, "circuit-breaker-half-open-probes", "should be greater than zero")
+	}
+	nVal.Check(worker.CmdEventMaxRetries >= 0, "event-max-retries", "shouldn't be negative")
+	for eventType, retries := range worker.CmdEventTypeMaxRetries {
+		_, found := data.LookupEventType(eventType)
+		nVal.Check(found, "event-type-max-retries", fmt.Sprintf("unknown event type %s", eventType))
+		nVal.Check(retries >= 0, "event-type-max-retries", fmt.Sprintf("budget of %s shouldn't be negative", eventType))
+	}
+	nVal.Check(worker.CmdEventRetryBackoff > 0, "event-retry-backoff", "should be greater than zero")
+	nVal.Check(worker.CmdEventRetryMaxBackoff >= worker.CmdEventRetryBackoff, "event-retry-max-backoff", "shouldn't be less than event-retry-backoff")
+	nVal.Check(worker.CmdEventRetryJitterFactor >= 0 && worker.CmdEventRetryJitterFactor <= 1, "event-retry-jitter-factor", "should be between 0 and 1")
+	for eventType, limit := range worker.CmdEventTypeConcurrency {
+		_, found := data.LookupEventType(eventType)
+		nVal.Check(found, "event-type-concurrency", fmt.Sprintf("unknown event type %s", eventType))
+		nVal.Check(limit > 0, "event-type-concurrency", fmt.Sprintf("budget of %s should be greater than zero", eventType))
+	}
+	for eventType, limit := range data.CmdEventTypeMaxBodyBytes {
+		_, found := data.LookupEventType(eventType)
+		nVal.Check(found, "event-type-max-body-bytes", fmt.Sprintf("unknown event type %s", eventType))
+		nVal.Check(limit > 0 && limit <= helpers.CmdMaxBodyBytes, "event-type-max-body-bytes", fmt.Sprintf("limit of %s should be between 1 and max-body-bytes", eventType))
+	}
+	generator.Validation(nVal)
+	nVal.Check(CmdSupportBundleLogLines >= 0, "support-bundle-log-lines", "shouldn't be negative")
+	nVal.Check(data.CmdLifetimeCountersFlushInterval > 0, "lifetime-counters-flush-interval", "should be greater than zero")
+	nVal.Check(data.CmdWebhookMaxSubscriptions >= 0, "webhook-max-subscriptions", "shouldn't be negative")
+	nVal.Check(worker.CmdWebhookTimeout > 0, "webhook-timeout", "should be greater than zero")
+	nVal.Check(worker.CmdWorkerIdempotencySize >= 0, "worker-idempotency-size", "shouldn't be negative")
+	nVal.Check(worker.CmdWorkerIdempotencyFile == "" || worker.CmdWorkerIdempotencySize > 0, "worker-idempotency-file", "requires worker-idempotency-size to be greater than zero")
+	nVal.Check(worker.CmdWebhookMaxAttempts > 0, "webhook-max-attempts", "should be greater than zero")
+	nVal.Check(worker.CmdWebhookRetryBackoff > 0, "webhook-retry-backoff", "should be greater than zero")
+	nVal.Check(worker.CmdWebhookRetryMaxBackoff >= worker.CmdWebhookRetryBackoff, "webhook-retry-max-backoff", "shouldn't be less than webhook-retry-backoff")
+	nVal.Check(helpers.In(data.CmdEventQueueCompression, data.CompressionNone, data.CompressionSnappy, data.CompressionZstd), "event-queue-compression", "invalid compression algorithm")
 
 	// parsing the listen address
 	url, err := url.Parse(CmdHTTPSrvListenAddr)
@@ -78,11 +303,13 @@ func Main() {
 	nApiCfg := NewApiServerCfg(url, CmdTlsCertFile,
 		CmdTlsKeyFile,
 		CmdEnableRateLimit,
+		CmdRateLimitDryRun,
 		CmdGlobalRateLimit,
 		CmdPerClientRateLimit,
 		CmdHTTPSrvReadTimeout,
 		CmdHTTPSrvIdleTimeout,
-		CmdHTTPSrvWriteTimeout)
+		CmdHTTPSrvWriteTimeout,
+		CmdMiddlewares)
 	if !nApiCfg.validation(*nVal).Valid() {
 		for key, err := range nVal.Errors {
 			err := fmt.Errorf("%s is invalid: %s", key, err)
@@ -91,7 +318,51 @@ func Main() {
 		return
 	}
 
-	nApi := NewApiServer(nApiCfg, &nlogger, nModel)
+	nApi := NewApiServer(nApiCfg, &nlogger, nModel, nWorker)
+	nApi.auditLogger, err = newAuditLogger(nlogger)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to open the audit log file")
+		return
+	}
+	nApi.refreshTokens, err = data.NewRefreshTokenStore(data.CmdRefreshTokenStateFile)
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the state of the used refresh tokens")
+		return
+	}
+	nApi.signer, err = newJwtSigner()
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to load the jwt signing keys")
+		return
+	}
+	nApi.ldap, err = newLdapAuthenticator()
+	if err != nil {
+		nlogger.Error().Err(err).Msg("failed to configure the ldap authentication")
+		return
+	}
+
+	helpers.BackgroundJob(func() {
+		flushLifetimeCounters(ctx, &nlogger, eq.Lifetime)
+	}, &nlogger, "lifetime counters flusher paniced")
+
+	// synthetic event generators used for the demos and local development
+	if len(generator.CmdGeneratorRates) > 0 {
+		nGenerator := generator.NewGenerator(&nlogger, eq, func() bool { return !nApi.draining.Load() })
+		helpers.BackgroundJob(func() {
+			nGenerator.Run(ctx)
+		}, &nlogger, "synthetic event generator paniced")
+	}
+	// recurring events registered by the admins
+	nScheduler := scheduler.NewScheduler(&nlogger, schedules, eq, func() bool { return !nApi.draining.Load() })
+	helpers.BackgroundJob(func() {
+		nScheduler.Run(ctx)
+	}, &nlogger, "scheduler of the recurring events paniced")
+	if resourceTuning != nil {
+		nlogger.Info().Interface("resources", resourceTuning).Msg("tuned the settings with respect to the resource limits")
+	}
+	nlogger.Info().
+		Str("build_time", BuildTime).
+		Interface("capabilities", nApi.capabilities()).
+		Msgf("behavox event queue %s", Version)
 	nSrv := http.Server{
 		Addr:         nApi.Cfg.ListenAddr.Host,
 		Handler:      nApi.routes(),
@@ -101,8 +372,78 @@ func Main() {
 		ErrorLog:     log.New(nApi.Logger, "", 0),
 	}
 
+	srvs := []*http.Server{&nSrv}
+
+	// admin unix socket used by the cli subcommands, bypassing the rate limit and authentication of the public listener
+	if CmdAdminSocket != "" {
+		localListener, err := listenAdminSocket(CmdAdminSocket)
+		if err != nil {
+			nlogger.Error().Err(err).Msg("failed to listen on the admin socket")
+			return
+		}
+		localSrv := &http.Server{
+			Handler:     nApi.localRoutes(),
+			IdleTimeout: nApi.Cfg.ServerIdleTimeout,
+			ErrorLog:    log.New(nApi.Logger, "", 0),
+		}
+		srvs = append(srvs, localSrv)
+		go func() {
+			nlogger.Info().Msgf("starting the admin server on unix socket %s", CmdAdminSocket)
+			err := localSrv.Serve(localListener)
+			if err != nil && err != http.ErrServerClosed {
+				nlogger.Error().Err(err).Msg("admin server stopped")
+			}
+		}()
+	}
+
+	// debug listener serving the pprof and expvar endpoints apart from the public traffic
+	if CmdDebugEndpoints && CmdDebugListenAddr != "" {
+		debugSrv := &http.Server{
+			Addr:        CmdDebugListenAddr,
+			Handler:     nApi.debugServerRoutes(),
+			ReadTimeout: nApi.Cfg.ServerReadTimeout,
+			IdleTimeout: nApi.Cfg.ServerIdleTimeout,
+			ErrorLog:    log.New(nApi.Logger, "", 0),
+		}
+		srvs = append(srvs, debugSrv)
+		go func() {
+			var err error
+			if nApi.Cfg.ListenAddr.Scheme == "https" {
+				nlogger.Info().Msgf("starting the debug server on %s over https", CmdDebugListenAddr)
+				err = debugSrv.ListenAndServeTLS(nApi.Cfg.TlsCertFile, nApi.Cfg.TlsKeyFile)
+			} else {
+				nlogger.Info().Msgf("starting the debug server on %s over http", CmdDebugListenAddr)
+				err = debugSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				nlogger.Error().Err(err).Msg("debug server stopped")
+			}
+		}()
+	}
+
+	// grpc listener serving the streams of the producers
+	if CmdGRPCListenAddr != "" {
+		nApi.grpc, err = nApi.newGRPCServer()
+		if err != nil {
+			nlogger.Error().Err(err).Msg("failed to initialize the grpc server")
+			return
+		}
+		grpcListener, err := net.Listen("tcp", CmdGRPCListenAddr)
+		if err != nil {
+			nlogger.Error().Err(err).Msg("failed to listen on the grpc listen address")
+			return
+		}
+		go func() {
+			nlogger.Info().Msgf("starting the grpc server on %s", CmdGRPCListenAddr)
+			err := nApi.grpc.Serve(grpcListener)
+			if err != nil && err != grpc.ErrServerStopped {
+				nlogger.Error().Err(err).Msg("grpc server stopped")
+			}
+		}()
+	}
+
 	shutdownChan := make(chan error)
-	go gracefulShutdown(nApi, &nlogger, shutdownChan, nSrv.Shutdown, nWorker.Shutdown, otelShut)
+	go gracefulShutdown(nApi, &nlogger, shutdownChan, nWorker, otelShut, srvs...)
 
 	if nApi.Cfg.ListenAddr.Scheme == "https" {
 		nlogger.Info().Msgf("starting the server on %s over %s", nApi.Cfg.ListenAddr.Host, nApi.Cfg.ListenAddr.Scheme)
@@ -125,32 +466,3 @@ func Main() {
 		nlogger.Error().Err(err).Send()
 	}
 }
-
-// gracefulShitdown catches the terminate, quit, interrupt signals and closes the connection gracefully
-func gracefulShutdown(api *ApiServer, logger *zerolog.Logger, shutdownChan chan error, shutdownFuncs ...func(context.Context) error) {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	s := <-sigChan
-
-	// log the signal catched
-	logger.Warn().Msgf("catched os signal %s", s)
-
-	// gracefully shutdown the services
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
-
-	for _, shutdownFunc := range shutdownFuncs {
-		err := shutdownFunc(ctx)
-		if err != nil {
-			shutdownChan <- err
-		}
-	}
-
-	// waiting for the background tasks to finish
-	logger.Info().Msg("waiting for background tasks to finish")
-	api.Wg.Wait()
-
-	shutdownChan <- nil
-
-	logger.Info().Msg("stopped the server")
-}
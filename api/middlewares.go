@@ -1,8 +1,11 @@
 package api
 
 import (
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net"
 	"net/http"
 	"runtime/debug"
@@ -11,6 +14,7 @@ import (
 	"time"
 
 	observ "github.com/cybrarymin/behavox/api/observability"
+	helpers "github.com/cybrarymin/behavox/internal"
 	"github.com/felixge/httpsnoop"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,6 +26,38 @@ import (
 	"golang.org/x/time/rate"
 )
 
+const (
+	MiddlewareTracing     = "tracing"
+	MiddlewarePrometheus  = "prom"
+	MiddlewareRateLimit   = "ratelimit"
+	MiddlewareAccessLog   = "access-log"
+	MiddlewareCORS        = "cors"
+	MiddlewareCompression = "compression"
+)
+
+var validMiddlewares = []string{MiddlewareTracing, MiddlewarePrometheus, MiddlewareRateLimit, MiddlewareAccessLog, MiddlewareCORS, MiddlewareCompression}
+
+/*
+middlewareChain wraps the handler with the middlewares specified in api configuration.
+The first middleware in the list will be the outermost one and the middlewares which are not listed are skipped entirely.
+*/
+func (api *ApiServer) middlewareChain(next http.Handler) http.Handler {
+	middlewares := map[string]func(http.Handler) http.Handler{
+		MiddlewareTracing:     api.otelHandler,
+		MiddlewarePrometheus:  api.promHandler,
+		MiddlewareRateLimit:   api.rateLimit,
+		MiddlewareAccessLog:   api.accessLog,
+		MiddlewareCORS:        api.enableCORS,
+		MiddlewareCompression: api.compressResponse,
+	}
+
+	handler := next
+	for i := len(api.Cfg.Middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[api.Cfg.Middlewares[i]](handler)
+	}
+	return handler
+}
+
 /*
 setContextHandler sets the required key, values on the http.request context
 */
@@ -68,8 +104,8 @@ func (api *ApiServer) otelHandler(next http.Handler) http.Handler {
 /*
 promHandler is gonna expose and calculate the prometheus metrics values on each api path.
 */
-func (api *ApiServer) promHandler(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (api *ApiServer) promHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		observ.PromHttpTotalRequests.WithLabelValues().Inc()
 		observ.PromHttpTotalPathRequests.WithLabelValues(r.RequestURI).Inc()
 		pTimer := prometheus.NewTimer(observ.PromHttpDuration.WithLabelValues(r.RequestURI))
@@ -77,7 +113,26 @@ func (api *ApiServer) promHandler(next http.HandlerFunc) http.HandlerFunc {
 		snoopMetrics := httpsnoop.CaptureMetrics(next, w, r)
 		observ.PromHttpTotalResponse.WithLabelValues().Inc()
 		observ.PromHttpResponseStatus.WithLabelValues(r.RequestURI, strconv.Itoa(snoopMetrics.Code)).Inc()
-	}
+		observ.OTelRecordHttpDuration(r.Context(), r.URL.Path, snoopMetrics.Code, snoopMetrics.Duration)
+	})
+}
+
+/*
+accessLog logs a single line for each request containing the method, path, status code, written bytes and duration of the request
+*/
+func (api *ApiServer) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snoopMetrics := httpsnoop.CaptureMetrics(next, w, r)
+		api.Logger.Info().Ctx(r.Context()).
+			Str("request_id", api.getReqIDContext(r)).
+			Str("remote_addr", r.RemoteAddr).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", snoopMetrics.Code).
+			Int64("bytes", snoopMetrics.Written).
+			Dur("duration", snoopMetrics.Duration).
+			Msg("access log")
+	})
 }
 
 /*
@@ -88,6 +143,29 @@ type ClientRateLimiter struct {
 	LastAccessTime *time.Timer
 }
 
+const (
+	rateLimitScopeGlobal = "global"
+	rateLimitScopeClient = "client"
+)
+
+/*
+rateLimitShadowed records the request rejected by the rate limiter and reports whether the request should be served anyway due to dry-run mode
+*/
+func (api *ApiServer) rateLimitShadowed(r *http.Request, scope string) bool {
+	if !api.Cfg.RateLimit.DryRun {
+		observ.PromHttpRateLimitRejections.WithLabelValues(scope, "enforced").Inc()
+		return false
+	}
+	observ.PromHttpRateLimitRejections.WithLabelValues(scope, "dry_run").Inc()
+	trace.SpanFromContext(r.Context()).AddEvent("request would be rejected by the rate limiter", trace.WithAttributes(attribute.String("rate_limit.scope", scope)))
+	api.Logger.Warn().
+		Str("request_id", api.getReqIDContext(r)).
+		Str("remote_addr", r.RemoteAddr).
+		Str("scope", scope).
+		Msg("request would be rejected by the rate limiter in enforcing mode")
+	return true
+}
+
 func (api *ApiServer) rateLimit(next http.Handler) http.Handler {
 	if api.Cfg.RateLimit.Enabled {
 		// Global rate limiter
@@ -109,7 +187,7 @@ func (api *ApiServer) rateLimit(next http.Handler) http.Handler {
 			// Update the request with the new context containing our span
 			r = r.WithContext(ctx)
 
-			if !nRL.Allow() { // In this code, whenever we call the Allow() method on the rate limiter exactly one token will be consumed from the bucket. And if there is no token in the bucket left Allow() will return false
+			if !nRL.Allow() && !api.rateLimitShadowed(r, rateLimitScopeGlobal) { // In this code, whenever we call the Allow() method on the rate limiter exactly one token will be consumed from the bucket. And if there is no token in the bucket left Allow() will return false
 				err := errors.New("request rate limit reached, please try again later")
 				span.RecordError(err)
 				span.SetStatus(codes.Error, "request rate limit reached, please try again later")
@@ -154,7 +232,7 @@ func (api *ApiServer) rateLimit(next http.Handler) http.Handler {
 			allow := pcnRL[clientAddr].Limit.Allow()
 			api.mu.RUnlock()
 
-			if !allow {
+			if !allow && !api.rateLimitShadowed(r, rateLimitScopeClient) {
 				err := errors.New("request rate limit reached, please try again later")
 				span.RecordError(err)
 				span.SetStatus(codes.Error, "request rate limit reached, please try again later")
@@ -171,7 +249,8 @@ func (api *ApiServer) rateLimit(next http.Handler) http.Handler {
 }
 
 /*
-JWTAuth will get the jwt token and verifies it
+JWTAuth will get the jwt token and verifies it. Tokens issued by the --oidc-issuer are verified by the published keys of the provider.
+Requests already authenticated by their hmac signature are accepted without a token.
 */
 func (api *ApiServer) JWTAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -180,56 +259,101 @@ func (api *ApiServer) JWTAuth(next http.HandlerFunc) http.HandlerFunc {
 		span.SetAttributes(attribute.String("http.target", r.RequestURI))
 		r = r.WithContext(ctx)
 
+		// producers signing their requests don't have a token
+		if api.hmacAuthenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		headerValue := r.Header.Get("Authorization")
 		if headerValue == "" {
 			err := errors.New("nil token received")
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "failed jwt authentication.")
+			api.auditAuthFailure(r, "", "auth.jwt", err)
 			api.invalidAuthenticationCredResponse(w, r)
 			return
 		}
 
 		headerValues := strings.Split(headerValue, " ")
-		if len(headerValues) != 2 && headerValues[0] != "Bearer" {
+		if len(headerValues) != 2 || headerValues[0] != "Bearer" {
 			err := errors.New("invalid auth header format")
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "failed jwt authentication.")
+			api.auditAuthFailure(r, "", "auth.jwt", err)
 			api.invalidAuthenticationCredResponse(w, r)
 			return
 		}
-		jToken := headerValues[1]
-		// ParseWithClaims will fetch the token and keystring of the token
-		// It will verify the signature to make sure token is valid
-		// It will verify all the registered claims of jwt.Registered claims
-		verifiedToken, err := jwt.ParseWithClaims(jToken, &customClaims{}, func(t *jwt.Token) (interface{}, error) {
-			return []byte(CmdJwtKey), nil
-		})
+		claims, err := api.verifyBearerToken(r, headerValues[1])
 		if err != nil {
-			switch {
-			case errors.Is(err, jwt.ErrTokenSignatureInvalid):
-				span.RecordError(err)
-				span.SetStatus(codes.Error, "failed jwt authentication")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed jwt authentication.")
+			if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
 				api.invalidJWTTokenSignatureResponse(w, r)
 				return
-			default:
-				span.RecordError(err)
-				span.SetStatus(codes.Error, "failed jwt authentication.")
-				api.invalidAuthenticationCredResponse(w, r)
-				return
 			}
-		}
-		if !verifiedToken.Valid {
-			err := errors.New("invalid jwt token")
-			span.RecordError(err)
-			span.SetStatus(codes.Error, "failed jwt authentication.")
 			api.invalidAuthenticationCredResponse(w, r)
 			return
 		}
-
+		span.SetAttributes(attribute.String("claims.subject", claims.Subject), attribute.String("claims.issuer", claims.Issuer))
+		if claims.Act != nil {
+			span.SetAttributes(attribute.StringSlice("claims.act", claims.Act.actors()))
+		}
+		r = api.setClaimsContext(r, claims)
 		next.ServeHTTP(w, r)
 	}
 }
 
+/*
+verifyBearerToken verifies the bearer token of the request and returns its claims, the failures are recorded in the audit log.
+Tokens of the corporate sso are verified by the keys of the oidc provider instead of the local key.
+*/
+func (api *ApiServer) verifyBearerToken(r *http.Request, jToken string) (*customClaims, error) {
+	if api.oidc != nil && api.oidc.issuedBy(jToken) {
+		claims, err := api.oidc.verify(r.Context(), jToken)
+		if err != nil {
+			api.auditAuthFailure(r, claimedSubject(jToken), "auth.oidc", err)
+			return nil, err
+		}
+		return claims, nil
+	}
+	// ParseWithClaims will fetch the token and keystring of the token
+	// It will verify the signature to make sure token is valid
+	// It will verify all the registered claims of jwt.Registered claims
+	verifiedToken, err := jwt.ParseWithClaims(jToken, &customClaims{}, api.accessTokenKey, api.accessTokenParserOptions()...)
+	if err == nil && !verifiedToken.Valid {
+		err = errors.New("invalid jwt token")
+	}
+	if err != nil {
+		api.auditAuthFailure(r, claimedSubject(jToken), "auth.jwt", err)
+		return nil, err
+	}
+	return verifiedToken.Claims.(*customClaims), nil
+}
+
+/*
+rejectWrites rejects all the requests which may change the state of the server when it's running in read-only mode.
+Issuing tokens is still allowed since the read endpoints require authentication, so is verifying the results for the forensics.
+External consumers keep acknowledging the pulled events like the worker keeps processing them.
+*/
+func (api *ApiServer) rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		case r.Method == http.MethodPost && helpers.In(r.URL.Path, "/v1/tokens", "/v1/tokens/exchange", "/v1/tokens/refresh"):
+		// verifying the results doesn't change them
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/events/") && strings.HasSuffix(r.URL.Path, "/verify"):
+		case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/events/leases/"):
+		// graphql queries are sent by post, its mutations are rejected by their resolvers
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/graphql":
+		default:
+			api.readOnlyResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 /*
 enableCORS is going add corss origin resource sharing required headers
 */
@@ -241,3 +365,140 @@ func (api *ApiServer) enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+/*
+gzipReadCloser decompresses the gzip request body. The gzip header is read on the first read,
+so the invalid bodies are reported by the decoders of the handlers like the other malformed bodies.
+*/
+type gzipReadCloser struct {
+	body     io.ReadCloser
+	gzReader *gzip.Reader
+}
+
+func (g *gzipReadCloser) Read(p []byte) (int, error) {
+	if g.gzReader == nil {
+		gzReader, err := gzip.NewReader(g.body)
+		if err != nil {
+			return 0, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		g.gzReader = gzReader
+	}
+	return g.gzReader.Read(p)
+}
+
+func (g *gzipReadCloser) Close() error {
+	return g.body.Close()
+}
+
+/*
+decompressRequest decompresses the request bodies sent with Content-Encoding: gzip before they reach the handlers.
+Body size limits of the handlers apply to the decompressed body, which protects the server from the decompression bombs.
+*/
+func (api *ApiServer) decompressRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			r.Body = &gzipReadCloser{body: r.Body}
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+		default:
+			message := fmt.Sprintf("unsupported content encoding %s, the body should be sent as is or gzip compressed", encoding)
+			api.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+gzipResponseWriter is a http.ResponseWriter which compresses the written body using gzip.
+Compression is decided once the header is written, so only the bodies of the api content types are compressed
+and the responses without a body or already encoded by the handler are written as is.
+*/
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzWriter *gzip.Writer // created on the first write of a compressed body
+	decided  bool
+	compress bool
+}
+
+/*
+compressibleContentType reports whether the responses of the media type are worth compressing
+*/
+func compressibleContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if _, found := helpers.LookupCodec(mediaType); found {
+		return true
+	}
+	return mediaType == contentTypeNDJSON || strings.HasPrefix(mediaType, "text/")
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.decided {
+		g.decided = true
+		header := g.Header()
+		g.compress = status != http.StatusNoContent && status != http.StatusNotModified &&
+			header.Get("Content-Encoding") == "" && compressibleContentType(header.Get("Content-Type"))
+		if g.compress {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.compress {
+		return g.ResponseWriter.Write(b)
+	}
+	if g.gzWriter == nil {
+		g.gzWriter = gzip.NewWriter(g.ResponseWriter)
+	}
+	return g.gzWriter.Write(b)
+}
+
+/*
+Unwrap returns the underlying writer, so http.ResponseController can reach the connection
+*/
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+/*
+Close flushes the compressed body if there's any
+*/
+func (g *gzipResponseWriter) Close() error {
+	if g.gzWriter == nil {
+		return nil
+	}
+	return g.gzWriter.Close()
+}
+
+/*
+compressResponse compresses the api responses with gzip when client supports it by sending Accept-Encoding: gzip header
+*/
+func (api *ApiServer) compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// inner handlers shouldn't compress the response again
+		r.Header.Del("Accept-Encoding")
+		gzWriter := &gzipResponseWriter{ResponseWriter: w}
+		defer func() {
+			err := gzWriter.Close()
+			if err != nil {
+				api.logError(err)
+			}
+		}()
+		next.ServeHTTP(gzWriter, r)
+	})
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestJWTAuthHeaderFormat(t *testing.T) {
+	api := newTestApiServer(t)
+	nRes, err := api.issueTokens(t.Context(), CmdApiAdmin, uuid.NewString(), scopeEventsRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.JWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{name: "bearer token", header: "Bearer " + nRes.Token, wantCode: http.StatusNoContent},
+		{name: "bare bearer", header: "Bearer", wantCode: http.StatusUnauthorized},
+		{name: "other scheme", header: "Basic " + nRes.Token, wantCode: http.StatusUnauthorized},
+		{name: "extra value", header: "Bearer " + nRes.Token + " extra", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodGet, "/v1/events", "")
+			r.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
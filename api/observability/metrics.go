@@ -1,6 +1,8 @@
 package observ
 
 import (
+	"context"
+
 	data "github.com/cybrarymin/behavox/internal/models"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -44,11 +46,53 @@ var (
 		Buckets:   prometheus.DefBuckets,
 	}, []string{"path"})
 
+	PromHttpRateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "http",
+		Name:      "rate_limit_rejections_total",
+		Help:      "Total number of requests rejected by the rate limiter. in dry-run mode requests are only counted and not rejected",
+	}, []string{"scope", "mode"})
+
+	PromHttpOversizedBodyRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "http",
+		Name:      "oversized_body_rejections_total",
+		Help:      "Total number of requests rejected since their body exceeded the global or event type specific size limit",
+	}, []string{"event_type"})
+
+	PromHttpBackpressureRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "http",
+		Name:      "backpressure_rejections_total",
+		Help:      "Total number of requests creating events rejected since the worker is paused and the event queue reached its high water mark",
+	}, []string{})
+
+	PromLogShippingSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "log_shipping",
+		Name:      "sent_lines_total",
+		Help:      "Total number of the log lines shipped to the log sink",
+	}, []string{"sink"})
+
+	PromLogShippingDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "log_shipping",
+		Name:      "dropped_lines_total",
+		Help:      "Total number of the log lines dropped since the buffer of the log sink is full or the sink is unavailable",
+	}, []string{"sink", "reason"})
+
+	PromHttpDeadlineExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "http",
+		Name:      "deadline_exceeded_total",
+		Help:      "Total number of requests which didn't finish before the deadline propagated by the client, by the stage the deadline is exceeded in",
+	}, []string{"stage"})
+
 	PromApplicationVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "application",
 		Name:      "info",
 		Help:      "Application binary version",
 	}, []string{"version"})
+
+	PromConfigInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "application",
+		Name:      "config_info",
+		Help:      "Hash of the effective configuration, used to detect the configuration drift across the instances",
+	}, []string{"hash"})
 )
 
 // Worker event consumer related metrics
@@ -77,6 +121,175 @@ var (
 		Help:      "Duration of event processing in seconds",
 		Buckets:   prometheus.DefBuckets,
 	}, []string{"event_type"})
+
+	PromTraceEventSpanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "trace_events_span_duration_seconds",
+		Help:      "Duration of the spans reported by processed trace events",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service"})
+
+	PromAuditEventTotalProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "audit_events_processed_total",
+		Help:      "Total number of audit events processed by the outcome of the audited action",
+	}, []string{"event_process_status", "outcome"})
+
+	PromEventDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "events_dead_lettered_total",
+		Help:      "Total number of events moved to the dead letter queue by failure reason",
+	}, []string{"reason", "event_type"})
+
+	PromEventDeadLetterReplayed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "events_dead_letter_replayed_total",
+		Help:      "Total number of dead lettered events re-enqueued by the replay requests by their failure reason",
+	}, []string{"reason", "event_type"})
+
+	PromEventLeases = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "event_leases_total",
+		Help:      "Total number of the events pulled by the external consumers by result. result is leased, acked, nacked or expired",
+	}, []string{"result"})
+
+	PromWebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "webhook_deliveries_total",
+		Help:      "Total number of webhook deliveries by result. result is delivered, failed after all the attempts or dropped when the dispatcher buffer is full",
+	}, []string{"result"})
+
+	PromWebhookRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "webhook_delivery_retries_total",
+		Help:      "Total number of retried webhook delivery attempts",
+	})
+
+	PromWebhookDeliveryDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "webhook_delivery_duration_seconds",
+		Help:      "Duration of the webhook delivery attempts",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	PromEventDigestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "event_digest_duration_seconds",
+		Help:      "Duration of calculating the digests of the processed events by the digest algorithm",
+		Buckets:   []float64{.000005, .00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01},
+	}, []string{"algorithm"})
+
+	PromEventAlreadyProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "events_already_processed_total",
+		Help:      "Total number of the events skipped by the worker since an event with the same event_id is already processed",
+	}, []string{"event_type"})
+
+	PromEventPoisoned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "events_poisoned_total",
+		Help:      "Total number of the events quarantined to the dead letter queue since they repeatedly crashed or timed out the processor",
+	}, []string{"reason", "event_type"})
+
+	PromCallbackDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "callback_deliveries_total",
+		Help:      "Total number of deliveries to the callback urls of the events by result. result is delivered, failed after all the attempts or dropped when the dispatcher buffer is full",
+	}, []string{"result"})
+
+	PromCallbackRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "callback_delivery_retries_total",
+		Help:      "Total number of retried callback delivery attempts",
+	})
+
+	PromEventBatchCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "event_batches_completed_total",
+		Help:      "Total number of completed event batches by result. result is failed if any event of the batch isn't processed successfully",
+	}, []string{"result"})
+
+	PromLineageEventsEmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "openlineage_events_total",
+		Help:      "Total number of OpenLineage run events by result. result is sent, failed or dropped when the emitter buffer is full",
+	}, []string{"result"})
+
+	PromEventCPUSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "event_cpu_seconds_total",
+		Help:      "Approximate cpu time spent on processing the events including the retries. tenant is the producer of the events",
+	}, []string{"event_type", "tenant"})
+
+	PromWorkerStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the stages of the processing pipeline including the failed ones",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"stage"})
+
+	PromWorkerStageFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "stage_failures_total",
+		Help:      "Total number of the events failed by the stages of the processing pipeline including the retries, by failure reason",
+	}, []string{"stage", "reason"})
+
+	PromWorkerSinkWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "sink_writes_total",
+		Help:      "Total number of the process results written to the sinks including the retries, by status",
+	}, []string{"sink", "status"})
+
+	PromWorkerMaxEventsPerSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "worker",
+		Name:      "max_events_per_second",
+		Help:      "Current limit of the events processed by the worker per second, zero if the throttling is disabled",
+	}, []string{})
+
+	PromWorkerCircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "worker",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker of the event processing, 1 for the current state",
+	}, []string{"state"})
+
+	PromWorkerCircuitBreakerOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "circuit_breaker_opened_total",
+		Help:      "Total number of times the circuit breaker of the event processing is opened",
+	}, []string{})
+
+	PromWorkerInFlightEvents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "worker",
+		Name:      "inflight_events",
+		Help:      "Current number of the events being processed by the worker",
+	}, []string{"event_type"})
+
+	PromWorkerPoolSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "worker",
+		Name:      "pool_size",
+		Help:      "Current number of the worker threads allowed to process the events, it's adjusted by the autoscaling",
+	}, []string{})
+
+	PromEventWrittenBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "event_written_bytes_total",
+		Help:      "Total number of bytes written to the processed events sink including the retries. tenant is the producer of the events",
+	}, []string{"event_type", "tenant"})
+)
+
+// Synthetic event generators related metrics
+var (
+	PromScheduledEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scheduler",
+		Name:      "events_total",
+		Help:      "Total number of events injected by the recurring schedules by status. status is dropped if the event couldn't be enqueued",
+	}, []string{"event_type", "status"})
+
+	PromGeneratedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "generator",
+		Name:      "events_total",
+		Help:      "Total number of synthetic events generated by status. status is dropped if the event couldn't be enqueued",
+	}, []string{"event_type", "status"})
 )
 
 // EventQueue related metrics
@@ -92,23 +305,33 @@ var (
 		Name:      "wait_time_seconds",
 		Help:      "Time events spend waiting in queue before processing",
 		Buckets:   prometheus.DefBuckets,
-	}, []string{"event_type"})
+	}, []string{"event_type", "priority"})
 )
 
-func PromInit(eq *data.EventQueue, appVersion string) {
+func PromInit(eq *data.EventQueue, dlq *data.DeadLetterQueue, appVersion string, configHash string) {
 	// Event Queue Gauge function
 	PromEventQueueSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: "queue",
 		Name:      "current_size",
 		Help:      "number of events inside the queue",
 	}, func() float64 {
-		return float64(len(eq.Events))
+		return float64(eq.Size(context.Background()))
 	})
+	// Dead letter queue Gauge function
+	PromDeadLetterQueueSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "dlq",
+		Name:      "current_size",
+		Help:      "number of events inside the dead letter queue",
+	}, func() float64 {
+		return float64(dlq.Size(context.Background()))
+	})
+
 	// setting eventQueue maximum capacity metric
 	PromEventQueueCapacity.WithLabelValues().Set(float64(eq.Capacity))
 
 	// setting application version metric
 	PromApplicationVersion.WithLabelValues(appVersion).Set(1)
+	PromConfigInfo.WithLabelValues(configHash).Set(1)
 
 	prometheus.MustRegister(
 		PromHttpTotalRequests,
@@ -116,7 +339,14 @@ func PromInit(eq *data.EventQueue, appVersion string) {
 		PromHttpResponseStatus,
 		PromHttpDuration,
 		PromApplicationVersion,
+		PromConfigInfo,
 		PromHttpTotalResponse,
+		PromHttpRateLimitRejections,
+		PromHttpOversizedBodyRejections,
+		PromHttpDeadlineExceeded,
+		PromHttpBackpressureRejections,
+		PromLogShippingSent,
+		PromLogShippingDropped,
 		PromEventTotalProcessed,
 		PromEventTotalProcessStatus,
 		PromEventProcessingDuration,
@@ -124,5 +354,33 @@ func PromInit(eq *data.EventQueue, appVersion string) {
 		PromEventQueueCapacity,
 		PromEventQueueWaitTime,
 		PromEventRetryCount,
+		PromEventDeadLettered,
+		PromEventDeadLetterReplayed,
+		PromEventLeases,
+		PromWebhookDeliveries,
+		PromWebhookRetries,
+		PromWebhookDeliveryDuration,
+		PromCallbackDeliveries,
+		PromEventAlreadyProcessed,
+		PromEventPoisoned,
+		PromEventDigestDuration,
+		PromCallbackRetries,
+		PromEventBatchCompleted,
+		PromLineageEventsEmitted,
+		PromEventCPUSeconds,
+		PromWorkerStageDuration,
+		PromWorkerStageFailures,
+		PromWorkerPoolSize,
+		PromWorkerInFlightEvents,
+		PromWorkerSinkWrites,
+		PromWorkerCircuitBreakerState,
+		PromWorkerMaxEventsPerSecond,
+		PromWorkerCircuitBreakerOpened,
+		PromEventWrittenBytes,
+		PromGeneratedEvents,
+		PromScheduledEvents,
+		PromDeadLetterQueueSize,
+		PromTraceEventSpanDuration,
+		PromAuditEventTotalProcessed,
 	)
 }
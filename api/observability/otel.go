@@ -24,7 +24,7 @@ var (
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func SetupOTelSDK(ctx context.Context, JeagerHost string, JeagerPort string, JeagerConnTimeout time.Duration, batchExpiry time.Duration) (shutdown func(context.Context) error, err error) {
+func SetupOTelSDK(ctx context.Context, JeagerHost string, JeagerPort string, JeagerConnTimeout time.Duration, batchExpiry time.Duration, metricsEndpoint string, metricsExportInterval time.Duration, exporterProtocol string, exporterProxy string) (shutdown func(context.Context) error, err error) {
 
 	var shutdownFuncs []func(context.Context) error
 
@@ -49,14 +49,20 @@ func SetupOTelSDK(ctx context.Context, JeagerHost string, JeagerPort string, Jea
 	prop := newPropagator()
 	otel.SetTextMapPropagator(prop)
 
+	res, err := newResource()
+	if err != nil {
+		handleErr(err)
+		return
+	}
+
 	// Set up Jaeger exporter
-	traceExporter, err := newJaegerTraceExporter(ctx, JeagerHost, JeagerPort, JeagerConnTimeout)
+	traceExporter, err := newJaegerTraceExporter(ctx, JeagerHost, JeagerPort, JeagerConnTimeout, exporterProtocol, exporterProxy)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	// Set up trace provider.
-	tracerProvider, err := newTraceProvider(traceExporter, batchExpiry)
+	tracerProvider, err := newTraceProvider(res, traceExporter, batchExpiry)
 	if err != nil {
 		handleErr(err)
 		return
@@ -65,6 +71,17 @@ func SetupOTelSDK(ctx context.Context, JeagerHost string, JeagerPort string, Jea
 	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
 	otel.SetTracerProvider(tracerProvider)
 
+	// Set up meter provider. metrics are only exported through otlp if the endpoint is specified
+	if metricsEndpoint != "" {
+		meterProvider, mErr := newMeterProvider(res, metricsEndpoint, JeagerConnTimeout, metricsExportInterval, exporterProtocol, exporterProxy)
+		if mErr != nil {
+			handleErr(mErr)
+			return
+		}
+		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+		otel.SetMeterProvider(meterProvider)
+	}
+
 	return
 }
 
@@ -76,12 +93,19 @@ func newPropagator() propagation.TextMapPropagator {
 	)
 }
 
-// Create an exporter over HTTP for Jaeger endpoint. In latest version, Jaeger supports otlp endpoint
-func newJaegerTraceExporter(ctx context.Context, host string, port string, connTimeout time.Duration) (trace.SpanExporter, error) {
-	traceClient := otlptracegrpc.NewClient(
+// Create an exporter over grpc or HTTP for Jaeger endpoint. In latest version, Jaeger supports otlp endpoint
+func newJaegerTraceExporter(ctx context.Context, host string, port string, connTimeout time.Duration, protocol string, proxy string) (trace.SpanExporter, error) {
+	var traceClient otlptrace.Client = otlptracegrpc.NewClient(
 		otlptracegrpc.WithEndpoint(host+":"+port),
 		otlptracegrpc.WithInsecure(), // TODO for security reason
 		otlptracegrpc.WithTimeout(connTimeout))
+	if protocol == OTLPProtocolHTTP {
+		httpClient, err := newOTLPHTTPClient(host+":"+port, proxy, connTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+		}
+		traceClient = &otlpHTTPTraceClient{httpClient}
+	}
 
 	traceExporter, err := otlptrace.New(ctx, traceClient)
 	if err != nil {
@@ -90,16 +114,15 @@ func newJaegerTraceExporter(ctx context.Context, host string, port string, connT
 	return traceExporter, nil
 }
 
-// a traceProvider using Jeager exporter
-func newTraceProvider(traceExporter trace.SpanExporter, batchExportPeriod time.Duration) (*trace.TracerProvider, error) {
-	// define resource attributes. resource attributes are attrs such as pod name, service name, os, arch and...
-	rattr, err := resource.Merge(
+// define resource attributes shared by the traces and the metrics. resource attributes are attrs such as pod name, service name, os, arch and...
+func newResource() (*resource.Resource, error) {
+	return resource.Merge(
 		resource.Default(),
 		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("eventApi")))
-	if err != nil {
-		return nil, err
-	}
+}
 
+// a traceProvider using Jeager exporter
+func newTraceProvider(rattr *resource.Resource, traceExporter trace.SpanExporter, batchExportPeriod time.Duration) (*trace.TracerProvider, error) {
 	traceProvider := trace.NewTracerProvider(
 		trace.WithBatcher(traceExporter,
 			// Default is 5s. Set to 1s for demonstrative purposes.
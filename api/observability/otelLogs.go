@@ -0,0 +1,188 @@
+package observ
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	CmdOTelLogsEndpoint string
+)
+
+// fields of the log lines carrying the span context the log line is written in
+const (
+	logTraceIDField = "trace_id"
+	logSpanIDField  = "span_id"
+)
+
+/*
+TraceContextHook adds the trace and span id of the span inside the context of the log event, so the logs can be correlated with the traces.
+The context is attached to the log events by zerolog.Event.Ctx.
+*/
+type TraceContextHook struct{}
+
+func (h TraceContextHook) Run(e *zerolog.Event, level zerolog.Level, message string) {
+	spanCtx := trace.SpanContextFromContext(e.GetCtx())
+	if !spanCtx.IsValid() {
+		return
+	}
+	e.Str(logTraceIDField, spanCtx.TraceID().String()).Str(logSpanIDField, spanCtx.SpanID().String())
+}
+
+// severities of the zerolog levels defined by the opentelemetry logs data model
+var otelLogSeverities = map[string]logspb.SeverityNumber{
+	"trace": logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+	"debug": logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+	"info":  logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+	"warn":  logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+	"error": logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	"fatal": logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+	"panic": logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4,
+}
+
+/*
+OTLPLogLine is a json log line written by zerolog and the time it's been written at
+*/
+type OTLPLogLine struct {
+	Line []byte
+	At   time.Time
+}
+
+/*
+OTLPLogExporter exports the zerolog json lines as the opentelemetry log records to the otlp grpc or http logs service.
+The level and message of the lines become the severity and body of the records, the trace and span ids become the span context
+of the records and the rest of the fields become their attributes.
+*/
+type OTLPLogExporter struct {
+	upload   func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error
+	close    func() error
+	resource *resourcepb.Resource
+	timeout  time.Duration
+}
+
+func NewOTLPLogExporter(endpoint string, timeout time.Duration, protocol string, proxy string) (*OTLPLogExporter, error) {
+	res, err := newResource()
+	if err != nil {
+		return nil, err
+	}
+	exporter := &OTLPLogExporter{resource: &resourcepb.Resource{Attributes: toKeyValues(res.Iter())}, timeout: timeout}
+	if protocol == OTLPProtocolHTTP {
+		httpClient, err := newOTLPHTTPClient(endpoint, proxy, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP log exporter: %w", err)
+		}
+		exporter.upload = func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+			return httpClient.export(ctx, "/v1/logs", req)
+		}
+		exporter.close = httpClient.close
+		return exporter, nil
+	}
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials())) // TODO for security reason
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP log exporter: %w", err)
+	}
+	client := collogspb.NewLogsServiceClient(conn)
+	exporter.upload = func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		_, err := client.Export(ctx, req)
+		return err
+	}
+	exporter.close = conn.Close
+	return exporter, nil
+}
+
+func (e *OTLPLogExporter) Export(lines []OTLPLogLine) error {
+	records := make([]*logspb.LogRecord, 0, len(lines))
+	for _, line := range lines {
+		records = append(records, toLogRecord(line))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	return e.upload(ctx, &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource:  e.resource,
+		ScopeLogs: []*logspb.ScopeLogs{{Scope: &commonpb.InstrumentationScope{Name: "github.com/cybrarymin/behavox"}, LogRecords: records}},
+	}}})
+}
+
+func (e *OTLPLogExporter) Close() error {
+	return e.close()
+}
+
+func toLogRecord(line OTLPLogLine) *logspb.LogRecord {
+	record := &logspb.LogRecord{
+		TimeUnixNano:         toUnixNano(line.At),
+		ObservedTimeUnixNano: toUnixNano(line.At),
+	}
+	fields := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(line.Line))
+	decoder.UseNumber()
+	if decoder.Decode(&fields) != nil {
+		// lines which aren't json are exported as they are
+		record.Body = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: string(line.Line)}}
+		return record
+	}
+	for name, value := range fields {
+		switch name {
+		case zerolog.LevelFieldName:
+			record.SeverityText, _ = value.(string)
+			record.SeverityNumber = otelLogSeverities[record.SeverityText]
+		case zerolog.MessageFieldName:
+			record.Body = toLogValue(value)
+		case zerolog.TimestampFieldName:
+			// zerolog timestamp has second precision, so the time of writing the line is kept instead
+		case logTraceIDField:
+			if traceID, err := trace.TraceIDFromHex(fmt.Sprint(value)); err == nil {
+				record.TraceId = traceID[:]
+			}
+		case logSpanIDField:
+			if spanID, err := hex.DecodeString(fmt.Sprint(value)); err == nil && len(spanID) == 8 {
+				record.SpanId = spanID
+			}
+		default:
+			record.Attributes = append(record.Attributes, &commonpb.KeyValue{Key: name, Value: toLogValue(value)})
+		}
+	}
+	return record
+}
+
+func toLogValue(value any) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: n}}
+		}
+		n, _ := v.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: n}}
+	case []any:
+		values := make([]*commonpb.AnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, toLogValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		kvs := make([]*commonpb.KeyValue, 0, len(v))
+		for name, item := range v {
+			kvs = append(kvs, &commonpb.KeyValue{Key: name, Value: toLogValue(item)})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+	default:
+		// null values
+		return &commonpb.AnyValue{}
+	}
+}
@@ -0,0 +1,305 @@
+package observ
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	CmdOTelMetricsEndpoint       string
+	CmdOTelMetricsExportInterval time.Duration
+)
+
+// instruments are created by the global meter provider, so they're exported once the meter provider is set up and no-op otherwise
+var otelMeter = otel.Meter("github.com/cybrarymin/behavox")
+
+// OpenTelemetry mirrors of the key prometheus metrics for the otlp native backends
+var (
+	otelHttpDuration = mustInstrument(otelMeter.Float64Histogram("http.server.response_time",
+		metric.WithUnit("s"), metric.WithDescription("Duration of HTTP requests")))
+
+	otelEventProcessingDuration = mustInstrument(otelMeter.Float64Histogram("worker.event.processing.duration",
+		metric.WithUnit("s"), metric.WithDescription("Time taken to process events by the worker")))
+
+	otelEventQueueWaitTime = mustInstrument(otelMeter.Float64Histogram("queue.wait_time",
+		metric.WithUnit("s"), metric.WithDescription("Time events spend waiting in queue before processing")))
+
+	otelEventProcessStatus = mustInstrument(otelMeter.Int64Counter("worker.events.processed",
+		metric.WithUnit("{event}"), metric.WithDescription("Number of the events processed by the worker by their process status")))
+)
+
+func mustInstrument[T any](instrument T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return instrument
+}
+
+/*
+OTelInit registers the OpenTelemetry gauges observing the queues like the prometheus gauge functions of PromInit
+*/
+func OTelInit(eq *data.EventQueue, dlq *data.DeadLetterQueue) error {
+	queueSize := mustInstrument(otelMeter.Int64ObservableGauge("queue.size",
+		metric.WithUnit("{event}"), metric.WithDescription("Number of events inside the queue")))
+	queueCapacity := mustInstrument(otelMeter.Int64ObservableGauge("queue.capacity",
+		metric.WithUnit("{event}"), metric.WithDescription("Capacity of the queue")))
+	deadLetterQueueSize := mustInstrument(otelMeter.Int64ObservableGauge("dlq.size",
+		metric.WithUnit("{event}"), metric.WithDescription("Number of events inside the dead letter queue")))
+	_, err := otelMeter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		observer.ObserveInt64(queueSize, int64(eq.Size(ctx)))
+		observer.ObserveInt64(queueCapacity, eq.Capacity)
+		observer.ObserveInt64(deadLetterQueueSize, int64(dlq.Size(ctx)))
+		return nil
+	}, queueSize, queueCapacity, deadLetterQueueSize)
+	return err
+}
+
+func OTelRecordHttpDuration(ctx context.Context, path string, statusCode int, duration time.Duration) {
+	otelHttpDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("path", path), attribute.Int("status_code", statusCode)))
+}
+
+func OTelRecordEventProcessingDuration(ctx context.Context, eventType string, duration time.Duration) {
+	otelEventProcessingDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("event_type", eventType)))
+}
+
+func OTelRecordEventQueueWaitTime(ctx context.Context, eventType string, priority int, waitTime time.Duration) {
+	otelEventQueueWaitTime.Record(ctx, waitTime.Seconds(), metric.WithAttributes(attribute.String("event_type", eventType), attribute.Int("priority", priority)))
+}
+
+func OTelRecordEventProcessStatus(ctx context.Context, eventType string, status string) {
+	otelEventProcessStatus.Add(ctx, 1, metric.WithAttributes(attribute.String("event_type", eventType), attribute.String("status", status)))
+}
+
+/*
+newMeterProvider returns the meter provider exporting the metrics to the otlp grpc or http endpoint every export interval
+*/
+func newMeterProvider(res *resource.Resource, endpoint string, connTimeout time.Duration, exportInterval time.Duration, protocol string, proxy string) (*sdkmetric.MeterProvider, error) {
+	exporter := &otlpMetricExporter{timeout: connTimeout}
+	if protocol == OTLPProtocolHTTP {
+		httpClient, err := newOTLPHTTPClient(endpoint, proxy, connTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP metric exporter: %w", err)
+		}
+		exporter.upload = func(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+			return httpClient.export(ctx, "/v1/metrics", req)
+		}
+		exporter.close = httpClient.close
+	} else {
+		conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials())) // TODO for security reason
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP metric exporter: %w", err)
+		}
+		client := colmetricpb.NewMetricsServiceClient(conn)
+		exporter.upload = func(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+			_, err := client.Export(ctx, req)
+			return err
+		}
+		exporter.close = conn.Close
+	}
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(exportInterval))),
+	), nil
+}
+
+/*
+otlpMetricExporter exports the collected metrics to the otlp metrics service, the module doesn't carry the otlp metric exporter of the sdk
+*/
+type otlpMetricExporter struct {
+	upload  func(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error
+	close   func() error
+	timeout time.Duration
+}
+
+func (e *otlpMetricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(kind)
+}
+
+func (e *otlpMetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *otlpMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.upload(ctx, &colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricpb.ResourceMetrics{toResourceMetrics(rm)}})
+}
+
+func (e *otlpMetricExporter) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+func (e *otlpMetricExporter) Shutdown(ctx context.Context) error {
+	return e.close()
+}
+
+func toResourceMetrics(rm *metricdata.ResourceMetrics) *metricpb.ResourceMetrics {
+	nRM := &metricpb.ResourceMetrics{
+		Resource:     &resourcepb.Resource{Attributes: toKeyValues(rm.Resource.Iter())},
+		SchemaUrl:    rm.Resource.SchemaURL(),
+		ScopeMetrics: make([]*metricpb.ScopeMetrics, 0, len(rm.ScopeMetrics)),
+	}
+	for _, sm := range rm.ScopeMetrics {
+		nSM := &metricpb.ScopeMetrics{
+			Scope:     &commonpb.InstrumentationScope{Name: sm.Scope.Name, Version: sm.Scope.Version},
+			SchemaUrl: sm.Scope.SchemaURL,
+			Metrics:   make([]*metricpb.Metric, 0, len(sm.Metrics)),
+		}
+		for _, m := range sm.Metrics {
+			if nMetric := toMetric(m); nMetric != nil {
+				nSM.Metrics = append(nSM.Metrics, nMetric)
+			}
+		}
+		nRM.ScopeMetrics = append(nRM.ScopeMetrics, nSM)
+	}
+	return nRM
+}
+
+/*
+toMetric converts the aggregations used by the instruments of the server, nil is returned for the others
+*/
+func toMetric(m metricdata.Metrics) *metricpb.Metric {
+	nMetric := &metricpb.Metric{Name: m.Name, Description: m.Description, Unit: m.Unit}
+	switch agg := m.Data.(type) {
+	case metricdata.Gauge[int64]:
+		nMetric.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: toNumberDataPoints(agg.DataPoints)}}
+	case metricdata.Gauge[float64]:
+		nMetric.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: toNumberDataPoints(agg.DataPoints)}}
+	case metricdata.Sum[int64]:
+		nMetric.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			AggregationTemporality: toTemporality(agg.Temporality), IsMonotonic: agg.IsMonotonic, DataPoints: toNumberDataPoints(agg.DataPoints)}}
+	case metricdata.Sum[float64]:
+		nMetric.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			AggregationTemporality: toTemporality(agg.Temporality), IsMonotonic: agg.IsMonotonic, DataPoints: toNumberDataPoints(agg.DataPoints)}}
+	case metricdata.Histogram[int64]:
+		nMetric.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			AggregationTemporality: toTemporality(agg.Temporality), DataPoints: toHistogramDataPoints(agg.DataPoints)}}
+	case metricdata.Histogram[float64]:
+		nMetric.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			AggregationTemporality: toTemporality(agg.Temporality), DataPoints: toHistogramDataPoints(agg.DataPoints)}}
+	default:
+		return nil
+	}
+	return nMetric
+}
+
+func toTemporality(temporality metricdata.Temporality) metricpb.AggregationTemporality {
+	switch temporality {
+	case metricdata.DeltaTemporality:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	case metricdata.CumulativeTemporality:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	default:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+	}
+}
+
+func toNumberDataPoints[N int64 | float64](dataPoints []metricdata.DataPoint[N]) []*metricpb.NumberDataPoint {
+	nDataPoints := make([]*metricpb.NumberDataPoint, 0, len(dataPoints))
+	for _, dp := range dataPoints {
+		nDP := &metricpb.NumberDataPoint{
+			Attributes:        toKeyValues(dp.Attributes.Iter()),
+			StartTimeUnixNano: toUnixNano(dp.StartTime),
+			TimeUnixNano:      toUnixNano(dp.Time),
+		}
+		switch value := any(dp.Value).(type) {
+		case int64:
+			nDP.Value = &metricpb.NumberDataPoint_AsInt{AsInt: value}
+		case float64:
+			nDP.Value = &metricpb.NumberDataPoint_AsDouble{AsDouble: value}
+		}
+		nDataPoints = append(nDataPoints, nDP)
+	}
+	return nDataPoints
+}
+
+func toHistogramDataPoints[N int64 | float64](dataPoints []metricdata.HistogramDataPoint[N]) []*metricpb.HistogramDataPoint {
+	nDataPoints := make([]*metricpb.HistogramDataPoint, 0, len(dataPoints))
+	for _, dp := range dataPoints {
+		sum := float64(dp.Sum)
+		nDP := &metricpb.HistogramDataPoint{
+			Attributes:        toKeyValues(dp.Attributes.Iter()),
+			StartTimeUnixNano: toUnixNano(dp.StartTime),
+			TimeUnixNano:      toUnixNano(dp.Time),
+			Count:             dp.Count,
+			Sum:               &sum,
+			BucketCounts:      dp.BucketCounts,
+			ExplicitBounds:    dp.Bounds,
+		}
+		if minValue, defined := dp.Min.Value(); defined {
+			nMin := float64(minValue)
+			nDP.Min = &nMin
+		}
+		if maxValue, defined := dp.Max.Value(); defined {
+			nMax := float64(maxValue)
+			nDP.Max = &nMax
+		}
+		nDataPoints = append(nDataPoints, nDP)
+	}
+	return nDataPoints
+}
+
+func toUnixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func toKeyValues(iter attribute.Iterator) []*commonpb.KeyValue {
+	keyValues := make([]*commonpb.KeyValue, 0, iter.Len())
+	for iter.Next() {
+		kv := iter.Attribute()
+		keyValues = append(keyValues, &commonpb.KeyValue{Key: string(kv.Key), Value: toAnyValue(kv.Value)})
+	}
+	return keyValues
+}
+
+func toAnyValue(value attribute.Value) *commonpb.AnyValue {
+	switch value.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value.AsFloat64()}}
+	case attribute.BOOLSLICE, attribute.INT64SLICE, attribute.FLOAT64SLICE, attribute.STRINGSLICE:
+		values := make([]*commonpb.AnyValue, 0)
+		switch items := value.AsInterface().(type) {
+		case []bool:
+			for _, item := range items {
+				values = append(values, toAnyValue(attribute.BoolValue(item)))
+			}
+		case []int64:
+			for _, item := range items {
+				values = append(values, toAnyValue(attribute.Int64Value(item)))
+			}
+		case []float64:
+			for _, item := range items {
+				values = append(values, toAnyValue(attribute.Float64Value(item)))
+			}
+		case []string:
+			for _, item := range items {
+				values = append(values, toAnyValue(attribute.StringValue(item)))
+			}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value.Emit()}}
+	}
+}
@@ -0,0 +1,106 @@
+package observ
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	CmdOTelExporterProtocol string
+	CmdOTelExporterProxy    string
+)
+
+// protocols the traces, metrics and logs are exported to the otlp endpoints over
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+var OTLPProtocols = []string{OTLPProtocolGRPC, OTLPProtocolHTTP}
+
+// maximum size of the otlp endpoint responses read for the error messages
+const otlpHTTPMaxErrorBytes = 4096
+
+/*
+otlpHTTPClient exports the otlp protobuf requests over http, e.g. to the 4318 port of the collector, for the networks blocking grpc.
+Requests go through the proxy if it's specified and through the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables otherwise.
+*/
+type otlpHTTPClient struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newOTLPHTTPClient(endpoint string, proxy string, timeout time.Duration) (*otlpHTTPClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("otel exporter proxy should be a valid url")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &otlpHTTPClient{
+		endpoint: "http://" + endpoint, // TODO for security reason
+		client:   &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+/*
+export posts the request to the signal path of the endpoint like /v1/traces, /v1/metrics and /v1/logs
+*/
+func (c *otlpHTTPClient) export(ctx context.Context, path string, req proto.Message) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	res, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, otlpHTTPMaxErrorBytes))
+		return fmt.Errorf("otlp endpoint %s responded with status %d: %s", c.endpoint+path, res.StatusCode, bytes.TrimSpace(message))
+	}
+	// the response is drained so the connection can be reused
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
+
+func (c *otlpHTTPClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+/*
+otlpHTTPTraceClient is the otlptrace client uploading the spans over http
+*/
+type otlpHTTPTraceClient struct {
+	*otlpHTTPClient
+}
+
+func (c *otlpHTTPTraceClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *otlpHTTPTraceClient) Stop(ctx context.Context) error {
+	return c.close()
+}
+
+func (c *otlpHTTPTraceClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	return c.export(ctx, "/v1/traces", &coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdOIDCIssuer     string
+	CmdOIDCJWKSURL    string
+	CmdOIDCAudience   string
+	CmdOIDCAdminGroup string
+)
+
+const (
+	oidcRequestTimeout = 10 * time.Second
+	// keys are fetched again after the ttl, or on an unknown kid at most once per refresh interval since the providers rotate their keys
+	oidcJWKSCacheTTL         = time.Hour
+	oidcJWKSRefreshInterval  = time.Minute
+	oidcDiscoveryPath        = "/.well-known/openid-configuration"
+	oidcMaxMetadataBodyBytes = 1 << 20
+)
+
+// asymmetric algorithms accepted from the provider, HS256 is only accepted from the locally signed tokens
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+var (
+	ErrOIDCUnknownKey = errors.New("signing key of the token isn't published by the oidc provider")
+)
+
+/*
+oidcClaims are the claims of the tokens issued by the external provider. Unlike the local tokens the email claim is optional.
+*/
+type oidcClaims struct {
+	Email  string   `json:"email"`
+	Scope  string   `json:"scope"`
+	Groups []string `json:"groups"`
+	jwt.RegisteredClaims
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+/*
+oidcVerifier verifies the bearer tokens issued by an external OIDC provider using the public keys of its JWKS.
+The JWKS url is discovered from the issuer unless it's configured explicitly.
+*/
+type oidcVerifier struct {
+	issuer     string
+	audience   string
+	adminGroup string // members of the group are granted the admin scope, the admin scope of the provider is never honoured
+	client     *http.Client
+	mu         sync.Mutex
+	jwksURL    string
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+}
+
+/*
+newOIDCVerifier returns nil if no issuer is configured, which disables the OIDC tokens
+*/
+func newOIDCVerifier(issuer string, jwksURL string, audience string, adminGroup string) *oidcVerifier {
+	if issuer == "" {
+		return nil
+	}
+	return &oidcVerifier{
+		issuer:     issuer,
+		audience:   audience,
+		adminGroup: adminGroup,
+		jwksURL:    jwksURL,
+		client:     &http.Client{Timeout: oidcRequestTimeout},
+		keys:       make(map[string]crypto.PublicKey),
+	}
+}
+
+/*
+issuedBy reports whether the token claims to be issued by the provider. The claim isn't verified, it only chooses the verifier of the token.
+*/
+func (ov *oidcVerifier) issuedBy(token string) bool {
+	claims := &jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	return err == nil && claims.Issuer == ov.issuer
+}
+
+/*
+verify verifies the signature, issuer, audience and expiry of the token and converts its claims to the claims of the local tokens.
+The admin scope is only granted to the members of the admin group, like the directory users it can't be requested by the scope claim.
+*/
+func (ov *oidcVerifier) verify(ctx context.Context, token string) (*customClaims, error) {
+	ctx, span := otel.Tracer("oidcVerifier.Verify.Tracer").Start(ctx, "oidcVerifier.Verify.Span")
+	defer span.End()
+
+	verifiedToken, err := jwt.ParseWithClaims(token, &oidcClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return ov.key(ctx, kid)
+	}, jwt.WithValidMethods(oidcSigningMethods), jwt.WithIssuer(ov.issuer), jwt.WithAudience(ov.audience), jwt.WithExpirationRequired())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed oidc token verification")
+		return nil, err
+	}
+	claims := verifiedToken.Claims.(*oidcClaims)
+	if claims.Subject == "" {
+		return nil, errors.New("oidc token doesn't have a subject")
+	}
+	span.SetAttributes(attribute.String("claims.subject", claims.Subject))
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(claims.Scope) {
+		if scope != scopeAdmin {
+			scopes = append(scopes, scope)
+		}
+	}
+	if ov.adminGroup != "" && helpers.In(ov.adminGroup, claims.Groups...) {
+		scopes = append(scopes, scopeAdmin)
+	}
+	return &customClaims{Email: claims.Email, Scope: strings.Join(scopes, " "), RegisteredClaims: claims.RegisteredClaims}, nil
+}
+
+/*
+key returns the public key of the kid. Tokens without a kid are accepted only if the provider publishes a single key.
+*/
+func (ov *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ov.mu.Lock()
+	defer ov.mu.Unlock()
+
+	key, found := ov.lookup(kid)
+	sinceFetch := time.Since(ov.fetchedAt)
+	// unknown kids are fetched again sooner since the provider might have rotated its keys
+	if (found && sinceFetch < oidcJWKSCacheTTL) || (!found && sinceFetch < oidcJWKSRefreshInterval) {
+		if !found {
+			return nil, ErrOIDCUnknownKey
+		}
+		return key, nil
+	}
+
+	err := ov.fetchKeys(ctx)
+	if err != nil {
+		// keys of the last fetch are still used while the provider is unreachable
+		if found {
+			return key, nil
+		}
+		return nil, err
+	}
+	key, found = ov.lookup(kid)
+	if !found {
+		return nil, ErrOIDCUnknownKey
+	}
+	return key, nil
+}
+
+func (ov *oidcVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ov.keys) == 1 {
+		for _, key := range ov.keys {
+			return key, true
+		}
+	}
+	key, found := ov.keys[kid]
+	return key, found
+}
+
+/*
+fetchKeys replaces the cached keys with the signing keys of the JWKS, discovering its url first if it's not known yet
+*/
+func (ov *oidcVerifier) fetchKeys(ctx context.Context) error {
+	ctx, span := otel.Tracer("oidcVerifier.FetchKeys.Tracer").Start(ctx, "oidcVerifier.FetchKeys.Span")
+	defer span.End()
+	// failed fetches are also rate limited, so an unreachable provider isn't called on every request
+	ov.fetchedAt = time.Now()
+
+	if ov.jwksURL == "" {
+		var metadata struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		err := ov.getJson(ctx, strings.TrimSuffix(ov.issuer, "/")+oidcDiscoveryPath, &metadata)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to discover the jwks url")
+			return fmt.Errorf("failed to discover the jwks url of the oidc provider: %w", err)
+		}
+		if metadata.JWKSURI == "" {
+			return errors.New("oidc provider metadata doesn't have a jwks_uri")
+		}
+		ov.jwksURL = metadata.JWKSURI
+	}
+	span.SetAttributes(attribute.String("oidc.jwks_url", ov.jwksURL))
+
+	var keySet jsonWebKeySet
+	err := ov.getJson(ctx, ov.jwksURL, &keySet)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to fetch the jwks")
+		return fmt.Errorf("failed to fetch the jwks of the oidc provider: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// keys of the unsupported types don't prevent using the others
+			span.RecordError(err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	ov.keys = keys
+	span.SetAttributes(attribute.Int("oidc.keys", len(keys)))
+	return nil
+}
+
+func (ov *oidcVerifier) getJson(ctx context.Context, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := ov.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, oidcMaxMetadataBodyBytes)).Decode(dst)
+}
+
+/*
+publicKey decodes the RSA and EC keys of the JWKS
+*/
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("invalid parameter of the key %s", jwk.Kid)
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent of the key %s", jwk.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s of the key %s", jwk.Crv, jwk.Kid)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid point of the key %s", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s of the key %s", jwk.Kty, jwk.Kid)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testOIDCIssuer   = "https://sso.example.com"
+	testOIDCAudience = "behavox-client"
+)
+
+/*
+newTestOIDCProvider serves the jwks of a test rsa key and returns the verifier of its tokens along with the function signing them
+*/
+func newTestOIDCProvider(t *testing.T, adminGroup string) (*oidcVerifier, func(claims *oidcClaims) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet := jsonWebKeySet{Keys: []jsonWebKey{{
+		Kty: "RSA", Kid: "test-key", Use: "sig", Alg: "RS256",
+		N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keySet)
+	}))
+	t.Cleanup(srv.Close)
+
+	sign := func(claims *oidcClaims) string {
+		now := time.Now()
+		claims.Issuer = testOIDCIssuer
+		if claims.Audience == nil {
+			claims.Audience = jwt.ClaimStrings{testOIDCAudience}
+		}
+		claims.IssuedAt, claims.ExpiresAt = jwt.NewNumericDate(now), jwt.NewNumericDate(now.Add(time.Hour))
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	return newOIDCVerifier(testOIDCIssuer, srv.URL, testOIDCAudience, adminGroup), sign
+}
+
+func TestOIDCVerifyScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminGroup string
+		claims     *oidcClaims
+		wantScopes []string
+	}{
+		{name: "scopes of the provider are kept", claims: &oidcClaims{Scope: "events:read results:read"},
+			wantScopes: []string{scopeEventsRead, scopeResultsRead}},
+		{name: "admin scope of the provider is dropped", claims: &oidcClaims{Scope: "admin events:read"},
+			wantScopes: []string{scopeEventsRead}},
+		{name: "admin scope alone falls back to the default scopes", claims: &oidcClaims{Scope: "admin"},
+			wantScopes: defaultTokenScopes},
+		{name: "admin scope of the provider is dropped without the admin group", adminGroup: "behavox-admins",
+			claims: &oidcClaims{Scope: "admin", Groups: []string{"developers"}}, wantScopes: defaultTokenScopes},
+		{name: "members of the admin group are granted the admin scope", adminGroup: "behavox-admins",
+			claims:     &oidcClaims{Scope: "events:read", Groups: []string{"developers", "behavox-admins"}},
+			wantScopes: []string{scopeEventsRead, scopeAdmin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, sign := newTestOIDCProvider(t, tt.adminGroup)
+			tt.claims.Subject = "alice"
+			claims, err := verifier.verify(t.Context(), sign(tt.claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			granted := grantedScopes(claims.Scope)
+			if len(granted) != len(tt.wantScopes) {
+				t.Fatalf("granted scopes = %v, want %v", granted, tt.wantScopes)
+			}
+			for _, scope := range tt.wantScopes {
+				if !claims.hasScope(scope) {
+					t.Errorf("granted scopes = %v, want %v", granted, tt.wantScopes)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyErrors(t *testing.T) {
+	verifier, sign := newTestOIDCProvider(t, "")
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{name: "token without a subject", token: func() string { return sign(&oidcClaims{}) }},
+		{name: "token of another audience", token: func() string {
+			return sign(&oidcClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", Audience: jwt.ClaimStrings{"other-client"}}})
+		}},
+		{name: "token signed by another key", token: func() string {
+			_, other := newTestOIDCProvider(t, "")
+			return other(&oidcClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}})
+		}},
+		{name: "locally signed token", token: func() string {
+			signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Issuer: testOIDCIssuer, Subject: "alice"}).SignedString([]byte("key"))
+			return signed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.verify(t.Context(), tt.token())
+			if err == nil {
+				t.Error("invalid oidc token is verified")
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// security schemes of the operations
+const (
+	securityBasic = "basicAuth"
+	securityJwt   = "bearerAuth"
+)
+
+/*
+ErrorRes is the body of the error responses. error is a message or the validation errors by field name.
+*/
+type ErrorRes struct {
+	Error     interface{} `json:"error"`
+	RequestID string      `json:"request_id"`
+}
+
+type GraphQLRes struct {
+	Data   interface{}              `json:"data"`
+	Errors []map[string]interface{} `json:"errors,omitempty"` // message, path and extensions of the failed fields
+}
+
+type HealthRes struct {
+	Status interface{} `json:"status"` // ok or the status of each readiness check
+}
+
+type apiParam struct {
+	name        string
+	in          string // query, path or header
+	description string
+}
+
+/*
+apiOperation documents a route of the public api. Request and response are zero values of the go types the handler decodes and encodes.
+*/
+type apiOperation struct {
+	method      string
+	path        string // httprouter path, :name segments are the path parameters
+	tag         string
+	summary     string
+	security    string // empty for the public operations
+	params      []apiParam
+	request     interface{} // nil if the operation doesn't have a body
+	requestType string      // media type of the request body, json if empty
+	response    interface{} // nil if the response isn't json
+	result      bool        // response is wrapped in the result envelope
+	errors      []int       // error statuses the operation may respond with, besides 500
+}
+
+/*
+apiOperations lists the operations of the public api. It should be kept in sync with the routes.
+*/
+var apiOperations = []apiOperation{
+	{method: http.MethodPost, path: "/v1/events", tag: "events", summary: "Create a new event and add it to the event queue", security: securityJwt,
+		params: []apiParam{
+			{name: "ack", in: "query", description: "enqueue (default) responds once the event is queued, processed waits for the worker to process it"},
+			{name: correlationIDHeader, in: "header", description: "correlation id of the event if it's not specified in the body"},
+			{name: requestTimeoutHeader, in: "header", description: "timeout of the client in seconds or as a duration, applies to enqueuing and to processing with ack=processed"},
+			{name: requestDeadlineHeader, in: "header", description: "deadline of the client in RFC3339 format"},
+		},
+		request: EventCreateReq{}, response: EventCreateResEnvelope{}, errors: []int{400, 401, 409, 413, 422, 429, 503, 504}},
+	{method: http.MethodGet, path: "/v1/events", tag: "events", summary: "List the queued and recently processed events", security: securityJwt,
+		params: []apiParam{
+			{name: "event_type", in: "query"}, {name: "status", in: "query"},
+			{name: "since", in: "query", description: "RFC3339 time"}, {name: "until", in: "query", description: "RFC3339 time"},
+			{name: "limit", in: "query", description: "between 1 and 1000, defaults to 100"}, {name: "cursor", in: "query", description: "next_cursor of the previous page"},
+		},
+		response: EventListRes{}, result: true, errors: []int{401, 404, 422}},
+	{method: http.MethodDelete, path: "/v1/events/:event_id", tag: "events", summary: "Cancel a queued event", security: securityJwt,
+		response: EventCancelRes{}, result: true, errors: []int{401, 404, 409}},
+	{method: http.MethodPost, path: "/v1/events/batch", tag: "events", summary: "Create a batch of events", security: securityJwt,
+		params: []apiParam{
+			{name: requestTimeoutHeader, in: "header", description: "timeout of the client in seconds or as a duration, applies to enqueuing"},
+			{name: requestDeadlineHeader, in: "header", description: "deadline of the client in RFC3339 format"},
+		},
+		request: EventBatchCreateReq{}, response: EventBatchCreateRes{}, result: true, errors: []int{400, 401, 413, 422, 503, 504}},
+	{method: http.MethodPost, path: "/v1/events/bulk", tag: "events", summary: "Stream newline delimited json events, one event per line", security: securityJwt,
+		params: []apiParam{
+			{name: requestTimeoutHeader, in: "header", description: "timeout of the client in seconds or as a duration, ingestion is aborted once it's exceeded"},
+			{name: requestDeadlineHeader, in: "header", description: "deadline of the client in RFC3339 format"},
+		},
+		request: map[string]interface{}{}, requestType: contentTypeNDJSON, response: EventBulkCreateRes{}, result: true, errors: []int{400, 401, 415, 503}},
+	{method: http.MethodPost, path: "/v1/events/import", tag: "events", summary: "Import json or newline delimited json files uploaded as multipart/form-data for the backfills", security: securityJwt,
+		params: []apiParam{
+			{name: requestTimeoutHeader, in: "header", description: "timeout of the client in seconds or as a duration, ingestion is aborted once it's exceeded"},
+			{name: requestDeadlineHeader, in: "header", description: "deadline of the client in RFC3339 format"},
+		},
+		request: map[string]interface{}{}, requestType: contentTypeMultipart, response: EventImportRes{}, result: true, errors: []int{400, 401, 415, 503}},
+	{method: http.MethodGet, path: "/v1/events/batch/:batch_id", tag: "events", summary: "Get the processing progress of a batch", security: securityJwt,
+		response: EventBatchGetRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/events/next", tag: "events", summary: "Lease the next event of the queue to an external consumer", security: securityJwt,
+		params: []apiParam{
+			{name: "wait", in: "query", description: "duration to wait for an event, 204 is responded if none is available meanwhile"},
+			{name: "consumer", in: "query", description: "name of the consumer, defaults to the subject of the token"},
+		},
+		response: EventLeaseRes{}, result: true, errors: []int{401, 422}},
+	{method: http.MethodPost, path: "/v1/events/leases/:lease_id/ack", tag: "events", summary: "Acknowledge the processing outcome of a leased event", security: securityJwt,
+		request: EventAckReq{}, response: EventAckRes{}, result: true, errors: []int{400, 401, 404, 422}},
+	{method: http.MethodPost, path: "/v1/events/leases/:lease_id/nack", tag: "events", summary: "Give a leased event back to the queue to be delivered again", security: securityJwt,
+		response: EventAckRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/stats", tag: "stats", summary: "Get the queue, processing and worker statistics",
+		params:   []apiParam{{name: "If-None-Match", in: "header", description: "ETag of the previous response, 304 is responded if the stats didn't change"}},
+		response: EventStatsGetRes{}, result: true},
+	{method: http.MethodGet, path: "/v1/stats/lifetime", tag: "stats", summary: "Get the cumulative event counters surviving the restarts",
+		params:   []apiParam{{name: "If-None-Match", in: "header", description: "ETag of the previous response, 304 is responded if the counters didn't change"}},
+		response: LifetimeStatsRes{}, result: true},
+	{method: http.MethodGet, path: "/v1/capabilities", tag: "stats", summary: "List the features enabled on the server",
+		response: CapabilitiesRes{}, result: true},
+	{method: http.MethodPost, path: "/v1/tokens", tag: "tokens", summary: "Issue a jwt token, optionally narrowed to the requested scopes", security: securityBasic,
+		request: TokenCreateReq{}, response: TokenCreateRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodPost, path: "/v1/tokens/exchange", tag: "tokens", summary: "Exchange the token of a trusted actor for a token acting on behalf of a producer", security: securityJwt,
+		request: TokenExchangeReq{}, response: TokenExchangeRes{}, result: true, errors: []int{400, 401, 403, 422}},
+	{method: http.MethodPost, path: "/v1/tokens/refresh", tag: "tokens", summary: "Exchange a refresh token for a new access token and refresh token",
+		request: TokenRefreshReq{}, response: TokenCreateRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodGet, path: "/.well-known/jwks.json", tag: "tokens", summary: "Publish the public keys verifying the access tokens if they're signed by a private key",
+		response: jsonWebKeySet{}, errors: []int{404}},
+	{method: http.MethodPost, path: "/v1/graphql", tag: "graphql", summary: "Query the events, results and stats and create the events over graphql", security: securityJwt,
+		request: GraphQLReq{}, response: GraphQLRes{}, errors: []int{400, 401, 422}},
+	{method: http.MethodGet, path: "/v1/results", tag: "results", summary: "Export the results store in its output format", security: securityJwt,
+		errors: []int{401}},
+	{method: http.MethodGet, path: "/v1/results/:event_id", tag: "results", summary: "Look up the process results of an event", security: securityJwt,
+		response: ResultLookupRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodPost, path: "/v1/events/:event_id/verify", tag: "results", summary: "Verify the digest of the process results of an event", security: securityJwt,
+		response: ResultVerifyRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/dlq", tag: "dlq", summary: "List the dead letters", security: securityJwt,
+		params: []apiParam{
+			{name: "reason", in: "query"}, {name: "event_type", in: "query"}, {name: "poison", in: "query", description: "only the poison dead letters if true"},
+			{name: "limit", in: "query", description: "between 1 and 1000, defaults to 100"}, {name: "cursor", in: "query", description: "next_cursor of the previous page"},
+		},
+		response: DeadLetterListRes{}, result: true, errors: []int{401, 422}},
+	{method: http.MethodGet, path: "/v1/dlq/:event_id", tag: "dlq", summary: "Get the dead letter of an event", security: securityJwt,
+		response: data.DeadLetterSnapshot{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodDelete, path: "/v1/dlq/:event_id", tag: "dlq", summary: "Delete the dead letter of an event", security: securityJwt,
+		response: DeadLetterDeleteRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/dlq/stats", tag: "dlq", summary: "Aggregate the dead letters", security: securityJwt,
+		response: data.DeadLetterStats{}, result: true, errors: []int{401}},
+	{method: http.MethodPost, path: "/v1/dlq/purge", tag: "dlq", summary: "Purge the dead letter queue", security: securityJwt,
+		request: PurgeReq{}, response: PurgeRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodPost, path: "/v1/dlq/:event_id/replay", tag: "dlq", summary: "Re-enqueue the dead lettered event with a fresh retry budget", security: securityJwt,
+		response: DeadLetterReplayRes{}, result: true, errors: []int{401, 404, 503}},
+	{method: http.MethodPost, path: "/v1/dlq/replay-all", tag: "dlq", summary: "Re-enqueue the dead lettered events from the oldest to the newest", security: securityJwt,
+		params: []apiParam{
+			{name: "reason", in: "query"}, {name: "event_type", in: "query"}, {name: "poison", in: "query", description: "only the poison dead letters if true"},
+			{name: "limit", in: "query", description: "maximum number of the replayed events, defaults to the room of the event queue"},
+		},
+		response: DeadLetterReplayRes{}, result: true, errors: []int{401, 422, 503}},
+	{method: http.MethodPost, path: "/v1/subscriptions", tag: "subscriptions", summary: "Subscribe a webhook to the processing outcome of the events", security: securityJwt,
+		request: SubscriptionCreateReq{}, response: SubscriptionCreateRes{}, result: true, errors: []int{400, 401, 409, 422}},
+	{method: http.MethodGet, path: "/v1/subscriptions", tag: "subscriptions", summary: "List the webhook subscriptions with their delivery stats", security: securityJwt,
+		response: []*data.Subscription{}, result: true, errors: []int{401}},
+	{method: http.MethodDelete, path: "/v1/subscriptions/:subscription_id", tag: "subscriptions", summary: "Delete a webhook subscription", security: securityJwt,
+		response: data.Subscription{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/callbacks/secret", tag: "subscriptions", summary: "Get the secret signing the callbacks of the events of the producer", security: securityJwt,
+		response: CallbackSecretRes{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodPost, path: "/v1/schemas/infer", tag: "schemas", summary: "Infer the schema of sample payloads", security: securityJwt,
+		request: SchemaInferReq{}, response: SchemaInferRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodPost, path: "/v1/admin/queue/purge", tag: "admin", summary: "Purge the event queue", security: securityJwt,
+		request: PurgeReq{}, response: PurgeRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodPost, path: "/v1/admin/queue/drain", tag: "admin", summary: "Stop accepting new events and drain the queue", security: securityJwt,
+		params:   []apiParam{{name: "wait", in: "query", description: "duration to wait for the queue to be drained, less than the server write timeout"}},
+		response: DrainRes{}, result: true, errors: []int{401, 422}},
+	{method: http.MethodGet, path: "/v1/admin/support-bundle", tag: "admin", summary: "Download a gzipped tar archive of the redacted diagnostics for the bug reports", security: securityJwt,
+		errors: []int{401}},
+	{method: http.MethodPost, path: "/v1/admin/worker/pause", tag: "admin", summary: "Stop the worker from taking new events out of the queue", security: securityJwt,
+		response: WorkerStateRes{}, result: true, errors: []int{401}},
+	{method: http.MethodPost, path: "/v1/admin/worker/resume", tag: "admin", summary: "Resume the paused worker", security: securityJwt,
+		response: WorkerStateRes{}, result: true, errors: []int{401}},
+	{method: http.MethodGet, path: "/v1/admin/worker/inflight", tag: "admin", summary: "List the events currently being processed by the worker", security: securityJwt,
+		response: WorkerInFlightRes{}, result: true, errors: []int{401}},
+	{method: http.MethodGet, path: "/v1/admin/worker/throttle", tag: "admin", summary: "Get the limit of the events processed by the worker per second", security: securityJwt,
+		response: WorkerThrottleRes{}, result: true, errors: []int{401}},
+	{method: http.MethodPut, path: "/v1/admin/worker/throttle", tag: "admin", summary: "Change the limit of the events processed by the worker per second", security: securityJwt,
+		request: WorkerThrottleReq{}, response: WorkerThrottleRes{}, result: true, errors: []int{400, 401, 422}},
+	{method: http.MethodPost, path: "/v1/admin/schedules", tag: "admin", summary: "Register a recurring event injected into the queue by a cron expression", security: securityJwt,
+		request: ScheduleCreateReq{}, response: data.Schedule{}, result: true, errors: []int{400, 401, 409, 422}},
+	{method: http.MethodGet, path: "/v1/admin/schedules", tag: "admin", summary: "List the recurring events with their next run", security: securityJwt,
+		response: []data.Schedule{}, result: true, errors: []int{401}},
+	{method: http.MethodDelete, path: "/v1/admin/schedules/:schedule_id", tag: "admin", summary: "Delete a recurring event", security: securityJwt,
+		response: data.Schedule{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodGet, path: "/v1/admin/changes", tag: "admin", summary: "List the change records of the admin mutations", security: securityJwt,
+		params:   []apiParam{{name: "target", in: "query"}},
+		response: ChangeListRes{}, result: true, errors: []int{401}},
+	{method: http.MethodGet, path: "/v1/admin/changes/:version", tag: "admin", summary: "Get a change record", security: securityJwt,
+		response: data.ChangeRecord{}, result: true, errors: []int{401, 404}},
+	{method: http.MethodPost, path: "/v1/admin/changes/:version/rollback", tag: "admin", summary: "Roll back a change", security: securityJwt,
+		response: data.ChangeRecord{}, result: true, errors: []int{401, 404, 422}},
+	{method: http.MethodGet, path: "/healthz", tag: "health", summary: "Liveness check", response: HealthRes{}},
+	{method: http.MethodGet, path: "/readyz", tag: "health", summary: "Readiness check", response: HealthRes{}, errors: []int{503}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPISpec map[string]interface{}
+)
+
+/*
+openAPIDocument generates the OpenAPI 3 document of the public api out of the request and response structs of the operations
+*/
+func openAPIDocument() map[string]interface{} {
+	schemas := helpers.NewOpenAPISchemas()
+	errorSchemas := map[string]interface{}{
+		apiVersion1: schemas.SchemaOf(ErrorRes{}),
+		apiVersion2: schemas.SchemaOf(ErrorResV2{}),
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range append(apiOperations, apiV2Operations()...) {
+		errorSchema := errorSchemas[apiVersion1]
+		if strings.HasPrefix(op.path, "/"+apiVersion2+"/") {
+			errorSchema = errorSchemas[apiVersion2]
+		}
+		segments := strings.Split(op.path, "/")
+		params := []interface{}{}
+		for i, segment := range segments {
+			if name, found := strings.CutPrefix(segment, ":"); found {
+				segments[i] = "{" + name + "}"
+				params = append(params, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}})
+			}
+		}
+		for _, param := range op.params {
+			params = append(params, map[string]interface{}{"name": param.name, "in": param.in, "description": param.description, "schema": map[string]interface{}{"type": "string"}})
+		}
+
+		responses := map[string]interface{}{}
+		success := map[string]interface{}{"description": "successful operation"}
+		if op.response != nil {
+			schema := schemas.SchemaOf(op.response)
+			if op.result {
+				schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{"result": schema}, "required": []string{"result"}}
+			}
+			success["content"] = map[string]interface{}{helpers.ContentTypeJson: map[string]interface{}{"schema": schema}}
+		}
+		responses["200"] = success
+		for _, status := range append(op.errors, http.StatusInternalServerError) {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     map[string]interface{}{helpers.ContentTypeJson: map[string]interface{}{"schema": errorSchema}},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"tags":        []string{op.tag},
+			"summary":     op.summary,
+			"operationId": operationID(op),
+			"parameters":  params,
+			"responses":   responses,
+		}
+		if op.security != "" {
+			operation["security"] = []interface{}{map[string]interface{}{op.security: []string{}}}
+		}
+		if op.request != nil {
+			requestType := op.requestType
+			if requestType == "" {
+				requestType = helpers.ContentTypeJson
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{requestType: map[string]interface{}{"schema": schemas.SchemaOf(op.request)}},
+			}
+		}
+
+		path := strings.Join(segments, "/")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Event Queue API",
+			"description": "API for creating events, retrieving queue statistics, and generating authentication tokens",
+			"version":     Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.Components,
+			"securitySchemes": map[string]interface{}{
+				securityBasic: map[string]interface{}{"type": "http", "scheme": "basic"},
+				securityJwt:   map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+/*
+operationID derives a unique id of the operation out of its method and path, e.g. post_v1_results_event_id_verify
+*/
+func operationID(op apiOperation) string {
+	words := []string{strings.ToLower(op.method)}
+	for _, segment := range strings.Split(op.path, "/") {
+		if segment = strings.TrimPrefix(segment, ":"); segment != "" {
+			words = append(words, segment)
+		}
+	}
+	return strings.Join(words, "_")
+}
+
+/*
+getOpenAPIHandler serves the OpenAPI document of the api, so the clients can generate their SDKs out of it
+*/
+func (api *ApiServer) getOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getOpenAPIHandler.Tracer").Start(r.Context(), "getOpenAPIHandler.Span")
+	defer span.End()
+
+	openAPIOnce.Do(func() {
+		openAPISpec = openAPIDocument()
+	})
+	// document is always served in json regardless of the Accept header
+	r.Header.Set("Accept", helpers.ContentTypeJson)
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, openAPISpec, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>Event Queue API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "%s", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+/*
+swaggerUIHandler serves the Swagger UI of the OpenAPI document. UI assets are loaded from the public cdn.
+*/
+func (api *ApiServer) swaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer("swaggerUIHandler.Tracer").Start(r.Context(), "swaggerUIHandler.Span")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, swaggerUIPage, "/v1/openapi.json")
+}
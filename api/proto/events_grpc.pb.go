@@ -0,0 +1,123 @@
+// Protobuf schema accepted by POST /v1/events with Content-Type: application/x-protobuf.
+// Responses are encoded with the same schema when the client sends Accept: application/x-protobuf.
+// The EventIngest service is served by the grpc listener of --grpc-listen-addr.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: proto/events.proto
+
+package eventpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EventIngest_Ingest_FullMethodName = "/behavox.v1.EventIngest/Ingest"
+)
+
+// EventIngestClient is the client API for EventIngest service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventIngestClient interface {
+	// Ingest enqueues the events streamed by the producer like POST /v1/events and acks them periodically with the queue utilization.
+	// The stream requires the events:write scope of the bearer token of the authorization metadata.
+	Ingest(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EventCreateRequest, IngestAck], error)
+}
+
+type eventIngestClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventIngestClient(cc grpc.ClientConnInterface) EventIngestClient {
+	return &eventIngestClient{cc}
+}
+
+func (c *eventIngestClient) Ingest(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EventCreateRequest, IngestAck], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &EventIngest_ServiceDesc.Streams[0], EventIngest_Ingest_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[EventCreateRequest, IngestAck]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventIngest_IngestClient = grpc.BidiStreamingClient[EventCreateRequest, IngestAck]
+
+// EventIngestServer is the server API for EventIngest service.
+// All implementations must embed UnimplementedEventIngestServer
+// for forward compatibility.
+type EventIngestServer interface {
+	// Ingest enqueues the events streamed by the producer like POST /v1/events and acks them periodically with the queue utilization.
+	// The stream requires the events:write scope of the bearer token of the authorization metadata.
+	Ingest(grpc.BidiStreamingServer[EventCreateRequest, IngestAck]) error
+	mustEmbedUnimplementedEventIngestServer()
+}
+
+// UnimplementedEventIngestServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEventIngestServer struct{}
+
+func (UnimplementedEventIngestServer) Ingest(grpc.BidiStreamingServer[EventCreateRequest, IngestAck]) error {
+	return status.Errorf(codes.Unimplemented, "method Ingest not implemented")
+}
+func (UnimplementedEventIngestServer) mustEmbedUnimplementedEventIngestServer() {}
+func (UnimplementedEventIngestServer) testEmbeddedByValue()                     {}
+
+// UnsafeEventIngestServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventIngestServer will
+// result in compilation errors.
+type UnsafeEventIngestServer interface {
+	mustEmbedUnimplementedEventIngestServer()
+}
+
+func RegisterEventIngestServer(s grpc.ServiceRegistrar, srv EventIngestServer) {
+	// If the following call pancis, it indicates UnimplementedEventIngestServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EventIngest_ServiceDesc, srv)
+}
+
+func _EventIngest_Ingest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EventIngestServer).Ingest(&grpc.GenericServerStream[EventCreateRequest, IngestAck]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventIngest_IngestServer = grpc.BidiStreamingServer[EventCreateRequest, IngestAck]
+
+// EventIngest_ServiceDesc is the grpc.ServiceDesc for EventIngest service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventIngest_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "behavox.v1.EventIngest",
+	HandlerType: (*EventIngestServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ingest",
+			Handler:       _EventIngest_Ingest_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/events.proto",
+}
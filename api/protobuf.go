@@ -0,0 +1,239 @@
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative proto/events.proto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	eventpb "github.com/cybrarymin/behavox/api/proto"
+	helpers "github.com/cybrarymin/behavox/internal"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func init() {
+	helpers.RegisterCodec(protobufCodec{})
+}
+
+// implemented by the requests which can be decoded from protobuf messages
+type protoUnmarshaler interface {
+	UnmarshalProto(b []byte) error
+}
+
+// implemented by the responses which can be encoded into protobuf messages
+type protoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+/*
+protobufCodec serializes the bodies using the protobuf messages generated from proto/events.proto.
+Only the requests and responses which have a protobuf message are supported, others are negotiated to the other codecs.
+*/
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return protobufContentType }
+
+func (protobufCodec) Supports(v interface{}) bool {
+	_, ok := v.(protoMarshaler)
+	return ok
+}
+
+func (protobufCodec) Encode(w io.Writer, v interface{}) error {
+	msg, ok := v.(protoMarshaler)
+	if !ok {
+		return fmt.Errorf("%T doesn't have a protobuf message", v)
+	}
+	b, err := msg.MarshalProto()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (protobufCodec) Decode(r io.Reader, v interface{}) error {
+	msg, ok := v.(protoUnmarshaler)
+	if !ok {
+		return errors.New("protobuf body is not supported for this request")
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return errors.New("protobuf body must not be empty")
+	}
+	return msg.UnmarshalProto(body)
+}
+
+/*
+UnmarshalProto decodes the EventCreateRequest protobuf message into the EventCreateReq.
+Unknown fields are skipped to stay compatible with the messages produced by newer schema versions.
+*/
+func (nReq *EventCreateReq) UnmarshalProto(body []byte) error {
+	msg := &eventpb.EventCreateRequest{}
+	err := proto.Unmarshal(body, msg)
+	if err != nil {
+		return fmt.Errorf("body contains badly-formed protobuf: %w", err)
+	}
+	return nReq.fromProto(msg)
+}
+
+/*
+fromProto sets the event of the request from the decoded EventCreateRequest message, it's shared with the events streamed by the grpc producers
+*/
+func (nReq *EventCreateReq) fromProto(msg *eventpb.EventCreateRequest) error {
+	if msg.GetEvent() == nil {
+		return nil
+	}
+	return structFromProto(reflect.ValueOf(&nReq.Event).Elem(), msg.GetEvent().ProtoReflect())
+}
+
+/*
+MarshalProto encodes the response into EventCreateResponse protobuf message
+*/
+func (envelope *EventCreateResEnvelope) MarshalProto() ([]byte, error) {
+	msg := &eventpb.EventCreateResponse{Event: &eventpb.Event{}}
+	err := structToProto(reflect.ValueOf(&envelope.Event.Event).Elem(), msg.Event.ProtoReflect())
+	if err != nil {
+		return nil, err
+	}
+	if envelope.ProcessResult != nil {
+		msg.ProcessResult = &eventpb.EventProcessResult{}
+		err = structToProto(reflect.ValueOf(envelope.ProcessResult).Elem(), msg.ProcessResult.ProtoReflect())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(msg)
+}
+
+/*
+MarshalProto encodes the v2 response into the same EventCreateResponse protobuf message as v1
+*/
+func (nRes *EventCreateResV2) MarshalProto() ([]byte, error) {
+	envelope := &EventCreateResEnvelope{Event: &EventCreateRes{Event: nRes.EventCreateResEvent}, ProcessResult: nRes.ProcessResult}
+	return envelope.MarshalProto()
+}
+
+/*
+protoField returns the field of the protobuf message having the json name of the struct field, so the fields of the messages are
+derived from the request and response structs (including the embedded data.EventFields) instead of being listed separately
+*/
+func protoField(msg protoreflect.Message, field reflect.StructField) (protoreflect.FieldDescriptor, error) {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		return nil, fmt.Errorf("protobuf message %s doesn't have the field %s", msg.Descriptor().FullName(), name)
+	}
+	return fd, nil
+}
+
+/*
+structFromProto sets the fields of the struct from the fields present in the protobuf message, the pointer fields stay nil if they're absent
+*/
+func structFromProto(v reflect.Value, msg protoreflect.Message) error {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.Anonymous {
+			err := structFromProto(value, msg)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fd, err := protoField(msg, field)
+		if err != nil {
+			return err
+		}
+		if !msg.Has(fd) {
+			continue
+		}
+		if value.Kind() == reflect.Pointer {
+			value.Set(reflect.New(value.Type().Elem()))
+			value = value.Elem()
+		}
+		err = setFromProto(value, fd, msg.Get(fd))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setFromProto(value reflect.Value, fd protoreflect.FieldDescriptor, pv protoreflect.Value) error {
+	switch {
+	case value.Kind() == reflect.String && fd.Kind() == protoreflect.StringKind:
+		value.SetString(pv.String())
+	case value.Kind() == reflect.Float64 && fd.Kind() == protoreflect.DoubleKind:
+		value.SetFloat(pv.Float())
+	case value.Kind() == reflect.Bool && fd.Kind() == protoreflect.BoolKind:
+		value.SetBool(pv.Bool())
+	case value.Kind() == reflect.Int && (fd.Kind() == protoreflect.Int32Kind || fd.Kind() == protoreflect.Int64Kind):
+		value.SetInt(pv.Int())
+	default:
+		return fmt.Errorf("protobuf field %s of kind %s can't be decoded into %s", fd.Name(), fd.Kind(), value.Type())
+	}
+	return nil
+}
+
+/*
+structToProto sets the fields of the protobuf message from the struct, the nil and zero values are left out like the omitempty json fields
+*/
+func structToProto(v reflect.Value, msg protoreflect.Message) error {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.Anonymous {
+			err := structToProto(value, msg)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fd, err := protoField(msg, field)
+		if err != nil {
+			return err
+		}
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		} else if value.IsZero() {
+			continue
+		}
+		pv, err := protoValue(value, fd)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, pv)
+	}
+	return nil
+}
+
+func protoValue(value reflect.Value, fd protoreflect.FieldDescriptor) (protoreflect.Value, error) {
+	switch {
+	case value.Type() == timeType && fd.Kind() == protoreflect.StringKind:
+		return protoreflect.ValueOfString(value.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	case value.Kind() == reflect.String && fd.Kind() == protoreflect.StringKind:
+		return protoreflect.ValueOfString(value.String()), nil
+	case value.Kind() == reflect.Float64 && fd.Kind() == protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(value.Float()), nil
+	case value.Kind() == reflect.Bool && fd.Kind() == protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(value.Bool()), nil
+	case value.Kind() == reflect.Int && fd.Kind() == protoreflect.Int32Kind:
+		return protoreflect.ValueOfInt32(int32(value.Int())), nil
+	case value.Kind() == reflect.Int && fd.Kind() == protoreflect.Int64Kind:
+		return protoreflect.ValueOfInt64(value.Int()), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s can't be encoded into the protobuf field %s of kind %s", value.Type(), fd.Name(), fd.Kind())
+}
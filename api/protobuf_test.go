@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	eventpb "github.com/cybrarymin/behavox/api/proto"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+fillStruct sets every field of the struct, including the pointers and the embedded structs, to a non-zero value
+*/
+func fillStruct(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i)
+		if value.Kind() == reflect.Pointer {
+			value.Set(reflect.New(value.Type().Elem()))
+			value = value.Elem()
+		}
+		switch {
+		case value.Type() == timeType:
+			value.Set(reflect.ValueOf(time.Now()))
+		case value.Kind() == reflect.Struct:
+			fillStruct(value)
+		case value.Kind() == reflect.String:
+			value.SetString("1")
+		case value.Kind() == reflect.Float64:
+			value.SetFloat(1)
+		case value.Kind() == reflect.Int:
+			value.SetInt(1)
+		case value.Kind() == reflect.Bool:
+			value.SetBool(true)
+		}
+	}
+}
+
+func TestProtoMessagesCoverStructs(t *testing.T) {
+	// decoding a message with all of its fields set checks every field of the request has a protobuf field of a compatible kind
+	msg := (&eventpb.Event{}).ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		switch fd := fields.Get(i); fd.Kind() {
+		case protoreflect.StringKind:
+			msg.Set(fd, protoreflect.ValueOfString("1"))
+		case protoreflect.DoubleKind:
+			msg.Set(fd, protoreflect.ValueOfFloat64(1))
+		case protoreflect.Int32Kind:
+			msg.Set(fd, protoreflect.ValueOfInt32(1))
+		case protoreflect.BoolKind:
+			msg.Set(fd, protoreflect.ValueOfBool(true))
+		}
+	}
+	nReq := &EventCreateReq{}
+	err := structFromProto(reflect.ValueOf(&nReq.Event).Elem(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and encoding the responses with all of their fields set checks it for the fields of the responses
+	tests := []struct {
+		name string
+		v    interface{}
+		msg  protoreflect.Message
+	}{
+		{name: "event response", v: &EventCreateResEvent{}, msg: (&eventpb.Event{}).ProtoReflect()},
+		{name: "process result", v: &EventProcessRes{}, msg: (&eventpb.EventProcessResult{}).ProtoReflect()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := reflect.ValueOf(tt.v).Elem()
+			fillStruct(v)
+			err := structToProto(v, tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEventCreateReqUnmarshalProto(t *testing.T) {
+	msg := &eventpb.EventCreateRequest{Event: &eventpb.Event{
+		EventType:     "trace",
+		EventId:       "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+		TraceId:       proto.String(strings.Repeat("a", 32)),
+		SpanId:        proto.String(strings.Repeat("b", 16)),
+		Duration:      proto.Float64(0.25),
+		Service:       proto.String("checkout"),
+		Priority:      proto.Int32(7),
+		SchemaVersion: proto.Int32(1),
+		CorrelationId: proto.String("order-1"),
+		Delay:         proto.String("10m"),
+		CallbackUrl:   proto.String("https://example.com/callback"),
+	}}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fields of the newer schema versions are skipped
+	body = protowire.AppendTag(body, 99, protowire.BytesType)
+	body = protowire.AppendString(body, "unknown")
+
+	nReq := &EventCreateReq{}
+	err = nReq.UnmarshalProto(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := nReq.Event
+	if got.EventType != "trace" || got.EventID != msg.Event.EventId {
+		t.Errorf("event_type, event_id = %s, %s, want trace, %s", got.EventType, got.EventID, msg.Event.EventId)
+	}
+	if got.TraceID == nil || *got.TraceID != msg.Event.GetTraceId() || got.Duration == nil || *got.Duration != 0.25 || got.Service == nil || *got.Service != "checkout" {
+		t.Errorf("trace fields = %+v, want the fields of the message", got.EventFields)
+	}
+	if got.Priority == nil || *got.Priority != 7 || got.SchemaVersion == nil || *got.SchemaVersion != 1 {
+		t.Errorf("priority, schema_version = %v, %v, want 7, 1", got.Priority, got.SchemaVersion)
+	}
+	if got.Delay == nil || *got.Delay != "10m" || got.CallbackURL == nil || *got.CallbackURL != "https://example.com/callback" {
+		t.Errorf("delay, callback_url = %v, %v, want the fields of the message", got.Delay, got.CallbackURL)
+	}
+	if got.Level != nil || got.Value != nil || got.DeliverAt != nil || got.ParentEventID != nil {
+		t.Errorf("absent fields are set: %+v", got)
+	}
+}
+
+func TestEventCreateReqUnmarshalProtoErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "truncated event", body: protowire.AppendTag(nil, 1, protowire.BytesType)},
+		{name: "invalid utf-8 string", body: func() []byte {
+			event := protowire.AppendTag(nil, 5, protowire.BytesType)
+			event = protowire.AppendBytes(event, []byte{0xff, 0xfe})
+			b := protowire.AppendTag(nil, 1, protowire.BytesType)
+			return protowire.AppendBytes(b, event)
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&EventCreateReq{}).UnmarshalProto(tt.body)
+			if err == nil {
+				t.Fatal("badly-formed protobuf is decoded without an error")
+			}
+		})
+	}
+}
+
+func TestEventCreateResMarshalProto(t *testing.T) {
+	value := 95.8
+	timestamp := time.Date(2026, 10, 14, 12, 30, 0, 123456789, time.UTC)
+	processedAt := timestamp.Add(time.Second)
+	nRes := &EventCreateRes{Event: EventCreateResEvent{
+		EventType:     "metric",
+		EventID:       "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+		Priority:      3,
+		SchemaVersion: 1,
+		CorrelationID: "order-1",
+		Timestamp:     timestamp,
+		EventFields:   data.EventFields{Value: &value},
+	}}
+	tests := []struct {
+		name     string
+		envelope protoMarshaler
+		want     *eventpb.EventCreateResponse
+	}{
+		{name: "enqueued event", envelope: &EventCreateResEnvelope{Event: nRes}, want: &eventpb.EventCreateResponse{
+			Event: &eventpb.Event{EventType: "metric", EventId: nRes.Event.EventID, Value: proto.Float64(95.8), Priority: proto.Int32(3),
+				SchemaVersion: proto.Int32(1), CorrelationId: proto.String("order-1"), Timestamp: proto.String("2026-10-14T12:30:00.123456789Z")},
+		}},
+		{name: "processed event of v2", envelope: &EventCreateResV2{EventCreateResEvent: nRes.Event, ProcessResult: &EventProcessRes{
+			Status: "success", Digest: "digest", DigestAlgorithm: "sha256", Length: 42, ProcessingTime: "1ms", ProcessedAt: &processedAt,
+		}}, want: &eventpb.EventCreateResponse{
+			Event: &eventpb.Event{EventType: "metric", EventId: nRes.Event.EventID, Value: proto.Float64(95.8), Priority: proto.Int32(3),
+				SchemaVersion: proto.Int32(1), CorrelationId: proto.String("order-1"), Timestamp: proto.String("2026-10-14T12:30:00.123456789Z")},
+			ProcessResult: &eventpb.EventProcessResult{Status: "success", Digest: "digest", DigestAlgorithm: "sha256", Length: 42,
+				ProcessingTime: "1ms", ProcessedAt: "2026-10-14T12:30:01.123456789Z"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := protobufCodec{}.Encode(&b, tt.envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &eventpb.EventCreateResponse{}
+			err = proto.Unmarshal(b.Bytes(), got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("response = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
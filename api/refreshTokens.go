@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdJwtRefreshKey string
+	CmdJwtRefreshTTL time.Duration
+)
+
+const DefaultJwtRefreshKey = "defaultJWTRefreshToken"
+
+/*
+refreshClaims are the claims of the refresh tokens. The refresh tokens issued by rotating each other share the family of the first one,
+so all of them are revoked once a used refresh token is presented again, e.g. when it's stolen.
+*/
+type refreshClaims struct {
+	Family     string `json:"fam"`
+	Generation string `json:"gen"`             // generation of the store tracking the used refresh tokens
+	Scope      string `json:"scope,omitempty"` // the scope of the access tokens issued by the refresh token
+	jwt.RegisteredClaims
+}
+
+type TokenRefreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+/*
+signRefreshToken signs a new refresh token of the family for the user, which keeps issuing the access tokens of the scope. Refresh tokens are signed by their own key,
+so they can't be used as the access tokens and vice versa.
+*/
+func signRefreshToken(nUser string, family string, scope string, generation string, now time.Time) (string, *refreshClaims, error) {
+	claims := &refreshClaims{
+		Family:     family,
+		Generation: generation,
+		Scope:      scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    CmdJwtIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(CmdJwtRefreshTTL)),
+			Subject:   nUser,
+			Audience:  []string{CmdJwtAudience},
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+	}
+	signedToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(CmdJwtRefreshKey))
+	if err != nil {
+		return "", nil, err
+	}
+	return signedToken, claims, nil
+}
+
+/*
+rotateRefreshToken marks the refresh token as used, so it can't be exchanged for new tokens anymore.
+Presenting a used token again revokes its family and data.ErrRefreshTokenReused is returned.
+*/
+func (api *ApiServer) rotateRefreshToken(claims *refreshClaims) error {
+	return api.refreshTokens.Rotate(claims.Generation, claims.ID, claims.Family, claims.ExpiresAt.Time, CmdJwtRefreshTTL)
+}
+
+/*
+userExists reports whether the user can still get the tokens, it's either the admin user or a member of the ldap directory
+*/
+func (api *ApiServer) userExists(ctx context.Context, user string) bool {
+	if user == CmdApiAdmin {
+		return true
+	}
+	if api.ldap == nil {
+		return false
+	}
+	_, err := api.ldap.lookup(ctx, user)
+	if err != nil && !errors.Is(err, ErrLdapUserNotFound) {
+		api.Logger.Error().Err(err).Str("user", user).Msg("failed to look up the user on the ldap server")
+	}
+	return err == nil
+}
+
+/*
+refreshTokenHandler exchanges a refresh token for a new access token and a new refresh token, so the clients don't need to keep the basic authentication credentials around.
+Each refresh token can be used only once and reusing it revokes all the refresh tokens of the same login.
+*/
+func (api *ApiServer) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("refreshTokenHandler.Tracer").Start(r.Context(), "refreshTokenHandler.Span")
+	defer span.End()
+
+	nReq, err := helpers.ReadRequest[TokenRefreshReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+	nVal := helpers.NewValidator()
+	nVal.Check(nReq.RefreshToken != "", "refresh_token", "must be provided")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	refreshToken, err := jwt.ParseWithClaims(nReq.RefreshToken, &refreshClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return []byte(CmdJwtRefreshKey), nil
+	}, jwtParserOptions()...)
+	if err != nil || !refreshToken.Valid {
+		if err == nil {
+			err = errors.New("invalid refresh token")
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid refresh token")
+		api.auditAuthFailure(r, claimedSubject(nReq.RefreshToken), "auth.refresh_token", err)
+		api.invalidRefreshTokenResponse(w, r)
+		return
+	}
+	claims := refreshToken.Claims.(*refreshClaims)
+	span.SetAttributes(attribute.String("claims.subject", claims.Subject), attribute.String("refresh_token.family", claims.Family))
+	// refresh tokens of the previous admin user or the removed ldap users are rejected
+	if !api.userExists(ctx, claims.Subject) || claims.Family == "" {
+		span.SetStatus(codes.Error, "refresh token of an unknown user")
+		api.auditAuthFailure(r, claims.Subject, "auth.refresh_token", errors.New("refresh token of an unknown user"))
+		api.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	// scopes the user isn't entitled to anymore aren't granted to the refreshed tokens
+	allowed := api.allowedScopes(ctx, claims.Subject)
+	for _, scope := range strings.Fields(claims.Scope) {
+		if !helpers.In(scope, allowed...) {
+			span.SetStatus(codes.Error, "refresh token of a scope the user isn't allowed")
+			api.auditAuthFailure(r, claims.Subject, "auth.refresh_token", fmt.Errorf("scope %s isn't allowed for the user", scope))
+			api.invalidRefreshTokenResponse(w, r)
+			return
+		}
+	}
+
+	err = api.rotateRefreshToken(claims)
+	if err != nil && !errors.Is(err, data.ErrRefreshTokenReused) && !errors.Is(err, data.ErrRefreshTokenRevoked) && !errors.Is(err, data.ErrRefreshTokenGeneration) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to persist the used refresh token")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "refresh token is already used")
+		if errors.Is(err, data.ErrRefreshTokenReused) {
+			api.auditLog(r, claims.Subject, "token.refresh_reuse").
+				Str("token_id", claims.ID).
+				Str("family", claims.Family).
+				Send()
+		}
+		api.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	nRes, err := api.issueTokens(ctx, claims.Subject, claims.Family, claims.Scope)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sign the token")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, claims.Subject, "token.refresh").
+		Str("token_id", claims.ID).
+		Str("family", claims.Family).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
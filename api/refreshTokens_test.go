@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+func issueTestTokens(t *testing.T, api *ApiServer) *TokenCreateRes {
+	t.Helper()
+	r := newTestRequest(api, http.MethodPost, "/v1/tokens", "")
+	r.SetBasicAuth(CmdApiAdmin, CmdApiAdminPass)
+	w := httptest.NewRecorder()
+	api.createJWTTokenHandler(w, r)
+	return decodeTestTokens(t, w)
+}
+
+func decodeTestTokens(t *testing.T, w *httptest.ResponseRecorder) *TokenCreateRes {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var res struct {
+		Result TokenCreateRes `json:"result"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &res.Result
+}
+
+func refreshTestToken(api *ApiServer, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(TokenRefreshReq{RefreshToken: refreshToken})
+	r := newTestRequest(api, http.MethodPost, "/v1/tokens/refresh", string(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.refreshTokenHandler(w, r)
+	return w
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refresh-tokens.jsonl")
+	api := newTestApiServer(t)
+	var err error
+	api.refreshTokens, err = data.NewRefreshTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := issueTestTokens(t, api)
+	second := decodeTestTokens(t, refreshTestToken(api, first.RefreshToken))
+
+	// the server restarts, the used tokens are still rejected
+	restarted := newTestApiServer(t)
+	restarted.refreshTokens, err = data.NewRefreshTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inMemory := newTestApiServer(t)
+
+	tests := []struct {
+		name     string
+		api      *ApiServer
+		token    string
+		wantCode int
+	}{
+		{name: "used token is replayed after the restart", api: restarted, token: first.RefreshToken, wantCode: http.StatusUnauthorized},
+		{name: "latest token of the revoked family", api: restarted, token: second.RefreshToken, wantCode: http.StatusUnauthorized},
+		{name: "token of another state", api: inMemory, token: issueTestTokens(t, api).RefreshToken, wantCode: http.StatusUnauthorized},
+		{name: "token of a new login", api: restarted, token: issueTestTokens(t, restarted).RefreshToken, wantCode: http.StatusOK},
+		{name: "access token", api: restarted, token: first.Token, wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := refreshTestToken(tt.api, tt.token)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"math"
+	"os"
+	"runtime"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+)
+
+var (
+	CmdResourceAutoTune bool
+)
+
+const (
+	// worker threads mostly wait on the file writes, so a few of them are run per cpu core
+	workerThreadsPerCPU = 4
+	maxTunedWorkers     = 64
+	// share of the memory limit used by the queue and the estimated memory footprint of a queued event
+	queueMemoryShare       = 0.1
+	queuedEventFootprint   = 4096
+	minTunedEventQueueSize = 100
+	maxTunedEventQueueSize = 1_000_000
+)
+
+/*
+ResourceTuning reports the detected resource limits and the values derived from them.
+Values explicitly specified by the flags are kept as is and don't appear in Derived.
+*/
+type ResourceTuning struct {
+	Limits         helpers.ResourceLimits `json:"limits"`
+	GoMaxProcs     int                    `json:"gomaxprocs"`
+	WorkerThreads  int                    `json:"worker_threads"`
+	EventQueueSize int64                  `json:"event_queue_size"`
+	Derived        []string               `json:"derived"` // settings derived from the resource limits
+}
+
+// resourceTuning is nil if the auto tuning is disabled
+var resourceTuning *ResourceTuning
+
+/*
+AutoTuneResources derives the worker concurrency, queue capacity and GOMAXPROCS from the cpu and memory limits of the container.
+explicit reports whether a flag is specified by the user, in which case its value isn't changed. It should be called before Main.
+*/
+func AutoTuneResources(explicit func(flag string) bool) {
+	limits := helpers.DetectResourceLimits()
+	resourceTuning = &ResourceTuning{Limits: limits, Derived: []string{}}
+
+	if limits.CPU > 0 {
+		procs := int(math.Ceil(limits.CPU))
+		// GOMAXPROCS environment variable is already applied by the runtime
+		if os.Getenv("GOMAXPROCS") == "" {
+			runtime.GOMAXPROCS(procs)
+			resourceTuning.Derived = append(resourceTuning.Derived, "gomaxprocs")
+		}
+		if !explicit("event-queue-max-worker-threads") {
+			worker.CmdmaxWorkerGoroutines = min(procs*workerThreadsPerCPU, maxTunedWorkers)
+			resourceTuning.Derived = append(resourceTuning.Derived, "event-queue-max-worker-threads")
+		}
+	}
+	if limits.Memory > 0 && !explicit("event-queue-size") {
+		queueSize := int64(float64(limits.Memory) * queueMemoryShare / queuedEventFootprint)
+		data.CmdEventQueueSize = max(minTunedEventQueueSize, min(queueSize, maxTunedEventQueueSize))
+		resourceTuning.Derived = append(resourceTuning.Derived, "event-queue-size")
+	}
+
+	resourceTuning.GoMaxProcs = runtime.GOMAXPROCS(0)
+	resourceTuning.WorkerThreads = worker.CmdmaxWorkerGoroutines
+	resourceTuning.EventQueueSize = data.CmdEventQueueSize
+}
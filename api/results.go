@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type ResultLookupRes struct {
+	EventID string                   `json:"event_id"`
+	Results []map[string]interface{} `json:"results"` // process results of the event in the processed events file, more than one if the event is processed again after restoring it
+}
+
+/*
+getResultHandler looks up the process results of an event inside the results store. It scans the whole processed events file,
+so it's meant for the forensics rather than the hot path of the clients.
+*/
+func (api *ApiServer) getResultHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("getResultHandler.Tracer").Start(r.Context(), "getResultHandler.Span")
+	defer span.End()
+
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	results, err := worker.LookupResults(ctx, eventID)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, worker.ErrResultNotFound) {
+			span.SetStatus(codes.Error, "process result not found")
+			api.notFoundResponse(w, r)
+			return
+		}
+		span.SetStatus(codes.Error, "failed to look up the process result")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nRes := &ResultLookupRes{EventID: eventID, Results: results}
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+exportResultsHandler streams the whole results store in the configured output format of the processed events file
+*/
+func (api *ApiServer) exportResultsHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer("exportResultsHandler.Tracer").Start(r.Context(), "exportResultsHandler.Span")
+	defer span.End()
+
+	w.Header().Set("Content-Type", worker.ResultsContentType())
+	file, err := worker.OpenResults()
+	if errors.Is(err, os.ErrNotExist) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to open the results store")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	w.WriteHeader(http.StatusOK)
+	n, err := io.Copy(w, file)
+	span.SetAttributes(attribute.Int64("export.bytes", n))
+	if err != nil {
+		// response is already partially written, so the client only notices the truncated body
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to stream the results store")
+		api.logError(err)
+	}
+}
+
+type ResultVerifyRes struct {
+	EventID string                      `json:"event_id"`
+	Verdict string                      `json:"verdict"` // tampered if any of the process results is tampered, unverifiable if any can't be verified, otherwise intact
+	Results []worker.ResultVerification `json:"results"`
+}
+
+/*
+verifyResultHandler recomputes the canonical digest of the event for its stored process results and compares it against the recorded one
+to detect the tampering of the results store. Every verification is audit logged for the integrity audits.
+*/
+func (api *ApiServer) verifyResultHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("verifyResultHandler.Tracer").Start(r.Context(), "verifyResultHandler.Span")
+	defer span.End()
+
+	eventID := httprouter.ParamsFromContext(r.Context()).ByName("event_id")
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	verifications, err := worker.VerifyResults(ctx, eventID)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, worker.ErrResultNotFound) {
+			span.SetStatus(codes.Error, "process result not found")
+			api.notFoundResponse(w, r)
+			return
+		}
+		span.SetStatus(codes.Error, "failed to verify the process result")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nRes := &ResultVerifyRes{EventID: eventID, Verdict: worker.VerdictIntact, Results: verifications}
+	for _, verification := range verifications {
+		switch {
+		case verification.Verdict == worker.VerdictTampered:
+			nRes.Verdict = worker.VerdictTampered
+		case verification.Verdict == worker.VerdictUnverifiable && nRes.Verdict == worker.VerdictIntact:
+			nRes.Verdict = worker.VerdictUnverifiable
+		}
+	}
+	span.SetAttributes(attribute.String("verify.verdict", nRes.Verdict))
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	api.auditLog(r, actor, "results.verify").
+		Str("event_id", eventID).
+		Str("verdict", nRes.Verdict).
+		Int("results", len(verifications)).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
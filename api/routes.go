@@ -11,20 +11,89 @@ func (api *ApiServer) routes() http.Handler {
 	router := httprouter.New()
 
 	// handle error responses for both notFoundResponses and InvalidMethods
-	router.NotFound = api.promHandler(http.HandlerFunc(api.notFoundResponse))
-	router.MethodNotAllowed = api.promHandler(api.methodNotAllowedResponse)
+	router.NotFound = http.HandlerFunc(api.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(api.methodNotAllowedResponse)
+
+	// handle the event, the event routes are served by all the api versions
+	for _, version := range apiVersions {
+		api.eventRoutes(router, version)
+	}
+	router.HandlerFunc(http.MethodGet, "/v1/stats", api.GetEventStatsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/stats/lifetime", api.getLifetimeStatsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/capabilities", api.getCapabilitiesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens", api.createJWTTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/exchange", api.JWTAuth(api.exchangeTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", api.refreshTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", api.jwksHandler)
+	// graphql, the scopes are required by the fields of the query
+	router.HandlerFunc(http.MethodPost, "/v1/graphql", api.JWTAuth(api.graphqlHandler()))
+
+	// api documentation
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", api.getOpenAPIHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/docs", api.swaggerUIHandler)
+
+	// results store
+	router.HandlerFunc(http.MethodGet, "/v1/results", api.JWTAuth(api.requireScope(scopeResultsRead, api.exportResultsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/results/:event_id", api.JWTAuth(api.requireScope(scopeResultsRead, api.getResultHandler)))
+	// POST /v1/events/:event_id/verify is served by eventItemActionHandler
+
+	// dead letter queue
+	router.HandlerFunc(http.MethodGet, "/v1/dlq", api.JWTAuth(api.requireScope(scopeDeadLettersRead, api.listDeadLettersHandler)))
+	// GET /v1/dlq/stats is served by getDeadLetterHandler
+	router.HandlerFunc(http.MethodGet, "/v1/dlq/:event_id", api.JWTAuth(api.requireScope(scopeDeadLettersRead, api.getDeadLetterHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/dlq/:event_id", api.JWTAuth(api.requireScope(scopeDeadLettersWrite, api.deleteDeadLetterHandler)))
+	// POST /v1/dlq/purge and /v1/dlq/replay-all are served by deadLetterActionHandler
+	router.HandlerFunc(http.MethodPost, "/v1/dlq/:event_id", api.JWTAuth(api.requireScope(scopeDeadLettersWrite, api.deadLetterActionHandler())))
+	router.HandlerFunc(http.MethodPost, "/v1/dlq/:event_id/replay", api.JWTAuth(api.requireScope(scopeDeadLettersWrite, api.replayDeadLetterHandler)))
+
+	// webhook subscriptions
+	router.HandlerFunc(http.MethodPost, "/v1/subscriptions", api.JWTAuth(api.requireScope(scopeSubscriptionsWrite, api.createSubscriptionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/subscriptions", api.JWTAuth(api.requireScope(scopeSubscriptionsRead, api.listSubscriptionsHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/subscriptions/:subscription_id", api.JWTAuth(api.requireScope(scopeSubscriptionsWrite, api.deleteSubscriptionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/callbacks/secret", api.JWTAuth(api.requireScope(scopeEventsWrite, api.getCallbackSecretHandler)))
+
+	// schemas
+	router.HandlerFunc(http.MethodPost, "/v1/schemas/infer", api.JWTAuth(api.requireScope(scopeSchemasRead, api.inferSchemaHandler)))
+
+	// admin
+	router.HandlerFunc(http.MethodPost, "/v1/admin/queue/purge", api.JWTAuth(api.requireScope(scopeAdmin, api.purgeEventQueueHandler())))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/queue/drain", api.JWTAuth(api.requireScope(scopeAdmin, api.drainQueueHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/support-bundle", api.JWTAuth(api.requireScope(scopeAdmin, api.supportBundleHandler(""))))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/worker/pause", api.JWTAuth(api.requireScope(scopeAdmin, api.workerStateHandler("pause", api.worker.Pause))))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/worker/resume", api.JWTAuth(api.requireScope(scopeAdmin, api.workerStateHandler("resume", api.worker.Resume))))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/worker/inflight", api.JWTAuth(api.requireScope(scopeAdmin, api.getWorkerInFlightHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/worker/throttle", api.JWTAuth(api.requireScope(scopeAdmin, api.getWorkerThrottleHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/worker/throttle", api.JWTAuth(api.requireScope(scopeAdmin, api.setWorkerThrottleHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/schedules", api.JWTAuth(api.requireScope(scopeAdmin, api.createScheduleHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/schedules", api.JWTAuth(api.requireScope(scopeAdmin, api.listSchedulesHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/schedules/:schedule_id", api.JWTAuth(api.requireScope(scopeAdmin, api.deleteScheduleHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/changes", api.JWTAuth(api.requireScope(scopeAdmin, api.listChangesHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/changes/:version", api.JWTAuth(api.requireScope(scopeAdmin, api.getChangeHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/changes/:version/rollback", api.JWTAuth(api.requireScope(scopeAdmin, api.rollbackChangeHandler)))
+
+	// health checks
+	router.HandlerFunc(http.MethodGet, "/healthz", api.healthzHandler)
+	router.HandlerFunc(http.MethodGet, "/readyz", api.readyzHandler)
 
-	// handle the event
-	router.HandlerFunc(http.MethodPost, "/v1/events", api.promHandler(api.JWTAuth(api.createEventHandler)))
-	router.HandlerFunc(http.MethodGet, "/v1/stats", api.promHandler(api.GetEventStatsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/tokens", api.promHandler((api.createJWTTokenHandler)))
 	// Prometheus Handler
 	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
 
-	// Otel http instrumentation
+	// pprof and expvar debug endpoints, served by the separate debug listener if it's specified
+	if CmdDebugEndpoints && CmdDebugListenAddr == "" {
+		api.debugRoutes(router)
+	}
+
+	var handler http.Handler = router
+	if CmdReadOnly {
+		handler = api.rejectWrites(router)
+	}
+
+	// wrapping the router with the configured cross-cutting middlewares in the specified order
 	return api.panicRecovery(
-		api.setContextHandler(
-			api.enableCORS(
-				(api.otelHandler(
-					api.rateLimit(router))))))
+		api.drainConnections(
+			api.setContextHandler(
+				api.ipFilterHandler(
+					api.hmacAuth(
+						api.decompressRequest(
+							api.middlewareChain(handler)))))))
 }
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type ScheduleCreateReq struct {
+	Cron  string `json:"cron"` // e.g. "* * * * *", "@hourly" or "@every 30s", evaluated in UTC
+	Event struct {
+		EventType     string  `json:"event_type"`
+		Priority      *int    `json:"priority,omitempty"`
+		CorrelationID *string `json:"correlation_id,omitempty"`
+		data.EventFields
+	} `json:"event"`
+}
+
+/*
+createScheduleHandler registers a recurring event, its events are injected into the queue by the scheduler on every run of the cron expression
+*/
+func (api *ApiServer) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("createScheduleHandler.Tracer").Start(r.Context(), "createScheduleHandler.Span")
+	defer span.End()
+
+	nReq, err := helpers.ReadRequest[ScheduleCreateReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	_, err = helpers.ParseCron(nReq.Cron)
+	nVal.Check(err == nil, "cron", fmt.Sprint(err))
+
+	// the event template is validated like an event created through the api
+	eventReq := NewEventCreateReq(nReq.Event.EventType, uuid.NewString(), nReq.Event.EventFields)
+	eventReq.Event.Priority = nReq.Event.Priority
+	eventReq.Event.CorrelationID = nReq.Event.CorrelationID
+	eventVal := helpers.NewValidator()
+	event, err := api.newEvent(r, eventVal, eventReq, 0)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+	for key, message := range eventVal.Errors {
+		nVal.AddError("event."+key, message)
+	}
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	schedule := &data.Schedule{
+		ID:   uuid.New().String(),
+		Cron: nReq.Cron,
+		Event: data.ScheduleEvent{
+			EventType:     event.GetEventType(),
+			Priority:      event.GetPriority(),
+			CorrelationID: event.GetCorrelationID(),
+			Fields:        nReq.Event.EventFields,
+		},
+		CreatedBy: actor,
+		CreatedAt: time.Now(),
+	}
+	span.SetAttributes(attribute.String("schedule.id", schedule.ID))
+
+	err = api.models.Schedules.Add(ctx, schedule)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, data.ErrScheduleLimit) {
+			span.SetStatus(codes.Error, "maximum number of schedules reached")
+			api.scheduleLimitResponse(w, r)
+			return
+		}
+		span.SetStatus(codes.Error, "failed to add the schedule")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, actor, "schedule.create").
+		Str("schedule_id", schedule.ID).
+		Str("cron", schedule.Cron).
+		Str("event_type", schedule.Event.EventType).
+		Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusCreated, helpers.Envelope{"result": schedule}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+listSchedulesHandler lists the recurring events along with their next run and run stats
+*/
+func (api *ApiServer) listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("listSchedulesHandler.Tracer").Start(r.Context(), "listSchedulesHandler.Span")
+	defer span.End()
+
+	schedules := api.models.Schedules.List(ctx)
+	span.SetAttributes(attribute.Int("schedules.count", len(schedules)))
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": schedules}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+deleteScheduleHandler stops the recurring event. Events already injected by it stay inside the queue.
+*/
+func (api *ApiServer) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("deleteScheduleHandler.Tracer").Start(r.Context(), "deleteScheduleHandler.Span")
+	defer span.End()
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("schedule_id")
+	span.SetAttributes(attribute.String("schedule.id", id))
+
+	schedule, found, err := api.models.Schedules.Delete(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to delete the schedule")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	if !found {
+		span.SetStatus(codes.Error, "schedule not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	api.auditLog(r, actor, "schedule.delete").Str("schedule_id", id).Str("cron", schedule.Cron).Send()
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": schedule}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// maximum number of samples accepted for a single schema inference
+const schemaInferMaxSamples = 100
+
+type SchemaInferReq struct {
+	Name    string            `json:"name"`
+	Samples []json.RawMessage `json:"samples"`
+}
+
+type SchemaInferRes struct {
+	Name    string                 `json:"name,omitempty"`
+	Samples int                    `json:"samples"`
+	Schema  map[string]interface{} `json:"schema"`
+}
+
+/*
+inferSchemaHandler returns a draft json schema inferred from the sample payloads of a custom event type.
+The schema is only a starting point and it should be reviewed before registering it.
+*/
+func (api *ApiServer) inferSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("inferSchemaHandler.Tracer").Start(r.Context(), "inferSchemaHandler.Span")
+	defer span.End()
+
+	nReq, err := helpers.ReadJson[SchemaInferReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	nVal.Check(len(nReq.Samples) > 0, "samples", "should contain at least one sample")
+	nVal.Check(len(nReq.Samples) <= schemaInferMaxSamples, "samples", fmt.Sprintf("must not contain more than %d samples", schemaInferMaxSamples))
+	nVal.Check(len(nReq.Name) <= 100, "name", "must not be more than 100 bytes long")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	// decoding the samples with UseNumber to distinguish integer values from floating point ones
+	samples := make([]interface{}, 0, len(nReq.Samples))
+	for i, rawSample := range nReq.Samples {
+		var sample interface{}
+		dec := json.NewDecoder(bytes.NewReader(rawSample))
+		dec.UseNumber()
+		err = dec.Decode(&sample)
+		if err != nil {
+			err = fmt.Errorf("sample %d contains badly-formed json", i)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid input")
+			api.badRequestResponse(w, r, err)
+			return
+		}
+		samples = append(samples, sample)
+	}
+	span.SetAttributes(attribute.Int("schema.samples", len(samples)))
+
+	nRes := &SchemaInferRes{
+		Name:    nReq.Name,
+		Samples: len(samples),
+		Schema:  data.InferSchema(nReq.Name, samples),
+	}
+
+	api.Logger.Info().
+		Str("schema_name", nReq.Name).
+		Int("samples", len(samples)).
+		Msg("inferred the json schema from the samples")
+
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scopes of the tokens, each route requires one of them
+const (
+	scopeEventsWrite        = "events:write"
+	scopeEventsRead         = "events:read"
+	scopeEventsConsume      = "events:consume" // pulling and acknowledging the events by the external consumers
+	scopeResultsRead        = "results:read"
+	scopeDeadLettersRead    = "dlq:read"
+	scopeDeadLettersWrite   = "dlq:write"
+	scopeSubscriptionsRead  = "subscriptions:read"
+	scopeSubscriptionsWrite = "subscriptions:write"
+	scopeSchemasRead        = "schemas:read"
+	scopeAdmin              = "admin"
+)
+
+var tokenScopes = []string{
+	scopeEventsWrite,
+	scopeEventsRead,
+	scopeEventsConsume,
+	scopeResultsRead,
+	scopeDeadLettersRead,
+	scopeDeadLettersWrite,
+	scopeSubscriptionsRead,
+	scopeSubscriptionsWrite,
+	scopeSchemasRead,
+	scopeAdmin,
+}
+
+// scopes granted to the tokens which don't carry any known scope, e.g. the oidc tokens only granted openid and profile
+var defaultTokenScopes = []string{scopeEventsWrite, scopeEventsRead}
+
+/*
+grantedScopes returns the known scopes of the space delimited scope claim like the oauth2 scopes.
+Scopes unknown to the server are ignored and the tokens without any known scope are only granted the default scopes.
+*/
+func grantedScopes(scope string) []string {
+	granted := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if helpers.In(s, tokenScopes...) && !helpers.In(s, granted...) {
+			granted = append(granted, s)
+		}
+	}
+	if len(granted) == 0 {
+		return defaultTokenScopes
+	}
+	return granted
+}
+
+/*
+hasScope reports whether the token is allowed to call the routes of the scope
+*/
+func (c *customClaims) hasScope(scope string) bool {
+	return helpers.In(scope, grantedScopes(c.Scope)...)
+}
+
+/*
+allowedScopes returns the scopes the user is entitled to request for its tokens.
+Only the static admin and the members of the ldap admin group are allowed the admin scope.
+*/
+func (api *ApiServer) allowedScopes(ctx context.Context, user string) []string {
+	if user == CmdApiAdmin {
+		return tokenScopes
+	}
+	if api.ldap != nil {
+		admin, err := api.ldap.isAdmin(ctx, user)
+		if err != nil && !errors.Is(err, ErrLdapUserNotFound) {
+			api.Logger.Error().Err(err).Str("user", user).Msg("failed to look up the admin group of the user on the ldap server")
+		}
+		if admin {
+			return tokenScopes
+		}
+	}
+	allowed := make([]string, 0, len(tokenScopes))
+	for _, scope := range tokenScopes {
+		if scope != scopeAdmin {
+			allowed = append(allowed, scope)
+		}
+	}
+	return allowed
+}
+
+/*
+requireScope rejects the requests whose token isn't granted the scope, it should be wrapped by JWTAuth
+*/
+func (api *ApiServer) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := api.getClaimsContext(r)
+		if claims == nil || !claims.hasScope(scope) {
+			span := trace.SpanFromContext(r.Context())
+			span.SetAttributes(attribute.String("auth.required_scope", scope))
+			span.SetStatus(codes.Error, "insufficient scope")
+			if scope == scopeAdmin {
+				actor := ""
+				if claims != nil {
+					actor = claims.Subject
+				}
+				api.auditAuthFailure(r, actor, "admin.access", errors.New("insufficient scope"))
+			}
+			api.insufficientScopeResponse(w, r, scope)
+			return
+		}
+		// every call of the admin endpoints is recorded besides the audit records of the actions themselves
+		if scope == scopeAdmin {
+			api.auditLog(r, claims.Subject, "admin.access").
+				Str("outcome", "success").
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Send()
+		}
+		next.ServeHTTP(w, r)
+	}
+}
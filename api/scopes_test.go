@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/rs/zerolog"
+)
+
+/*
+newTestApiServer returns an api server of the default test credentials without the optional subsystems
+*/
+func newTestApiServer(t *testing.T) *ApiServer {
+	t.Helper()
+	CmdApiAdmin, CmdApiAdminPass = "behavox-admin", "behavox-pass"
+	CmdJwtKey, CmdJwtRefreshKey = "test-jwt-key", "test-jwt-refresh-key"
+	CmdJwtIssuer, CmdJwtAudience = "behavox", "behavox-api"
+	CmdJwtTTL, CmdJwtRefreshTTL = time.Hour, 24*time.Hour
+	logger := zerolog.Nop()
+	cfg := NewApiServerCfg(nil, "", "", false, false, 0, 0, time.Second, time.Second, time.Second, nil)
+	api := NewApiServer(cfg, &logger, &data.Models{}, nil)
+	var err error
+	api.refreshTokens, err = data.NewRefreshTokenStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+/*
+newTestRequest returns a request carrying the request id set by setContextHandler
+*/
+func newTestRequest(api *ApiServer, method string, target string, body string) *http.Request {
+	return api.setReqIDContext(httptest.NewRequest(method, target, strings.NewReader(body)))
+}
+
+func TestGrantedScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		want  []string
+	}{
+		{name: "empty scope is granted the default scopes", scope: "", want: defaultTokenScopes},
+		{name: "oidc scopes are granted the default scopes", scope: "openid profile", want: defaultTokenScopes},
+		{name: "known scopes are kept", scope: "dlq:read admin", want: []string{scopeDeadLettersRead, scopeAdmin}},
+		{name: "unknown scopes are ignored", scope: "openid results:read", want: []string{scopeResultsRead}},
+		{name: "duplicate scopes are granted once", scope: "admin admin", want: []string{scopeAdmin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := grantedScopes(tt.scope)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("grantedScopes(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		check string
+		want  bool
+	}{
+		{name: "unscoped token can't call the admin routes", scope: "", check: scopeAdmin, want: false},
+		{name: "unscoped token can write events", scope: "", check: scopeEventsWrite, want: true},
+		{name: "oidc token can't call the admin routes", scope: "openid profile", check: scopeAdmin, want: false},
+		{name: "oidc token can't read the dead letters", scope: "openid profile", check: scopeDeadLettersRead, want: false},
+		{name: "scoped token can't call the other routes", scope: "results:read", check: scopeEventsWrite, want: false},
+		{name: "admin token can call the admin routes", scope: "admin", check: scopeAdmin, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &customClaims{Scope: tt.scope}
+			if got := claims.hasScope(tt.check); got != tt.want {
+				t.Errorf("hasScope(%q) of %q = %v, want %v", tt.check, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	api := newTestApiServer(t)
+	handler := api.requireScope(scopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name   string
+		claims *customClaims
+		want   int
+	}{
+		{name: "missing claims", claims: nil, want: http.StatusForbidden},
+		{name: "unscoped token", claims: &customClaims{}, want: http.StatusForbidden},
+		{name: "oidc token", claims: &customClaims{Scope: "openid profile"}, want: http.StatusForbidden},
+		{name: "admin token", claims: &customClaims{Scope: "admin"}, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodGet, "/v1/admin/changes", "")
+			if tt.claims != nil {
+				r = api.setClaimsContext(r, tt.claims)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedScopes(t *testing.T) {
+	api := newTestApiServer(t)
+	admin := api.allowedScopes(t.Context(), CmdApiAdmin)
+	if !strings.Contains(strings.Join(admin, " "), scopeAdmin) {
+		t.Errorf("static admin should be allowed the admin scope, got %v", admin)
+	}
+	for _, scope := range api.allowedScopes(t.Context(), "directory-user") {
+		if scope == scopeAdmin {
+			t.Errorf("directory users shouldn't be allowed the admin scope")
+		}
+	}
+}
+
+func TestCreateJWTTokenScopes(t *testing.T) {
+	api := newTestApiServer(t)
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		wantScope string
+	}{
+		{name: "no scope is issued the default scopes", body: "", wantCode: http.StatusOK, wantScope: "events:write events:read"},
+		{name: "admin can request the admin scope", body: `{"scope": "admin"}`, wantCode: http.StatusOK, wantScope: "admin"},
+		{name: "unknown scope is rejected", body: `{"scope": "root"}`, wantCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(api, http.MethodPost, "/v1/tokens", tt.body)
+			r.Header.Set("Content-Type", "application/json")
+			r.SetBasicAuth(CmdApiAdmin, CmdApiAdminPass)
+			w := httptest.NewRecorder()
+			api.createJWTTokenHandler(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var res struct {
+				Result TokenCreateRes `json:"result"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Result.Scope != tt.wantScope {
+				t.Errorf("scope = %q, want %q", res.Result.Scope, tt.wantScope)
+			}
+		})
+	}
+}
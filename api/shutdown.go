@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/rs/zerolog"
+)
+
+var (
+	CmdShutdownDrainGracePeriod  time.Duration
+	CmdShutdownHTTPTimeout       time.Duration
+	CmdShutdownQueueDrainTimeout time.Duration
+	CmdShutdownWorkerTimeout     time.Duration
+	CmdShutdownSinksTimeout      time.Duration
+	CmdShutdownTelemetryTimeout  time.Duration
+)
+
+// timeout of the shutdown phases which are not configurable since they don't wait for any external party
+const shutdownInstantPhaseTimeout = 5 * time.Second
+
+/*
+shutdownPhase is a single step of the graceful shutdown. Phases run in order and each of them has its own timeout,
+so a stuck phase doesn't consume the time budget of the next ones.
+*/
+type shutdownPhase struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+/*
+shutdownSummary reports the result of the graceful shutdown and the work abandoned during it
+*/
+type shutdownSummary struct {
+	failedPhases      []string
+	abandonedRequest  bool  // http server couldn't drain all the requests in time
+	abandonedInFlight int64 // events the worker was still processing when its phase timed out and the events leased by the consumers
+	abandonedQueued   int   // events left inside the queue without being processed
+	deadLetters       int   // events left inside the dead letter queue
+}
+
+// gracefulShitdown catches the terminate, quit, interrupt signals and shuts down the services in ordered phases
+func gracefulShutdown(api *ApiServer, logger *zerolog.Logger, shutdownChan chan error, nWorker *worker.Worker, otelShut func(context.Context) error, srvs ...*http.Server) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	s := <-sigChan
+
+	// log the signal catched
+	logger.Warn().Msgf("catched os signal %s", s)
+
+	summary := &shutdownSummary{}
+	phases := []shutdownPhase{
+		{
+			name:    "drain_load_balancers",
+			timeout: CmdShutdownDrainGracePeriod + shutdownInstantPhaseTimeout,
+			run: func(ctx context.Context) error {
+				// failing readiness checks while still serving the requests, so load balancers stop routing new traffic before the listener closes
+				api.unready.Store(true)
+				select {
+				case <-time.After(CmdShutdownDrainGracePeriod):
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		},
+		{
+			name:    "stop_accepting",
+			timeout: shutdownInstantPhaseTimeout,
+			run: func(ctx context.Context) error {
+				api.draining.Store(true)
+				return nil
+			},
+		},
+		{
+			name:    "drain_http",
+			timeout: CmdShutdownHTTPTimeout,
+			run: func(ctx context.Context) error {
+				var errs []error
+				for _, srv := range srvs {
+					err := srv.Shutdown(ctx)
+					if err != nil {
+						errs = append(errs, err)
+					}
+				}
+				if api.grpc != nil {
+					err := stopGRPCServer(ctx, api.grpc)
+					if err != nil {
+						errs = append(errs, err)
+					}
+				}
+				if len(errs) > 0 {
+					summary.abandonedRequest = true
+					return errors.Join(errs...)
+				}
+				// waiting for the background tasks started by the handlers to finish
+				done := make(chan struct{})
+				go func() {
+					api.Wg.Wait()
+					close(done)
+				}()
+				select {
+				case <-done:
+					return nil
+				case <-ctx.Done():
+					summary.abandonedRequest = true
+					return ctx.Err()
+				}
+			},
+		},
+		{
+			name:    "drain_queue",
+			timeout: CmdShutdownQueueDrainTimeout + shutdownInstantPhaseTimeout,
+			run: func(ctx context.Context) error {
+				if CmdShutdownQueueDrainTimeout <= 0 {
+					return nil
+				}
+				ctx, cancel := context.WithTimeout(ctx, CmdShutdownQueueDrainTimeout)
+				defer cancel()
+				return api.drainQueue(ctx, logger)
+			},
+		},
+		{
+			name:    "drain_worker",
+			timeout: CmdShutdownWorkerTimeout,
+			run: func(ctx context.Context) error {
+				err := nWorker.Shutdown(ctx)
+				summary.abandonedInFlight = nWorker.InFlight()
+				return err
+			},
+		},
+		{
+			name:    "flush_sinks",
+			timeout: CmdShutdownSinksTimeout,
+			run:     nWorker.Flush,
+		},
+		{
+			name:    "close_backends",
+			timeout: shutdownInstantPhaseTimeout,
+			run: func(ctx context.Context) error {
+				// events still inside the queue are lost since the queue lives in memory
+				abandoned := api.models.EventQueue.Purge(ctx)
+				for _, event := range abandoned {
+					api.models.EventQueue.NotifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusSkipped})
+				}
+				summary.abandonedQueued = len(abandoned)
+				// so are the events leased by the external consumers which can't acknowledge them anymore
+				leases := api.models.EventQueue.DropLeases()
+				for _, lease := range leases {
+					api.models.EventQueue.NotifyProcessed(&data.ProcessedEvent{Event: lease.Event, Status: data.EventProcessStatusSkipped})
+				}
+				summary.abandonedInFlight += int64(len(leases))
+				summary.deadLetters = api.models.DeadLetterQueue.Size(ctx)
+				// counters are flushed after the abandoned events are counted as skipped
+				return api.models.EventQueue.Lifetime.Flush()
+			},
+		},
+		{
+			name:    "flush_telemetry",
+			timeout: CmdShutdownTelemetryTimeout,
+			run:     otelShut,
+		},
+	}
+
+	var errs []error
+	for i, phase := range phases {
+		err := runShutdownPhase(logger, phase, i+1, len(phases))
+		if err != nil {
+			summary.failedPhases = append(summary.failedPhases, phase.name)
+			errs = append(errs, fmt.Errorf("shutdown phase %s failed: %w", phase.name, err))
+		}
+	}
+
+	logger.Info().
+		Strs("failed_phases", summary.failedPhases).
+		Bool("abandoned_http_requests", summary.abandonedRequest).
+		Int64("abandoned_inflight_events", summary.abandonedInFlight).
+		Int("abandoned_queued_events", summary.abandonedQueued).
+		Int("dead_letters", summary.deadLetters).
+		Msg("stopped the server")
+
+	shutdownChan <- errors.Join(errs...)
+}
+
+/*
+runShutdownPhase runs the phase with its own timeout and logs the progress of it
+*/
+func runShutdownPhase(logger *zerolog.Logger, phase shutdownPhase, index int, total int) error {
+	logger.Info().
+		Str("phase", phase.name).
+		Msgf("shutdown phase %d/%d started", index, total)
+
+	ctx, cancel := context.WithTimeout(context.Background(), phase.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := phase.run(ctx)
+	if err != nil {
+		logger.Error().Err(err).
+			Str("phase", phase.name).
+			Dur("duration", time.Since(start)).
+			Msgf("shutdown phase %d/%d failed", index, total)
+		return err
+	}
+	logger.Info().
+		Str("phase", phase.name).
+		Dur("duration", time.Since(start)).
+		Msgf("shutdown phase %d/%d finished", index, total)
+	return nil
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/cybrarymin/behavox/worker"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// minimum length of the secrets provided by the subscribers
+const subscriptionSecretMinLength = 16
+
+type SubscriptionCreateReq struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"` // all the event types if empty
+	Secret     string   `json:"secret"`      // generated if empty
+}
+
+/*
+SubscriptionCreateRes is the created subscription along with its secret, which is only reported once
+*/
+type SubscriptionCreateRes struct {
+	*data.Subscription
+	Secret string `json:"secret"`
+}
+
+/*
+webhookURLError returns the validation error of a webhook or a callback url
+*/
+func webhookURLError(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, worker.ErrWebhookAddressNotAllowed):
+		return "should resolve to a public address or its host should be one of --webhook-allowed-hosts"
+	case errors.Is(err, worker.ErrWebhookHostUnresolved):
+		return "host can't be resolved"
+	}
+	return "should be an absolute http or https url"
+}
+
+/*
+createSubscriptionHandler subscribes a webhook to the processing outcome of the events.
+The worker posts a payload signed by the secret of the subscription to the url once processing of each matching event finishes.
+*/
+func (api *ApiServer) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("createSubscriptionHandler.Tracer").Start(r.Context(), "createSubscriptionHandler.Span")
+	defer span.End()
+
+	nReq, err := helpers.ReadRequest[SubscriptionCreateReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	webhookURL, err := url.Parse(nReq.URL)
+	if err == nil {
+		err = worker.CheckWebhookURL(ctx, webhookURL)
+	}
+	nVal.Check(err == nil, "url", webhookURLError(err))
+	for _, eventType := range nReq.EventTypes {
+		_, found := data.LookupEventType(eventType)
+		nVal.Check(found, "event_types", "unknown event type "+eventType)
+	}
+	nVal.Check(nReq.Secret == "" || len(nReq.Secret) >= subscriptionSecretMinLength, "secret", "should be at least 16 characters")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	if nReq.Secret == "" {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to generate the subscription secret")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+		nReq.Secret = hex.EncodeToString(secret)
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	subscription := &data.Subscription{
+		ID:         uuid.New().String(),
+		URL:        nReq.URL,
+		EventTypes: nReq.EventTypes,
+		Secret:     nReq.Secret,
+		CreatedBy:  actor,
+		CreatedAt:  time.Now(),
+	}
+	if subscription.EventTypes == nil {
+		subscription.EventTypes = []string{}
+	}
+	span.SetAttributes(attribute.String("subscription.id", subscription.ID))
+
+	err = api.models.Subscriptions.Add(ctx, subscription)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, data.ErrSubscriptionLimit) {
+			span.SetStatus(codes.Error, "maximum number of subscriptions reached")
+			api.subscriptionLimitResponse(w, r)
+			return
+		}
+		span.SetStatus(codes.Error, "failed to add the subscription")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, actor, "subscription.create").
+		Str("subscription_id", subscription.ID).
+		Str("url", subscription.URL).
+		Strs("event_types", subscription.EventTypes).
+		Send()
+
+	nRes := &SubscriptionCreateRes{Subscription: subscription, Secret: subscription.Secret}
+	err = helpers.WriteResponse(ctx, w, r, http.StatusCreated, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+listSubscriptionsHandler lists the webhook subscriptions along with their delivery stats
+*/
+func (api *ApiServer) listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("listSubscriptionsHandler.Tracer").Start(r.Context(), "listSubscriptionsHandler.Span")
+	defer span.End()
+
+	subscriptions := api.models.Subscriptions.List(ctx)
+	span.SetAttributes(attribute.Int("subscriptions.count", len(subscriptions)))
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": subscriptions}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+/*
+deleteSubscriptionHandler unsubscribes the webhook. Deliveries already queued for it are still sent.
+*/
+func (api *ApiServer) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("deleteSubscriptionHandler.Tracer").Start(r.Context(), "deleteSubscriptionHandler.Span")
+	defer span.End()
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("subscription_id")
+	span.SetAttributes(attribute.String("subscription.id", id))
+
+	subscription, found := api.models.Subscriptions.Delete(ctx, id)
+	if !found {
+		span.SetStatus(codes.Error, "subscription not found")
+		api.notFoundResponse(w, r)
+		return
+	}
+
+	actor := ""
+	if claims := api.getClaimsContext(r); claims != nil {
+		actor = claims.Subject
+	}
+	api.auditLog(r, actor, "subscription.delete").Str("subscription_id", id).Str("url", subscription.URL).Send()
+
+	err := helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": subscription}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdSupportBundleLogLines int
+)
+
+// placeholder of the redacted secrets inside the support bundle
+const redactedValue = "[REDACTED]"
+
+// jwt tokens might be logged by the clients' requests or the errors, so they're redacted regardless of the signing key
+var jwtTokenRX = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// secretValues are the values of the sensitive flags redacted from the support bundle
+var secretValues []string
+
+/*
+SetSecretValues sets the values of the sensitive flags, the same flags provided by the secret environment variables. It should be called before Main.
+*/
+func SetSecretValues(values []string) {
+	// the longer values are redacted first, so the urls aren't left partially redacted by their passwords
+	secretValues = slices.SortedFunc(slices.Values(values), func(a, b string) int { return len(b) - len(a) })
+}
+
+// serverStartedAt is the startup time of the server reported in the support bundle
+var serverStartedAt = time.Now()
+
+/*
+logBuffer keeps the most recent log lines in memory to be attached to the support bundle
+*/
+type logBuffer struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int // index of the oldest line once the buffer is full
+	size  int
+}
+
+func newLogBuffer(size int) *logBuffer {
+	size = max(size, 0)
+	return &logBuffer{lines: make([][]byte, 0, size), size: size}
+}
+
+/*
+Write keeps a copy of the log line. zerolog writes each log event with a single call.
+*/
+func (b *logBuffer) Write(p []byte) (int, error) {
+	if b == nil || b.size <= 0 {
+		return len(p), nil
+	}
+	line := append([]byte(nil), p...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.lines) < b.size {
+		b.lines = append(b.lines, line)
+	} else {
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % b.size
+	}
+	return len(p), nil
+}
+
+/*
+Bytes returns the buffered log lines from the oldest to the newest
+*/
+func (b *logBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out bytes.Buffer
+	for i := range b.lines {
+		out.Write(b.lines[(b.next+i)%len(b.lines)])
+	}
+	return out.Bytes()
+}
+
+// recentLogs is the log lines of the server attached to the support bundle, nil if Main isn't called
+var recentLogs *logBuffer
+
+/*
+redactSecrets replaces the values of the sensitive flags and the jwt tokens inside the content
+*/
+func redactSecrets(content []byte) []byte {
+	for _, secret := range secretValues {
+		// short values would redact unrelated content and aren't secrets worth protecting
+		if len(secret) < 4 {
+			continue
+		}
+		content = bytes.ReplaceAll(content, []byte(secret), []byte(redactedValue))
+	}
+	return jwtTokenRX.ReplaceAll(content, []byte(redactedValue))
+}
+
+type SupportBundleVersion struct {
+	Version   string    `json:"version"`
+	BuildTime string    `json:"build_time"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+}
+
+type SupportBundleRuntime struct {
+	Goroutines    int             `json:"goroutines"`
+	GoMaxProcs    int             `json:"gomaxprocs"`
+	NumCPU        int             `json:"num_cpu"`
+	HeapAlloc     uint64          `json:"heap_alloc_bytes"`
+	HeapInuse     uint64          `json:"heap_inuse_bytes"`
+	Sys           uint64          `json:"sys_bytes"`
+	NumGC         uint32          `json:"num_gc"`
+	PauseTotal    string          `json:"gc_pause_total"`
+	LastGC        *time.Time      `json:"last_gc,omitempty"`
+	Resources     *ResourceTuning `json:"resources,omitempty"`
+	BundleTakenAt time.Time       `json:"bundle_taken_at"`
+}
+
+type supportBundleFile struct {
+	name    string
+	content []byte
+}
+
+/*
+supportBundleFiles collects the files of the support bundle with the secrets redacted
+*/
+func (api *ApiServer) supportBundleFiles(ctx context.Context) ([]supportBundleFile, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	runtimeInfo := &SupportBundleRuntime{
+		Goroutines:    runtime.NumGoroutine(),
+		GoMaxProcs:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		HeapAlloc:     memStats.HeapAlloc,
+		HeapInuse:     memStats.HeapInuse,
+		Sys:           memStats.Sys,
+		NumGC:         memStats.NumGC,
+		PauseTotal:    time.Duration(memStats.PauseTotalNs).String(),
+		Resources:     resourceTuning,
+		BundleTakenAt: time.Now(),
+	}
+	if memStats.LastGC > 0 {
+		lastGC := time.Unix(0, int64(memStats.LastGC))
+		runtimeInfo.LastGC = &lastGC
+	}
+
+	documents := []struct {
+		name     string
+		document interface{}
+	}{
+		{"version.json", &SupportBundleVersion{
+			Version:   Version,
+			BuildTime: BuildTime,
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			StartedAt: serverStartedAt,
+			Uptime:    time.Since(serverStartedAt).Round(time.Second).String(),
+		}},
+		// sensitive values of the effective configuration are already redacted
+		{"config.json", &ConfigSnapshot{Hash: effectiveConfigHash, Version: Version, SavedAt: time.Now(), Config: effectiveConfig}},
+		{"runtime.json", runtimeInfo},
+		{"capabilities.json", api.capabilities()},
+		{"queue.json", api.models.EventQueue.Inspect(ctx)},
+		{"worker.json", api.worker.Stats()},
+		{"worker_inflight.json", api.worker.InFlightEvents()},
+		{"dlq.json", api.models.DeadLetterQueue.Stats(ctx)},
+		{"drain.json", api.drainProgress(ctx)},
+		{"lifetime.json", api.models.EventQueue.Lifetime.Snapshot()},
+	}
+
+	files := make([]supportBundleFile, 0, len(documents)+2)
+	for _, document := range documents {
+		content, err := json.MarshalIndent(document.document, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize %s: %w", document.name, err)
+		}
+		files = append(files, supportBundleFile{name: document.name, content: content})
+	}
+
+	var goroutines bytes.Buffer
+	err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump the goroutines: %w", err)
+	}
+	files = append(files,
+		supportBundleFile{name: "goroutines.txt", content: goroutines.Bytes()},
+		supportBundleFile{name: "logs.jsonl", content: recentLogs.Bytes()})
+
+	for i := range files {
+		files[i].content = redactSecrets(files[i].content)
+	}
+	return files, nil
+}
+
+/*
+buildSupportBundle returns the name and content of the support bundle as a gzipped tar archive
+*/
+func (api *ApiServer) buildSupportBundle(ctx context.Context) (string, []byte, error) {
+	files, err := api.supportBundleFiles(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var archive bytes.Buffer
+	gzWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzWriter)
+	takenAt := time.Now()
+	dir := "behavox-support-" + takenAt.UTC().Format("20060102T150405Z")
+	for _, file := range files {
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:    dir + "/" + file.name,
+			Mode:    0600,
+			Size:    int64(len(file.content)),
+			ModTime: takenAt,
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		_, err = tarWriter.Write(file.content)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	err = tarWriter.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	err = gzWriter.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return dir + ".tar.gz", archive.Bytes(), nil
+}
+
+/*
+supportBundleHandler returns an archive of the sanitized configuration, recent logs, runtime stats, queue and worker state,
+goroutine dump and version information to be attached to the bug reports. Secrets are redacted from all the files.
+actor is recorded on the audit log if the request isn't authenticated by a token, e.g. on the admin socket.
+*/
+func (api *ApiServer) supportBundleHandler(actor string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("supportBundleHandler.Tracer").Start(r.Context(), "supportBundleHandler.Span")
+		defer span.End()
+
+		bundleActor := actor
+		if claims := api.getClaimsContext(r); claims != nil {
+			bundleActor = claims.Subject
+		}
+
+		// the archive is built in memory so the failures can still be reported by an error response
+		name, archive, err := api.buildSupportBundle(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to generate the support bundle")
+			api.serverErrorResponse(w, r, err)
+			return
+		}
+		api.auditLog(r, bundleActor, "support_bundle").Int("size", len(archive)).Send()
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(archive)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to write the response for the client")
+			api.logError(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdTokenExchangeTrustedActors []string
+	CmdTokenExchangeTTL           time.Duration
+)
+
+// RFC 8693 identifiers
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJwt           = "urn:ietf:params:oauth:token-type:jwt"
+	// subject_token is the identity of the downstream producer asserted by the trusted actor
+	tokenTypeProducer = "urn:behavox:params:oauth:token-type:producer"
+)
+
+// maximum number of the hops recorded in the act claim of an exchanged token
+const maxDelegationDepth = 5
+
+/*
+actorClaim is the act claim of RFC 8693. It identifies the party acting on behalf of the subject of the token,
+prior actors of a multi-hop delegation are nested inside it.
+*/
+type actorClaim struct {
+	Subject string      `json:"sub"`
+	Act     *actorClaim `json:"act,omitempty"`
+}
+
+/*
+actors returns the chain of the actors starting from the current one
+*/
+func (a *actorClaim) actors() []string {
+	var chain []string
+	for actor := a; actor != nil; actor = actor.Act {
+		chain = append(chain, actor.Subject)
+	}
+	return chain
+}
+
+type TokenExchangeReq struct {
+	GrantType        string `json:"grant_type"`
+	SubjectToken     string `json:"subject_token"`
+	SubjectTokenType string `json:"subject_token_type"`
+	Audience         string `json:"audience,omitempty"`
+}
+
+type TokenExchangeRes struct {
+	AccessToken     string   `json:"access_token"`
+	IssuedTokenType string   `json:"issued_token_type"`
+	TokenType       string   `json:"token_type"`
+	ExpiresIn       int64    `json:"expires_in"` // seconds
+	Subject         string   `json:"subject"`
+	Actors          []string `json:"actors"` // delegation chain starting from the current actor
+}
+
+/*
+exchangeTokenHandler exchanges the token of a trusted actor (e.g. a gateway) for a token acting on behalf of a downstream producer.
+subject_token is either the producer identity or a token issued by the server, which is how the delegation is chained across multiple hops.
+The issued token expires no later than the actor and subject tokens and --token-exchange-ttl, and its events are attributed to the producer.
+*/
+func (api *ApiServer) exchangeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("exchangeTokenHandler.Tracer").Start(r.Context(), "exchangeTokenHandler.Span")
+	defer span.End()
+
+	actorClaims := api.getClaimsContext(r)
+	span.SetAttributes(attribute.String("token_exchange.actor", actorClaims.Subject))
+	if actorClaims.Act != nil || !helpers.In(actorClaims.Subject, CmdTokenExchangeTrustedActors...) {
+		span.SetStatus(codes.Error, "untrusted actor")
+		api.untrustedActorResponse(w, r)
+		return
+	}
+
+	nReq, err := helpers.ReadRequest[TokenExchangeReq](ctx, w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid input")
+		api.badRequestResponse(w, r, err)
+		return
+	}
+
+	nVal := helpers.NewValidator()
+	nVal.Check(nReq.GrantType == tokenExchangeGrantType, "grant_type", "should be "+tokenExchangeGrantType)
+	nVal.Check(nReq.SubjectToken != "", "subject_token", "must be provided")
+	nVal.Check(helpers.In(nReq.SubjectTokenType, tokenTypeJwt, tokenTypeProducer), "subject_token_type", "should be either "+tokenTypeJwt+" or "+tokenTypeProducer)
+	nVal.Check(nReq.Audience == "" || nReq.Audience == CmdJwtAudience, "audience", "should be the audience of the tokens issued by the server")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	expiresAt := time.Now().Add(CmdTokenExchangeTTL)
+	if actorClaims.ExpiresAt != nil && actorClaims.ExpiresAt.Before(expiresAt) {
+		expiresAt = actorClaims.ExpiresAt.Time
+	}
+
+	subject := nReq.SubjectToken
+	act := &actorClaim{Subject: actorClaims.Subject}
+	if nReq.SubjectTokenType == tokenTypeJwt {
+		subjectToken, err := jwt.ParseWithClaims(nReq.SubjectToken, &customClaims{}, api.accessTokenKey, api.accessTokenParserOptions()...)
+		if err != nil || !subjectToken.Valid {
+			if err == nil {
+				err = errors.New("invalid jwt token")
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid subject token")
+			nVal.AddError("subject_token", "should be a valid token issued by the server")
+			api.failedValidationResponse(w, r, nVal.Errors)
+			return
+		}
+		subjectClaims := subjectToken.Claims.(*customClaims)
+		subject = subjectClaims.Subject
+		act.Act = subjectClaims.Act
+		if subjectClaims.ExpiresAt != nil && subjectClaims.ExpiresAt.Before(expiresAt) {
+			expiresAt = subjectClaims.ExpiresAt.Time
+		}
+	}
+	nVal.Check(subject != "" && len(subject) <= 200, "subject_token", "subject should be between 1 and 200 bytes long")
+	nVal.Check(helpers.EmailRX.MatchString(subject+"@behavox.com"), "subject_token", "subject should be a valid producer identity")
+	nVal.Check(len(act.actors()) <= maxDelegationDepth, "subject_token", "delegation chain is too long")
+	if !nVal.Valid() {
+		span.SetStatus(codes.Error, "invalid input")
+		api.failedValidationResponse(w, r, nVal.Errors)
+		return
+	}
+
+	// the exchanged token can't be granted more than the actor. It's signed by the server, so it's issued like the other local tokens
+	// even if the actor token is issued by the oidc provider.
+	claims := customClaims{
+		Email: subject + "@behavox.com",
+		Scope: actorClaims.Scope,
+		Act:   act,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    CmdJwtIssuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			Subject:   subject,
+			Audience:  []string{CmdJwtAudience},
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+		},
+	}
+	span.SetAttributes(attribute.String("claims.subject", claims.Subject))
+	span.SetAttributes(attribute.StringSlice("claims.act", act.actors()))
+	span.SetAttributes(attribute.String("claims.id", claims.ID))
+
+	signedToken, err := api.signAccessToken(claims)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sign the token")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+	api.auditLog(r, actorClaims.Subject, "token.exchange").
+		Str("subject", subject).
+		Strs("actors", act.actors()).
+		Str("token_id", claims.ID).
+		Time("expires_at", expiresAt).
+		Send()
+
+	nRes := &TokenExchangeRes{
+		AccessToken:     signedToken,
+		IssuedTokenType: tokenTypeJwt,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(expiresAt).Seconds()),
+		Subject:         subject,
+		Actors:          act.actors(),
+	}
+	err = helpers.WriteResponse(ctx, w, r, http.StatusOK, helpers.Envelope{"result": nRes}, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write the response for the client")
+		api.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestExchangeToken(t *testing.T) {
+	api := newTestApiServer(t)
+	CmdTokenExchangeTrustedActors, CmdTokenExchangeTTL = []string{"gateway"}, time.Hour
+	t.Cleanup(func() {
+		CmdTokenExchangeTrustedActors, CmdTokenExchangeTTL = nil, 0
+	})
+	verifier, sign := newTestOIDCProvider(t, "")
+	api.oidc = verifier
+	localToken, err := api.issueTokens(t.Context(), "gateway", uuid.NewString(), scopeEventsWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcToken := sign(&oidcClaims{Scope: scopeEventsWrite, RegisteredClaims: jwt.RegisteredClaims{Subject: "gateway"}})
+	exchange := api.JWTAuth(api.exchangeTokenHandler)
+	authenticated := api.JWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		actorToken string
+		audience   string
+		wantCode   int
+	}{
+		{name: "local actor", actorToken: localToken.Token, wantCode: http.StatusOK},
+		{name: "oidc actor", actorToken: oidcToken, wantCode: http.StatusOK},
+		{name: "audience of the server", actorToken: oidcToken, audience: CmdJwtAudience, wantCode: http.StatusOK},
+		{name: "other audience", actorToken: oidcToken, audience: testOIDCAudience, wantCode: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(&TokenExchangeReq{GrantType: tokenExchangeGrantType, SubjectToken: "producer-1",
+				SubjectTokenType: tokenTypeProducer, Audience: tt.audience})
+			r := newTestRequest(api, http.MethodPost, "/v1/tokens/exchange", string(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+tt.actorToken)
+			w := httptest.NewRecorder()
+			exchange(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var res struct {
+				Result TokenExchangeRes `json:"result"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := &customClaims{}
+			_, _, err = jwt.NewParser().ParseUnverified(res.Result.AccessToken, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Issuer != CmdJwtIssuer || len(claims.Audience) != 1 || claims.Audience[0] != CmdJwtAudience {
+				t.Errorf("iss, aud = %s, %v, want %s, [%s]", claims.Issuer, claims.Audience, CmdJwtIssuer, CmdJwtAudience)
+			}
+
+			// the exchanged token is accepted by the server
+			r = newTestRequest(api, http.MethodGet, "/v1/events", "")
+			r.Header.Set("Authorization", "Bearer "+res.Result.AccessToken)
+			w = httptest.NewRecorder()
+			authenticated(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Errorf("exchanged token is rejected with %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"github.com/julienschmidt/httprouter"
+)
+
+/*
+Versions of the api. Handlers are shared between the versions and only the shape of their responses differs:
+  - v1 wraps the results in {"result": ...} and reports the errors as a message or the invalid fields
+  - v2 responds with the result itself, e.g. a flat event object, and reports the errors as {"code", "message", "details"}
+*/
+const (
+	apiVersion1 = "v1"
+	apiVersion2 = "v2"
+)
+
+var apiVersions = []string{apiVersion1, apiVersion2}
+
+// machine readable codes of the v2 errors, the errors without a specific code use the code of their status
+const (
+	errorCodeBadRequest           = "bad_request"
+	errorCodeUnauthorized         = "unauthorized"
+	errorCodeForbidden            = "forbidden"
+	errorCodeNotFound             = "not_found"
+	errorCodeMethodNotAllowed     = "method_not_allowed"
+	errorCodeConflict             = "conflict"
+	errorCodeUnsupportedMediaType = "unsupported_media_type"
+	errorCodeValidationFailed     = "validation_failed"
+	errorCodeRateLimited          = "rate_limited"
+	errorCodeInternal             = "internal_error"
+	errorCodeUnavailable          = "unavailable"
+	errorCodeDeadlineExceeded     = "deadline_exceeded"
+	errorCodeDuplicateEvent       = "duplicate_event"
+	errorCodeEventProcessing      = "event_processing"
+	errorCodeQueueFull            = "queue_full"
+	errorCodeDraining             = "draining"
+	errorCodeBackpressure         = "backpressure"
+	errorCodeReadOnly             = "read_only"
+	errorCodeInsufficientScope    = "insufficient_scope"
+)
+
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:           errorCodeBadRequest,
+	http.StatusUnauthorized:         errorCodeUnauthorized,
+	http.StatusForbidden:            errorCodeForbidden,
+	http.StatusNotFound:             errorCodeNotFound,
+	http.StatusMethodNotAllowed:     errorCodeMethodNotAllowed,
+	http.StatusConflict:             errorCodeConflict,
+	http.StatusUnsupportedMediaType: errorCodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:  errorCodeValidationFailed,
+	http.StatusTooManyRequests:      errorCodeRateLimited,
+	http.StatusInternalServerError:  errorCodeInternal,
+	http.StatusServiceUnavailable:   errorCodeUnavailable,
+	http.StatusGatewayTimeout:       errorCodeDeadlineExceeded,
+}
+
+/*
+ErrorDetailsRes is the error of the v2 responses
+*/
+type ErrorDetailsRes struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"` // invalid fields of the validation errors or the structured information of the error
+}
+
+type ErrorResV2 struct {
+	Error     ErrorDetailsRes `json:"error"`
+	RequestID string          `json:"request_id"`
+}
+
+/*
+newErrorDetailsRes converts the message of the v1 errors into the v2 error
+*/
+func newErrorDetailsRes(status int, code string, message interface{}) ErrorDetailsRes {
+	if code == "" {
+		code = statusErrorCodes[status]
+	}
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	nRes := ErrorDetailsRes{Code: code}
+	switch message := message.(type) {
+	case string:
+		nRes.Message = message
+	case map[string]string:
+		nRes.Message = "the request contains invalid fields"
+		nRes.Details = message
+	case *DeadlineExceededRes:
+		nRes.Message = message.Message
+		nRes.Details = message
+	default:
+		nRes.Message = http.StatusText(status)
+		nRes.Details = message
+	}
+	return nRes
+}
+
+/*
+versioned serves the handler as the version of the api. It should be the outermost wrapper of the route,
+so the errors of the authentication are also reported in the format of the version.
+*/
+func (api *ApiServer) versioned(version string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, api.setAPIVersionContext(r, version))
+	}
+}
+
+/*
+writeResult writes the result of the handler in the envelope of the api version of the request
+*/
+func (api *ApiServer) writeResult(ctx context.Context, w http.ResponseWriter, r *http.Request, status int, result interface{}, headers http.Header) error {
+	if api.getAPIVersionContext(r) == apiVersion1 {
+		return helpers.WriteResponse(ctx, w, r, status, helpers.Envelope{"result": result}, headers)
+	}
+	return helpers.WriteResponse(ctx, w, r, status, result, headers)
+}
+
+/*
+eventRoutes registers the event routes of the api version
+*/
+func (api *ApiServer) eventRoutes(router *httprouter.Router, version string) {
+	prefix := "/" + version
+	router.HandlerFunc(http.MethodPost, prefix+"/events", api.versioned(version, api.JWTAuth(api.requireScope(scopeEventsWrite, api.createEventHandler))))
+	router.HandlerFunc(http.MethodGet, prefix+"/events", api.versioned(version, api.JWTAuth(api.requireScope(scopeEventsRead, api.listEventsHandler))))
+	router.HandlerFunc(http.MethodDelete, prefix+"/events/:event_id", api.versioned(version, api.JWTAuth(api.requireScope(scopeEventsWrite, api.cancelEventHandler))))
+	// POST /events/batch, /events/bulk and /events/import are served by eventActionHandler
+	router.HandlerFunc(http.MethodPost, prefix+"/events/:event_id", api.versioned(version, api.eventActionHandler()))
+	// POST /events/:event_id/verify and /events/leases/:lease_id/ack and nack are served by eventItemActionHandler
+	router.HandlerFunc(http.MethodPost, prefix+"/events/:event_id/*action", api.versioned(version, api.eventItemActionHandler(version)))
+	router.HandlerFunc(http.MethodGet, prefix+"/events/batch/:batch_id", api.versioned(version, api.JWTAuth(api.requireScope(scopeEventsRead, api.getEventBatchHandler))))
+	router.HandlerFunc(http.MethodGet, prefix+"/events/next", api.versioned(version, api.JWTAuth(api.requireScope(scopeEventsConsume, api.pullEventHandler))))
+}
+
+/*
+eventActionHandler creates the handler of the POST requests on the events.
+httprouter doesn't allow the static routes next to the wildcard of the verify route, so batch, bulk and import are dispatched from here.
+*/
+func (api *ApiServer) eventActionHandler() http.HandlerFunc {
+	batch := api.JWTAuth(api.requireScope(scopeEventsWrite, api.createEventBatchHandler))
+	bulk := api.JWTAuth(api.requireScope(scopeEventsWrite, api.createEventBulkHandler))
+	importEvents := api.JWTAuth(api.requireScope(scopeEventsWrite, api.importEventsHandler))
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch httprouter.ParamsFromContext(r.Context()).ByName("event_id") {
+		case "batch":
+			batch(w, r)
+		case "bulk":
+			bulk(w, r)
+		case "import":
+			importEvents(w, r)
+		default:
+			api.notFoundResponse(w, r)
+		}
+	}
+}
+
+/*
+eventItemActionHandler creates the handler of the POST requests on an event, the acks and nacks of the leases are dispatched from here for the same reason.
+Verifying the process results of an event is only served by the v1 api like the rest of the results routes.
+*/
+func (api *ApiServer) eventItemActionHandler(version string) http.HandlerFunc {
+	verify := api.JWTAuth(api.requireScope(scopeResultsRead, api.verifyResultHandler))
+	ack := api.JWTAuth(api.requireScope(scopeEventsConsume, api.ackEventHandler))
+	nack := api.JWTAuth(api.requireScope(scopeEventsConsume, api.nackEventHandler))
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		action := params.ByName("action")
+		if params.ByName("event_id") == "leases" {
+			// the action of a lease is prefixed by its id, e.g. /<lease id>/ack
+			leaseID, leaseAction, _ := strings.Cut(strings.TrimPrefix(action, "/"), "/")
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "lease_id", Value: leaseID}}))
+			switch leaseAction {
+			case "ack":
+				ack(w, r)
+			case "nack":
+				nack(w, r)
+			default:
+				api.notFoundResponse(w, r)
+			}
+			return
+		}
+		if action == "/verify" && version == apiVersion1 {
+			verify(w, r)
+			return
+		}
+		api.notFoundResponse(w, r)
+	}
+}
+
+/*
+apiV2Operations documents the v2 routes out of the v1 operations of the routes served by both versions
+*/
+func apiV2Operations() []apiOperation {
+	var operations []apiOperation
+	for _, op := range apiOperations {
+		if op.tag != "events" {
+			continue
+		}
+		op.path = "/" + apiVersion2 + strings.TrimPrefix(op.path, "/"+apiVersion1)
+		op.result = false
+		if _, ok := op.response.(EventCreateResEnvelope); ok {
+			op.response = EventCreateResV2{}
+		}
+		operations = append(operations, op)
+	}
+	return operations
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cybrarymin/behavox/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdLocalTimeout        time.Duration
+	cmdDrainWait           bool
+	cmdSupportBundleOutput string
+)
+
+// queueCmd groups the operational commands of the event queue
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "operational commands of the event queue of a running server",
+	Long:  `operational commands of the event queue of a running server. commands are sent through the admin unix socket`,
+}
+
+// queueInspectCmd represents the queue inspect command
+var queueInspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "showing the events waiting inside the queue by priority and event type",
+	Long:  `showing the events waiting inside the queue by priority and event type`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printLocalRequest(http.MethodGet, "/local/queue")
+	},
+}
+
+// workerCmd groups the operational commands of the worker
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "operational commands of the worker of a running server",
+	Long:  `operational commands of the worker of a running server. commands are sent through the admin unix socket`,
+}
+
+// workerStatsCmd represents the worker stats command
+var workerStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "showing the in flight and processed events of the worker",
+	Long:  `showing the in flight and processed events of the worker`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printLocalRequest(http.MethodGet, "/local/worker")
+	},
+}
+
+// drainCmd represents the drain command
+var drainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "stop accepting new events on a running server and let the worker drain the queue",
+	Long:  `stop accepting new events on a running server and let the worker drain the queue. /readyz starts failing as well so load balancers stop routing traffic to the server`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !cmdDrainWait {
+			return printLocalRequest(http.MethodPost, "/local/drain")
+		}
+
+		// polling the drain progress until the queue and in flight events are all processed
+		deadline := time.Now().Add(cmdLocalTimeout)
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), cmdLocalTimeout)
+			result, err := api.LocalRequest(ctx, api.CmdAdminSocket, http.MethodPost, "/local/drain")
+			cancel()
+			if err != nil {
+				return err
+			}
+			var progress api.DrainRes
+			err = json.Unmarshal(result, &progress)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("queue_size: %d, in_flight: %d\n", progress.QueueSize, progress.InFlight)
+			if progress.Drained {
+				fmt.Println("drained")
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("server is not drained after %s", cmdLocalTimeout)
+			}
+			time.Sleep(time.Second)
+		}
+	},
+}
+
+// supportBundleCmd represents the support-bundle command
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "generating an archive of the diagnostics of a running server to be attached to the bug reports",
+	Long:  `generating an archive of the sanitized configuration, recent logs, runtime stats, queue and worker state, goroutine dump and version information of a running server. secrets are redacted from the archive`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := cmdSupportBundleOutput
+		if output == "" {
+			output = "behavox-support-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+		}
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), cmdLocalTimeout)
+		defer cancel()
+		err = api.LocalDownload(ctx, api.CmdAdminSocket, "/local/support-bundle", file)
+		if err != nil {
+			os.Remove(output)
+			return err
+		}
+		fmt.Println(output)
+		return nil
+	},
+}
+
+/*
+printLocalRequest sends the request to the admin socket and prints the indented result
+*/
+func printLocalRequest(method string, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cmdLocalTimeout)
+	defer cancel()
+	result, err := api.LocalRequest(ctx, api.CmdAdminSocket, method, path)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, result, "", "  ")
+	if err != nil {
+		return err
+	}
+	out.WriteTo(os.Stdout)
+	fmt.Println()
+	return nil
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{queueInspectCmd, workerStatsCmd, drainCmd, supportBundleCmd} {
+		cmd.SilenceUsage = true // errors of these commands are runtime errors and not the usage ones
+		cmd.Flags().DurationVar(&cmdLocalTimeout, "timeout", 30*time.Second, "maximum amount of time to wait for the response of the server")
+	}
+	drainCmd.Flags().BoolVar(&cmdDrainWait, "wait", false, "wait until the queue and in flight events are all processed")
+	supportBundleCmd.Flags().StringVarP(&cmdSupportBundleOutput, "output", "o", "", "path of the support bundle archive, defaults to behavox-support-<time>.tar.gz in the current directory")
+
+	queueCmd.AddCommand(queueInspectCmd)
+	workerCmd.AddCommand(workerStatsCmd)
+	rootCmd.AddCommand(queueCmd, workerCmd, drainCmd, supportBundleCmd)
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// prefix of the environment variables providing the sensitive flags, e.g. BEHAVOX_JWKEY and BEHAVOX_JWKEY_FILE for --jwkey
+const secretEnvPrefix = "BEHAVOX_"
+
+/*
+secretEnvName returns the environment variable of the sensitive flag
+*/
+func secretEnvName(flagName string) string {
+	return secretEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+/*
+loadSecretFlags sets the sensitive flags which aren't given on the command line from the environment, so the secrets aren't visible in the ps output.
+BEHAVOX_<FLAG>_FILE reads the secret from a file, e.g. a mounted kubernetes or docker secret, and BEHAVOX_<FLAG> provides the secret itself.
+*/
+func loadSecretFlags(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if _, sensitive := flag.Annotations[sensitiveFlagAnnotation]; !sensitive || flag.Changed || err != nil {
+			return
+		}
+		envName := secretEnvName(flag.Name)
+		value, fromEnv := os.LookupEnv(envName)
+		file, fromFile := os.LookupEnv(envName + "_FILE")
+		switch {
+		case fromEnv && fromFile:
+			err = fmt.Errorf("only one of %s and %s_FILE should be set", envName, envName)
+			return
+		case fromFile:
+			content, readErr := os.ReadFile(file)
+			if readErr != nil {
+				err = fmt.Errorf("failed to read %s of %s_FILE: %w", file, envName, readErr)
+				return
+			}
+			// editors and kubectl create secret --from-file keep the trailing newline
+			value = strings.TrimRight(string(content), "\r\n")
+		case !fromEnv:
+			return
+		}
+		setErr := flags.Set(flag.Name, value)
+		if setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", envName, setErr)
+		}
+	})
+	return err
+}
+
+/*
+secretFlagValues returns the values of the sensitive flags to be redacted from the support bundle, the entries of the map flags
+like --hmac-keys separately and the passwords of the urls like --sink-database-url also by themselves
+*/
+func secretFlagValues(flags *pflag.FlagSet) []string {
+	var values []string
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if _, sensitive := flag.Annotations[sensitiveFlagAnnotation]; !sensitive {
+			return
+		}
+		switch flag.Value.Type() {
+		case "stringToString":
+			entries, _ := flags.GetStringToString(flag.Name)
+			for _, value := range entries {
+				values = append(values, value)
+			}
+			return
+		case "stringSlice":
+			entries, _ := flags.GetStringSlice(flag.Name)
+			values = append(values, entries...)
+			return
+		}
+		value := flag.Value.String()
+		values = append(values, value)
+		if parsedURL, err := url.Parse(value); err == nil && parsedURL.User != nil {
+			if password, found := parsedURL.User.Password(); found {
+				values = append(values, password, url.QueryEscape(password))
+			}
+		}
+	})
+	return values
+}
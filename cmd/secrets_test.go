@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSecretFlagValues(t *testing.T) {
+	flags := rootCmd.Flags()
+	tests := []struct {
+		flag  string
+		value string
+		want  []string
+	}{
+		{flag: "jwkey", value: "jwt-signing-secret", want: []string{"jwt-signing-secret"}},
+		{flag: "hmac-keys", value: "client-a=hmac-secret-of-client-a", want: []string{"hmac-secret-of-client-a"}},
+		{flag: "sink-database-url", value: "postgres://behavox:db%2Fpassword@db:5432/events", want: []string{"postgres://behavox:db%2Fpassword@db:5432/events", "db/password", "db%2Fpassword"}},
+		{flag: "sink-s3-session-token", value: "s3-session-token", want: []string{"s3-session-token"}},
+	}
+	for _, tt := range tests {
+		err := flags.Set(tt.flag, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	values := secretFlagValues(flags)
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			for _, want := range tt.want {
+				if !slices.Contains(values, want) {
+					t.Errorf("secret values don't contain %q of --%s", want, tt.flag)
+				}
+			}
+		})
+	}
+	if slices.Contains(values, flags.Lookup("api-admin-user").Value.String()) {
+		t.Error("secret values contain a flag which isn't sensitive")
+	}
+}
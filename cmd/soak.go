@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cybrarymin/behavox/soak"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdSoakConfig soak.Config
+	cmdSoakReport string
+)
+
+// soakCmd represents the soak command
+var soakCmd = &cobra.Command{
+	Use:   "soak",
+	Short: "running a long soak test against a running server with synthetic producers",
+	Long: `running a long soak test against a running server with synthetic producers and a validating consumer.
+every accepted event is tracked by its producer sequence and the consumer verifies it's processed exactly once by tailing the processed events file of the server,
+so the command should run on the same host as the server and the server should use the ndjson output format.
+the report is printed at the end and the command fails if any event is lost or duplicated. interrupting the command stops producing and still generates the report`,
+	SilenceUsage: true,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch {
+		case cmdSoakConfig.Producers < 1:
+			return errors.New("--producers should be at least 1")
+		case cmdSoakConfig.Rate <= 0:
+			return errors.New("--rate should be positive")
+		case cmdSoakConfig.Duration <= 0:
+			return errors.New("--duration should be positive")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		cmdSoakConfig.Logger = &logger
+		report, err := soak.Run(ctx, cmdSoakConfig)
+		if err != nil {
+			return err
+		}
+
+		jReport, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jReport))
+		if cmdSoakReport != "" {
+			err = os.WriteFile(cmdSoakReport, append(jReport, '\n'), 0644)
+			if err != nil {
+				return err
+			}
+		}
+		if !report.Passed {
+			return fmt.Errorf("soak test failed: %d lost and %d duplicated events", report.Lost, report.Duplicated)
+		}
+		return nil
+	},
+}
+
+func init() {
+	soakCmd.Flags().StringVar(&cmdSoakConfig.Target, "target", "http://127.0.0.1:80", "base url of the server under test")
+	soakCmd.Flags().StringVar(&cmdSoakConfig.Username, "username", "behavox-admin", "api admin user used to issue the token of the producers")
+	soakCmd.Flags().StringVar(&cmdSoakConfig.Password, "password", "behavox-pass", "api admin password used to issue the token of the producers")
+	soakCmd.Flags().BoolVar(&cmdSoakConfig.Insecure, "insecure", false, "skip the tls certificate verification of the server")
+	soakCmd.Flags().IntVar(&cmdSoakConfig.Producers, "producers", 4, "number of synthetic producers sending the events concurrently")
+	soakCmd.Flags().Float64Var(&cmdSoakConfig.Rate, "rate", 10, "number of events per second sent by each producer")
+	soakCmd.Flags().DurationVar(&cmdSoakConfig.Duration, "duration", time.Hour, "amount of time the producers send events")
+	soakCmd.Flags().DurationVar(&cmdSoakConfig.DrainTimeout, "drain-timeout", 2*time.Minute, "maximum amount of time to wait for the accepted events to be processed after the producers stop. unprocessed events are reported as lost")
+	soakCmd.Flags().StringVar(&cmdSoakConfig.ProcessedFile, "event-processor-file", "/tmp/events.json", "processed events file of the server tailed by the validating consumer")
+	soakCmd.Flags().StringVar(&cmdSoakReport, "report", "", "file to write the json report into in addition to the stdout")
+
+	rootCmd.AddCommand(soakCmd)
+}
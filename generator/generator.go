@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+var (
+	CmdGeneratorRates       map[string]int // events per second by event type, generators are disabled if empty
+	CmdGeneratorProducer    string
+	CmdGeneratorLogLevels   []string
+	CmdGeneratorLogMessages []string
+	CmdGeneratorMetricMin   float64
+	CmdGeneratorMetricMax   float64
+)
+
+// maximum rate of a generator, higher rates are better served by the soak subcommand against the api
+const MaxGeneratorRate = 1000
+
+// event types which can be generated
+var EventTypes = []string{data.EventTypeLog, data.EventTypeMetric}
+
+// status of the generated events
+const (
+	statusEnqueued = "enqueued"
+	statusDropped  = "dropped"
+)
+
+/*
+Generator produces synthetic events directly into the event queue, so the demos and local development don't need an external traffic source
+*/
+type Generator struct {
+	logger    *zerolog.Logger
+	eq        *data.EventQueue
+	accepting func() bool // events aren't generated once the server stops accepting new events
+}
+
+func NewGenerator(logger *zerolog.Logger, eq *data.EventQueue, accepting func() bool) *Generator {
+	return &Generator{
+		logger:    logger,
+		eq:        eq,
+		accepting: accepting,
+	}
+}
+
+/*
+Validation validates the generator flags
+*/
+func Validation(v *helpers.Validator) {
+	for eventType, rate := range CmdGeneratorRates {
+		v.Check(helpers.In(eventType, EventTypes...), "generator-rates", fmt.Sprintf("event type %s can't be generated", eventType))
+		v.Check(rate > 0 && rate <= MaxGeneratorRate, "generator-rates", fmt.Sprintf("rate of %s should be between 1 and %d", eventType, MaxGeneratorRate))
+	}
+	if _, found := CmdGeneratorRates[data.EventTypeLog]; found {
+		v.Check(len(CmdGeneratorLogLevels) > 0, "generator-log-levels", "should contain at least one level")
+		v.Check(len(CmdGeneratorLogMessages) > 0, "generator-log-messages", "should contain at least one message")
+	}
+	v.Check(CmdGeneratorMetricMin <= CmdGeneratorMetricMax, "generator-metric-min", "shouldn't be greater than generator-metric-max")
+}
+
+/*
+Run starts a generator per configured event type and blocks until the context is cancelled
+*/
+func (g *Generator) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for eventType, rate := range CmdGeneratorRates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.generate(ctx, eventType, rate)
+		}()
+		g.logger.Info().Str("event_type", eventType).Int("rate", rate).Msg("started the synthetic event generator")
+	}
+	wg.Wait()
+}
+
+func (g *Generator) generate(ctx context.Context, eventType string, rate int) {
+	spec, _ := data.LookupEventType(eventType)
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !g.accepting() {
+			continue
+		}
+
+		fields := g.fields(eventType)
+		nVal := helpers.NewValidator()
+		spec.ValidateFields(nVal, fields)
+		if !nVal.Valid() {
+			g.logger.Error().Str("event_type", eventType).Interface("errors", nVal.Errors).Msg("generated an invalid event")
+			return
+		}
+		event := spec.New(uuid.NewString(), fields)
+		event.SetProducer(CmdGeneratorProducer)
+
+		err := g.eq.PutEvent(ctx, event)
+		switch {
+		case err == nil:
+			observ.PromGeneratedEvents.WithLabelValues(eventType, statusEnqueued).Inc()
+		case errors.Is(err, data.ErrEventQueueFull):
+			// generators are best effort and don't retry, so a full queue only slows down the demo traffic
+			observ.PromGeneratedEvents.WithLabelValues(eventType, statusDropped).Inc()
+		default:
+			observ.PromGeneratedEvents.WithLabelValues(eventType, statusDropped).Inc()
+			g.logger.Error().Err(err).Str("event_type", eventType).Msg("failed to enqueue the generated event")
+		}
+	}
+}
+
+/*
+fields generates random type specific fields of the event
+*/
+func (g *Generator) fields(eventType string) *data.EventFields {
+	fields := &data.EventFields{}
+	switch eventType {
+	case data.EventTypeLog:
+		level := CmdGeneratorLogLevels[rand.Intn(len(CmdGeneratorLogLevels))]
+		message := CmdGeneratorLogMessages[rand.Intn(len(CmdGeneratorLogMessages))]
+		fields.Level, fields.Message = &level, &message
+	case data.EventTypeMetric:
+		value := CmdGeneratorMetricMin + rand.Float64()*(CmdGeneratorMetricMax-CmdGeneratorMetricMin)
+		fields.Value = &value
+	}
+	return fields
+}
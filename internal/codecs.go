@@ -0,0 +1,455 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// maximum size of the request bodies accepted by all the codecs
+	CmdMaxBodyBytes int64 = 1_048_576 // _ here is only for visual separator purpose and for int values go's compiler will ignore it.
+)
+
+/*
+BodyTooLargeError is returned when the request body exceeds the global limit or the limit of its event type
+*/
+type BodyTooLargeError struct {
+	Limit     int64
+	EventType string // empty if the global limit is exceeded before the event type is known
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+const (
+	ContentTypeJson    = "application/json"
+	ContentTypeMsgpack = "application/msgpack"
+	ContentTypeCbor    = "application/cbor"
+)
+
+/*
+Codec serializes the request and response bodies of a media type.
+Decode should reject the fields which cannot be mapped to the destination, same as the json decoding of the requests.
+*/
+type Codec interface {
+	ContentType() string
+	// Supports reports whether the value can be serialized by the codec
+	Supports(v interface{}) bool
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+var (
+	codecsMu     sync.RWMutex
+	codecs       = make(map[string]Codec)
+	contentTypes []string // content types of the registered codecs without their aliases
+)
+
+/*
+RegisterCodec adds the codec to the registry for its content type and the aliases. It panics if a media type is already registered.
+*/
+func RegisterCodec(codec Codec, aliases ...string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, mediaType := range append([]string{codec.ContentType()}, aliases...) {
+		if _, exists := codecs[mediaType]; exists {
+			panic(fmt.Sprintf("codec of %s is already registered", mediaType))
+		}
+		codecs[mediaType] = codec
+	}
+	contentTypes = append(contentTypes, codec.ContentType())
+}
+
+/*
+ContentTypes returns the sorted content types of all registered codecs
+*/
+func ContentTypes() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	types := append([]string(nil), contentTypes...)
+	sort.Strings(types)
+	return types
+}
+
+/*
+LookupCodec returns the codec registered for the media type
+*/
+func LookupCodec(mediaType string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, found := codecs[mediaType]
+	return codec, found
+}
+
+/*
+RequestCodec returns the codec of the request body based on its Content-Type.
+json codec is returned for the unknown or missing content types to stay compatible with the clients which don't set it.
+*/
+func RequestCodec(r *http.Request) Codec {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if codec, found := LookupCodec(mediaType); found {
+		return codec
+	}
+	return jsonCodecInstance
+}
+
+/*
+ResponseCodec negotiates the codec of the response based on the Accept header of the request.
+Media types are tried in order of their quality value and the first registered codec supporting v is returned.
+json codec is returned if none of the accepted media types can be used.
+*/
+func ResponseCodec(r *http.Request, v interface{}) Codec {
+	type acceptedType struct {
+		mediaType string
+		quality   float64
+	}
+	var accepted []acceptedType
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, found := params["q"]; found {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil || quality <= 0 {
+				continue
+			}
+		}
+		accepted = append(accepted, acceptedType{mediaType, quality})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+
+	for _, accept := range accepted {
+		if codec, found := LookupCodec(accept.mediaType); found && codec.Supports(v) {
+			return codec
+		}
+	}
+	return jsonCodecInstance
+}
+
+/*
+ReadRequest reads the request body with the codec of its Content-Type and deserialize it in the output
+*/
+func ReadRequest[T any](ctx context.Context, w http.ResponseWriter, r *http.Request) (T, error) {
+	var output, zero T
+	err := readBody(ctx, w, r, RequestCodec(r), &output)
+	if err != nil {
+		return zero, err
+	}
+	return output, nil
+}
+
+/*
+WriteResponse writes the data as response with the codec negotiated from the Accept header of the request
+*/
+func WriteResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, status int, data interface{}, headers http.Header) error {
+	return writeBody(ctx, w, nil, status, ResponseCodec(r, data), data, headers)
+}
+
+/*
+WriteConditionalResponse writes the data like WriteResponse along with a weak ETag of the encoded body.
+304 without the body is responded instead if the ETag matches the If-None-Match header of the request, so the clients polling the same data over and over only pay for the changes.
+*/
+func WriteConditionalResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, status int, data interface{}, headers http.Header) error {
+	return writeBody(ctx, w, r, status, ResponseCodec(r, data), data, headers)
+}
+
+/*
+weakETag derives the weak entity tag of the encoded body. The tag is weak since the body might be compressed on the way to the client.
+*/
+func weakETag(body []byte) string {
+	hash := fnv.New64a()
+	hash.Write(body)
+	return fmt.Sprintf(`W/"%016x"`, hash.Sum64())
+}
+
+/*
+etagMatches reports whether one of the entity tags of the If-None-Match header matches the etag using the weak comparison
+*/
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func readBody(ctx context.Context, w http.ResponseWriter, r *http.Request, codec Codec, dst interface{}) error {
+	_, span := otel.Tracer("ReadBody.Tracer").Start(ctx, "ReadBody.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("content_type", codec.ContentType()))
+	span.SetAttributes(attribute.Int64("max_bytes", CmdMaxBodyBytes))
+
+	// Limit the amount of bytes accepted as post request body
+	r.Body = http.MaxBytesReader(w, r.Body, CmdMaxBodyBytes)
+	err := codec.Decode(r.Body, dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = &BodyTooLargeError{Limit: maxBytesError.Limit}
+			span.SetAttributes(attribute.Int64("max_bytes_allowed", maxBytesError.Limit))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to read the body")
+		return err
+	}
+	span.SetStatus(codes.Ok, "successfully parsed the body")
+	return nil
+}
+
+// buffers used for encoding the responses to avoid allocating a new one per response
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+/*
+writeBody encodes the data with the codec and writes it as the response. The conditional request is only set when the response should carry an ETag.
+*/
+func writeBody(ctx context.Context, w http.ResponseWriter, conditional *http.Request, status int, codec Codec, data interface{}, headers http.Header) error {
+	_, span := otel.Tracer("WriteBody.Tracer").Start(ctx, "WriteBody.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("content_type", codec.ContentType()))
+
+	// considering bytes.Buffer instead of directly writing to the http.responseWriter to be able to segregate the error handling for serialization and write errors
+	nBuffer := bufferPool.Get().(*bytes.Buffer)
+	nBuffer.Reset()
+	defer bufferPool.Put(nBuffer)
+
+	err := codec.Encode(nBuffer, data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, fmt.Sprintf("failed to serialize data into %s format", codec.ContentType()))
+		return err
+	}
+	span.SetAttributes(attribute.Int("encoded_bytes", nBuffer.Len()))
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	if conditional != nil && status == http.StatusOK {
+		etag := weakETag(nBuffer.Bytes())
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept")
+		if ifNoneMatch := conditional.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			span.SetAttributes(attribute.Int("status_code", http.StatusNotModified))
+			span.SetStatus(codes.Ok, "response isn't modified")
+			return nil
+		}
+	}
+	w.WriteHeader(status)
+	span.SetAttributes(attribute.Int("status_code", status))
+
+	_, err = w.Write(nBuffer.Bytes())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write data as a response")
+		return err
+	}
+
+	span.SetStatus(codes.Ok, "successfully wrote response")
+	return nil
+}
+
+/*
+jsonCodec is the default codec of the api
+*/
+type jsonCodec struct{}
+
+var jsonCodecInstance = jsonCodec{}
+
+func (jsonCodec) ContentType() string         { return ContentTypeJson }
+func (jsonCodec) Supports(v interface{}) bool { return true }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
+	// before decoding. This means that if the JSON from the client now includes any
+	// field which cannot be mapped to the target destination, the decoder will return
+	// an error instead of just ignoring the field.
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return jsonDecodeError(err)
+	}
+
+	// by default decode method of json package will read json values one by one.
+	// If the request body only contained a single JSON value this will
+	// return an io.EOF error. So if we get anything else, we know that there is
+	// additional data in the request body and we return our own custom error message.
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errors.New("body must only contain a single json value")
+	}
+	return nil
+}
+
+/*
+jsonDecodeError converts the json decoding errors to the messages which can be returned to the clients
+*/
+func jsonDecodeError(err error) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	// This happens if we json syntax errors. having wrong commas or indentation or missing quotes
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed json (at character %d)", syntaxError.Offset)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+
+	// This will happen if we try to unmarshal a json value of a type to a struct field that doesn't support that specific type
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("invalid type used for the key %s", unmarshalTypeError.Field)
+		}
+		// if client provide completely different type of json. for example instead of json of object type it sends an array content json
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+
+	// If the JSON contains a field which cannot be mapped to the target destination
+	// then Decode() will now return an error message in the format "json: unknown
+	// field "<n>"". We check for this, extract the field name from the error,
+	// and interpolate it into our custom error message.
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
+		return fmt.Errorf("body contains unknown field %s", fieldName)
+
+	// If the request body exceeds the size limit the decode will fail with the MaxBytesError which is reported by the caller
+	case errors.As(err, &maxBytesError):
+		return err
+
+	// Error will happen if we pass invalid type to json.Decode function. we should always pass a pointer otherwise it will give us error
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	case errors.Is(err, io.EOF):
+		return errors.New("json body must not be empty")
+
+	default:
+		return err
+	}
+}
+
+/*
+msgpackCodec serializes the bodies in msgpack format using the json tags of the structs
+*/
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string         { return ContentTypeMsgpack }
+func (msgpackCodec) Supports(v interface{}) bool { return true }
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	err := dec.Decode(v)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return err
+		case errors.Is(err, io.EOF):
+			return errors.New("msgpack body must not be empty")
+		case strings.Contains(err.Error(), "unknown field"):
+			return fmt.Errorf("body contains unknown field %s", err.Error()[strings.LastIndex(err.Error(), " ")+1:])
+		default:
+			return fmt.Errorf("body contains badly-formed msgpack: %w", err)
+		}
+	}
+	return nil
+}
+
+/*
+cborCodec serializes the bodies in cbor format using the json tags of the structs
+*/
+type cborCodec struct {
+	encMode cbor.EncMode
+	decMode cbor.DecMode
+}
+
+func newCborCodec() *cborCodec {
+	encMode, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	decMode, err := cbor.DecOptions{
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+		DefaultMapType:    reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &cborCodec{encMode: encMode, decMode: decMode}
+}
+
+func (*cborCodec) ContentType() string         { return ContentTypeCbor }
+func (*cborCodec) Supports(v interface{}) bool { return true }
+
+func (c *cborCodec) Encode(w io.Writer, v interface{}) error {
+	return c.encMode.NewEncoder(w).Encode(v)
+}
+
+func (c *cborCodec) Decode(r io.Reader, v interface{}) error {
+	err := c.decMode.NewDecoder(r).Decode(v)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var unknownFieldError *cbor.UnknownFieldError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return err
+		case errors.Is(err, io.EOF):
+			return errors.New("cbor body must not be empty")
+		case errors.As(err, &unknownFieldError):
+			return errors.New("body contains unknown field")
+		default:
+			return fmt.Errorf("body contains badly-formed cbor: %w", err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCodec(jsonCodecInstance)
+	RegisterCodec(msgpackCodec{}, "application/x-msgpack", "application/vnd.msgpack")
+	RegisterCodec(newCborCodec())
+}
@@ -0,0 +1,168 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shorthands of the common cron expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cron expressions without any matching time within this horizon, e.g. 30th of february, are rejected
+const cronSearchHorizon = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 6}}
+
+/*
+CronSchedule is a parsed cron expression. The standard five fields are supported with the lists, ranges and steps,
+along with the @hourly like macros and @every <duration> for the intervals shorter than a minute.
+As in the standard cron, the day matches either the day of month or the day of week if both of them are restricted.
+*/
+type CronSchedule struct {
+	every  time.Duration
+	fields [5]uint64 // bitset of the allowed values of each field
+	domAny bool
+	dowAny bool
+}
+
+/*
+ParseCron parses the cron expression
+*/
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if every, found := strings.CutPrefix(expr, "@every "); found {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || interval < time.Second {
+			return nil, errors.New("@every should be followed by a duration of at least 1s")
+		}
+		return &CronSchedule{every: interval}, nil
+	}
+	if macro, found := cronMacros[expr]; found {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, errors.New("cron expression should have 5 fields: minute hour day-of-month month day-of-week")
+	}
+	cs := &CronSchedule{domAny: parts[2] == "*", dowAny: parts[4] == "*"}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		cs.fields[i] = bits
+	}
+	// 7 is also accepted as sunday
+	if cs.fields[4]&(1<<7) != 0 {
+		cs.fields[4] |= 1
+	}
+	if cs.Next(time.Now()).IsZero() {
+		return nil, errors.New("cron expression never matches")
+	}
+	return cs, nil
+}
+
+func parseCronField(part string, field cronField) (uint64, error) {
+	maxValue := field.max
+	if field.name == "day of week" {
+		maxValue = 7
+	}
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q of the %s field", stepPart, field.name)
+			}
+		}
+		low, high := field.min, maxValue
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			low, err = strconv.Atoi(lowPart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q of the %s field", lowPart, field.name)
+			}
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(highPart)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q of the %s field", highPart, field.name)
+				}
+			} else if hasStep {
+				high = maxValue
+			}
+		}
+		if low < field.min || high > maxValue || low > high {
+			return 0, fmt.Errorf("%s field should be between %d and %d", field.name, field.min, maxValue)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (cs *CronSchedule) matches(field int, value int) bool {
+	return cs.fields[field]&(1<<value) != 0
+}
+
+func (cs *CronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := cs.matches(2, t.Day()), cs.matches(4, int(t.Weekday()))
+	switch {
+	case cs.domAny && cs.dowAny:
+		return true
+	case cs.domAny:
+		return dow
+	case cs.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+/*
+Next returns the first time after t matching the schedule in the location of t, zero if there isn't any within the next five years
+*/
+func (cs *CronSchedule) Next(t time.Time) time.Time {
+	if cs.every > 0 {
+		return t.Truncate(time.Second).Add(cs.every)
+	}
+	loc := t.Location()
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	horizon := t.Add(cronSearchHorizon)
+	for next.Before(horizon) {
+		switch {
+		case !cs.matches(3, int(next.Month())):
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !cs.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, loc)
+		case !cs.matches(1, next.Hour()):
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, loc)
+		case !cs.matches(0, next.Minute()):
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// wednesday
+	from := time.Date(2026, 10, 14, 12, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2026, 10, 14, 12, 31, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2026, 10, 14, 12, 45, 0, 0, time.UTC)},
+		{expr: "0 * * * *", want: time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC)},
+		// the current minute has already started, so it's not matched again
+		{expr: "30 12 * * *", want: time.Date(2026, 10, 15, 12, 30, 0, 0, time.UTC)},
+		{expr: "10,40 8-18/2 * * *", want: time.Date(2026, 10, 14, 12, 40, 0, 0, time.UTC)},
+		{expr: "5 1-3 * * *", want: time.Date(2026, 10, 15, 1, 5, 0, 0, time.UTC)},
+		{expr: "@daily", want: time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 9 * * 1-5", want: time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)},
+		{expr: "@weekly", want: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", want: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 * *", want: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)},
+		// restricting both the day of month and the day of week matches either of them
+		{expr: "0 0 13 * 5", want: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 13 * *", want: time.Date(2026, 11, 13, 0, 0, 0, 0, time.UTC)},
+		{expr: "@yearly", want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "59 23 31 12 *", from: time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC), want: time.Date(2027, 12, 31, 23, 59, 0, 0, time.UTC)},
+		// the schedule is evaluated in the location of the time
+		{expr: "0 9 * * *", from: time.Date(2026, 10, 14, 8, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+			want: time.Date(2026, 10, 14, 9, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))},
+		{expr: "@every 90s", want: time.Date(2026, 10, 14, 12, 31, 45, 0, time.UTC)},
+		{expr: "@every 1m", from: time.Date(2026, 10, 14, 12, 30, 15, 500, time.UTC), want: time.Date(2026, 10, 14, 12, 31, 15, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cs, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := tt.from
+			if start.IsZero() {
+				start = from
+			}
+			if got := cs.Next(start); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", start, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@every 500ms",
+		"@every tomorrow",
+		"@fortnightly",
+		// never matches
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseCron(expr)
+			if err == nil {
+				t.Errorf("invalid cron expression %q is parsed", expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// size of the aes-256 keys read from the key files
+const KeySize = 32
+
+/*
+ReadKeyFile reads the raw 32 bytes aes-256 key of the file, or its hex or base64 encoding
+*/
+func ReadKeyFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == KeySize {
+		return content, nil
+	}
+	text := strings.TrimSpace(string(content))
+	if key, err := hex.DecodeString(text); err == nil {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("key should be raw bytes, hex or base64 encoded")
+}
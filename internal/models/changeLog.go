@@ -0,0 +1,353 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdChangeLogFile             string
+	CmdChangeLogKeyFile          string
+	CmdChangeLogMaxRecords       int
+	CmdChangeLogMaxPreviousBytes int
+)
+
+var (
+	ErrChangeRecordNotFound = errors.New("change record not found")
+	ErrChangeRolledBack     = errors.New("change is already rolled back")
+)
+
+// action of the change records created by rolling back another change
+const ChangeActionRollback = "rollback"
+
+/*
+ChangeRecord is a versioned record of an admin mutation along with the previous value required to roll it back
+*/
+type ChangeRecord struct {
+	Version      int64           `json:"version"`
+	Target       string          `json:"target"`
+	Action       string          `json:"action"`
+	Actor        string          `json:"actor"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Count        int             `json:"count"`                    // number of items affected by the change
+	Previous     json.RawMessage `json:"previous,omitempty"`       // value before the change, e.g. the soft deleted items of a purge
+	RollbackOf   int64           `json:"rollback_of,omitempty"`    // version of the change rolled back by this change
+	RolledBackBy int64           `json:"rolled_back_by,omitempty"` // version of the change rolled back this change
+}
+
+/*
+changeLogLine is a change record as it's persisted in the change log file. The previous value is only persisted encrypted by the change log key.
+*/
+type changeLogLine struct {
+	*ChangeRecord
+	Previous       json.RawMessage `json:"previous,omitempty"`        // shadows the plaintext previous value of the record
+	SealedPrevious []byte          `json:"sealed_previous,omitempty"` // nonce followed by the aes-256-gcm ciphertext of the previous value
+}
+
+/*
+ChangeLog keeps the change records of the admin mutations. Records are appended to the change log file to survive the restarts
+and kept in memory to be queried and rolled back.
+Only the newest maxRecords records are retained and the previous values are dropped from the oldest records once they take more than maxPreviousBytes,
+those changes can't be rolled back anymore. The file is compacted to the retained records once as many records are appended.
+*/
+type ChangeLog struct {
+	mu               sync.RWMutex
+	path             string
+	aead             cipher.AEAD // nil if the previous values aren't persisted
+	maxRecords       int
+	maxPreviousBytes int
+	previousBytes    int
+	appended         int             // records appended to the file since it's compacted
+	records          []*ChangeRecord // ordered by version
+}
+
+/*
+NewChangeLog loads the change records persisted in the file. Records are only kept in memory if path is empty.
+*/
+func NewChangeLog(path string) (*ChangeLog, error) {
+	cl := &ChangeLog{path: path, maxRecords: CmdChangeLogMaxRecords, maxPreviousBytes: CmdChangeLogMaxPreviousBytes}
+	if CmdChangeLogKeyFile != "" {
+		key, err := helpers.ReadKeyFile(CmdChangeLogKeyFile)
+		if err == nil && len(key) != helpers.KeySize {
+			err = fmt.Errorf("key of the admin change log should be %d bytes, got %d", helpers.KeySize, len(key))
+		}
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		cl.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path == "" {
+		return cl, nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cl, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	// records are decoded one by one, so the size of a single record isn't limited by a line buffer
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for n := 1; ; n++ {
+		line := &changeLogLine{ChangeRecord: &ChangeRecord{}}
+		err := decoder.Decode(line)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// the last record might be partially written by a crash
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid change record %d of %s: %w", n, path, err)
+		}
+		// previous values which can't be decrypted, e.g. after rotating the key, are dropped and their changes can't be rolled back
+		line.ChangeRecord.Previous, _ = cl.open(line.Version, line.SealedPrevious)
+		cl.apply(line.ChangeRecord)
+	}
+	err = file.Chmod(0600)
+	if err != nil {
+		return nil, err
+	}
+	// the file is rewritten once on startup, which drops the records out of the retention and the previous values persisted in plaintext by the older versions
+	return cl, cl.rewrite()
+}
+
+/*
+apply adds the record to the in memory records, marks the rolled back record and enforces the retention. cl.mu should be held by the caller.
+*/
+func (cl *ChangeLog) apply(record *ChangeRecord) {
+	if record.RollbackOf != 0 {
+		if rolledBack := cl.find(record.RollbackOf); rolledBack != nil {
+			rolledBack.RolledBackBy = record.Version
+		}
+	}
+	cl.records = append(cl.records, record)
+	cl.previousBytes += len(record.Previous)
+
+	if cl.maxRecords > 0 && len(cl.records) > cl.maxRecords {
+		for _, dropped := range cl.records[:len(cl.records)-cl.maxRecords] {
+			cl.previousBytes -= len(dropped.Previous)
+		}
+		clear(cl.records[:len(cl.records)-cl.maxRecords])
+		cl.records = cl.records[len(cl.records)-cl.maxRecords:]
+	}
+	for i := 0; cl.maxPreviousBytes > 0 && cl.previousBytes > cl.maxPreviousBytes && i < len(cl.records); i++ {
+		cl.previousBytes -= len(cl.records[i].Previous)
+		cl.records[i].Previous = nil
+	}
+}
+
+func (cl *ChangeLog) find(version int64) *ChangeRecord {
+	for _, record := range cl.records {
+		if record.Version == version {
+			return record
+		}
+	}
+	return nil
+}
+
+/*
+seal encrypts the previous value of the record bound to its version, it returns nil if the previous values aren't persisted
+*/
+func (cl *ChangeLog) seal(version int64, previous []byte) ([]byte, error) {
+	if cl.aead == nil || len(previous) == 0 {
+		return nil, nil
+	}
+	nonce := make([]byte, cl.aead.NonceSize(), cl.aead.NonceSize()+len(previous)+cl.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return cl.aead.Seal(nonce, nonce, previous, binary.BigEndian.AppendUint64(nil, uint64(version))), nil
+}
+
+func (cl *ChangeLog) open(version int64, sealed []byte) ([]byte, error) {
+	if cl.aead == nil || len(sealed) < cl.aead.NonceSize() {
+		return nil, nil
+	}
+	nonceSize := cl.aead.NonceSize()
+	return cl.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+/*
+encode returns the line of the record persisted in the change log file
+*/
+func (cl *ChangeLog) encode(record *ChangeRecord) ([]byte, error) {
+	sealed, err := cl.seal(record.Version, record.Previous)
+	if err != nil {
+		return nil, err
+	}
+	jRecord, err := json.Marshal(&changeLogLine{ChangeRecord: record, SealedPrevious: sealed})
+	if err != nil {
+		return nil, err
+	}
+	return append(jRecord, '\n'), nil
+}
+
+/*
+compact rewrites the change log file with the retained records once maxRecords records are appended since the last compaction.
+cl.mu should be held by the caller.
+*/
+func (cl *ChangeLog) compact() error {
+	if cl.path == "" || cl.maxRecords <= 0 || cl.appended < cl.maxRecords {
+		return nil
+	}
+	return cl.rewrite()
+}
+
+/*
+rewrite replaces the change log file with the retained records
+*/
+func (cl *ChangeLog) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(cl.path), filepath.Base(cl.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	writer := bufio.NewWriter(tmp)
+	for _, record := range cl.records {
+		line, err := cl.encode(record)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), cl.path)
+	if err != nil {
+		return err
+	}
+	cl.appended = 0
+	return nil
+}
+
+/*
+Record assigns the next version to the record and persists it.
+Previous values larger than maxPreviousBytes aren't kept, so the change can't be rolled back.
+*/
+func (cl *ChangeLog) Record(ctx context.Context, record *ChangeRecord) error {
+	_, span := otel.Tracer("ChangeLog.Record.Tracer").Start(ctx, "ChangeLog.Record.Span")
+	defer span.End()
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if record.RollbackOf != 0 {
+		rolledBack := cl.find(record.RollbackOf)
+		if rolledBack == nil {
+			return ErrChangeRecordNotFound
+		}
+		if rolledBack.RolledBackBy != 0 {
+			return ErrChangeRolledBack
+		}
+	}
+	if cl.maxPreviousBytes > 0 && len(record.Previous) > cl.maxPreviousBytes {
+		record.Previous = nil
+	}
+
+	record.Version = 1
+	if len(cl.records) > 0 {
+		record.Version = cl.records[len(cl.records)-1].Version + 1
+	}
+	record.CreatedAt = time.Now()
+	span.SetAttributes(attribute.Int64("change.version", record.Version), attribute.String("change.target", record.Target))
+
+	if cl.path != "" {
+		line, err := cl.encode(record)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		file, err := os.OpenFile(cl.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		defer file.Close()
+		_, err = file.Write(line)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		cl.appended++
+	}
+
+	cl.apply(record)
+	err := cl.compact()
+	if err != nil {
+		// the record is already appended, so it's only the compaction which is retried by the next record
+		span.RecordError(err)
+	}
+	return nil
+}
+
+/*
+Get returns a copy of the change record with the version
+*/
+func (cl *ChangeLog) Get(version int64) (*ChangeRecord, bool) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	record := cl.find(version)
+	if record == nil {
+		return nil, false
+	}
+	recordCopy := *record
+	return &recordCopy, true
+}
+
+/*
+List returns the change records of the target from the newest to the oldest without their previous values. All the records are returned for an empty target.
+*/
+func (cl *ChangeLog) List(target string) []ChangeRecord {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	records := make([]ChangeRecord, 0, len(cl.records))
+	for i := len(cl.records) - 1; i >= 0; i-- {
+		if target != "" && cl.records[i].Target != target {
+			continue
+		}
+		record := *cl.records[i]
+		record.Previous = nil
+		records = append(records, record)
+	}
+	return records
+}
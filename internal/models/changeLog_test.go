@@ -0,0 +1,187 @@
+package data
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+setTestChangeLogFlags configures the change log flags and restores them at the end of the test
+*/
+func setTestChangeLogFlags(t *testing.T, keyFile string, maxRecords int, maxPreviousBytes int) {
+	t.Helper()
+	CmdChangeLogKeyFile, CmdChangeLogMaxRecords, CmdChangeLogMaxPreviousBytes = keyFile, maxRecords, maxPreviousBytes
+	t.Cleanup(func() {
+		CmdChangeLogKeyFile, CmdChangeLogMaxRecords, CmdChangeLogMaxPreviousBytes = "", 0, 0
+	})
+}
+
+func writeTestKey(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "change-log.key")
+	err := os.WriteFile(path, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func recordTestChange(t *testing.T, cl *ChangeLog, previous string) *ChangeRecord {
+	t.Helper()
+	record := &ChangeRecord{Target: "queue", Action: "purge", Actor: "admin", Count: 1}
+	if previous != "" {
+		record.Previous = json.RawMessage(previous)
+	}
+	err := cl.Record(t.Context(), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestChangeLogPersistence(t *testing.T) {
+	secret := `[{"payload":"customer-secret"}]`
+	tests := []struct {
+		name         string
+		encrypted    bool
+		wantPrevious bool
+	}{
+		{name: "previous values aren't persisted without the key", encrypted: false, wantPrevious: false},
+		{name: "previous values are persisted encrypted by the key", encrypted: true, wantPrevious: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile := ""
+			if tt.encrypted {
+				keyFile = writeTestKey(t)
+			}
+			setTestChangeLogFlags(t, keyFile, 0, 0)
+			path := filepath.Join(t.TempDir(), "state", "changes.jsonl")
+			cl, err := NewChangeLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordTestChange(t, cl, secret)
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(content, []byte("customer-secret")) {
+				t.Errorf("previous value is persisted in plaintext: %s", content)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("change log permissions = %o, want 600", info.Mode().Perm())
+			}
+
+			reloaded, err := NewChangeLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, found := reloaded.Get(1)
+			if !found {
+				t.Fatal("change record isn't reloaded")
+			}
+			if got := string(record.Previous) == secret; got != tt.wantPrevious {
+				t.Errorf("previous value reloaded = %v, want %v: %s", got, tt.wantPrevious, record.Previous)
+			}
+		})
+	}
+}
+
+func TestChangeLogRetention(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxRecords       int
+		maxPreviousBytes int
+		changes          int
+		wantRecords      int
+		wantRollbackable int // newest records keeping their previous value
+	}{
+		{name: "unlimited", changes: 5, wantRecords: 5, wantRollbackable: 5},
+		{name: "records are capped", maxRecords: 3, changes: 5, wantRecords: 3, wantRollbackable: 3},
+		{name: "previous values of the oldest records are dropped", maxPreviousBytes: 25, changes: 5, wantRecords: 5, wantRollbackable: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestChangeLogFlags(t, writeTestKey(t), tt.maxRecords, tt.maxPreviousBytes)
+			path := filepath.Join(t.TempDir(), "changes.jsonl")
+			cl, err := NewChangeLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.changes; i++ {
+				recordTestChange(t, cl, fmt.Sprintf(`["item-%d"]`, i)) // 10 bytes each
+			}
+			for _, log := range []*ChangeLog{cl, mustReloadChangeLog(t, path)} {
+				records := log.List("")
+				if len(records) != tt.wantRecords {
+					t.Fatalf("records = %d, want %d", len(records), tt.wantRecords)
+				}
+				if records[0].Version != int64(tt.changes) {
+					t.Errorf("newest version = %d, want %d", records[0].Version, tt.changes)
+				}
+				rollbackable := 0
+				for _, r := range records {
+					if record, _ := log.Get(r.Version); len(record.Previous) > 0 {
+						rollbackable++
+					}
+				}
+				if rollbackable != tt.wantRollbackable {
+					t.Errorf("records keeping the previous value = %d, want %d", rollbackable, tt.wantRollbackable)
+				}
+			}
+		})
+	}
+}
+
+func mustReloadChangeLog(t *testing.T, path string) *ChangeLog {
+	t.Helper()
+	cl, err := NewChangeLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+func TestChangeLogLargeAndPartialRecords(t *testing.T) {
+	setTestChangeLogFlags(t, writeTestKey(t), 0, 0)
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	cl, err := NewChangeLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// larger than the default buffer of a line scanner
+	large := `["` + string(bytes.Repeat([]byte("x"), 4<<20)) + `"]`
+	recordTestChange(t, cl, large)
+
+	// a crash in the middle of appending a record leaves a partial line behind
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteString(`{"version":2,"target":"que`)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := mustReloadChangeLog(t, path)
+	record, found := reloaded.Get(1)
+	if !found || string(record.Previous) != large {
+		t.Fatal("large change record isn't reloaded")
+	}
+	recordTestChange(t, reloaded, "")
+	if records := mustReloadChangeLog(t, path).List(""); len(records) != 2 {
+		t.Errorf("records after appending to the recovered file = %d, want 2", len(records))
+	}
+}
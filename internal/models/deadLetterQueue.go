@@ -0,0 +1,271 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdDeadLetterQueueSize int64
+)
+
+/*
+DeadLetter is an event which its processing is failed permanently along with the failure information
+*/
+type DeadLetter struct {
+	Seq      uint64 // insertion sequence of the dead letter, used to paginate the dead letters
+	Event    Event
+	Reason   string
+	Error    string
+	Attempts int
+	FailedAt time.Time
+	Poison   bool // quarantined since the event repeatedly crashed or timed out the processor
+}
+
+/*
+DeadLetterQueue keeps the permanently failed events. When the queue is full the oldest dead letter will be dropped.
+*/
+type DeadLetterQueue struct {
+	Capacity int64
+	mu       sync.RWMutex
+	seq      uint64
+	letters  []*DeadLetter // ordered from oldest to newest
+}
+
+func NewDeadLetterQueue() *DeadLetterQueue {
+	return &DeadLetterQueue{
+		Capacity: CmdDeadLetterQueueSize,
+		letters:  make([]*DeadLetter, 0, CmdDeadLetterQueueSize),
+	}
+}
+
+/*
+PutDeadLetter adds a failed event to the dead letter queue. It returns true if the oldest dead letter is dropped to make room
+*/
+func (dlq *DeadLetterQueue) PutDeadLetter(ctx context.Context, letter *DeadLetter) bool {
+	_, span := otel.Tracer("DeadLetterQueue.PutDeadLetter.Tracer").Start(ctx, "DeadLetterQueue.PutDeadLetter.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", letter.Event.GetEventID()))
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+
+	if dlq.Capacity <= 0 {
+		return true
+	}
+
+	dropped := false
+	if int64(len(dlq.letters)) >= dlq.Capacity {
+		dlq.letters = dlq.letters[1:]
+		dropped = true
+	}
+	dlq.seq++
+	letter.Seq = dlq.seq
+	dlq.letters = append(dlq.letters, letter)
+	return dropped
+}
+
+/*
+Size function will get the number of events inside the dead letter queue
+*/
+func (dlq *DeadLetterQueue) Size(ctx context.Context) int {
+	dlq.mu.RLock()
+	defer dlq.mu.RUnlock()
+	return len(dlq.letters)
+}
+
+/*
+Purge removes all the dead letters and returns the removed ones from the oldest to the newest
+*/
+func (dlq *DeadLetterQueue) Purge(ctx context.Context) []*DeadLetter {
+	_, span := otel.Tracer("DeadLetterQueue.Purge.Tracer").Start(ctx, "DeadLetterQueue.Purge.Span")
+	defer span.End()
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	purged := dlq.letters
+	dlq.letters = make([]*DeadLetter, 0, dlq.Capacity)
+	span.SetAttributes(attribute.Int("dlq.purged", len(purged)))
+	return purged
+}
+
+/*
+Restore puts the dead letters back in the order of their insertion sequence. Restored letters are dropped first if the queue doesn't have enough capacity.
+It returns the number of restored dead letters.
+*/
+func (dlq *DeadLetterQueue) Restore(ctx context.Context, letters []*DeadLetter) int {
+	_, span := otel.Tracer("DeadLetterQueue.Restore.Tracer").Start(ctx, "DeadLetterQueue.Restore.Span")
+	defer span.End()
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	room := int(dlq.Capacity) - len(dlq.letters)
+	if room < 0 {
+		room = 0
+	}
+	if len(letters) > room {
+		letters = letters[len(letters)-room:]
+	}
+	dlq.letters = append(append(make([]*DeadLetter, 0, dlq.Capacity), letters...), dlq.letters...)
+	// restored letters are older than the current ones unless they're deleted one by one, keeping the order is required by the pagination
+	sort.SliceStable(dlq.letters, func(i, j int) bool { return dlq.letters[i].Seq < dlq.letters[j].Seq })
+	span.SetAttributes(attribute.Int("dlq.restored", len(letters)))
+	return len(letters)
+}
+
+/*
+DeadLetterFilter filters the listed dead letters. Cursor is the sequence of the last dead letter of the previous page.
+*/
+type DeadLetterFilter struct {
+	Reason    string
+	EventType string
+	Poison    bool // only the poison dead letters
+	Limit     int
+	Cursor    uint64
+}
+
+/*
+List returns the dead letters matching the filter from the newest to the oldest, along with the cursor of the next page which is 0 if there are no more dead letters
+*/
+func (dlq *DeadLetterQueue) List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, uint64) {
+	_, span := otel.Tracer("DeadLetterQueue.List.Tracer").Start(ctx, "DeadLetterQueue.List.Span")
+	defer span.End()
+
+	dlq.mu.RLock()
+	defer dlq.mu.RUnlock()
+
+	letters := make([]*DeadLetter, 0, filter.Limit)
+	for i := len(dlq.letters) - 1; i >= 0; i-- {
+		letter := dlq.letters[i]
+		switch {
+		case filter.Cursor != 0 && letter.Seq >= filter.Cursor:
+			continue
+		case filter.Reason != "" && letter.Reason != filter.Reason:
+			continue
+		case filter.EventType != "" && letter.Event.GetEventType() != filter.EventType:
+			continue
+		case filter.Poison && !letter.Poison:
+			continue
+		}
+		if len(letters) == filter.Limit {
+			return letters, letters[len(letters)-1].Seq
+		}
+		letters = append(letters, letter)
+	}
+	return letters, 0
+}
+
+/*
+Get returns the newest dead letter of the event
+*/
+func (dlq *DeadLetterQueue) Get(ctx context.Context, eventID string) (*DeadLetter, bool) {
+	dlq.mu.RLock()
+	defer dlq.mu.RUnlock()
+	for i := len(dlq.letters) - 1; i >= 0; i-- {
+		if dlq.letters[i].Event.GetEventID() == eventID {
+			return dlq.letters[i], true
+		}
+	}
+	return nil, false
+}
+
+/*
+Delete removes the newest dead letter of the event and returns it
+*/
+func (dlq *DeadLetterQueue) Delete(ctx context.Context, eventID string) (*DeadLetter, bool) {
+	_, span := otel.Tracer("DeadLetterQueue.Delete.Tracer").Start(ctx, "DeadLetterQueue.Delete.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	for i := len(dlq.letters) - 1; i >= 0; i-- {
+		if letter := dlq.letters[i]; letter.Event.GetEventID() == eventID {
+			dlq.letters = append(dlq.letters[:i], dlq.letters[i+1:]...)
+			return letter, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Take removes the dead letters matching the reason and event type of the filter from the oldest to the newest, up to the limit of the filter if it's positive.
+It returns the removed dead letters along with the number of the matching dead letters left in the queue.
+*/
+func (dlq *DeadLetterQueue) Take(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, int) {
+	_, span := otel.Tracer("DeadLetterQueue.Take.Tracer").Start(ctx, "DeadLetterQueue.Take.Span")
+	defer span.End()
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	taken := make([]*DeadLetter, 0)
+	kept := make([]*DeadLetter, 0, dlq.Capacity)
+	remaining := 0
+	for _, letter := range dlq.letters {
+		matched := (filter.Reason == "" || letter.Reason == filter.Reason) &&
+			(filter.EventType == "" || letter.Event.GetEventType() == filter.EventType) &&
+			(!filter.Poison || letter.Poison)
+		switch {
+		case !matched:
+			kept = append(kept, letter)
+		case filter.Limit > 0 && len(taken) >= filter.Limit:
+			kept = append(kept, letter)
+			remaining++
+		default:
+			taken = append(taken, letter)
+		}
+	}
+	dlq.letters = kept
+	span.SetAttributes(attribute.Int("dlq.taken", len(taken)), attribute.Int("dlq.remaining", remaining))
+	return taken, remaining
+}
+
+/*
+DeadLetterStats is the aggregation of the dead letter queue contents
+*/
+type DeadLetterStats struct {
+	Total       int            `json:"total"`
+	Poison      int            `json:"poison"`
+	ByReason    map[string]int `json:"by_reason"`
+	ByEventType map[string]int `json:"by_event_type"`
+	ByProducer  map[string]int `json:"by_producer"`
+	OldestAt    *time.Time     `json:"oldest_failed_at,omitempty"`
+	NewestAt    *time.Time     `json:"newest_failed_at,omitempty"`
+}
+
+/*
+Stats aggregates the dead letters by failure reason, event type and producer
+*/
+func (dlq *DeadLetterQueue) Stats(ctx context.Context) *DeadLetterStats {
+	_, span := otel.Tracer("DeadLetterQueue.Stats.Tracer").Start(ctx, "DeadLetterQueue.Stats.Span")
+	defer span.End()
+
+	dlq.mu.RLock()
+	defer dlq.mu.RUnlock()
+
+	stats := &DeadLetterStats{
+		Total:       len(dlq.letters),
+		ByReason:    make(map[string]int),
+		ByEventType: make(map[string]int),
+		ByProducer:  make(map[string]int),
+	}
+	for _, letter := range dlq.letters {
+		stats.ByReason[letter.Reason]++
+		stats.ByEventType[letter.Event.GetEventType()]++
+		stats.ByProducer[letter.Event.GetProducer()]++
+		if letter.Poison {
+			stats.Poison++
+		}
+	}
+	if len(dlq.letters) > 0 {
+		stats.OldestAt = &dlq.letters[0].FailedAt
+		stats.NewestAt = &dlq.letters[len(dlq.letters)-1].FailedAt
+	}
+	span.SetAttributes(attribute.Int("dlq.size", stats.Total))
+	return stats
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// amount of time the status of a completed batch is kept to be queried
+const batchStatusRetention = 10 * time.Minute
+
+var ErrEmptyBatch = errors.New("batch should contain at least one event")
+
+/*
+EventBatch wraps multiple events sharing the same batch id which are enqueued all together or none of them
+*/
+type EventBatch struct {
+	BatchID string
+	Events  []Event
+}
+
+/*
+NewEventBatch creates a new batch of the events and sets the batch id on all of them
+*/
+func NewEventBatch(batchID string, events []Event) *EventBatch {
+	for _, event := range events {
+		event.SetBatchID(batchID)
+	}
+	return &EventBatch{
+		BatchID: batchID,
+		Events:  events,
+	}
+}
+
+/*
+BatchStatus reports the processing progress of a batch
+*/
+type BatchStatus struct {
+	BatchID     string     `json:"batch_id"`
+	Total       int        `json:"total"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Skipped     int        `json:"skipped"`
+	EnqueuedAt  time.Time  `json:"enqueued_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+/*
+Pending returns the number of events of the batch which are not processed yet
+*/
+func (b BatchStatus) Pending() int {
+	return b.Total - b.Succeeded - b.Failed - b.Skipped
+}
+
+/*
+PutBatch adds all the events of the batch to the queue atomically.
+None of the events are enqueued if the queue doesn't have enough capacity for all of them or any of them is a duplicate.
+*/
+func (eq *EventQueue) PutBatch(ctx context.Context, batch *EventBatch) error {
+	_, span := otel.Tracer("EventQueue.PutBatch.Tracer").Start(ctx, "EventQueue.PutBatch.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("batch.id", batch.BatchID), attribute.Int("batch.size", len(batch.Events)))
+
+	if len(batch.Events) == 0 {
+		return ErrEmptyBatch
+	}
+	// the deadline of the client might already be exceeded while reading and validating the batch
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if eq.Size(ctx)+len(batch.Events) > int(eq.Capacity) {
+		return ErrEventQueueFull
+	}
+
+	// reserving all the event ids, the reserved ones are released if any of them is a duplicate
+	reserved := make([]string, 0, len(batch.Events))
+	release := func() {
+		for _, eventID := range reserved {
+			eq.dedup.release(eventID)
+		}
+	}
+	seen := make(map[string]struct{}, len(batch.Events))
+	for _, event := range batch.Events {
+		if _, inBatch := seen[event.GetEventID()]; inBatch {
+			release()
+			span.AddEvent("duplicate event rejected")
+			return &DuplicateEventError{EventID: event.GetEventID()}
+		}
+		if receivedAt, ok := eq.dedup.reserve(event.GetEventID()); !ok {
+			release()
+			span.AddEvent("duplicate event rejected")
+			return &DuplicateEventError{EventID: event.GetEventID(), ReceivedAt: receivedAt}
+		}
+		seen[event.GetEventID()] = struct{}{}
+		reserved = append(reserved, event.GetEventID())
+	}
+
+	enqueueTime := time.Now()
+	cEvents := make([]Event, 0, len(batch.Events))
+	for _, event := range batch.Events {
+		event.SetEnqueueTime(enqueueTime)
+		cEvent, err := CompressEvent(event, CmdEventQueueCompression, CmdEventQueueCompressionMinBytes)
+		if err != nil {
+			span.RecordError(err)
+			release()
+			return err
+		}
+		cEvents = append(cEvents, cEvent)
+	}
+
+	err := eq.push(cEvents, &BatchStatus{
+		BatchID:    batch.BatchID,
+		Total:      len(cEvents),
+		EnqueuedAt: enqueueTime,
+	})
+	if err != nil {
+		release()
+		return err
+	}
+	eq.Lifetime.Ingested(batch.Events)
+	return nil
+}
+
+/*
+BatchStatus returns a copy of the current status of the batch
+*/
+func (eq *EventQueue) BatchStatus(batchID string) (*BatchStatus, bool) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	status, found := eq.batches[batchID]
+	if !found {
+		return nil, false
+	}
+	statusCopy := *status
+	return &statusCopy, true
+}
+
+/*
+forgetCompletedBatches removes the status of the batches completed before the retention period. eq.mu should be held by the caller.
+*/
+func (eq *EventQueue) forgetCompletedBatches() {
+	now := time.Now()
+	for batchID, status := range eq.batches {
+		if status.CompletedAt != nil && now.Sub(*status.CompletedAt) > batchStatusRetention {
+			delete(eq.batches, batchID)
+		}
+	}
+}
+
+/*
+recordBatchOutcome updates the status of the batch the processed event belongs to.
+It returns a copy of the batch status if the batch is completed with this event. eq.mu should be held by the caller.
+*/
+func (eq *EventQueue) recordBatchOutcome(processed *ProcessedEvent) *BatchStatus {
+	status, found := eq.batches[processed.Event.GetBatchID()]
+	if !found || status.CompletedAt != nil {
+		return nil
+	}
+	switch processed.Status {
+	case EventProcessStatusSuccess:
+		status.Succeeded++
+	case EventProcessStatusFailed:
+		status.Failed++
+	default:
+		status.Skipped++
+	}
+	if status.Pending() > 0 {
+		return nil
+	}
+	now := time.Now()
+	status.CompletedAt = &now
+	statusCopy := *status
+	return &statusCopy
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	CmdEventQueueCompression         string
+	CmdEventQueueCompressionMinBytes int
+)
+
+// compression algorithms supported for the events payload inside the queue
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+/*
+CompressedEvent holds the compressed payload of an event while it's waiting inside the queue.
+It keeps a copy of the common fields so the event can still be identified without decompression.
+*/
+type CompressedEvent struct {
+	*BaseEvent
+	Compression string
+	Payload     []byte
+	eventType   reflect.Type
+}
+
+/*
+GetMetadata returns metadata of the original event. It requires decompression of the payload
+*/
+func (c CompressedEvent) GetMetadata() map[string]interface{} {
+	event, err := DecompressEvent(&c)
+	if err != nil {
+		return c.GetCommonMetadata()
+	}
+	return event.GetMetadata()
+}
+
+/*
+CompressEvent serializes and compresses the event with the specified algorithm.
+Events which their serialized size is less than minBytes are returned untouched since compressing them is not worth it.
+*/
+func CompressEvent(event Event, compression string, minBytes int) (Event, error) {
+	if compression == CompressionNone || compression == "" {
+		return event, nil
+	}
+
+	jEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if len(jEvent) < minBytes {
+		return event, nil
+	}
+
+	var payload []byte
+	switch compression {
+	case CompressionSnappy:
+		payload = snappy.Encode(nil, jEvent)
+	case CompressionZstd:
+		payload = zstdEncoder.EncodeAll(jEvent, make([]byte, 0, len(jEvent)))
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %s", compression)
+	}
+
+	return &CompressedEvent{
+		BaseEvent: &BaseEvent{
+			EventID:       event.GetEventID(),
+			EventType:     event.GetEventType(),
+			Timestamp:     event.GetTimestamp(),
+			Producer:      event.GetProducer(),
+			Priority:      event.GetPriority(),
+			SchemaVersion: event.GetSchemaVersion(),
+			BatchID:       event.GetBatchID(),
+			CorrelationID: event.GetCorrelationID(),
+			ParentEventID: event.GetParentEventID(),
+			DeliverAt:     event.GetDeliverAt(),
+			CallbackURL:   event.GetCallbackURL(),
+			Retention:     event.GetRetention(),
+			LegalHold:     event.GetLegalHold(),
+			EnqueueTime:   event.GetEnqueueTime(),
+		},
+		Compression: compression,
+		Payload:     payload,
+		eventType:   reflect.TypeOf(event).Elem(),
+	}, nil
+}
+
+/*
+DecompressEvent returns the original event if the event is compressed otherwise returns the event itself
+*/
+func DecompressEvent(event Event) (Event, error) {
+	cEvent, ok := event.(*CompressedEvent)
+	if !ok {
+		return event, nil
+	}
+
+	var jEvent []byte
+	var err error
+	switch cEvent.Compression {
+	case CompressionSnappy:
+		jEvent, err = snappy.Decode(nil, cEvent.Payload)
+	case CompressionZstd:
+		jEvent, err = zstdDecoder.DecodeAll(cEvent.Payload, nil)
+	default:
+		err = fmt.Errorf("unsupported compression algorithm %s", cEvent.Compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	original := reflect.New(cEvent.eventType)
+	err = json.Unmarshal(jEvent, original.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return original.Interface().(Event), nil
+}
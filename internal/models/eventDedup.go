@@ -0,0 +1,108 @@
+package data
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	CmdEventDedupWindow time.Duration
+	CmdEventDedupSize   int
+)
+
+var ErrDuplicateEvent = errors.New("an event with the same event_id is already received")
+
+/*
+DuplicateEventError reports which event is a duplicate. It matches ErrDuplicateEvent with errors.Is
+*/
+type DuplicateEventError struct {
+	EventID    string
+	ReceivedAt time.Time // when the original event is received, zero if the duplicate is inside the same batch
+}
+
+func (e *DuplicateEventError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrDuplicateEvent, e.EventID)
+}
+
+func (e *DuplicateEventError) Unwrap() error {
+	return ErrDuplicateEvent
+}
+
+/*
+eventDeduplicator remembers the recently seen event ids to reject the events which are sent again by the retrying clients.
+Event ids are forgotten after the window elapses or when the number of remembered ids exceeds the size, whichever comes first.
+*/
+type eventDeduplicator struct {
+	window time.Duration
+	size   int
+	mu     sync.Mutex
+	seen   map[string]*list.Element
+	order  *list.List // seen event ids ordered from the oldest to newest
+}
+
+type seenEvent struct {
+	eventID string
+	seenAt  time.Time
+}
+
+/*
+newEventDeduplicator returns nil if either window or size is zero which disables the deduplication
+*/
+func newEventDeduplicator(window time.Duration, size int) *eventDeduplicator {
+	if window <= 0 || size <= 0 {
+		return nil
+	}
+	return &eventDeduplicator{
+		window: window,
+		size:   size,
+		seen:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+/*
+reserve records the event id and reports whether it was not seen inside the window.
+It returns the time the event id was seen at if it's a duplicate.
+*/
+func (d *eventDeduplicator) reserve(eventID string) (time.Time, bool) {
+	if d == nil {
+		return time.Time{}, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for oldest := d.order.Front(); oldest != nil && now.Sub(oldest.Value.(seenEvent).seenAt) > d.window; oldest = d.order.Front() {
+		d.order.Remove(oldest)
+		delete(d.seen, oldest.Value.(seenEvent).eventID)
+	}
+
+	if element, found := d.seen[eventID]; found {
+		return element.Value.(seenEvent).seenAt, false
+	}
+	d.seen[eventID] = d.order.PushBack(seenEvent{eventID: eventID, seenAt: now})
+	if d.order.Len() > d.size {
+		oldest := d.order.Front()
+		d.order.Remove(oldest)
+		delete(d.seen, oldest.Value.(seenEvent).eventID)
+	}
+	return time.Time{}, true
+}
+
+/*
+release forgets the reserved event id in case the event couldn't be added to the queue, so the client can retry it
+*/
+func (d *eventDeduplicator) release(eventID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if element, found := d.seen[eventID]; found {
+		d.order.Remove(element)
+		delete(d.seen, eventID)
+	}
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+var (
+	CmdEventIndexSize int
+)
+
+// status of the indexed events which are not processed yet, processed events have their process status
+const (
+	EventIndexStatusQueued     = "queued"
+	EventIndexStatusProcessing = "processing"
+)
+
+/*
+EventIndexEntry is the summary of a queued or recently processed event kept by the event index
+*/
+type EventIndexEntry struct {
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Producer      string     `json:"producer,omitempty"`
+	Priority      int        `json:"priority"`
+	BatchID       string     `json:"batch_id,omitempty"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	EnqueuedAt    time.Time  `json:"enqueued_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	seq           uint64
+}
+
+/*
+EventIndexFilter selects the entries returned by the event index. Empty fields don't filter the entries.
+Cursor is the sequence of the last entry of the previous page and Limit is the maximum number of returned entries.
+*/
+type EventIndexFilter struct {
+	EventType string
+	Status    string
+	Since     time.Time
+	Until     time.Time
+	Cursor    uint64
+	Limit     int
+}
+
+func (f *EventIndexFilter) matches(entry *EventIndexEntry) bool {
+	switch {
+	case f.EventType != "" && entry.EventType != f.EventType:
+		return false
+	case f.Status != "" && entry.Status != f.Status:
+		return false
+	case !f.Since.IsZero() && entry.EnqueuedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.EnqueuedAt.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+/*
+EventIndex keeps the summary of the most recently enqueued events along with their processing status to be listed by the clients.
+Entries are ordered by their enqueue sequence and the oldest entries are forgotten when the number of entries exceeds the size.
+*/
+type EventIndex struct {
+	size    int
+	seq     uint64
+	mu      sync.RWMutex
+	entries map[string]*list.Element
+	order   *list.List // entries ordered from the oldest to newest enqueued
+}
+
+/*
+NewEventIndex returns nil if size is zero which disables the event index
+*/
+func NewEventIndex(size int) *EventIndex {
+	if size <= 0 {
+		return nil
+	}
+	return &EventIndex{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+/*
+Queued records the event added to the queue. Events enqueued again, e.g. by restoring a purge, are moved to the newest position.
+*/
+func (idx *EventIndex) Queued(event Event) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if element, found := idx.entries[event.GetEventID()]; found {
+		idx.order.Remove(element)
+	}
+	idx.seq++
+	idx.entries[event.GetEventID()] = idx.order.PushBack(&EventIndexEntry{
+		EventID:       event.GetEventID(),
+		EventType:     event.GetEventType(),
+		Producer:      event.GetProducer(),
+		Priority:      event.GetPriority(),
+		BatchID:       event.GetBatchID(),
+		CorrelationID: event.GetCorrelationID(),
+		Status:        EventIndexStatusQueued,
+		EnqueuedAt:    event.GetEnqueueTime(),
+		seq:           idx.seq,
+	})
+	if idx.order.Len() > idx.size {
+		oldest := idx.order.Front()
+		idx.order.Remove(oldest)
+		delete(idx.entries, oldest.Value.(*EventIndexEntry).EventID)
+	}
+}
+
+/*
+Processing records the event taken out of the queue by the worker
+*/
+func (idx *EventIndex) Processing(event Event) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if element, found := idx.entries[event.GetEventID()]; found {
+		entry := element.Value.(*EventIndexEntry)
+		now := time.Now()
+		entry.Status = EventIndexStatusProcessing
+		entry.StartedAt = &now
+	}
+}
+
+/*
+Processed records the processing outcome of the event
+*/
+func (idx *EventIndex) Processed(processed *ProcessedEvent) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if element, found := idx.entries[processed.Event.GetEventID()]; found {
+		entry := element.Value.(*EventIndexEntry)
+		now := time.Now()
+		entry.Status = processed.Status
+		entry.ProcessedAt = &now
+		entry.Error = ""
+		if processed.Err != nil {
+			entry.Error = processed.Err.Error()
+		}
+	}
+}
+
+/*
+List returns the entries matching the filter from the newest to the oldest enqueued event.
+The returned cursor should be used to get the next page and it's zero if there are no more matching entries.
+*/
+func (idx *EventIndex) List(filter EventIndexFilter) ([]EventIndexEntry, uint64) {
+	if idx == nil {
+		return []EventIndexEntry{}, 0
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	entries := make([]EventIndexEntry, 0, filter.Limit)
+	for element := idx.order.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*EventIndexEntry)
+		if filter.Cursor != 0 && entry.seq >= filter.Cursor {
+			continue
+		}
+		if !filter.matches(entry) {
+			continue
+		}
+		if len(entries) == filter.Limit {
+			return entries, entries[len(entries)-1].seq
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, 0
+}
+
+/*
+Len returns the number of the indexed events
+*/
+func (idx *EventIndex) Len() int {
+	if idx == nil {
+		return 0
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.order.Len()
+}
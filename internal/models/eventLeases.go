@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdEventLeaseTimeout time.Duration
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease isn't found, it's either acknowledged or expired")
+)
+
+/*
+EventLease is an event pulled out of the queue by an external consumer instead of the worker.
+The consumer should acknowledge the event before the lease expires, otherwise the event is put back into the queue to be delivered again.
+*/
+type EventLease struct {
+	ID        string
+	Consumer  string
+	Delivery  int // number of times the event is delivered including this lease
+	LeasedAt  time.Time
+	ExpiresAt time.Time
+	Event     Event // decompressed event
+}
+
+/*
+Pushed returns a channel which is closed once the next events are added to the queue.
+Unlike the Ready channel all the receivers are woken up, so the consumers pulling the events can wait on it without taking the signals of the worker.
+*/
+func (eq *EventQueue) Pushed() <-chan struct{} {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.pushed
+}
+
+/*
+Lease registers the lease of the event taken out of the queue by GetEvent for the consumer.
+The event stays in flight until its lease is released or expired.
+*/
+func (eq *EventQueue) Lease(ctx context.Context, event Event, consumer string, timeout time.Duration) *EventLease {
+	_, span := otel.Tracer("EventQueue.Lease.Tracer").Start(ctx, "EventQueue.Lease.Span")
+	defer span.End()
+
+	now := time.Now()
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	eq.deliveries[event.GetEventID()]++
+	lease := &EventLease{
+		ID:        uuid.New().String(),
+		Consumer:  consumer,
+		Delivery:  eq.deliveries[event.GetEventID()],
+		LeasedAt:  now,
+		ExpiresAt: now.Add(timeout),
+		Event:     event,
+	}
+	eq.leases[lease.ID] = lease
+	span.SetAttributes(attribute.String("lease.id", lease.ID), attribute.Int("lease.delivery", lease.Delivery))
+	return lease
+}
+
+/*
+Release removes the lease once its event is acknowledged by the consumer. It returns ErrLeaseNotFound if the lease is already released or expired.
+The event isn't in flight anymore if it's going to be restored into the queue for the redelivery.
+*/
+func (eq *EventQueue) Release(ctx context.Context, leaseID string, requeue bool) (*EventLease, error) {
+	_, span := otel.Tracer("EventQueue.Release.Tracer").Start(ctx, "EventQueue.Release.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("lease.id", leaseID))
+
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	lease, found := eq.leases[leaseID]
+	if !found || time.Now().After(lease.ExpiresAt) {
+		return nil, ErrLeaseNotFound
+	}
+	delete(eq.leases, leaseID)
+	if requeue {
+		delete(eq.inFlight, lease.Event.GetEventID())
+	}
+	return lease, nil
+}
+
+/*
+ExpireLeases removes the leases expired before now and returns them ordered by their expiry, so their events can be restored into the queue
+*/
+func (eq *EventQueue) ExpireLeases(now time.Time) []*EventLease {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	var expired []*EventLease
+	for id, lease := range eq.leases {
+		if now.After(lease.ExpiresAt) {
+			expired = append(expired, lease)
+			delete(eq.leases, id)
+			delete(eq.inFlight, lease.Event.GetEventID())
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ExpiresAt.Before(expired[j].ExpiresAt) })
+	return expired
+}
+
+/*
+DropLeases removes all the leases, e.g. during the shutdown when the consumers can't acknowledge their events anymore
+*/
+func (eq *EventQueue) DropLeases() []*EventLease {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	leases := make([]*EventLease, 0, len(eq.leases))
+	for _, lease := range eq.leases {
+		leases = append(leases, lease)
+	}
+	eq.leases = make(map[string]*EventLease)
+	eq.deliveries = make(map[string]int)
+	return leases
+}
+
+/*
+Leased returns the number of events leased by the consumers and not acknowledged yet
+*/
+func (eq *EventQueue) Leased() int {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return len(eq.leases)
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// schema version of the event payloads sent by the producers before the versioning was introduced
+const EventSchemaVersionInitial = 1
+
+/*
+EventMigration upgrades the raw payload of an event from a schema version to the next one.
+It's applied on the decoded json object of the event before decoding it into the event fields,
+so it can rename, remove or convert the fields which don't exist in the current version anymore.
+*/
+type EventMigration func(payload map[string]json.RawMessage) error
+
+var (
+	eventMigrationsMu sync.RWMutex
+	eventMigrations   = make(map[string]map[int]EventMigration) // event type -> from version -> migration
+)
+
+/*
+RegisterEventMigration adds the migration upgrading the payload of the event type from fromVersion to fromVersion+1.
+It panics if a migration is already registered for the same version of the event type.
+Bumping SchemaVersion of an EventTypeSpec requires registering the migrations of all the versions before it.
+*/
+func RegisterEventMigration(eventType string, fromVersion int, migration EventMigration) {
+	eventMigrationsMu.Lock()
+	defer eventMigrationsMu.Unlock()
+	if eventMigrations[eventType] == nil {
+		eventMigrations[eventType] = make(map[int]EventMigration)
+	}
+	if _, exists := eventMigrations[eventType][fromVersion]; exists {
+		panic(fmt.Sprintf("migration of event type %s from version %d is already registered", eventType, fromVersion))
+	}
+	eventMigrations[eventType][fromVersion] = migration
+}
+
+/*
+MigratePayload upgrades the raw payload of an event to the current schema version of the event type.
+Payloads without schema_version are considered to be in the initial version.
+schema_version of the payload is set to the current version after a successful migration.
+*/
+func (s *EventTypeSpec) MigratePayload(payload map[string]json.RawMessage) error {
+	version := EventSchemaVersionInitial
+	if rawVersion, found := payload["schema_version"]; found {
+		err := json.Unmarshal(rawVersion, &version)
+		if err != nil {
+			return fmt.Errorf("invalid type used for the key schema_version")
+		}
+	}
+	if version < EventSchemaVersionInitial || version > s.SchemaVersion {
+		return fmt.Errorf("unsupported schema_version %d for event type %s", version, s.Name)
+	}
+
+	eventMigrationsMu.RLock()
+	defer eventMigrationsMu.RUnlock()
+	for ; version < s.SchemaVersion; version++ {
+		migration, found := eventMigrations[s.Name][version]
+		if !found {
+			return fmt.Errorf("no migration found for event type %s from schema_version %d", s.Name, version)
+		}
+		err := migration(payload)
+		if err != nil {
+			return fmt.Errorf("failed to migrate event type %s from schema_version %d: %w", s.Name, version, err)
+		}
+	}
+
+	payload["schema_version"], _ = json.Marshal(s.SchemaVersion)
+	return nil
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+)
+
+/*
+EventSnapshot is the serializable form of an event which can be restored back into the event later,
+e.g. to keep the soft deleted events of a purge for rolling it back.
+*/
+type EventSnapshot struct {
+	EventType     string        `json:"event_type"`
+	EventID       string        `json:"event_id"`
+	Timestamp     time.Time     `json:"timestamp"`
+	Producer      string        `json:"producer,omitempty"`
+	Priority      int           `json:"priority"`
+	SchemaVersion int           `json:"schema_version"`
+	BatchID       string        `json:"batch_id,omitempty"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	ParentEventID string        `json:"parent_event_id,omitempty"`
+	DeliverAt     *time.Time    `json:"deliver_at,omitempty"`
+	CallbackURL   string        `json:"callback_url,omitempty"`
+	Retention     time.Duration `json:"retention,omitempty"`
+	LegalHold     bool          `json:"legal_hold,omitempty"`
+	EnqueueTime   time.Time     `json:"enqueue_time"`
+	Fields        EventFields   `json:"fields"`
+}
+
+/*
+NewEventSnapshot takes a snapshot of the event. Compressed events are decompressed first.
+*/
+func NewEventSnapshot(event Event) (*EventSnapshot, error) {
+	event, err := DecompressEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	// type specific fields of the metadata have the same name as the json name of the EventFields
+	jMeta, err := json.Marshal(event.GetMetadata())
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &EventSnapshot{
+		EventType:     event.GetEventType(),
+		EventID:       event.GetEventID(),
+		Timestamp:     event.GetTimestamp(),
+		Producer:      event.GetProducer(),
+		Priority:      event.GetPriority(),
+		SchemaVersion: event.GetSchemaVersion(),
+		BatchID:       event.GetBatchID(),
+		CorrelationID: event.GetCorrelationID(),
+		ParentEventID: event.GetParentEventID(),
+		CallbackURL:   event.GetCallbackURL(),
+		Retention:     event.GetRetention(),
+		LegalHold:     event.GetLegalHold(),
+		EnqueueTime:   event.GetEnqueueTime(),
+	}
+	if deliverAt := event.GetDeliverAt(); !deliverAt.IsZero() {
+		snapshot.DeliverAt = &deliverAt
+	}
+	err = json.Unmarshal(jMeta, &snapshot.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+/*
+Restore constructs the event out of the snapshot. It returns an error if the event type isn't registered anymore or the fields aren't valid for it.
+*/
+func (s *EventSnapshot) Restore() (Event, error) {
+	spec, found := LookupEventType(s.EventType)
+	if !found {
+		return nil, fmt.Errorf("unknown event type %s", s.EventType)
+	}
+	fields := s.Fields
+	nVal := helpers.NewValidator()
+	spec.ValidateFields(nVal, &fields)
+	if !nVal.Valid() {
+		return nil, fmt.Errorf("invalid snapshot of the event %s: %v", s.EventID, nVal.Errors)
+	}
+
+	event := spec.New(s.EventID, &fields)
+	event.SetTimestamp(s.Timestamp)
+	event.SetProducer(s.Producer)
+	event.SetPriority(s.Priority)
+	event.SetBatchID(s.BatchID)
+	event.SetCorrelationID(s.CorrelationID)
+	event.SetParentEventID(s.ParentEventID)
+	event.SetCallbackURL(s.CallbackURL)
+	event.SetRetention(s.Retention)
+	event.SetLegalHold(s.LegalHold)
+	event.SetEnqueueTime(s.EnqueueTime)
+	if s.DeliverAt != nil {
+		event.SetDeliverAt(*s.DeliverAt)
+	}
+	return event, nil
+}
+
+/*
+DeadLetterSnapshot is the serializable form of a dead letter
+*/
+type DeadLetterSnapshot struct {
+	Seq      uint64         `json:"seq,omitempty"`
+	Event    *EventSnapshot `json:"event"`
+	Reason   string         `json:"reason"`
+	Error    string         `json:"error"`
+	Attempts int            `json:"attempts"`
+	FailedAt time.Time      `json:"failed_at"`
+	Poison   bool           `json:"poison,omitempty"`
+}
+
+/*
+NewDeadLetterSnapshot takes a snapshot of the dead letter
+*/
+func NewDeadLetterSnapshot(letter *DeadLetter) (*DeadLetterSnapshot, error) {
+	event, err := NewEventSnapshot(letter.Event)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterSnapshot{
+		Seq:      letter.Seq,
+		Event:    event,
+		Reason:   letter.Reason,
+		Error:    letter.Error,
+		Attempts: letter.Attempts,
+		FailedAt: letter.FailedAt,
+		Poison:   letter.Poison,
+	}, nil
+}
+
+/*
+Restore constructs the dead letter out of the snapshot
+*/
+func (s *DeadLetterSnapshot) Restore() (*DeadLetter, error) {
+	event, err := s.Event.Restore()
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{
+		Seq:      s.Seq,
+		Event:    event,
+		Reason:   s.Reason,
+		Error:    s.Error,
+		Attempts: s.Attempts,
+		FailedAt: s.FailedAt,
+		Poison:   s.Poison,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	CmdEventDelayTick time.Duration
+	CmdEventMaxDelay  time.Duration
+)
+
+// number of the slots of the timer wheel, events further than a full revolution wait for more rounds inside their slot
+const timerWheelSlots = 512
+
+type timerWheelEntry struct {
+	event     Event
+	deliverAt time.Time
+	rounds    int // full revolutions of the wheel left before the event is due
+}
+
+/*
+timerWheel holds the delayed events until they're due. Each slot covers a tick, so scheduling and advancing the wheel
+don't depend on the number of the held events and the events are delivered at most a tick after their delivery time.
+*/
+type timerWheel struct {
+	tick   time.Duration
+	slots  [][]timerWheelEntry
+	cursor int
+	next   time.Time // time the slot at the cursor is due, only meaningful while the wheel holds events
+	count  int
+}
+
+func newTimerWheel(tick time.Duration) *timerWheel {
+	return &timerWheel{tick: tick, slots: make([][]timerWheelEntry, timerWheelSlots)}
+}
+
+/*
+add schedules the event in the slot of the first tick after its delivery time
+*/
+func (tw *timerWheel) add(event Event, deliverAt time.Time, now time.Time) {
+	if tw.count == 0 {
+		tw.next = now.Add(tw.tick)
+	}
+	ticks := 0
+	if wait := deliverAt.Sub(tw.next); wait > 0 {
+		ticks = int((wait + tw.tick - 1) / tw.tick)
+	}
+	slot := (tw.cursor + ticks) % timerWheelSlots
+	tw.slots[slot] = append(tw.slots[slot], timerWheelEntry{event: event, deliverAt: deliverAt, rounds: ticks / timerWheelSlots})
+	tw.count++
+}
+
+/*
+advance moves the cursor over the slots due by now and returns their events in the order of their delivery time
+*/
+func (tw *timerWheel) advance(now time.Time) []Event {
+	var due []timerWheelEntry
+	for tw.count > 0 && !tw.next.After(now) {
+		kept := tw.slots[tw.cursor][:0]
+		for _, entry := range tw.slots[tw.cursor] {
+			if entry.rounds > 0 {
+				entry.rounds--
+				kept = append(kept, entry)
+				continue
+			}
+			due = append(due, entry)
+			tw.count--
+		}
+		clear(tw.slots[tw.cursor][len(kept):])
+		tw.slots[tw.cursor] = kept
+		tw.cursor = (tw.cursor + 1) % timerWheelSlots
+		tw.next = tw.next.Add(tw.tick)
+	}
+	return entryEvents(due)
+}
+
+/*
+find returns the event with the id held by the wheel, nil if it isn't held
+*/
+func (tw *timerWheel) find(eventID string) Event {
+	for _, slot := range tw.slots {
+		for _, entry := range slot {
+			if entry.event.GetEventID() == eventID {
+				return entry.event
+			}
+		}
+	}
+	return nil
+}
+
+/*
+nextDelivery returns the earliest delivery time of the held events, zero if the wheel is empty
+*/
+func (tw *timerWheel) nextDelivery() time.Time {
+	var earliest time.Time
+	for _, slot := range tw.slots {
+		for _, entry := range slot {
+			if earliest.IsZero() || entry.deliverAt.Before(earliest) {
+				earliest = entry.deliverAt
+			}
+		}
+	}
+	return earliest
+}
+
+/*
+purge removes all the held events and returns them in the order of their delivery time
+*/
+func (tw *timerWheel) purge() []Event {
+	var purged []timerWheelEntry
+	for i := range tw.slots {
+		purged = append(purged, tw.slots[i]...)
+		tw.slots[i] = nil
+	}
+	tw.count = 0
+	return entryEvents(purged)
+}
+
+func entryEvents(entries []timerWheelEntry) []Event {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].deliverAt.Before(entries[j].deliverAt) })
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, entry.event)
+	}
+	return events
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+)
+
+/*
+EventFields holds all the type specific fields an event can carry in the request body.
+Each event type specifies which of these fields are allowed for it.
+*/
+type EventFields struct {
+	Value    *float64 `json:"value,omitempty"`
+	Level    *string  `json:"level,omitempty"`
+	Message  *string  `json:"message,omitempty"`
+	TraceID  *string  `json:"trace_id,omitempty"`
+	SpanID   *string  `json:"span_id,omitempty"`
+	Duration *float64 `json:"duration,omitempty"`
+	Service  *string  `json:"service,omitempty"`
+	Actor    *string  `json:"actor,omitempty"`
+	Action   *string  `json:"action,omitempty"`
+	Resource *string  `json:"resource,omitempty"`
+	Outcome  *string  `json:"outcome,omitempty"`
+}
+
+/*
+EventTypeSpec holds everything required to accept a new type of event.
+Registering a spec makes the event type acceptable by the api without any further change on the api or worker.
+*/
+type EventTypeSpec struct {
+	Name          string                                          // value of event_type field identifying the event type
+	SchemaVersion int                                             // current schema version of the event payload, defaults to EventSchemaVersionInitial
+	Fields        []string                                        // json name of the type specific fields allowed and required for the event type
+	MaxBodyBytes  int64                                           // maximum size of the request body carrying the event type, 0 uses the global limit
+	Validate      func(v *helpers.Validator, fields *EventFields) // type specific validation rules of the field values, optional
+	New           func(eventID string, fields *EventFields) Event // constructs the event after a successful validation
+}
+
+var (
+	CmdEventTypeMaxBodyBytes map[string]int64
+)
+
+var (
+	eventTypesMu sync.RWMutex
+	eventTypes   = make(map[string]*EventTypeSpec)
+)
+
+/*
+RegisterEventType adds a new event type to the registry.
+It panics if the event type is already registered or any of its fields is not a field of EventFields.
+*/
+func RegisterEventType(spec *EventTypeSpec) {
+	eventTypesMu.Lock()
+	defer eventTypesMu.Unlock()
+	if _, exists := eventTypes[spec.Name]; exists {
+		panic(fmt.Sprintf("event type %s is already registered", spec.Name))
+	}
+	names := EventFieldNames()
+	for _, field := range spec.Fields {
+		if !helpers.In(field, names...) {
+			panic(fmt.Sprintf("event type %s has the unknown field %s", spec.Name, field))
+		}
+	}
+	if spec.SchemaVersion == 0 {
+		spec.SchemaVersion = EventSchemaVersionInitial
+	}
+	eventTypes[spec.Name] = spec
+}
+
+/*
+LookupEventType returns the spec of the registered event type
+*/
+func LookupEventType(name string) (*EventTypeSpec, bool) {
+	eventTypesMu.RLock()
+	defer eventTypesMu.RUnlock()
+	spec, found := eventTypes[name]
+	return spec, found
+}
+
+/*
+EventTypes returns the sorted name of all registered event types
+*/
+func EventTypes() []string {
+	eventTypesMu.RLock()
+	defer eventTypesMu.RUnlock()
+	names := make([]string, 0, len(eventTypes))
+	for name := range eventTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+EventFieldNames returns the json name of all type specific fields an event can carry
+*/
+func EventFieldNames() []string {
+	fTypes := reflect.TypeOf(EventFields{})
+	names := make([]string, 0, fTypes.NumField())
+	for i := 0; i < fTypes.NumField(); i++ {
+		names = append(names, strings.Split(fTypes.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+/*
+CheckFields returns an error if any field which is not allowed for the event type is set
+*/
+func (s *EventTypeSpec) CheckFields(fields *EventFields) error {
+	fValues := reflect.ValueOf(fields).Elem()
+	fTypes := fValues.Type()
+	for i := 0; i < fValues.NumField(); i++ {
+		if fValues.Field(i).IsNil() {
+			continue
+		}
+		name := strings.Split(fTypes.Field(i).Tag.Get("json"), ",")[0]
+		if !helpers.In(name, s.Fields...) {
+			return fmt.Errorf("body contains unknown field \"%s\"", name)
+		}
+	}
+	return nil
+}
+
+/*
+ValidateFields checks all the fields of the event type are set and then runs the type specific validation rules of the spec.
+The required fields are derived from the Fields of the spec, so they're not repeated in the Validate function.
+*/
+func (s *EventTypeSpec) ValidateFields(v *helpers.Validator, fields *EventFields) {
+	fValues := reflect.ValueOf(fields).Elem()
+	fTypes := fValues.Type()
+	for i := 0; i < fValues.NumField(); i++ {
+		name := strings.Split(fTypes.Field(i).Tag.Get("json"), ",")[0]
+		if helpers.In(name, s.Fields...) {
+			v.Check(!fValues.Field(i).IsNil(), name, "shouldn't be nil")
+		}
+	}
+	if s.Validate != nil {
+		s.Validate(v, fields)
+	}
+}
+
+/*
+BodyLimit returns the maximum size of the request body carrying the event type.
+Limit configured by the operator takes precedence over the one specified on the spec and 0 means only the global limit applies.
+*/
+func (s *EventTypeSpec) BodyLimit() int64 {
+	if limit, found := CmdEventTypeMaxBodyBytes[s.Name]; found {
+		return limit
+	}
+	return s.MaxBodyBytes
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+)
+
+func TestEventTypeSpecValidateFields(t *testing.T) {
+	level, message, value := "info", "hello", 1.5
+	traceID, spanID, duration, service := strings.Repeat("a", 32), strings.Repeat("b", 16), 0.25, "checkout"
+	emptyService := ""
+	tests := []struct {
+		name       string
+		eventType  string
+		fields     EventFields
+		wantErrors []string
+	}{
+		{name: "log with all fields", eventType: EventTypeLog, fields: EventFields{Level: &level, Message: &message}},
+		{name: "log without message", eventType: EventTypeLog, fields: EventFields{Level: &level}, wantErrors: []string{"message"}},
+		{name: "metric with all fields", eventType: EventTypeMetric, fields: EventFields{Value: &value}},
+		{name: "metric without value", eventType: EventTypeMetric, wantErrors: []string{"value"}},
+		{name: "trace with all fields", eventType: EventTypeTrace, fields: EventFields{TraceID: &traceID, SpanID: &spanID, Duration: &duration, Service: &service}},
+		{name: "trace without fields", eventType: EventTypeTrace, wantErrors: []string{"trace_id", "span_id", "duration", "service"}},
+		{name: "trace with invalid service", eventType: EventTypeTrace,
+			fields:     EventFields{TraceID: &traceID, SpanID: &spanID, Duration: &duration, Service: &emptyService},
+			wantErrors: []string{"service"}},
+		{name: "audit without fields", eventType: EventTypeAudit, wantErrors: []string{"actor", "action", "resource", "outcome"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, found := LookupEventType(tt.eventType)
+			if !found {
+				t.Fatalf("event type %s is not registered", tt.eventType)
+			}
+			nVal := helpers.NewValidator()
+			spec.ValidateFields(nVal, &tt.fields)
+			if len(nVal.Errors) != len(tt.wantErrors) {
+				t.Fatalf("errors = %v, want the errors of %v", nVal.Errors, tt.wantErrors)
+			}
+			for _, key := range tt.wantErrors {
+				if _, found := nVal.Errors[key]; !found {
+					t.Errorf("errors = %v, want the error of %s", nVal.Errors, key)
+				}
+			}
+		})
+	}
+}
+
+func TestEventTypeFieldsAreEventFields(t *testing.T) {
+	// every field of EventFields is a field of some event type, and the fields of the event types are checked while registering them
+	used := make(map[string]bool)
+	for _, name := range EventTypes() {
+		spec, _ := LookupEventType(name)
+		for _, field := range spec.Fields {
+			used[field] = true
+		}
+	}
+	for _, field := range EventFieldNames() {
+		if !used[field] {
+			t.Errorf("field %s of EventFields is not used by any event type", field)
+		}
+	}
+	if n := reflect.TypeOf(EventFields{}).NumField(); len(used) != n {
+		t.Errorf("event types use %d fields, want the %d fields of EventFields", len(used), n)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("event type with an unknown field is registered")
+		}
+	}()
+	RegisterEventType(&EventTypeSpec{Name: "test-unknown-field", Fields: []string{"unknown"}})
+}
@@ -1,13 +1,31 @@
 package data
 
 import (
-	"fmt"
+	"strings"
 	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
 )
 
 const (
 	EventTypeMetric = "metric"
 	EventTypeLog    = "log"
+	EventTypeTrace  = "trace"
+	EventTypeAudit  = "audit"
+)
+
+// possible outcomes of the action recorded by an audit event
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeUnknown = "unknown"
+)
+
+const (
+	EventProcessStatusSuccess   = "success"
+	EventProcessStatusFailed    = "failed"
+	EventProcessStatusSkipped   = "skipped"
+	EventProcessStatusCancelled = "cancelled"
 )
 
 /*
@@ -17,27 +35,77 @@ type Event interface {
 	GetMetadata() map[string]interface{}
 	GetCommonMetadata() map[string]interface{}
 	GetEventID() string
+	GetEventType() string
+	GetEnqueueTime() time.Time
+	SetEnqueueTime(t time.Time)
+	SetThreadID(id int)
+	GetTimestamp() time.Time
+	SetTimestamp(t time.Time)
+	GetProducer() string
+	SetProducer(producer string)
+	GetPriority() int
+	SetPriority(priority int)
+	GetSchemaVersion() int
+	GetBatchID() string
+	SetBatchID(batchID string)
+	GetCorrelationID() string
+	SetCorrelationID(correlationID string)
+	GetParentEventID() string
+	SetParentEventID(parentEventID string)
+	GetDeliverAt() time.Time
+	SetDeliverAt(t time.Time)
+	GetCallbackURL() string
+	SetCallbackURL(callbackURL string)
+	GetRetention() time.Duration
+	SetRetention(retention time.Duration)
+	GetLegalHold() bool
+	SetLegalHold(legalHold bool)
 }
 
+// range of the priorities accepted for the events. Events with higher priority are processed first
+const (
+	EventPriorityMin     = 0
+	EventPriorityMax     = 9
+	EventPriorityDefault = EventPriorityMin
+)
+
 /*
 BaseEvent implements common functionality for all events
 */
 type BaseEvent struct {
-	EventID     string
-	Timestamp   string
-	ThreadID    int
-	EnqueueTime time.Time // Time when the event was added to the queue
+	EventID       string
+	EventType     string
+	Timestamp     time.Time // Time the event is happened, serialized in RFC3339 format with nanoseconds
+	ThreadID      int
+	Producer      string        // Identity of the client produced the event
+	Priority      int           // Processing priority of the event between EventPriorityMin and EventPriorityMax
+	SchemaVersion int           // Schema version of the event payload
+	BatchID       string        // Id of the batch the event is enqueued with, empty for the events enqueued individually
+	CorrelationID string        // Id shared by all the related events, e.g. the events of the same business transaction
+	ParentEventID string        // Id of the event caused this event
+	DeliverAt     time.Time     // Time the event is handed to the worker at, zero if it's delivered as soon as it's enqueued
+	CallbackURL   string        // Url the processing outcome of the event is posted to, empty if the producer doesn't need it
+	Retention     time.Duration // How long the results of the event are kept, zero if the retention of the results store applies
+	LegalHold     bool          // Results of the event are kept regardless of their retention, e.g. while they're subject to a litigation
+	EnqueueTime   time.Time     // Time when the event was added to the queue
 }
 
 /*
-NewBaseEvent creates a new BaseEvent with the given event ID
+NewBaseEvent creates a new BaseEvent with the given event ID and event type
 */
-func NewBaseEvent(eventID string) *BaseEvent {
+func NewBaseEvent(eventID string, eventType string) *BaseEvent {
+	schemaVersion := EventSchemaVersionInitial
+	if spec, found := LookupEventType(eventType); found {
+		schemaVersion = spec.SchemaVersion
+	}
 	return &BaseEvent{
-		EventID:     eventID,
-		Timestamp:   time.Now().Format("2006-01-02 15:04:05"),
-		ThreadID:    0,
-		EnqueueTime: time.Time{}, // Will be set when added to queue
+		EventID:       eventID,
+		EventType:     eventType,
+		Timestamp:     time.Now().UTC(),
+		ThreadID:      0,
+		Priority:      EventPriorityDefault,
+		SchemaVersion: schemaVersion,
+		EnqueueTime:   time.Time{}, // Will be set when added to queue
 	}
 }
 
@@ -48,16 +116,215 @@ func (b BaseEvent) GetEventID() string {
 	return b.EventID
 }
 
+/*
+GetEventType returns the event type
+*/
+func (b BaseEvent) GetEventType() string {
+	return b.EventType
+}
+
+/*
+GetEnqueueTime returns the time event was added to the queue
+*/
+func (b BaseEvent) GetEnqueueTime() time.Time {
+	return b.EnqueueTime
+}
+
+/*
+SetEnqueueTime sets the time event was added to the queue
+*/
+func (b *BaseEvent) SetEnqueueTime(t time.Time) {
+	b.EnqueueTime = t
+}
+
+/*
+SetThreadID sets the id of the goroutine processing the event
+*/
+func (b *BaseEvent) SetThreadID(id int) {
+	b.ThreadID = id
+}
+
+/*
+GetTimestamp returns the time event is happened
+*/
+func (b BaseEvent) GetTimestamp() time.Time {
+	return b.Timestamp
+}
+
+/*
+SetTimestamp overrides the event timestamp with the time event is actually happened on the client side
+*/
+func (b *BaseEvent) SetTimestamp(t time.Time) {
+	b.Timestamp = t
+}
+
+/*
+GetProducer returns the identity of the client produced the event
+*/
+func (b BaseEvent) GetProducer() string {
+	return b.Producer
+}
+
+/*
+SetProducer sets the identity of the client produced the event
+*/
+func (b *BaseEvent) SetProducer(producer string) {
+	b.Producer = producer
+}
+
+/*
+GetPriority returns the processing priority of the event
+*/
+func (b BaseEvent) GetPriority() int {
+	return b.Priority
+}
+
+/*
+SetPriority sets the processing priority of the event
+*/
+func (b *BaseEvent) SetPriority(priority int) {
+	b.Priority = priority
+}
+
+/*
+GetSchemaVersion returns the schema version of the event payload
+*/
+func (b BaseEvent) GetSchemaVersion() int {
+	return b.SchemaVersion
+}
+
+/*
+GetBatchID returns the id of the batch the event belongs to
+*/
+func (b BaseEvent) GetBatchID() string {
+	return b.BatchID
+}
+
+/*
+SetBatchID sets the id of the batch the event belongs to
+*/
+func (b *BaseEvent) SetBatchID(batchID string) {
+	b.BatchID = batchID
+}
+
+/*
+GetCorrelationID returns the id shared by all the events related to this event
+*/
+func (b BaseEvent) GetCorrelationID() string {
+	return b.CorrelationID
+}
+
+/*
+SetCorrelationID sets the id shared by all the events related to this event
+*/
+func (b *BaseEvent) SetCorrelationID(correlationID string) {
+	b.CorrelationID = correlationID
+}
+
+/*
+GetParentEventID returns the id of the event caused this event
+*/
+func (b BaseEvent) GetParentEventID() string {
+	return b.ParentEventID
+}
+
+/*
+SetParentEventID sets the id of the event caused this event
+*/
+func (b *BaseEvent) SetParentEventID(parentEventID string) {
+	b.ParentEventID = parentEventID
+}
+
+/*
+GetDeliverAt returns the time the event is handed to the worker at, zero if it isn't delayed
+*/
+func (b BaseEvent) GetDeliverAt() time.Time {
+	return b.DeliverAt
+}
+
+/*
+SetDeliverAt delays the event inside the queue until the time
+*/
+func (b *BaseEvent) SetDeliverAt(t time.Time) {
+	b.DeliverAt = t
+}
+
+/*
+GetCallbackURL returns the url the processing outcome of the event is posted to
+*/
+func (b BaseEvent) GetCallbackURL() string {
+	return b.CallbackURL
+}
+
+/*
+SetCallbackURL sets the url the processing outcome of the event is posted to
+*/
+func (b *BaseEvent) SetCallbackURL(callbackURL string) {
+	b.CallbackURL = callbackURL
+}
+
+/*
+GetRetention returns how long the results of the event are kept, zero if the retention of the results store applies
+*/
+func (b BaseEvent) GetRetention() time.Duration {
+	return b.Retention
+}
+
+/*
+SetRetention overrides the retention of the results store for the results of the event
+*/
+func (b *BaseEvent) SetRetention(retention time.Duration) {
+	b.Retention = retention
+}
+
+/*
+GetLegalHold reports whether the results of the event are kept regardless of their retention
+*/
+func (b BaseEvent) GetLegalHold() bool {
+	return b.LegalHold
+}
+
+/*
+SetLegalHold sets whether the results of the event are kept regardless of their retention
+*/
+func (b *BaseEvent) SetLegalHold(legalHold bool) {
+	b.LegalHold = legalHold
+}
+
 /*
 GetCommonMetadata returns common metadata for all event types
 */
 func (b BaseEvent) GetCommonMetadata() map[string]interface{} {
-	return map[string]interface{}{
-		"event_id":   b.EventID,
-		"timestamp":  b.Timestamp,
-		"thread_id":  b.ThreadID,
-		"event_type": fmt.Sprintf("%T", b),
+	metadata := map[string]interface{}{
+		"event_id":       b.EventID,
+		"timestamp":      b.Timestamp.Format(time.RFC3339Nano),
+		"thread_id":      b.ThreadID,
+		"event_type":     b.EventType,
+		"priority":       b.Priority,
+		"schema_version": b.SchemaVersion,
+	}
+	if b.BatchID != "" {
+		metadata["batch_id"] = b.BatchID
+	}
+	if b.CorrelationID != "" {
+		metadata["correlation_id"] = b.CorrelationID
 	}
+	if b.ParentEventID != "" {
+		metadata["parent_event_id"] = b.ParentEventID
+	}
+	if !b.DeliverAt.IsZero() {
+		metadata["deliver_at"] = b.DeliverAt.Format(time.RFC3339Nano)
+	}
+	if b.CallbackURL != "" {
+		metadata["callback_url"] = b.CallbackURL
+	}
+	if b.Retention > 0 {
+		metadata["retention"] = b.Retention.String()
+	}
+	if b.LegalHold {
+		metadata["legal_hold"] = true
+	}
+	return metadata
 }
 
 /*
@@ -73,7 +340,7 @@ NewEventMetric creates a new EventMetric
 */
 func NewEventMetric(eventID string, value float64) *EventMetric {
 	return &EventMetric{
-		BaseEvent: NewBaseEvent(eventID),
+		BaseEvent: NewBaseEvent(eventID, EventTypeMetric),
 		Value:     value,
 	}
 }
@@ -101,7 +368,7 @@ NewEventLog creates a new EventLog
 */
 func NewEventLog(eventID string, level string, message string) *EventLog {
 	return &EventLog{
-		BaseEvent: NewBaseEvent(eventID),
+		BaseEvent: NewBaseEvent(eventID, EventTypeLog),
 		Level:     level,
 		Message:   message,
 	}
@@ -116,3 +383,160 @@ func (e EventLog) GetMetadata() map[string]interface{} {
 	metadata["message"] = e.Message
 	return metadata
 }
+
+/*
+EventTrace represents a distributed tracing span summary
+*/
+type EventTrace struct {
+	*BaseEvent
+	TraceID  string
+	SpanID   string
+	Duration float64 // span duration in seconds
+	Service  string
+}
+
+/*
+NewEventTrace creates a new EventTrace
+*/
+func NewEventTrace(eventID string, traceID string, spanID string, duration float64, service string) *EventTrace {
+	return &EventTrace{
+		BaseEvent: NewBaseEvent(eventID, EventTypeTrace),
+		TraceID:   traceID,
+		SpanID:    spanID,
+		Duration:  duration,
+		Service:   service,
+	}
+}
+
+/*
+GetMetadata returns metadata for EventTrace
+*/
+func (e EventTrace) GetMetadata() map[string]interface{} {
+	metadata := e.GetCommonMetadata()
+	metadata["trace_id"] = e.TraceID
+	metadata["span_id"] = e.SpanID
+	metadata["duration"] = e.Duration
+	metadata["service"] = e.Service
+	return metadata
+}
+
+/*
+EventAudit represents an action done by an actor on a resource for compliance purposes
+*/
+type EventAudit struct {
+	*BaseEvent
+	Actor    string
+	Action   string
+	Resource string
+	Outcome  string
+}
+
+/*
+NewEventAudit creates a new EventAudit
+*/
+func NewEventAudit(eventID string, actor string, action string, resource string, outcome string) *EventAudit {
+	return &EventAudit{
+		BaseEvent: NewBaseEvent(eventID, EventTypeAudit),
+		Actor:     actor,
+		Action:    action,
+		Resource:  resource,
+		Outcome:   outcome,
+	}
+}
+
+/*
+GetMetadata returns metadata for EventAudit
+*/
+func (e EventAudit) GetMetadata() map[string]interface{} {
+	metadata := e.GetCommonMetadata()
+	metadata["actor"] = e.Actor
+	metadata["action"] = e.Action
+	metadata["resource"] = e.Resource
+	metadata["outcome"] = e.Outcome
+	return metadata
+}
+
+func init() {
+	RegisterEventType(&EventTypeSpec{
+		Name:   EventTypeLog,
+		Fields: []string{"level", "message"},
+		New: func(eventID string, fields *EventFields) Event {
+			return NewEventLog(eventID, *fields.Level, *fields.Message)
+		},
+	})
+
+	RegisterEventType(&EventTypeSpec{
+		Name:   EventTypeMetric,
+		Fields: []string{"value"},
+		New: func(eventID string, fields *EventFields) Event {
+			return NewEventMetric(eventID, *fields.Value)
+		},
+	})
+
+	RegisterEventType(&EventTypeSpec{
+		Name:   EventTypeTrace,
+		Fields: []string{"trace_id", "span_id", "duration", "service"},
+		Validate: func(v *helpers.Validator, fields *EventFields) {
+			if fields.TraceID != nil {
+				v.Check(helpers.Matches(*fields.TraceID, helpers.TraceIDRX) && *fields.TraceID != strings.Repeat("0", 32), "trace_id", "should be 32 lowercase hex characters and not all zeros")
+			}
+			if fields.SpanID != nil {
+				v.Check(helpers.Matches(*fields.SpanID, helpers.SpanIDRX) && *fields.SpanID != strings.Repeat("0", 16), "span_id", "should be 16 lowercase hex characters and not all zeros")
+			}
+			if fields.Duration != nil {
+				v.Check(*fields.Duration >= 0, "duration", "shouldn't be negative")
+			}
+			if fields.Service != nil {
+				v.Check(*fields.Service != "", "service", "shouldn't be empty")
+				v.Check(len(*fields.Service) <= 255, "service", "must not be more than 255 bytes long")
+			}
+		},
+		New: func(eventID string, fields *EventFields) Event {
+			return NewEventTrace(eventID, *fields.TraceID, *fields.SpanID, *fields.Duration, *fields.Service)
+		},
+	})
+
+	RegisterEventType(&EventTypeSpec{
+		Name:   EventTypeAudit,
+		Fields: []string{"actor", "action", "resource", "outcome"},
+		Validate: func(v *helpers.Validator, fields *EventFields) {
+			if fields.Actor != nil {
+				v.Check(strings.TrimSpace(*fields.Actor) != "", "actor", "shouldn't be empty")
+				v.Check(len(*fields.Actor) <= 500, "actor", "must not be more than 500 bytes long")
+			}
+			if fields.Action != nil {
+				v.Check(strings.TrimSpace(*fields.Action) != "", "action", "shouldn't be empty")
+				v.Check(len(*fields.Action) <= 500, "action", "must not be more than 500 bytes long")
+			}
+			if fields.Outcome != nil {
+				v.Check(helpers.In(*fields.Outcome, AuditOutcomeSuccess, AuditOutcomeFailure, AuditOutcomeUnknown), "outcome", "invalid")
+			}
+		},
+		New: func(eventID string, fields *EventFields) Event {
+			return NewEventAudit(eventID, *fields.Actor, *fields.Action, *fields.Resource, *fields.Outcome)
+		},
+	})
+}
+
+/*
+EventProcessResult is the information worker calculates and persists after processing an event
+*/
+type EventProcessResult struct {
+	Event           Event
+	Digest          string
+	DigestAlgorithm string
+	Length          int
+	ProcessingTime  string
+	ProcessedAt     time.Time
+}
+
+/*
+ProcessedEvent represents the outcome of processing an event by the worker.
+Result is nil in case processing is failed or skipped.
+*/
+type ProcessedEvent struct {
+	Event  Event
+	Status string
+	Result *EventProcessResult
+	Err    error
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"container/heap"
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -12,19 +14,88 @@ var (
 	CmdEventQueueSize int64
 )
 
+var (
+	ErrEventQueueFull  = errors.New("event queue is full")
+	ErrEventNotQueued  = errors.New("event isn't inside the queue")
+	ErrEventProcessing = errors.New("event is already being processed")
+	ErrEventCancelled  = errors.New("event is cancelled before being processed")
+)
+
+/*
+EventQueue keeps the events in priority order. Events with higher priority are handed out first
+and events with the same priority are handed out in FIFO mode.
+Ready channel receives a signal per each event added to the queue so the consumers can block on it until an event is available.
+Events with a future delivery time are held in a timer wheel and only added to the queue once they're due, they count towards the capacity meanwhile.
+*/
 type EventQueue struct {
-	Capacity int64
-	Events   chan Event
+	Capacity       int64
+	Index          *EventIndex       // nil if the event index is disabled
+	Lifetime       *LifetimeCounters // cumulative counters surviving the restarts, nil if not tracked
+	events         eventHeap
+	seq            uint64
+	ready          chan struct{}
+	dedup          *eventDeduplicator
+	batches        map[string]*BatchStatus
+	mu             sync.Mutex
+	processWaiters map[string][]chan *ProcessedEvent
+	cancelled      map[string]struct{} // ids of the queued events marked to be skipped by the worker
+	inFlight       map[string]string   // producers of the events taken out of the queue and not processed yet by their ids
+	leases         map[string]*EventLease
+	deliveries     map[string]int // number of the leases of the events pulled by the consumers, kept until they're processed
+	pushed         chan struct{}  // closed and replaced once events are added to the queue
+	scheduled      *timerWheel    // delayed events which aren't due yet
+	scheduleTimer  *time.Timer    // fires when the next slot of the timer wheel is due, nil if the wheel is empty
 }
 
 func NewEventQueue() *EventQueue {
-	eq := make(chan Event, CmdEventQueueSize)
 	return &EventQueue{
-		Capacity: int64(CmdEventQueueSize),
-		Events:   eq,
+		Capacity:       int64(CmdEventQueueSize),
+		Index:          NewEventIndex(CmdEventIndexSize),
+		events:         make(eventHeap, 0, CmdEventQueueSize),
+		ready:          make(chan struct{}, CmdEventQueueSize),
+		dedup:          newEventDeduplicator(CmdEventDedupWindow, CmdEventDedupSize),
+		batches:        make(map[string]*BatchStatus),
+		processWaiters: make(map[string][]chan *ProcessedEvent),
+		cancelled:      make(map[string]struct{}),
+		inFlight:       make(map[string]string),
+		leases:         make(map[string]*EventLease),
+		deliveries:     make(map[string]int),
+		pushed:         make(chan struct{}),
+		scheduled:      newTimerWheel(CmdEventDelayTick),
 	}
 }
 
+/*
+queuedEvent wraps the event with its insertion sequence to keep the FIFO order among the events with the same priority
+*/
+type queuedEvent struct {
+	event Event
+	seq   uint64
+}
+
+/*
+eventHeap implements heap.Interface ordering the events by priority descending and insertion sequence ascending
+*/
+type eventHeap []queuedEvent
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].event.GetPriority() != h[j].event.GetPriority() {
+		return h[i].event.GetPriority() > h[j].event.GetPriority()
+	}
+	return h[i].seq < h[j].seq
+}
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x any)   { *h = append(*h, x.(queuedEvent)) }
+func (h *eventHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = queuedEvent{}
+	*h = old[:n-1]
+	return item
+}
+
 /*
 PutEvent function will get an event and add that to the event queue
 */
@@ -32,41 +103,388 @@ func (eq *EventQueue) PutEvent(ctx context.Context, event Event) error {
 	_, span := otel.Tracer("EventQueue.PutEvent.Tracer").Start(ctx, "EventQueue.PutEvent.Span")
 	defer span.End()
 
-	if len(eq.Events) == cap(eq.Events) {
-		return errors.New("event queue is full")
+	// the deadline of the client might already be exceeded while reading and validating the event
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if eq.Size(ctx)+eq.Scheduled() >= int(eq.Capacity) {
+		return ErrEventQueueFull
 	}
 
-	// Set the enqueue time if the event implements BaseEvent
-	if baseEvent, ok := event.(*EventLog); ok {
-		baseEvent.BaseEvent.EnqueueTime = time.Now()
-	} else if baseEvent, ok := event.(*EventMetric); ok {
-		baseEvent.BaseEvent.EnqueueTime = time.Now()
+	// reserving the event id to reject the same event sent again by the client inside the deduplication window
+	if receivedAt, ok := eq.dedup.reserve(event.GetEventID()); !ok {
+		span.AddEvent("duplicate event rejected")
+		return &DuplicateEventError{EventID: event.GetEventID(), ReceivedAt: receivedAt}
+	}
+
+	// Set the enqueue time of the event
+	event.SetEnqueueTime(time.Now())
+
+	// Compress the event payload to keep the memory footprint of the queue low
+	cEvent, err := CompressEvent(event, CmdEventQueueCompression, CmdEventQueueCompressionMinBytes)
+	if err != nil {
+		span.RecordError(err)
+		eq.dedup.release(event.GetEventID())
+		return err
 	}
 
 	// Append to the Queue
-	eq.Events <- event
+	err = eq.push([]Event{cEvent}, nil)
+	if err != nil {
+		eq.dedup.release(event.GetEventID())
+		return err
+	}
+	eq.Lifetime.Ingested([]Event{event})
 	return nil
 }
 
 /*
-GetEvent function will get an event out the queue completely in FIFO mode and and shrinks the eventQueue
+Restore puts the previously removed events back to the queue, all of them or none if the queue doesn't have enough capacity.
+Restored events bypass the deduplication since their ids are already seen by the queue.
+*/
+func (eq *EventQueue) Restore(ctx context.Context, events []Event) error {
+	_, span := otel.Tracer("EventQueue.Restore.Tracer").Start(ctx, "EventQueue.Restore.Span")
+	defer span.End()
+
+	cEvents := make([]Event, 0, len(events))
+	for _, event := range events {
+		cEvent, err := CompressEvent(event, CmdEventQueueCompression, CmdEventQueueCompressionMinBytes)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		cEvents = append(cEvents, cEvent)
+	}
+	return eq.push(cEvents, nil)
+}
+
+/*
+push adds all the events to the queue or none of them if the queue doesn't have enough capacity.
+Events which aren't due yet are scheduled in the timer wheel instead. batch is registered to track its completion if it's provided.
+*/
+func (eq *EventQueue) push(events []Event, batch *BatchStatus) error {
+	now := time.Now()
+	eq.mu.Lock()
+	if eq.events.Len()+eq.scheduled.count+len(events) > int(eq.Capacity) {
+		eq.mu.Unlock()
+		return ErrEventQueueFull
+	}
+	due := 0
+	for _, event := range events {
+		eq.Index.Queued(event)
+		if deliverAt := event.GetDeliverAt(); deliverAt.After(now) {
+			eq.scheduled.add(event, deliverAt, now)
+			continue
+		}
+		eq.seq++
+		heap.Push(&eq.events, queuedEvent{event: event, seq: eq.seq})
+		due++
+	}
+	if batch != nil {
+		eq.forgetCompletedBatches()
+		eq.batches[batch.BatchID] = batch
+	}
+	if due < len(events) && eq.scheduleTimer == nil {
+		eq.scheduleTimer = time.AfterFunc(time.Until(eq.scheduled.next), eq.deliverScheduled)
+	}
+	eq.mu.Unlock()
+	eq.signalPushed(due)
+	return nil
+}
+
+/*
+signalPushed wakes up the consumers waiting for the events added to the queue, it should be called without holding the lock
+*/
+func (eq *EventQueue) signalPushed(count int) {
+	if count == 0 {
+		return
+	}
+	eq.mu.Lock()
+	close(eq.pushed)
+	eq.pushed = make(chan struct{})
+	eq.mu.Unlock()
+
+	// a full ready channel means there are already enough signals pending for all the events inside the queue
+	for range count {
+		select {
+		case eq.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+/*
+deliverScheduled moves the delayed events which are due out of the timer wheel into the queue and rearms the timer for the next slot
+*/
+func (eq *EventQueue) deliverScheduled() {
+	eq.mu.Lock()
+	due := eq.scheduled.advance(time.Now())
+	for _, event := range due {
+		eq.seq++
+		heap.Push(&eq.events, queuedEvent{event: event, seq: eq.seq})
+	}
+	eq.scheduleTimer = nil
+	if eq.scheduled.count > 0 {
+		eq.scheduleTimer = time.AfterFunc(time.Until(eq.scheduled.next), eq.deliverScheduled)
+	}
+	eq.mu.Unlock()
+	eq.signalPushed(len(due))
+}
+
+/*
+Ready returns a channel which receives a signal per each event added to the queue.
+Receiving a signal doesn't guarantee an event is still available since the queue might be purged meanwhile, so GetEvent result should be checked.
+*/
+func (eq *EventQueue) Ready() <-chan struct{} {
+	return eq.ready
+}
+
+/*
+GetEvent function will get the event with highest priority out of the queue and shrinks the eventQueue.
+Events with the same priority are returned in FIFO mode.
 */
 func (eq *EventQueue) GetEvent(ctx context.Context) Event {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
 	// Check if the queue is empty
-	if len(eq.Events) == 0 {
+	if eq.events.Len() == 0 {
 		return nil
 	}
 	_, span := otel.Tracer("EventQueue.GetEvent.Tracer").Start(ctx, "EventQueue.GetEvent.Span")
 	defer span.End()
 	span.AddEvent("Event removed from queue")
-	return <-eq.Events
+	event := heap.Pop(&eq.events).(queuedEvent).event
+	eq.inFlight[event.GetEventID()] = event.GetProducer()
+	return event
+}
+
+/*
+GetEventFunc gets the event with the highest priority accepted by accept out of the queue, the events not accepted stay inside the queue.
+It returns nil if the queue doesn't have any accepted event.
+*/
+func (eq *EventQueue) GetEventFunc(ctx context.Context, accept func(event Event) bool) Event {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	best := -1
+	for i := range eq.events {
+		if (best == -1 || eq.events.Less(i, best)) && accept(eq.events[i].event) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	_, span := otel.Tracer("EventQueue.GetEventFunc.Tracer").Start(ctx, "EventQueue.GetEventFunc.Span")
+	defer span.End()
+	span.AddEvent("Event removed from queue")
+	event := heap.Remove(&eq.events, best).(queuedEvent).event
+	eq.inFlight[event.GetEventID()] = event.GetProducer()
+	return event
 }
 
 /*
-Size function will get the size of current Queue
+Cancel marks the queued event to be skipped by the worker once it's taken out of the queue.
+producer is the producer the event should belong to, the events of any producer are cancelled if it's empty.
+It returns ErrEventProcessing if the event is already taken out of the queue and ErrEventNotQueued if the event isn't inside the queue,
+the events of the other producers aren't inside the queue either so their ids aren't disclosed.
+Cancelling an already cancelled event doesn't return any error.
+*/
+func (eq *EventQueue) Cancel(ctx context.Context, eventID string, producer string) error {
+	_, span := otel.Tracer("EventQueue.Cancel.Tracer").Start(ctx, "EventQueue.Cancel.Span")
+	defer span.End()
+
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	eventProducer, found := eq.producerOf(eventID)
+	if !found || (producer != "" && eventProducer != producer) {
+		return ErrEventNotQueued
+	}
+	if _, found := eq.cancelled[eventID]; found {
+		return nil
+	}
+	if _, found := eq.inFlight[eventID]; found {
+		return ErrEventProcessing
+	}
+	// delayed events are skipped once they're due as well
+	eq.cancelled[eventID] = struct{}{}
+	span.AddEvent("Event marked as cancelled")
+	return nil
+}
+
+/*
+producerOf returns the producer of the event which is inside the queue, delayed or taken out of the queue and not processed yet
+*/
+func (eq *EventQueue) producerOf(eventID string) (string, bool) {
+	if producer, found := eq.inFlight[eventID]; found {
+		return producer, true
+	}
+	for _, queued := range eq.events {
+		if queued.event.GetEventID() == eventID {
+			return queued.event.GetProducer(), true
+		}
+	}
+	if event := eq.scheduled.find(eventID); event != nil {
+		return event.GetProducer(), true
+	}
+	return "", false
+}
+
+/*
+Cancelled reports whether the event taken out of the queue is cancelled and should be skipped. The cancellation mark is cleared afterwards.
+*/
+func (eq *EventQueue) Cancelled(event Event) bool {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	if _, found := eq.cancelled[event.GetEventID()]; !found {
+		return false
+	}
+	delete(eq.cancelled, event.GetEventID())
+	return true
+}
+
+/*
+Purge function will remove all the events currently inside the queue and returns them in the order they would have been processed,
+followed by the delayed events in the order of their delivery time
+*/
+func (eq *EventQueue) Purge(ctx context.Context) []Event {
+	_, span := otel.Tracer("EventQueue.Purge.Tracer").Start(ctx, "EventQueue.Purge.Span")
+	defer span.End()
+
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	purged := make([]Event, 0, eq.events.Len())
+	for eq.events.Len() > 0 {
+		purged = append(purged, heap.Pop(&eq.events).(queuedEvent).event)
+	}
+	purged = append(purged, eq.scheduled.purge()...)
+	if eq.scheduleTimer != nil {
+		eq.scheduleTimer.Stop()
+		eq.scheduleTimer = nil
+	}
+	// cancellation of the purged events doesn't matter anymore
+	eq.cancelled = make(map[string]struct{})
+	span.AddEvent("Events removed from queue")
+	return purged
+}
+
+/*
+Size function will get the size of current Queue, the delayed events which aren't due yet aren't included
 */
 func (eq *EventQueue) Size(ctx context.Context) int {
 	_, span := otel.Tracer("EventQueue.Size.Tracer").Start(ctx, "EventQueue.Size.Span")
 	defer span.End()
-	return len(eq.Events)
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.events.Len()
+}
+
+/*
+Scheduled returns the number of the delayed events waiting for their delivery time
+*/
+func (eq *EventQueue) Scheduled() int {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.scheduled.count
+}
+
+/*
+RegisterProcessWaiter registers a waiter which will receive the processing outcome of the event with the specified id.
+Waiter should be registered before putting the event in the queue, otherwise the outcome may be missed.
+*/
+func (eq *EventQueue) RegisterProcessWaiter(eventID string) chan *ProcessedEvent {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	waiter := make(chan *ProcessedEvent, 1) // buffered to avoid blocking the worker if nobody reads the outcome anymore
+	eq.processWaiters[eventID] = append(eq.processWaiters[eventID], waiter)
+	return waiter
+}
+
+/*
+RemoveProcessWaiter removes the registered waiter of an event in case the waiter is not interested in the outcome anymore
+*/
+func (eq *EventQueue) RemoveProcessWaiter(eventID string, waiter chan *ProcessedEvent) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	waiters := eq.processWaiters[eventID]
+	for i := range waiters {
+		if waiters[i] == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(eq.processWaiters, eventID)
+		return
+	}
+	eq.processWaiters[eventID] = waiters
+}
+
+/*
+NotifyProcessed sends the processing outcome of an event to all of its registered waiters and records it in the event index.
+If the event is the last pending event of a batch, status of the completed batch is returned otherwise it returns nil.
+*/
+func (eq *EventQueue) NotifyProcessed(processed *ProcessedEvent) *BatchStatus {
+	eventID := processed.Event.GetEventID()
+	eq.Index.Processed(processed)
+	eq.Lifetime.Processed(processed.Event.GetEventType(), processed.Status)
+	eq.mu.Lock()
+	waiters := eq.processWaiters[eventID]
+	delete(eq.processWaiters, eventID)
+	delete(eq.inFlight, eventID)
+	delete(eq.deliveries, eventID)
+	completedBatch := eq.recordBatchOutcome(processed)
+	eq.mu.Unlock()
+
+	for _, waiter := range waiters {
+		waiter <- processed
+	}
+	return completedBatch
+}
+
+/*
+QueueInspection is a snapshot of the events waiting inside the queue
+*/
+type QueueInspection struct {
+	Size            int            `json:"size"`
+	Capacity        int64          `json:"capacity"`
+	ByPriority      map[int]int    `json:"by_priority"`
+	ByEventType     map[string]int `json:"by_event_type"`
+	OldestEnqueueAt *time.Time     `json:"oldest_enqueued_at,omitempty"`
+	NextEventID     string         `json:"next_event_id,omitempty"` // id of the event will be handed out next
+	Scheduled       int            `json:"scheduled"`               // delayed events which aren't due yet
+	NextDeliveryAt  *time.Time     `json:"next_delivery_at,omitempty"`
+}
+
+/*
+Inspect returns a snapshot of the queue without removing any event from it
+*/
+func (eq *EventQueue) Inspect(ctx context.Context) *QueueInspection {
+	_, span := otel.Tracer("EventQueue.Inspect.Tracer").Start(ctx, "EventQueue.Inspect.Span")
+	defer span.End()
+
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+
+	inspection := &QueueInspection{
+		Size:        eq.events.Len(),
+		Capacity:    eq.Capacity,
+		ByPriority:  make(map[int]int),
+		ByEventType: make(map[string]int),
+	}
+	for _, queued := range eq.events {
+		inspection.ByPriority[queued.event.GetPriority()]++
+		inspection.ByEventType[queued.event.GetEventType()]++
+		enqueueTime := queued.event.GetEnqueueTime()
+		if inspection.OldestEnqueueAt == nil || enqueueTime.Before(*inspection.OldestEnqueueAt) {
+			inspection.OldestEnqueueAt = &enqueueTime
+		}
+	}
+	if eq.events.Len() > 0 {
+		inspection.NextEventID = eq.events[0].event.GetEventID()
+	}
+	inspection.Scheduled = eq.scheduled.count
+	if nextDelivery := eq.scheduled.nextDelivery(); !nextDelivery.IsZero() {
+		inspection.NextDeliveryAt = &nextDelivery
+	}
+	return inspection
 }
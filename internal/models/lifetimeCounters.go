@@ -0,0 +1,203 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	CmdLifetimeCountersFile          string
+	CmdLifetimeCountersFlushInterval time.Duration
+)
+
+/*
+LifetimeCounts is the number of the ingested events and the processed events by their process status
+*/
+type LifetimeCounts struct {
+	Ingested  int64            `json:"ingested"`
+	Processed map[string]int64 `json:"processed"`
+}
+
+func newLifetimeCounts() *LifetimeCounts {
+	return &LifetimeCounts{Processed: map[string]int64{
+		EventProcessStatusSuccess:   0,
+		EventProcessStatusFailed:    0,
+		EventProcessStatusSkipped:   0,
+		EventProcessStatusCancelled: 0,
+	}}
+}
+
+/*
+LifetimeStats is the cumulative counters since the counters file is created, persisted as is in the counters file
+*/
+type LifetimeStats struct {
+	Since       time.Time                  `json:"since"`
+	Starts      int64                      `json:"starts"` // number of the server startups sharing the counters
+	PersistedAt *time.Time                 `json:"persisted_at,omitempty"`
+	Totals      *LifetimeCounts            `json:"totals"`
+	ByEventType map[string]*LifetimeCounts `json:"by_event_type"`
+}
+
+/*
+LifetimeCounters keeps the cumulative counters of the events surviving the restarts, unlike the prometheus counters which reset on every deploy.
+Counters are kept in memory and flushed to the counters file periodically and on shutdown, so a crash loses at most the counts of a flush interval.
+*/
+type LifetimeCounters struct {
+	mu    sync.Mutex
+	path  string
+	stats LifetimeStats
+	dirty bool // counters changed since the last flush
+}
+
+/*
+NewLifetimeCounters loads the counters persisted in the file and counts a new startup. Counters are only kept in memory if path is empty.
+*/
+func NewLifetimeCounters(path string) (*LifetimeCounters, error) {
+	lc := &LifetimeCounters{
+		path: path,
+		stats: LifetimeStats{
+			Since:       time.Now(),
+			Totals:      newLifetimeCounts(),
+			ByEventType: make(map[string]*LifetimeCounts),
+		},
+		dirty: true,
+	}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			err = json.Unmarshal(content, &lc.stats)
+			if err != nil {
+				return nil, fmt.Errorf("invalid lifetime counters file %s: %w", path, err)
+			}
+			if lc.stats.Totals == nil {
+				lc.stats.Totals = newLifetimeCounts()
+			}
+			if lc.stats.ByEventType == nil {
+				lc.stats.ByEventType = make(map[string]*LifetimeCounts)
+			}
+		}
+	}
+	lc.stats.Starts++
+	return lc, nil
+}
+
+func (lc *LifetimeCounters) eventType(eventType string) *LifetimeCounts {
+	counts, found := lc.stats.ByEventType[eventType]
+	if !found {
+		counts = newLifetimeCounts()
+		lc.stats.ByEventType[eventType] = counts
+	}
+	return counts
+}
+
+/*
+Ingested counts the events accepted into the queue
+*/
+func (lc *LifetimeCounters) Ingested(events []Event) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for _, event := range events {
+		lc.stats.Totals.Ingested++
+		lc.eventType(event.GetEventType()).Ingested++
+	}
+	lc.dirty = true
+}
+
+/*
+Processed counts the processing outcome of the event
+*/
+func (lc *LifetimeCounters) Processed(eventType string, status string) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.stats.Totals.Processed[status]++
+	lc.eventType(eventType).Processed[status]++
+	lc.dirty = true
+}
+
+/*
+Snapshot returns a copy of the counters
+*/
+func (lc *LifetimeCounters) Snapshot() *LifetimeStats {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	copyCounts := func(counts *LifetimeCounts) *LifetimeCounts {
+		nCounts := &LifetimeCounts{Ingested: counts.Ingested, Processed: make(map[string]int64, len(counts.Processed))}
+		for status, count := range counts.Processed {
+			nCounts.Processed[status] = count
+		}
+		return nCounts
+	}
+	snapshot := &LifetimeStats{
+		Since:       lc.stats.Since,
+		Starts:      lc.stats.Starts,
+		Totals:      copyCounts(lc.stats.Totals),
+		ByEventType: make(map[string]*LifetimeCounts, len(lc.stats.ByEventType)),
+	}
+	if lc.stats.PersistedAt != nil {
+		persistedAt := *lc.stats.PersistedAt
+		snapshot.PersistedAt = &persistedAt
+	}
+	for eventType, counts := range lc.stats.ByEventType {
+		snapshot.ByEventType[eventType] = copyCounts(counts)
+	}
+	return snapshot
+}
+
+/*
+Flush persists the counters if they're changed since the last flush. The file is replaced atomically so a crash during the flush doesn't corrupt it.
+*/
+func (lc *LifetimeCounters) Flush() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.path == "" || !lc.dirty {
+		return nil
+	}
+
+	persistedAt := time.Now()
+	previous := lc.stats.PersistedAt
+	lc.stats.PersistedAt = &persistedAt
+	jStats, err := json.Marshal(&lc.stats)
+	if err != nil {
+		lc.stats.PersistedAt = previous
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(lc.path), filepath.Base(lc.path)+".*.tmp")
+	if err != nil {
+		lc.stats.PersistedAt = previous
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(jStats)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), lc.path)
+	}
+	if err != nil {
+		lc.stats.PersistedAt = previous
+		return err
+	}
+	lc.dirty = false
+	return nil
+}
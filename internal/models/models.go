@@ -1,11 +1,19 @@
 package data
 
 type Models struct {
-	EventQueue *EventQueue
+	EventQueue      *EventQueue
+	DeadLetterQueue *DeadLetterQueue
+	ChangeLog       *ChangeLog
+	Subscriptions   *SubscriptionStore
+	Schedules       *ScheduleStore
 }
 
-func NewModels(eq *EventQueue, em *EventMetric, el *EventLog) *Models {
+func NewModels(eq *EventQueue, dlq *DeadLetterQueue, cl *ChangeLog, ss *SubscriptionStore, sch *ScheduleStore, em *EventMetric, el *EventLog) *Models {
 	return &Models{
-		EventQueue: eq,
+		EventQueue:      eq,
+		DeadLetterQueue: dlq,
+		ChangeLog:       cl,
+		Subscriptions:   ss,
+		Schedules:       sch,
 	}
 }
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var CmdRefreshTokenStateFile string
+
+var (
+	ErrRefreshTokenReused     = errors.New("refresh token is already used, the family of the token is revoked")
+	ErrRefreshTokenRevoked    = errors.New("family of the refresh token is revoked")
+	ErrRefreshTokenGeneration = errors.New("refresh token is issued before the state of the used refresh tokens is lost")
+)
+
+/*
+refreshTokenRecord is a line of the refresh token state file, it's either the generation of the file, a used refresh token or a revoked family
+*/
+type refreshTokenRecord struct {
+	Generation string    `json:"generation,omitempty"`
+	TokenID    string    `json:"token_id,omitempty"`
+	Family     string    `json:"family,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+}
+
+/*
+RefreshTokenStore keeps the ids of the rotated refresh tokens and the revoked families until they expire, so a used refresh token can't be replayed.
+Changes are appended to the state file to survive the restarts and the file is compacted to the unexpired entries on startup and once it doubles.
+The refresh tokens carry the generation of the store, which is random per state file or per boot if the state is only kept in memory,
+so the tokens issued while the used ones were tracked by another state are rejected instead of being replayable.
+*/
+type RefreshTokenStore struct {
+	mu         sync.Mutex
+	path       string
+	generation string
+	used       map[string]time.Time
+	revoked    map[string]time.Time
+	records    int // records of the state file since it's compacted
+}
+
+/*
+NewRefreshTokenStore loads the state persisted in the file. The state is only kept in memory if path is empty.
+*/
+func NewRefreshTokenStore(path string) (*RefreshTokenStore, error) {
+	rs := &RefreshTokenStore{path: path, used: make(map[string]time.Time), revoked: make(map[string]time.Time)}
+	if path != "" {
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return nil, err
+		}
+		err = rs.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rs.generation == "" {
+		generation := make([]byte, 16)
+		_, err := rand.Read(generation)
+		if err != nil {
+			return nil, err
+		}
+		rs.generation = hex.EncodeToString(generation)
+	}
+	rs.expire(time.Now())
+	if path == "" {
+		return rs, nil
+	}
+	return rs, rs.compact()
+}
+
+func (rs *RefreshTokenStore) load() error {
+	file, err := os.Open(rs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for n := 1; ; n++ {
+		var record refreshTokenRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// the last record might be partially written by a crash
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid refresh token record %d of %s: %w", n, rs.path, err)
+		}
+		rs.apply(&record)
+	}
+}
+
+func (rs *RefreshTokenStore) apply(record *refreshTokenRecord) {
+	switch {
+	case record.Generation != "":
+		rs.generation = record.Generation
+	case record.TokenID != "":
+		rs.used[record.TokenID] = record.ExpiresAt
+	case record.Family != "":
+		rs.revoked[record.Family] = record.ExpiresAt
+	}
+}
+
+/*
+expire drops the used tokens and the revoked families which have expired. rs.mu should be held by the caller once the store is created.
+*/
+func (rs *RefreshTokenStore) expire(now time.Time) {
+	for id, expiresAt := range rs.used {
+		if now.After(expiresAt) {
+			delete(rs.used, id)
+		}
+	}
+	for family, expiresAt := range rs.revoked {
+		if now.After(expiresAt) {
+			delete(rs.revoked, family)
+		}
+	}
+}
+
+/*
+compact replaces the state file with the generation and the unexpired entries. rs.mu should be held by the caller once the store is created.
+*/
+func (rs *RefreshTokenStore) compact() error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(rs.path), filepath.Base(rs.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	err = encoder.Encode(&refreshTokenRecord{Generation: rs.generation})
+	for id, expiresAt := range rs.used {
+		if err == nil {
+			err = encoder.Encode(&refreshTokenRecord{TokenID: id, ExpiresAt: expiresAt})
+		}
+	}
+	for family, expiresAt := range rs.revoked {
+		if err == nil {
+			err = encoder.Encode(&refreshTokenRecord{Family: family, ExpiresAt: expiresAt})
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), rs.path)
+	if err != nil {
+		return err
+	}
+	rs.records = 1 + len(rs.used) + len(rs.revoked)
+	return nil
+}
+
+/*
+append persists the record before it's applied to the memory, so a used token isn't accepted again after a crash. rs.mu should be held by the caller.
+*/
+func (rs *RefreshTokenStore) append(record *refreshTokenRecord) error {
+	if rs.path == "" {
+		return nil
+	}
+	jRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(rs.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(jRecord, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	rs.records++
+	return nil
+}
+
+/*
+Generation returns the generation the refresh tokens are issued for
+*/
+func (rs *RefreshTokenStore) Generation() string {
+	return rs.generation
+}
+
+/*
+Rotate marks the refresh token as used, so it can't be exchanged for new tokens anymore. Presenting a used token again revokes its family
+for familyTTL, the lifetime of the latest token of the family which might have been issued right now, and ErrRefreshTokenReused is returned.
+*/
+func (rs *RefreshTokenStore) Rotate(generation string, tokenID string, family string, expiresAt time.Time, familyTTL time.Duration) error {
+	if generation != rs.generation {
+		return ErrRefreshTokenGeneration
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	rs.expire(now)
+	if _, revoked := rs.revoked[family]; revoked {
+		return ErrRefreshTokenRevoked
+	}
+	if _, used := rs.used[tokenID]; used {
+		record := &refreshTokenRecord{Family: family, ExpiresAt: now.Add(familyTTL)}
+		err := rs.append(record)
+		if err != nil {
+			return err
+		}
+		rs.apply(record)
+		return ErrRefreshTokenReused
+	}
+	record := &refreshTokenRecord{TokenID: tokenID, ExpiresAt: expiresAt}
+	err := rs.append(record)
+	if err != nil {
+		return err
+	}
+	rs.apply(record)
+	if rs.path != "" && rs.records > 2*(1+len(rs.used)+len(rs.revoked)) {
+		// the record is already appended, so a failed compaction is only retried by the next rotation
+		_ = rs.compact()
+	}
+	return nil
+}
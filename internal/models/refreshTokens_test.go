@@ -0,0 +1,99 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenStoreRotate(t *testing.T) {
+	rs, err := NewRefreshTokenStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	tests := []struct {
+		name       string
+		generation string
+		tokenID    string
+		family     string
+		wantErr    error
+	}{
+		{name: "unused token", generation: rs.Generation(), tokenID: "token-1", family: "family-1"},
+		{name: "next token of the family", generation: rs.Generation(), tokenID: "token-2", family: "family-1"},
+		{name: "reused token revokes the family", generation: rs.Generation(), tokenID: "token-1", family: "family-1", wantErr: ErrRefreshTokenReused},
+		{name: "latest token of the revoked family", generation: rs.Generation(), tokenID: "token-3", family: "family-1", wantErr: ErrRefreshTokenRevoked},
+		{name: "other family", generation: rs.Generation(), tokenID: "token-4", family: "family-2"},
+		{name: "token of another generation", generation: "previous-boot", tokenID: "token-5", family: "family-3", wantErr: ErrRefreshTokenGeneration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rs.Rotate(tt.generation, tt.tokenID, tt.family, expiresAt, time.Hour)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Rotate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "refresh-tokens.jsonl")
+	rs, err := NewRefreshTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	for _, tokenID := range []string{"token-1", "token-2"} {
+		err = rs.Rotate(rs.Generation(), tokenID, "family-1", expiresAt, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rs.Rotate(rs.Generation(), "expired", "family-2", time.Now().Add(-time.Second), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("state file permissions = %o, want 600", info.Mode().Perm())
+	}
+
+	// a restarted server keeps the generation and the used tokens, the expired ones are dropped
+	restarted, err := NewRefreshTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Generation() != rs.Generation() {
+		t.Fatalf("generation = %s after the restart, want %s", restarted.Generation(), rs.Generation())
+	}
+	if _, found := restarted.used["expired"]; found {
+		t.Error("expired token is kept after the restart")
+	}
+	err = restarted.Rotate(restarted.Generation(), "token-1", "family-1", expiresAt, time.Hour)
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("replaying a used token after the restart error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	err = mustNewRefreshTokenStore(t, path).Rotate(rs.Generation(), "token-3", "family-1", expiresAt, time.Hour)
+	if !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("token of the family revoked before the restart error = %v, want %v", err, ErrRefreshTokenRevoked)
+	}
+
+	inMemory := mustNewRefreshTokenStore(t, "")
+	if inMemory.Generation() == mustNewRefreshTokenStore(t, "").Generation() {
+		t.Error("in memory stores of the different boots share the generation")
+	}
+}
+
+func mustNewRefreshTokenStore(t *testing.T, path string) *RefreshTokenStore {
+	t.Helper()
+	rs, err := NewRefreshTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
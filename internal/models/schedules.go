@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdSchedulesFile   string
+	CmdSchedulesMaxLen int
+)
+
+var (
+	ErrScheduleLimit = errors.New("maximum number of the schedules is reached")
+)
+
+/*
+ScheduleEvent is the template of the events injected into the queue by a schedule, every run gets a new event id
+*/
+type ScheduleEvent struct {
+	EventType     string      `json:"event_type"`
+	Priority      int         `json:"priority"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Fields        EventFields `json:"fields"`
+}
+
+/*
+Schedule is a recurring event registered by the admins, e.g. a synthetic heartbeat metric every minute.
+The cron expression is evaluated in UTC.
+*/
+type Schedule struct {
+	ID         string        `json:"id"`
+	Cron       string        `json:"cron"`
+	Event      ScheduleEvent `json:"event"`
+	CreatedBy  string        `json:"created_by,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	NextRunAt  time.Time     `json:"next_run_at"`
+	LastRunAt  *time.Time    `json:"last_run_at,omitempty"`
+	Runs       int64         `json:"runs"`    // number of the events enqueued by the schedule
+	Dropped    int64         `json:"dropped"` // number of the runs whose event couldn't be enqueued
+	LastError  string        `json:"last_error,omitempty"`
+	cronParsed *helpers.CronSchedule
+}
+
+/*
+NewEvent constructs a new event out of the template of the schedule
+*/
+func (s *Schedule) NewEvent(eventID string) (Event, error) {
+	spec, found := LookupEventType(s.Event.EventType)
+	if !found {
+		return nil, fmt.Errorf("unknown event type %s", s.Event.EventType)
+	}
+	fields := s.Event.Fields
+	nVal := helpers.NewValidator()
+	spec.ValidateFields(nVal, &fields)
+	if !nVal.Valid() {
+		return nil, fmt.Errorf("invalid event of the schedule %s: %v", s.ID, nVal.Errors)
+	}
+	event := spec.New(eventID, &fields)
+	event.SetPriority(s.Event.Priority)
+	event.SetCorrelationID(s.Event.CorrelationID)
+	return event, nil
+}
+
+/*
+ScheduleStore keeps the recurring events registered by the admins. The schedules file is replaced atomically on every change
+to survive the restarts, schedules are only kept in memory if the path is empty.
+*/
+type ScheduleStore struct {
+	Capacity  int
+	mu        sync.Mutex
+	path      string
+	schedules []*Schedule // ordered by the creation time
+	changed   chan struct{}
+}
+
+/*
+NewScheduleStore loads the schedules persisted in the file
+*/
+func NewScheduleStore(path string) (*ScheduleStore, error) {
+	ss := &ScheduleStore{Capacity: CmdSchedulesMaxLen, path: path, changed: make(chan struct{}, 1)}
+	if path == "" {
+		return ss, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ss, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &ss.schedules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedules file %s: %w", path, err)
+	}
+	for _, schedule := range ss.schedules {
+		schedule.cronParsed, err = helpers.ParseCron(schedule.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression of the schedule %s: %w", schedule.ID, err)
+		}
+	}
+	return ss, nil
+}
+
+/*
+Add registers the schedule and computes its first run. It returns ErrScheduleLimit if the store is full.
+*/
+func (ss *ScheduleStore) Add(ctx context.Context, schedule *Schedule) error {
+	_, span := otel.Tracer("ScheduleStore.Add.Tracer").Start(ctx, "ScheduleStore.Add.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("schedule.id", schedule.ID))
+
+	cronParsed, err := helpers.ParseCron(schedule.Cron)
+	if err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if len(ss.schedules) >= ss.Capacity {
+		span.RecordError(ErrScheduleLimit)
+		return ErrScheduleLimit
+	}
+	schedule.cronParsed = cronParsed
+	schedule.NextRunAt = cronParsed.Next(schedule.CreatedAt.UTC())
+	ss.schedules = append(ss.schedules, schedule)
+	err = ss.persist()
+	if err != nil {
+		ss.schedules = ss.schedules[:len(ss.schedules)-1]
+		span.RecordError(err)
+		return err
+	}
+	ss.notify()
+	return nil
+}
+
+/*
+List returns a copy of all the schedules
+*/
+func (ss *ScheduleStore) List(ctx context.Context) []Schedule {
+	_, span := otel.Tracer("ScheduleStore.List.Tracer").Start(ctx, "ScheduleStore.List.Span")
+	defer span.End()
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedules := make([]Schedule, 0, len(ss.schedules))
+	for _, schedule := range ss.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	return schedules
+}
+
+/*
+Delete removes the schedule, it returns false if the schedule doesn't exist
+*/
+func (ss *ScheduleStore) Delete(ctx context.Context, id string) (Schedule, bool, error) {
+	_, span := otel.Tracer("ScheduleStore.Delete.Tracer").Start(ctx, "ScheduleStore.Delete.Span")
+	defer span.End()
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	i := slices.IndexFunc(ss.schedules, func(schedule *Schedule) bool { return schedule.ID == id })
+	if i == -1 {
+		return Schedule{}, false, nil
+	}
+	deleted := ss.schedules[i]
+	ss.schedules = slices.Delete(ss.schedules, i, i+1)
+	err := ss.persist()
+	if err != nil {
+		ss.schedules = slices.Insert(ss.schedules, i, deleted)
+		span.RecordError(err)
+		return Schedule{}, false, err
+	}
+	ss.notify()
+	return *deleted, true, nil
+}
+
+/*
+Due returns a copy of the schedules whose next run is due by now. Runs missed while the server was down are only run once.
+*/
+func (ss *ScheduleStore) Due(now time.Time) []Schedule {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var due []Schedule
+	for _, schedule := range ss.schedules {
+		if !schedule.NextRunAt.After(now) {
+			due = append(due, *schedule)
+		}
+	}
+	return due
+}
+
+/*
+NextRun returns the earliest next run of the schedules, zero if there isn't any schedule
+*/
+func (ss *ScheduleStore) NextRun() time.Time {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var next time.Time
+	for _, schedule := range ss.schedules {
+		if next.IsZero() || schedule.NextRunAt.Before(next) {
+			next = schedule.NextRunAt
+		}
+	}
+	return next
+}
+
+/*
+Changed receives a signal once a schedule is added or deleted, so the scheduler recomputes its next run
+*/
+func (ss *ScheduleStore) Changed() <-chan struct{} {
+	return ss.changed
+}
+
+/*
+RecordRun records the outcome of the run of the schedule and moves it to its next run after now.
+runErr is the reason the event of the run couldn't be enqueued, nil if it's enqueued.
+*/
+func (ss *ScheduleStore) RecordRun(id string, ranAt time.Time, runErr error) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	i := slices.IndexFunc(ss.schedules, func(schedule *Schedule) bool { return schedule.ID == id })
+	if i == -1 {
+		// schedule is deleted meanwhile
+		return nil
+	}
+	schedule := ss.schedules[i]
+	schedule.LastRunAt = &ranAt
+	if runErr != nil {
+		schedule.Dropped++
+		schedule.LastError = runErr.Error()
+	} else {
+		schedule.Runs++
+		schedule.LastError = ""
+	}
+	schedule.NextRunAt = schedule.cronParsed.Next(ranAt.UTC())
+	return ss.persist()
+}
+
+/*
+notify wakes up the scheduler, ss.mu should be held by the caller
+*/
+func (ss *ScheduleStore) notify() {
+	select {
+	case ss.changed <- struct{}{}:
+	default:
+	}
+}
+
+/*
+persist replaces the schedules file atomically, ss.mu should be held by the caller
+*/
+func (ss *ScheduleStore) persist() error {
+	if ss.path == "" {
+		return nil
+	}
+	jSchedules, err := json.Marshal(ss.schedules)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(ss.path), filepath.Base(ss.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(jSchedules)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), ss.path)
+}
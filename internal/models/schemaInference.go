@@ -0,0 +1,151 @@
+package data
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// json schema dialect used by the inferred schemas
+const JsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var uuidRX = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+/*
+schemaNode accumulates the observed shapes of a json value across all the samples
+*/
+type schemaNode struct {
+	seen       int
+	types      map[string]int
+	properties map[string]*schemaNode
+	items      *schemaNode
+	formats    map[string]int // number of string values matching each known format
+	strings    int
+}
+
+func newSchemaNode() *schemaNode {
+	return &schemaNode{
+		types:      make(map[string]int),
+		properties: make(map[string]*schemaNode),
+		formats:    make(map[string]int),
+	}
+}
+
+/*
+InferSchema builds a draft json schema describing all the provided samples.
+Samples should be decoded with json.Decoder.UseNumber so integers can be distinguished from floating point numbers.
+Object properties present in every sample are marked as required.
+*/
+func InferSchema(title string, samples []interface{}) map[string]interface{} {
+	root := newSchemaNode()
+	for _, sample := range samples {
+		root.observe(sample)
+	}
+	schema := root.schema()
+	schema["$schema"] = JsonSchemaDialect
+	if title != "" {
+		schema["title"] = title
+	}
+	return schema
+}
+
+func (n *schemaNode) observe(value interface{}) {
+	n.seen++
+	switch v := value.(type) {
+	case nil:
+		n.types["null"]++
+	case bool:
+		n.types["boolean"]++
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			n.types["integer"]++
+		} else {
+			n.types["number"]++
+		}
+	case float64:
+		if v == float64(int64(v)) {
+			n.types["integer"]++
+		} else {
+			n.types["number"]++
+		}
+	case string:
+		n.types["string"]++
+		n.strings++
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			n.formats["date-time"]++
+		}
+		if uuidRX.MatchString(v) {
+			n.formats["uuid"]++
+		}
+	case []interface{}:
+		n.types["array"]++
+		if n.items == nil {
+			n.items = newSchemaNode()
+		}
+		for _, item := range v {
+			n.items.observe(item)
+		}
+	case map[string]interface{}:
+		n.types["object"]++
+		for key, item := range v {
+			property, found := n.properties[key]
+			if !found {
+				property = newSchemaNode()
+				n.properties[key] = property
+			}
+			property.observe(item)
+		}
+	}
+}
+
+func (n *schemaNode) schema() map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	// integer values are valid numbers as well, so only number is kept if both are observed
+	if n.types["integer"] > 0 && n.types["number"] > 0 {
+		n.types["number"] += n.types["integer"]
+		delete(n.types, "integer")
+	}
+	types := make([]string, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+
+	if n.strings > 0 {
+		for _, format := range []string{"date-time", "uuid"} {
+			if n.formats[format] == n.strings {
+				schema["format"] = format
+				break
+			}
+		}
+	}
+
+	if n.types["object"] > 0 {
+		properties := make(map[string]interface{}, len(n.properties))
+		required := []string{}
+		for key, property := range n.properties {
+			properties[key] = property.schema()
+			if property.seen == n.types["object"] {
+				required = append(required, key)
+			}
+		}
+		sort.Strings(required)
+		schema["properties"] = properties
+		schema["required"] = required
+		schema["additionalProperties"] = false
+	}
+
+	if n.items != nil && n.items.seen > 0 {
+		schema["items"] = n.items.schema()
+	}
+	return schema
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdWebhookMaxSubscriptions int
+)
+
+var (
+	ErrSubscriptionLimit = errors.New("maximum number of the webhook subscriptions is reached")
+)
+
+/*
+Subscription is a webhook receiving the processing outcome of the events matching its event types, all the event types if empty.
+Secret signs the delivered payloads so the subscriber can verify them and is never reported back after the creation.
+*/
+type Subscription struct {
+	ID             string     `json:"id"`
+	URL            string     `json:"url"`
+	EventTypes     []string   `json:"event_types"`
+	Secret         string     `json:"-"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Delivered      int64      `json:"delivered"`
+	Failed         int64      `json:"failed"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+/*
+Matches reports whether the subscription receives the events of the event type
+*/
+func (s *Subscription) Matches(eventType string) bool {
+	return len(s.EventTypes) == 0 || slices.Contains(s.EventTypes, eventType)
+}
+
+/*
+SubscriptionStore keeps the webhook subscriptions in memory, subscribers should subscribe again after a restart
+*/
+type SubscriptionStore struct {
+	Capacity      int
+	mu            sync.RWMutex
+	subscriptions []*Subscription // ordered by the creation time
+}
+
+func NewSubscriptionStore() *SubscriptionStore {
+	return &SubscriptionStore{
+		Capacity: CmdWebhookMaxSubscriptions,
+	}
+}
+
+/*
+Add registers the subscription. It returns ErrSubscriptionLimit if the store is full.
+*/
+func (ss *SubscriptionStore) Add(ctx context.Context, subscription *Subscription) error {
+	_, span := otel.Tracer("SubscriptionStore.Add.Tracer").Start(ctx, "SubscriptionStore.Add.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("subscription.id", subscription.ID))
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if len(ss.subscriptions) >= ss.Capacity {
+		span.RecordError(ErrSubscriptionLimit)
+		return ErrSubscriptionLimit
+	}
+	ss.subscriptions = append(ss.subscriptions, subscription)
+	return nil
+}
+
+/*
+List returns a copy of all the subscriptions
+*/
+func (ss *SubscriptionStore) List(ctx context.Context) []*Subscription {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	subscriptions := make([]*Subscription, 0, len(ss.subscriptions))
+	for _, subscription := range ss.subscriptions {
+		cSubscription := *subscription
+		subscriptions = append(subscriptions, &cSubscription)
+	}
+	return subscriptions
+}
+
+/*
+Delete removes the subscription and returns it. Deliveries already queued for the subscription are still sent.
+*/
+func (ss *SubscriptionStore) Delete(ctx context.Context, id string) (*Subscription, bool) {
+	_, span := otel.Tracer("SubscriptionStore.Delete.Tracer").Start(ctx, "SubscriptionStore.Delete.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("subscription.id", id))
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for i, subscription := range ss.subscriptions {
+		if subscription.ID == id {
+			ss.subscriptions = append(ss.subscriptions[:i], ss.subscriptions[i+1:]...)
+			return subscription, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Matching returns a copy of the subscriptions receiving the events of the event type. nil store has no subscriptions.
+*/
+func (ss *SubscriptionStore) Matching(eventType string) []*Subscription {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var subscriptions []*Subscription
+	for _, subscription := range ss.subscriptions {
+		if subscription.Matches(eventType) {
+			cSubscription := *subscription
+			subscriptions = append(subscriptions, &cSubscription)
+		}
+	}
+	return subscriptions
+}
+
+/*
+RecordDelivery updates the delivery counters of the subscription with the final outcome of a delivery, err is nil if it's delivered
+*/
+func (ss *SubscriptionStore) RecordDelivery(id string, err error) {
+	if ss == nil {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, subscription := range ss.subscriptions {
+		if subscription.ID != id {
+			continue
+		}
+		now := time.Now()
+		subscription.LastDeliveryAt = &now
+		if err != nil {
+			subscription.Failed++
+			subscription.LastError = err.Error()
+		} else {
+			subscription.Delivered++
+		}
+		return
+	}
+}
@@ -0,0 +1,137 @@
+package helpers
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// characters not allowed in the name of the OpenAPI components, e.g. brackets of the generic types
+var componentNameRX = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+/*
+OpenAPISchemas generates the OpenAPI 3 schemas of the go types out of their json encoding.
+Named struct types are registered once as components and referenced, anonymous and embedded structs are inlined.
+*/
+type OpenAPISchemas struct {
+	Components map[string]interface{}
+}
+
+func NewOpenAPISchemas() *OpenAPISchemas {
+	return &OpenAPISchemas{Components: make(map[string]interface{})}
+}
+
+/*
+SchemaOf returns the schema of the value's type
+*/
+func (s *OpenAPISchemas) SchemaOf(v interface{}) map[string]interface{} {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *OpenAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "duration in nanoseconds"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := componentNameRX.ReplaceAllString(t.Name(), "_")
+		// registering the component before generating its schema stops the recursion of the self referencing types
+		if _, found := s.Components[name]; !found {
+			s.Components[name] = map[string]interface{}{}
+			s.Components[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		// interfaces can hold any value
+		return map[string]interface{}{}
+	}
+}
+
+func (s *OpenAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	s.addProperties(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+/*
+addProperties adds the json encoded fields of the struct to the properties. Fields without omitempty are always encoded, so they're required.
+*/
+func (s *OpenAPISchemas) addProperties(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		// fields of the embedded structs are promoted to the parent by the json encoding
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			s.addProperties(fieldType, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package helpers
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// environment variables expected to be populated by the kubernetes downward api using resourceFieldRef of limits.cpu and limits.memory
+const (
+	EnvCPULimit    = "BEHAVOX_CPU_LIMIT"
+	EnvMemoryLimit = "BEHAVOX_MEMORY_LIMIT"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroup v1 reports a huge page aligned value instead of max when the memory isn't limited
+const cgroupV1UnlimitedMemory = 1 << 62
+
+/*
+ResourceLimits are the cpu and memory limits of the container. Zero values mean the resource isn't limited or the limit can't be detected.
+*/
+type ResourceLimits struct {
+	CPU          float64 `json:"cpu,omitempty"` // number of cpu cores, can be fractional
+	CPUSource    string  `json:"cpu_source,omitempty"`
+	Memory       int64   `json:"memory,omitempty"` // bytes
+	MemorySource string  `json:"memory_source,omitempty"`
+}
+
+/*
+DetectResourceLimits detects the limits from the downward api environment variables and falls back to the cgroups v2 and v1 files
+*/
+func DetectResourceLimits() ResourceLimits {
+	limits := ResourceLimits{}
+	if cpu, ok := parseCPUQuantity(os.Getenv(EnvCPULimit)); ok {
+		limits.CPU, limits.CPUSource = cpu, "downward_api"
+	} else if cpu, ok := cgroupV2CPU(); ok {
+		limits.CPU, limits.CPUSource = cpu, "cgroup_v2"
+	} else if cpu, ok := cgroupV1CPU(); ok {
+		limits.CPU, limits.CPUSource = cpu, "cgroup_v1"
+	}
+
+	if memory, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(EnvMemoryLimit)), 10, 64); err == nil && memory > 0 {
+		limits.Memory, limits.MemorySource = memory, "downward_api"
+	} else if memory, ok := readCgroupInt(cgroupV2Path("memory.max")); ok {
+		limits.Memory, limits.MemorySource = memory, "cgroup_v2"
+	} else if memory, ok := readCgroupInt(filepath.Join(cgroupRoot, "memory", "memory.limit_in_bytes")); ok && memory < cgroupV1UnlimitedMemory {
+		limits.Memory, limits.MemorySource = memory, "cgroup_v1"
+	}
+	return limits
+}
+
+/*
+parseCPUQuantity parses the cpu limit either in cores (e.g. 2 or 0.5) or millicores (e.g. 500m)
+*/
+func parseCPUQuantity(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	divisor := 1.0
+	if strings.HasSuffix(value, "m") {
+		value, divisor = strings.TrimSuffix(value, "m"), 1000
+	}
+	cpu, err := strconv.ParseFloat(value, 64)
+	if err != nil || cpu <= 0 {
+		return 0, false
+	}
+	return cpu / divisor, true
+}
+
+/*
+cgroupV2Path returns the path of the cgroup v2 interface file of the process. Containers usually see their own cgroup as the root,
+otherwise the cgroup of the process is read from /proc/self/cgroup.
+*/
+func cgroupV2Path(name string) string {
+	file, err := os.Open("/proc/self/cgroup")
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// cgroup v2 entry has the "0::<path>" format
+			if path, found := strings.CutPrefix(scanner.Text(), "0::"); found {
+				candidate := filepath.Join(cgroupRoot, path, name)
+				if _, err := os.Stat(candidate); err == nil {
+					return candidate
+				}
+			}
+		}
+	}
+	return filepath.Join(cgroupRoot, name)
+}
+
+/*
+cgroupV2CPU reads the "<quota> <period>" of cpu.max. quota is max when the cpu isn't limited.
+*/
+func cgroupV2CPU() (float64, bool) {
+	content, err := os.ReadFile(cgroupV2Path("cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+	return cpuQuota(fields[0], fields[1])
+}
+
+/*
+cgroupV1CPU reads the cfs quota and period. quota is -1 when the cpu isn't limited.
+*/
+func cgroupV1CPU() (float64, bool) {
+	quota, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func cpuQuota(quota string, period string) (float64, bool) {
+	nQuota, err := strconv.ParseFloat(quota, 64)
+	if err != nil || nQuota <= 0 {
+		return 0, false
+	}
+	nPeriod, err := strconv.ParseFloat(period, 64)
+	if err != nil || nPeriod <= 0 {
+		return 0, false
+	}
+	return nQuota / nPeriod, true
+}
+
+/*
+readCgroupInt reads a cgroup interface file containing a single number. max means the resource isn't limited.
+*/
+func readCgroupInt(path string) (int64, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	return value, true
+}
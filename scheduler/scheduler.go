@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdSchedulerProducer string
+)
+
+// status of the runs of the schedules
+const (
+	statusEnqueued = "enqueued"
+	statusDropped  = "dropped"
+)
+
+var errNotAccepting = errors.New("server doesn't accept new events")
+
+// the scheduler checks the schedules at least once per this interval, e.g. after the clock of the host is adjusted
+const maxSleep = time.Minute
+
+/*
+Scheduler injects the events of the recurring schedules registered by the admins into the same queue as the api
+*/
+type Scheduler struct {
+	logger    *zerolog.Logger
+	store     *data.ScheduleStore
+	eq        *data.EventQueue
+	accepting func() bool // schedules aren't run once the server stops accepting new events
+}
+
+func NewScheduler(logger *zerolog.Logger, store *data.ScheduleStore, eq *data.EventQueue, accepting func() bool) *Scheduler {
+	return &Scheduler{
+		logger:    logger,
+		store:     store,
+		eq:        eq,
+		accepting: accepting,
+	}
+}
+
+/*
+Run sleeps until the next run of the schedules and enqueues the events of the due schedules, it blocks until the context is cancelled
+*/
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Info().Msg("started the scheduler of the recurring events")
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.store.Changed():
+		case <-timer.C:
+			s.runDue(ctx, time.Now())
+		}
+
+		sleep := maxSleep
+		if next := s.store.NextRun(); !next.IsZero() {
+			sleep = min(max(time.Until(next), 0), maxSleep)
+		}
+		timer.Stop()
+		select {
+		case <-timer.C:
+		default:
+		}
+		timer.Reset(sleep)
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) {
+	for _, schedule := range s.store.Due(now) {
+		// the run is skipped rather than postponed, so a draining server doesn't enqueue all of them at once afterwards
+		err := errNotAccepting
+		if s.accepting() {
+			err = s.run(ctx, &schedule)
+		}
+		status := statusEnqueued
+		if err != nil {
+			status = statusDropped
+			s.logger.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to enqueue the event of the schedule")
+		}
+		observ.PromScheduledEvents.WithLabelValues(schedule.Event.EventType, status).Inc()
+		err = s.store.RecordRun(schedule.ID, now, err)
+		if err != nil {
+			s.logger.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to persist the run of the schedule")
+		}
+	}
+}
+
+/*
+run enqueues a new event out of the template of the schedule
+*/
+func (s *Scheduler) run(ctx context.Context, schedule *data.Schedule) error {
+	ctx, span := otel.Tracer("Scheduler.Run.Tracer").Start(ctx, "Scheduler.Run.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("schedule.id", schedule.ID))
+
+	event, err := schedule.NewEvent(uuid.NewString())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid event template")
+		return err
+	}
+	event.SetProducer(CmdSchedulerProducer)
+	span.SetAttributes(attribute.String("event.id", event.GetEventID()))
+
+	err = s.eq.PutEvent(ctx, event)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to add the event into the queue")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package soak
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	soakRequestTimeout    = 10 * time.Second
+	soakMaxAttempts       = 5
+	soakRetryBackoff      = 500 * time.Millisecond
+	soakConsumerInterval  = 200 * time.Millisecond
+	soakProgressInterval  = 30 * time.Second
+	soakReportedLostLimit = 100
+)
+
+/*
+Config of the soak test. Processed events are validated by tailing the processed events file of the target,
+so the soak test should run on the same host as the target and the target should use the json output format.
+*/
+type Config struct {
+	Target        string // base url of the target instance
+	Username      string
+	Password      string
+	Insecure      bool // skipping the tls verification of the target
+	Producers     int
+	Rate          float64 // number of events per second sent by each producer
+	Duration      time.Duration
+	DrainTimeout  time.Duration // maximum amount of time to wait for the accepted events to be processed after producing stops
+	ProcessedFile string
+	Logger        *zerolog.Logger
+}
+
+type LatencyReport struct {
+	P50 string `json:"p50"`
+	P90 string `json:"p90"`
+	P99 string `json:"p99"`
+	Max string `json:"max"`
+}
+
+/*
+Report is the outcome of the soak test. Test passes if all the accepted events are processed exactly once.
+*/
+type Report struct {
+	RunID      string         `json:"run_id"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Producers  int            `json:"producers"`
+	Sent       int64          `json:"sent"`     // number of unique events sent
+	Accepted   int64          `json:"accepted"` // number of events acknowledged by the target
+	Rejected   map[string]int `json:"rejected"` // number of events rejected by the target by the http status or error
+	Retries    int64          `json:"retries"`
+	Processed  int64          `json:"processed"` // number of unique accepted events found in the processed events file
+	Lost       int64          `json:"lost"`      // number of accepted events not processed until the end of the drain timeout
+	Duplicated int64          `json:"duplicated"`
+	OutOfOrder int64          `json:"out_of_order"` // number of events processed before an event sent earlier by the same producer
+	Throughput float64        `json:"throughput"`   // processed events per second
+	Latency    LatencyReport  `json:"latency"`      // time from sending the event until the consumer finds it processed
+	LostEvents []string       `json:"lost_events,omitempty"`
+	Passed     bool           `json:"passed"`
+}
+
+/*
+trackedEvent is the sequence tracking record of an event sent by a producer
+*/
+type trackedEvent struct {
+	producer int
+	seq      int64
+	sentAt   time.Time
+	seen     int
+}
+
+type runner struct {
+	cfg    Config
+	runID  string
+	client *http.Client
+	logger *zerolog.Logger
+
+	tokenMu sync.Mutex
+	token   string
+
+	mu         sync.Mutex
+	events     map[string]*trackedEvent // sent events by their event id, rejected events are removed
+	lastSeen   map[int]int64            // last processed sequence of each producer
+	latencies  []time.Duration
+	report     *Report
+	lastSeenAt time.Time
+}
+
+/*
+Run sends synthetic events from the producers to the target for the specified duration and validates
+every accepted event is processed exactly once. Cancelling the context stops producing early and the report is still generated.
+*/
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	runID := uuid.NewString()
+	r := &runner{
+		cfg:   cfg,
+		runID: runID,
+		client: &http.Client{
+			Timeout:   soakRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.Insecure}},
+		},
+		logger:   cfg.Logger,
+		events:   make(map[string]*trackedEvent),
+		lastSeen: make(map[int]int64),
+		report: &Report{
+			RunID:     runID,
+			StartedAt: time.Now(),
+			Producers: cfg.Producers,
+			Rejected:  make(map[string]int),
+		},
+	}
+
+	err := r.refreshToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a token from the target: %w", err)
+	}
+	// events processed before the soak starts are ignored by the consumer
+	offset, err := fileSize(cfg.ProcessedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the processed events file: %w", err)
+	}
+	r.logger.Info().
+		Str("run_id", runID).
+		Str("target", cfg.Target).
+		Int("producers", cfg.Producers).
+		Float64("rate", cfg.Rate).
+		Dur("duration", cfg.Duration).
+		Msg("starting the soak test")
+
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	defer stopConsumer()
+	consumerDone := make(chan error, 1)
+	go func() { consumerDone <- r.consume(consumerCtx, offset) }()
+
+	produceCtx, stopProducers := context.WithTimeout(ctx, cfg.Duration)
+	defer stopProducers()
+	var wg sync.WaitGroup
+	for producer := 0; producer < cfg.Producers; producer++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+			r.produce(produceCtx, producer)
+		}(producer)
+	}
+	progressDone := make(chan struct{})
+	go r.logProgress(progressDone)
+	wg.Wait()
+	r.logger.Info().Msg("producers stopped, waiting for the accepted events to be processed")
+
+	// waiting until all the accepted events are processed or the drain timeout elapses
+	deadline := time.Now().Add(cfg.DrainTimeout)
+	for r.pending() > 0 && time.Now().Before(deadline) {
+		select {
+		case err := <-consumerDone:
+			close(progressDone)
+			return nil, fmt.Errorf("consumer stopped: %w", err)
+		case <-time.After(soakConsumerInterval):
+		}
+	}
+	close(progressDone)
+	stopConsumer()
+	err = <-consumerDone
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return nil, fmt.Errorf("consumer stopped: %w", err)
+	}
+	return r.finish(), nil
+}
+
+/*
+produce sends the events of a producer at the configured rate until the context is done
+*/
+func (r *runner) produce(ctx context.Context, producer int) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.cfg.Rate))
+	defer ticker.Stop()
+	for seq := int64(1); ; seq++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// event id is derived from the sequence so the retries of the same event are deduplicated by the target
+		eventID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("%s/%d/%d", r.runID, producer, seq))).String()
+		// event is tracked before sending since it might be processed before its response is received
+		r.mu.Lock()
+		r.report.Sent++
+		r.events[eventID] = &trackedEvent{producer: producer, seq: seq, sentAt: time.Now()}
+		r.mu.Unlock()
+
+		// in progress events are still sent after producing stops so their outcome is known
+		outcome := r.send(context.WithoutCancel(ctx), eventID, producer, seq)
+		r.mu.Lock()
+		if outcome == "" {
+			r.report.Accepted++
+		} else {
+			r.report.Rejected[outcome]++
+			delete(r.events, eventID)
+		}
+		r.mu.Unlock()
+	}
+}
+
+/*
+send submits the event and retries the transient failures. It returns an empty outcome if the event is accepted
+otherwise the http status or the error of the last attempt.
+*/
+func (r *runner) send(ctx context.Context, eventID string, producer int, seq int64) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"event": map[string]interface{}{
+			"event_type": "log",
+			"event_id":   eventID,
+			"level":      "info",
+			"message":    fmt.Sprintf("soak run=%s producer=%d seq=%d", r.runID, producer, seq),
+		},
+	})
+
+	outcome := ""
+	for attempt := 1; attempt <= soakMaxAttempts; attempt++ {
+		if attempt > 1 {
+			r.mu.Lock()
+			r.report.Retries++
+			r.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return outcome
+			case <-time.After(soakRetryBackoff * time.Duration(attempt-1)):
+			}
+		}
+		status, err := r.post(ctx, "/v1/events", body)
+		switch {
+		case err != nil:
+			outcome = "error"
+			r.logger.Debug().Err(err).Str("event_id", eventID).Msg("failed to send the event")
+			continue
+		case status == http.StatusCreated || status == http.StatusAccepted:
+			return ""
+		case status == http.StatusConflict && attempt > 1:
+			// a previous attempt reached the target even though its response was lost
+			return ""
+		case status == http.StatusUnauthorized:
+			outcome = http.StatusText(status)
+			if r.refreshToken(ctx) != nil {
+				return outcome
+			}
+			continue
+		case status == http.StatusTooManyRequests || status >= http.StatusInternalServerError:
+			outcome = http.StatusText(status)
+			continue
+		default:
+			return http.StatusText(status)
+		}
+	}
+	return outcome
+}
+
+func (r *runner) post(ctx context.Context, path string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Target+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r.tokenMu.Lock()
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	r.tokenMu.Unlock()
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	return res.StatusCode, nil
+}
+
+/*
+refreshToken issues a new token for the producers using the basic authentication credentials
+*/
+func (r *runner) refreshToken(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Target+"/v1/tokens", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("target responded with status %d", res.StatusCode)
+	}
+	var tokenRes struct {
+		Result struct {
+			Token string `json:"token"`
+		} `json:"result"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tokenRes)
+	if err != nil {
+		return err
+	}
+	r.tokenMu.Lock()
+	r.token = tokenRes.Result.Token
+	r.tokenMu.Unlock()
+	return nil
+}
+
+/*
+consume tails the processed events file from the offset and tracks the processed events of this run until the context is done
+*/
+func (r *runner) consume(ctx context.Context, offset int64) error {
+	ticker := time.NewTicker(soakConsumerInterval)
+	defer ticker.Stop()
+	var partial []byte // last line which isn't completely written yet
+	for {
+		size, err := fileSize(r.cfg.ProcessedFile)
+		if err != nil {
+			return err
+		}
+		if size < offset {
+			// file is truncated or rotated
+			offset, partial = 0, nil
+		}
+		if size > offset {
+			file, err := os.Open(r.cfg.ProcessedFile)
+			if err != nil {
+				return err
+			}
+			var read int64
+			_, err = file.Seek(offset, io.SeekStart)
+			if err == nil {
+				// file might be grown after its size is checked, so the offset is moved by the bytes actually read
+				read, partial, err = r.consumeLines(bufio.NewReader(file), partial)
+			}
+			file.Close()
+			if err != nil {
+				return err
+			}
+			offset += read
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+consumeLines observes the complete lines of the reader and returns the number of bytes read along with the incomplete last line
+*/
+func (r *runner) consumeLines(reader *bufio.Reader, partial []byte) (int64, []byte, error) {
+	var read int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		read += int64(len(line))
+		if errors.Is(err, io.EOF) {
+			return read, append(partial, line...), nil
+		}
+		if err != nil {
+			return read, nil, err
+		}
+		line = append(partial, line...)
+		partial = nil
+
+		var processed struct {
+			Event struct {
+				EventID string `json:"EventID"`
+			} `json:"Event"`
+		}
+		if json.Unmarshal(line, &processed) != nil {
+			continue
+		}
+		r.observe(processed.Event.EventID)
+	}
+}
+
+/*
+observe tracks the processed event if it's sent by this run
+*/
+func (r *runner) observe(eventID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event, found := r.events[eventID]
+	if !found {
+		return
+	}
+	event.seen++
+	if event.seen > 1 {
+		r.report.Duplicated++
+		return
+	}
+	r.report.Processed++
+	r.lastSeenAt = time.Now()
+	r.latencies = append(r.latencies, time.Since(event.sentAt))
+	if event.seq < r.lastSeen[event.producer] {
+		r.report.OutOfOrder++
+	} else {
+		r.lastSeen[event.producer] = event.seq
+	}
+}
+
+/*
+pending returns the number of accepted events which are not processed yet
+*/
+func (r *runner) pending() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.report.Accepted - r.report.Processed
+}
+
+func (r *runner) logProgress(done chan struct{}) {
+	ticker := time.NewTicker(soakProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		r.logger.Info().
+			Int64("sent", r.report.Sent).
+			Int64("accepted", r.report.Accepted).
+			Int64("processed", r.report.Processed).
+			Int64("duplicated", r.report.Duplicated).
+			Int64("retries", r.report.Retries).
+			Msg("soak test progress")
+		r.mu.Unlock()
+	}
+}
+
+/*
+finish builds the final report out of the tracked events
+*/
+func (r *runner) finish() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := r.report
+	report.FinishedAt = time.Now()
+	lost := make([]*trackedEvent, 0)
+	lostIDs := make(map[*trackedEvent]string)
+	for eventID, event := range r.events {
+		if event.seen == 0 {
+			lost = append(lost, event)
+			lostIDs[event] = eventID
+		}
+	}
+	report.Lost = int64(len(lost))
+	sort.Slice(lost, func(i, j int) bool {
+		if lost[i].producer != lost[j].producer {
+			return lost[i].producer < lost[j].producer
+		}
+		return lost[i].seq < lost[j].seq
+	})
+	for i := 0; i < len(lost) && i < soakReportedLostLimit; i++ {
+		report.LostEvents = append(report.LostEvents, fmt.Sprintf("%s (producer=%d seq=%d)", lostIDs[lost[i]], lost[i].producer, lost[i].seq))
+	}
+
+	if elapsed := r.lastSeenAt.Sub(report.StartedAt).Seconds(); report.Processed > 0 && elapsed > 0 {
+		report.Throughput = float64(report.Processed) / elapsed
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	percentile := func(p float64) string {
+		if len(r.latencies) == 0 {
+			return "0s"
+		}
+		return r.latencies[int(p*float64(len(r.latencies)-1))].Round(time.Millisecond).String()
+	}
+	report.Latency = LatencyReport{P50: percentile(0.5), P90: percentile(0.9), P99: percentile(0.99), Max: percentile(1)}
+	report.Passed = report.Lost == 0 && report.Duplicated == 0
+	return report
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+)
+
+var (
+	CmdWorkerAutoscale              bool
+	CmdMinWorkerGoroutines          int
+	CmdWorkerAutoscaleInterval      time.Duration
+	CmdWorkerAutoscaleCooldown      time.Duration
+	CmdWorkerAutoscaleTargetLatency time.Duration
+)
+
+/*
+workerPool limits the number of the events processed concurrently by the worker threads. Unlike a semaphore its size can be changed
+while the events are processed, the busy threads above a shrunk size finish their events and aren't replaced.
+*/
+type workerPool struct {
+	mu           sync.Mutex
+	size         int
+	busy         int
+	freed        chan struct{} // receives a signal once a thread is available
+	latencySum   time.Duration // latencies of the events processed since the last takeLatency
+	latencyCount int
+}
+
+func newWorkerPool(size int) *workerPool {
+	observ.PromWorkerPoolSize.WithLabelValues().Set(float64(size))
+	return &workerPool{size: size, freed: make(chan struct{}, 1)}
+}
+
+/*
+acquire waits for an available thread, it returns false if ctx is done meanwhile
+*/
+func (p *workerPool) acquire(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		if p.busy < p.size {
+			p.busy++
+			p.mu.Unlock()
+			return true
+		}
+		p.mu.Unlock()
+		select {
+		case <-p.freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+/*
+release gives the thread back, latency is the time from enqueueing the processed event until it's finished or zero if the event isn't processed
+*/
+func (p *workerPool) release(latency time.Duration) {
+	p.mu.Lock()
+	p.busy--
+	if latency > 0 {
+		p.latencySum += latency
+		p.latencyCount++
+	}
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *workerPool) signal() {
+	select {
+	case p.freed <- struct{}{}:
+	default:
+	}
+}
+
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	p.size = size
+	p.mu.Unlock()
+	observ.PromWorkerPoolSize.WithLabelValues().Set(float64(size))
+	p.signal()
+}
+
+/*
+state returns the current size and the number of the busy threads
+*/
+func (p *workerPool) state() (size int, busy int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, p.busy
+}
+
+/*
+takeLatency returns the average latency of the events processed since the last call
+*/
+func (p *workerPool) takeLatency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var avg time.Duration
+	if p.latencyCount > 0 {
+		avg = p.latencySum / time.Duration(p.latencyCount)
+	}
+	p.latencySum, p.latencyCount = 0, 0
+	return avg
+}
+
+/*
+autoscale adjusts the pool size between --event-queue-min-worker-threads and --event-queue-max-worker-threads on every interval.
+The pool grows by half of its size once the queue holds more events than the threads or the average latency of the processed events exceeds the target,
+and shrinks by one thread while the queue is empty and less than half of the threads are busy. Shrinking waits for the cooldown since the last scaling,
+so a short lull doesn't drop the threads needed by the next burst.
+*/
+func (w *Worker) autoscale(ctx context.Context) {
+	ticker := time.NewTicker(CmdWorkerAutoscaleInterval)
+	defer ticker.Stop()
+	lastScaled := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		size, busy := w.pool.state()
+		depth := w.EventQueue.Size(ctx)
+		latency := w.pool.takeLatency()
+
+		newSize := size
+		switch {
+		case depth > size || latency > CmdWorkerAutoscaleTargetLatency:
+			newSize = min(CmdmaxWorkerGoroutines, size+max(1, size/2))
+		case depth == 0 && busy < size/2 && time.Since(lastScaled) >= CmdWorkerAutoscaleCooldown:
+			newSize = max(CmdMinWorkerGoroutines, size-1)
+		}
+		if newSize == size {
+			continue
+		}
+		w.pool.resize(newSize)
+		lastScaled = time.Now()
+		w.Logger.Info().
+			Int("from", size).
+			Int("to", newSize).
+			Int("queue_size", depth).
+			Dur("latency", latency).
+			Msg("worker pool is scaled")
+	}
+}
@@ -0,0 +1,22 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+/*
+loadAWSConfig returns the aws sdk configuration of the region. The static access keys, along with the session token of the temporary sts credentials,
+are used if they're specified, otherwise the default credential chain resolves them, e.g. the environment variables, the shared config files,
+the web identity tokens of the kubernetes service accounts (IRSA) or the instance roles.
+*/
+func loadAWSConfig(ctx context.Context, region string, accessKeyID string, secretAccessKey string, sessionToken string) (aws.Config, error) {
+	options := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	if accessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken)))
+	}
+	return config.LoadDefaultConfig(ctx, options...)
+}
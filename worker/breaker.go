@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	"github.com/rs/zerolog"
+)
+
+var (
+	CmdCircuitBreakerFailureRate    float64
+	CmdCircuitBreakerMinEvents      int
+	CmdCircuitBreakerWindow         time.Duration
+	CmdCircuitBreakerOpenDuration   time.Duration
+	CmdCircuitBreakerHalfOpenProbes int
+)
+
+// states of the circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var breakerStates = []string{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+/*
+circuitBreaker stops the worker from taking the events once the failure rate of processing them exceeds the threshold within the window,
+e.g. when the sink is broken, so the queued events wait for the downstream instead of being dead lettered one after another.
+After the open duration the breaker is half open and lets the probe events through, it closes once all the probes succeed and opens again on a failing probe.
+Only the failures which aren't caused by the event itself are counted, e.g. an invalid event doesn't open the breaker.
+*/
+type circuitBreaker struct {
+	mu             sync.Mutex
+	logger         *zerolog.Logger
+	state          string
+	windowStart    time.Time
+	succeeded      int
+	failed         int
+	openedAt       time.Time
+	probes         int // probe events being processed while the breaker is half open
+	probeSucceeded int
+	changed        chan struct{} // closed once the breaker lets more events through
+}
+
+/*
+newCircuitBreaker returns nil if the failure rate threshold isn't configured, which disables the circuit breaker
+*/
+func newCircuitBreaker(logger *zerolog.Logger) *circuitBreaker {
+	if CmdCircuitBreakerFailureRate <= 0 {
+		return nil
+	}
+	cb := &circuitBreaker{logger: logger, windowStart: time.Now(), changed: make(chan struct{})}
+	cb.setState(BreakerClosed)
+	return cb
+}
+
+/*
+setState moves the breaker to the state, it should be called while holding the lock
+*/
+func (cb *circuitBreaker) setState(state string) {
+	cb.state = state
+	for _, s := range breakerStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		observ.PromWorkerCircuitBreakerState.WithLabelValues(s).Set(value)
+	}
+}
+
+/*
+notify wakes up the worker waiting for the breaker, it should be called while holding the lock
+*/
+func (cb *circuitBreaker) notify() {
+	close(cb.changed)
+	cb.changed = make(chan struct{})
+}
+
+/*
+admit reports whether the worker can take the next event out of the queue. Otherwise the worker should wait until changed is closed
+or the retryAfter duration elapses, whichever is first. retryAfter is zero if only changed should be waited for.
+*/
+func (cb *circuitBreaker) admit(now time.Time) (admitted bool, changed <-chan struct{}, retryAfter time.Duration) {
+	if cb == nil {
+		return true, nil, 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == BreakerOpen {
+		reopenAt := cb.openedAt.Add(CmdCircuitBreakerOpenDuration)
+		if now.Before(reopenAt) {
+			return false, cb.changed, reopenAt.Sub(now)
+		}
+		cb.setState(BreakerHalfOpen)
+		cb.probes, cb.probeSucceeded = 0, 0
+		cb.logger.Info().Msg("circuit breaker is half open, probing the processing with the next events")
+	}
+	if cb.state == BreakerHalfOpen && cb.probes+cb.probeSucceeded >= CmdCircuitBreakerHalfOpenProbes {
+		return false, cb.changed, 0
+	}
+	return true, nil, 0
+}
+
+/*
+begin registers the event taken out of the queue after it's admitted, the events taken while the breaker is half open are the probes
+*/
+func (cb *circuitBreaker) begin() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == BreakerHalfOpen {
+		cb.probes++
+	}
+}
+
+/*
+record counts the processing outcome of the event and reports whether the breaker is open afterwards,
+in which case the failed event should be put back into the queue instead of being dead lettered
+*/
+func (cb *circuitBreaker) record(err error, now time.Time) (open bool) {
+	if cb == nil {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	counted := err == nil || breakerFailure(err)
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		switch {
+		case !counted:
+			cb.notify()
+		case err != nil:
+			cb.open(now, "probe event failed")
+		default:
+			cb.probeSucceeded++
+			if cb.probeSucceeded >= CmdCircuitBreakerHalfOpenProbes {
+				cb.setState(BreakerClosed)
+				cb.windowStart, cb.succeeded, cb.failed = now, 0, 0
+				cb.logger.Info().Msg("circuit breaker is closed, the probe events succeeded")
+			}
+			cb.notify()
+		}
+	case BreakerClosed:
+		if !counted {
+			break
+		}
+		if now.Sub(cb.windowStart) > CmdCircuitBreakerWindow {
+			cb.windowStart, cb.succeeded, cb.failed = now, 0, 0
+		}
+		if err != nil {
+			cb.failed++
+		} else {
+			cb.succeeded++
+		}
+		total := cb.succeeded + cb.failed
+		if total >= CmdCircuitBreakerMinEvents && float64(cb.failed)*100/float64(total) >= CmdCircuitBreakerFailureRate {
+			cb.open(now, "failure rate exceeded the threshold")
+		}
+	}
+	return cb.state == BreakerOpen && err != nil && counted
+}
+
+/*
+open opens the breaker, it should be called while holding the lock
+*/
+func (cb *circuitBreaker) open(now time.Time, reason string) {
+	cb.logger.Warn().
+		Str("reason", reason).
+		Int("succeeded", cb.succeeded).
+		Int("failed", cb.failed).
+		Dur("open_duration", CmdCircuitBreakerOpenDuration).
+		Msg("circuit breaker is open, the worker stops taking the events")
+	cb.setState(BreakerOpen)
+	cb.openedAt = now
+	cb.probes, cb.probeSucceeded = 0, 0
+	observ.PromWorkerCircuitBreakerOpened.WithLabelValues().Inc()
+}
+
+/*
+currentState returns the current state of the breaker, empty if it's disabled
+*/
+func (cb *circuitBreaker) currentState() string {
+	if cb == nil {
+		return ""
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+/*
+breakerFailure reports whether the processing failure is counted by the circuit breaker, the failures caused by the event itself aren't
+*/
+func breakerFailure(err error) bool {
+	return !errors.Is(err, ErrEventValidation) && !errors.Is(err, ErrEventSerialization) && !errors.Is(err, ErrEventDecompression) &&
+		!errors.Is(err, ErrEventPanic) && !errors.Is(err, ErrEventCrash)
+}
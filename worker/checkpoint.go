@@ -0,0 +1,322 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdWorkerCheckpointFile string
+)
+
+/*
+WorkerCheckpoint is the state of the checkpoint file, the snapshots of the events taken out of the queue by the worker and not processed yet
+*/
+type WorkerCheckpoint struct {
+	InFlight   map[string]*data.EventSnapshot
+	Recoveries map[string]int  // number of the times the events are recovered after a crash, counted as their crashes by the poison detection
+	Stopped    map[string]bool // events whose processing is stopped by the shutdown, they're recovered without counting a crash
+}
+
+const (
+	checkpointOpBegin    = "begin"
+	checkpointOpComplete = "complete"
+	checkpointOpStop     = "stop"
+)
+
+// the checkpoint file isn't compacted before it has this many records, so a few in-flight events don't compact it on every record
+const checkpointCompactMinRecords = 1000
+
+/*
+checkpointRecord is a line of the checkpoint file, the state is rebuilt by replaying the records from the oldest to the newest
+*/
+type checkpointRecord struct {
+	Op         string              `json:"op"`
+	EventID    string              `json:"event_id"`
+	Snapshot   *data.EventSnapshot `json:"snapshot,omitempty"`
+	Recoveries int                 `json:"recoveries,omitempty"`
+}
+
+/*
+checkpointStore persists the events being processed by the worker, so the events dequeued but never completed because of a crash
+are put back into the queue on the next startup. Taking and completing an event appends a record to the checkpoint file,
+and the file is compacted to the events still in flight once it grows to twice of them, like the idempotency file.
+*/
+type checkpointStore struct {
+	mu         sync.Mutex
+	path       string
+	inFlight   map[string]*data.EventSnapshot
+	recoveries map[string]int
+	stopped    map[string]bool
+	file       *os.File
+	appended   int // number of the records inside the file
+}
+
+/*
+newCheckpointStore returns nil if no checkpoint file is configured, which disables the checkpointing
+*/
+func newCheckpointStore(path string) *checkpointStore {
+	if path == "" {
+		return nil
+	}
+	return &checkpointStore{path: path, inFlight: make(map[string]*data.EventSnapshot), recoveries: make(map[string]int), stopped: make(map[string]bool)}
+}
+
+/*
+load replays the records of the checkpoint left by the previous run
+*/
+func (cs *checkpointStore) load() (*WorkerCheckpoint, error) {
+	checkpoint := &WorkerCheckpoint{InFlight: make(map[string]*data.EventSnapshot), Recoveries: make(map[string]int), Stopped: make(map[string]bool)}
+	file, err := os.Open(cs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record checkpointRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// the last record might be cut by the crash of the process while it's being appended
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, fmt.Errorf("invalid worker checkpoint file %s: %w", cs.path, err)
+		}
+		switch record.Op {
+		case checkpointOpBegin:
+			checkpoint.InFlight[record.EventID] = record.Snapshot
+			if record.Recoveries > 0 {
+				checkpoint.Recoveries[record.EventID] = record.Recoveries
+			}
+		case checkpointOpComplete:
+			delete(checkpoint.InFlight, record.EventID)
+			delete(checkpoint.Recoveries, record.EventID)
+			delete(checkpoint.Stopped, record.EventID)
+		case checkpointOpStop:
+			checkpoint.Stopped[record.EventID] = true
+		}
+	}
+	return checkpoint, nil
+}
+
+/*
+begin checkpoints the event taken out of the queue before it's processed
+*/
+func (cs *checkpointStore) begin(event data.Event) error {
+	if cs == nil {
+		return nil
+	}
+	snapshot, err := data.NewEventSnapshot(event)
+	if err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.inFlight[event.GetEventID()] = snapshot
+	return cs.append(&checkpointRecord{Op: checkpointOpBegin, EventID: event.GetEventID(), Snapshot: snapshot, Recoveries: cs.recoveries[event.GetEventID()]})
+}
+
+/*
+complete removes the event from the checkpoint once its processing is finished, whatever the outcome is
+*/
+func (cs *checkpointStore) complete(eventID string) error {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, found := cs.inFlight[eventID]; !found {
+		return nil
+	}
+	delete(cs.inFlight, eventID)
+	delete(cs.recoveries, eventID)
+	delete(cs.stopped, eventID)
+	return cs.append(&checkpointRecord{Op: checkpointOpComplete, EventID: eventID})
+}
+
+/*
+stop keeps the event in the checkpoint when its processing is stopped by the shutdown, so it's recovered on the next startup instead of being lost
+*/
+func (cs *checkpointStore) stop(eventID string) error {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, found := cs.inFlight[eventID]; !found {
+		return nil
+	}
+	cs.stopped[eventID] = true
+	return cs.append(&checkpointRecord{Op: checkpointOpStop, EventID: eventID})
+}
+
+/*
+append writes the record to the checkpoint file and compacts the file once it's grown enough. cs.mu should be held by the caller.
+A line written to the file survives a crash of the process, the file isn't synced on every record.
+*/
+func (cs *checkpointStore) append(record *checkpointRecord) error {
+	if cs.file == nil {
+		return cs.compact()
+	}
+	jRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = cs.file.Write(append(jRecord, '\n'))
+	if err != nil {
+		return err
+	}
+	cs.appended++
+	if cs.appended >= max(checkpointCompactMinRecords, 2*(len(cs.inFlight)+len(cs.stopped))) {
+		return cs.compact()
+	}
+	return nil
+}
+
+/*
+compact replaces the checkpoint file atomically with the records of the events in flight and reopens it for appending,
+so a crash during the write doesn't corrupt it. cs.mu should be held by the caller.
+*/
+func (cs *checkpointStore) compact() error {
+	if cs.file != nil {
+		cs.file.Close()
+		cs.file = nil
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(cs.path), filepath.Base(cs.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	appended := 0
+	for eventID, snapshot := range cs.inFlight {
+		err = encoder.Encode(&checkpointRecord{Op: checkpointOpBegin, EventID: eventID, Snapshot: snapshot, Recoveries: cs.recoveries[eventID]})
+		if err == nil && cs.stopped[eventID] {
+			err = encoder.Encode(&checkpointRecord{Op: checkpointOpStop, EventID: eventID})
+			appended++
+		}
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		appended++
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), cs.path)
+	if err != nil {
+		return err
+	}
+	cs.appended = appended
+	cs.file, err = os.OpenFile(cs.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	return err
+}
+
+/*
+close closes the checkpoint file
+*/
+func (cs *checkpointStore) close() error {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.file == nil {
+		return nil
+	}
+	err := cs.file.Close()
+	cs.file = nil
+	return err
+}
+
+/*
+Recover puts the events of the checkpoint left by a crashed run back into the queue, it should be called before Run.
+The events might have been written to the sinks before the crash, so they're delivered at least once.
+Events which can't be restored anymore, e.g. of the unregistered event types, are logged and dropped.
+Each recovery is counted as a crash of the event, so the events crashing the process over and over are quarantined as poison events.
+The events stopped by the shutdown didn't crash the process, so their recovery isn't counted.
+*/
+func (w *Worker) Recover(ctx context.Context) error {
+	if w.checkpoint == nil {
+		return nil
+	}
+	ctx, span := otel.Tracer("Worker.Recover.Tracer").Start(ctx, "Worker.Recover.Span")
+	defer span.End()
+
+	checkpoint, err := w.checkpoint.load()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	events := make([]data.Event, 0, len(checkpoint.InFlight))
+	snapshots := make(map[string]*data.EventSnapshot, len(checkpoint.InFlight))
+	recoveries := make(map[string]int, len(checkpoint.InFlight))
+	for eventID, snapshot := range checkpoint.InFlight {
+		event, err := snapshot.Restore()
+		if err != nil {
+			w.Logger.Error().Err(err).Str("event_id", eventID).Msg("failed to recover the checkpointed event")
+			continue
+		}
+		recovered := checkpoint.Recoveries[eventID]
+		if !checkpoint.Stopped[eventID] {
+			recovered++
+		}
+		if w.poison != nil && w.poison.add(eventID, recovered) >= w.poison.threshold {
+			w.quarantine(ctx, event, fmt.Errorf("%w: the event is recovered %d times after a crash", ErrEventCrash, recovered), recovered)
+			continue
+		}
+		snapshots[eventID] = snapshot
+		recoveries[eventID] = recovered
+		events = append(events, event)
+	}
+	span.SetAttributes(attribute.Int("checkpoint.recovered", len(events)))
+	if len(events) > 0 {
+		err = w.EventQueue.Restore(ctx, events)
+		if err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("failed to put the checkpointed events back into the queue: %w", err)
+		}
+		for _, event := range events {
+			if checkpoint.Stopped[event.GetEventID()] {
+				w.Logger.Info().Str("event_id", event.GetEventID()).Msg("event stopped by the shutdown of the previous run is put back into the queue")
+				continue
+			}
+			w.Logger.Warn().Str("event_id", event.GetEventID()).Msg("event taken out of the queue by the previous run isn't completed, it's put back into the queue")
+		}
+	}
+
+	// recovered events stay in the checkpoint until they're taken out of the queue and completed, so another crash doesn't lose them
+	w.checkpoint.mu.Lock()
+	defer w.checkpoint.mu.Unlock()
+	w.checkpoint.inFlight = snapshots
+	w.checkpoint.recoveries = recoveries
+	w.checkpoint.stopped = make(map[string]bool)
+	return w.checkpoint.compact()
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/rs/zerolog"
+)
+
+func TestCheckpointRecover(t *testing.T) {
+	data.CmdEventQueueSize, data.CmdEventIndexSize = 10, 10
+	t.Cleanup(func() {
+		data.CmdEventQueueSize, data.CmdEventIndexSize = 0, 0
+	})
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// events are taken by the worker, one is completed, one is stopped by the shutdown and one is left by a crash
+	cs := newCheckpointStore(path)
+	for _, eventID := range []string{"completed", "stopped", "crashed"} {
+		err := cs.begin(data.NewEventLog(eventID, "info", "message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cs.complete("completed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.stop("stopped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zerolog.Nop()
+	w := &Worker{Logger: &logger, EventQueue: data.NewEventQueue(), checkpoint: newCheckpointStore(path)}
+	err = w.Recover(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := w.EventQueue.Size(t.Context()); size != 2 {
+		t.Errorf("recovered events = %d, want 2", size)
+	}
+
+	tests := []struct {
+		eventID        string
+		wantRecoveries int
+	}{
+		{eventID: "stopped", wantRecoveries: 0},
+		{eventID: "crashed", wantRecoveries: 1},
+	}
+	checkpoint, err := w.checkpoint.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.eventID, func(t *testing.T) {
+			// recovered events stay in the checkpoint until they're completed
+			if checkpoint.InFlight[tt.eventID] == nil {
+				t.Error("recovered event isn't kept in the checkpoint")
+			}
+			if got := checkpoint.Recoveries[tt.eventID]; got != tt.wantRecoveries {
+				t.Errorf("recoveries = %d, want %d", got, tt.wantRecoveries)
+			}
+		})
+	}
+}
+
+func TestCheckpointCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	cs := newCheckpointStore(path)
+	t.Cleanup(func() { cs.close() })
+	for i := 0; i < 3*checkpointCompactMinRecords; i++ {
+		eventID := fmt.Sprintf("event-%d", i)
+		err := cs.begin(data.NewEventLog(eventID, "info", "message"))
+		if err == nil {
+			err = cs.complete(eventID)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cs.begin(data.NewEventLog("in-flight", "info", "message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.appended > checkpointCompactMinRecords {
+		t.Errorf("checkpoint file has %d records, want it compacted to at most %d", cs.appended, checkpointCompactMinRecords)
+	}
+
+	// the record cut by a crash while it's being appended is ignored
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteString(`{"op":"complete","event_id":"in-fl`)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := cs.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoint.InFlight) != 1 || checkpoint.InFlight["in-flight"] == nil {
+		t.Errorf("events in flight = %v, want only the in-flight event", checkpoint.InFlight)
+	}
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"maps"
+	"sync"
+)
+
+var (
+	CmdEventTypeConcurrency map[string]int
+)
+
+/*
+concurrencyLimiter keeps the concurrency budgets of the event types, so the slow event types can't take all the worker threads and the other event types keep being processed.
+Event types without a budget are only limited by the worker threads.
+*/
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limits   map[string]int
+	inFlight map[string]int
+	released chan struct{} // receives a signal once the budget of an event type is released
+}
+
+func newConcurrencyLimiter(limits map[string]int) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		limits:   maps.Clone(limits),
+		inFlight: make(map[string]int),
+		released: make(chan struct{}, 1),
+	}
+}
+
+/*
+available reports whether the event type has a free slot in its budget
+*/
+func (cl *concurrencyLimiter) available(eventType string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	limit, found := cl.limits[eventType]
+	return !found || cl.inFlight[eventType] < limit
+}
+
+/*
+acquire takes a slot of the event type budget, it should only be called after available by the run loop of the worker
+*/
+func (cl *concurrencyLimiter) acquire(eventType string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.inFlight[eventType]++
+}
+
+/*
+release gives the slot of the event type back once its event is processed
+*/
+func (cl *concurrencyLimiter) release(eventType string) {
+	cl.mu.Lock()
+	cl.inFlight[eventType]--
+	if cl.inFlight[eventType] <= 0 {
+		delete(cl.inFlight, eventType)
+	}
+	cl.mu.Unlock()
+
+	select {
+	case cl.released <- struct{}{}:
+	default:
+	}
+}
+
+/*
+snapshot returns the number of events being processed per event type
+*/
+func (cl *concurrencyLimiter) snapshot() map[string]int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return maps.Clone(cl.inFlight)
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"runtime"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+/*
+costTenant returns the tenant the processing cost of the event is charged to, which is the authenticated producer of the event
+*/
+func costTenant(event data.Event) string {
+	if event.GetProducer() == "" {
+		return "anonymous"
+	}
+	return event.GetProducer()
+}
+
+/*
+processEventWithCost processes the event and charges the cpu time spent on it to the event type and tenant of the event.
+goroutine is locked to its os thread during the processing so the cpu time of the thread is only spent on this event.
+cpu time isn't measured on the platforms without per thread cpu accounting.
+*/
+func (w *Worker) processEventWithCost(ctx context.Context, event data.Event) (*data.EventProcessResult, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	startCPU, measured := threadCPUTime()
+	result, err := w.processEvent(ctx, event)
+	if endCPU, ok := threadCPUTime(); ok && measured {
+		observ.PromEventCPUSeconds.WithLabelValues(event.GetEventType(), costTenant(event)).Add((endCPU - startCPU).Seconds())
+	}
+	return result, err
+}
+
+/*
+recordWrittenBytes charges the bytes written to the sink for the event to its event type and tenant
+*/
+func recordWrittenBytes(event data.Event, n int) {
+	if n <= 0 {
+		return
+	}
+	observ.PromEventWrittenBytes.WithLabelValues(event.GetEventType(), costTenant(event)).Add(float64(n))
+}
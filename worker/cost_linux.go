@@ -0,0 +1,18 @@
+package worker
+
+import (
+	"syscall"
+	"time"
+)
+
+/*
+threadCPUTime returns the user and system cpu time consumed by the calling thread
+*/
+func threadCPUTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_THREAD, &usage)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
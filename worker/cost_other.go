@@ -0,0 +1,12 @@
+//go:build !linux
+
+package worker
+
+import "time"
+
+/*
+threadCPUTime isn't supported since per thread cpu accounting is only available on linux
+*/
+func threadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,342 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdSinkDatabaseURL           string
+	CmdSinkDatabaseTable         string
+	CmdSinkDatabaseBatchSize     int
+	CmdSinkDatabaseBatchInterval time.Duration
+	CmdSinkDatabaseMaxConns      int
+	CmdSinkDatabaseTimeout       time.Duration
+	CmdSinkDatabaseRetention     time.Duration
+	CmdSinkDatabasePruneInterval time.Duration
+)
+
+// database/sql driver of the database sink, the pgx driver of postgres
+var databaseSinkDriver = "pgx"
+
+// table names are interpolated into the statements, so they're restricted to the plain and schema qualified identifiers
+var databaseTableRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// columns of the rows inserted by the database sink, postgres limits the parameters of a statement to 65535
+const (
+	databaseSinkColumns      = 8
+	databaseSinkMaxBatchSize = 65535 / databaseSinkColumns
+)
+
+/*
+databaseSinkMigrations are the versioned changes of the schema of the results table, applied in order and recorded with their version
+in the <table>_schema_migrations table. The statements are formatted with the table and the names of its processed_at and retain_until indexes.
+New changes are appended as the next versions, the applied ones shouldn't be edited.
+*/
+var databaseSinkMigrations = []string{
+	// 1: tables created before the migrations were versioned are adopted as they are
+	`CREATE TABLE IF NOT EXISTS %[1]s (
+	event_id text NOT NULL,
+	event_type text NOT NULL,
+	processed_at timestamptz NOT NULL,
+	digest_algorithm text NOT NULL,
+	digest text NOT NULL,
+	result jsonb NOT NULL,
+	PRIMARY KEY (event_id, processed_at)
+)`,
+	// 2: results are queried and pruned by their processing time
+	`CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (processed_at)`,
+	// 3: retention of the events overriding sink-database-retention, the results of the older versions are pruned by the latter
+	`ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS retain_until timestamptz, ADD COLUMN IF NOT EXISTS legal_hold boolean NOT NULL DEFAULT false`,
+	// 4: results pruned by the retention of their events, the ones on legal hold are never pruned
+	`CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (retain_until) WHERE NOT legal_hold`,
+}
+
+/*
+databaseSink inserts the process results as the rows of the postgres table, whose schema is migrated to the latest version on the first write.
+Rows are keyed by the event id and the processing time, so retrying a failed write doesn't duplicate the result while reprocessing the event adds a new row.
+The whole result is kept as jsonb besides the columns commonly queried.
+The results written concurrently are inserted together by a single statement once sink-database-batch-size of them are pending or the first of them
+has waited for sink-database-batch-interval. Writes return once their batch is committed, so a failed insert still fails the events of the batch.
+*/
+type databaseSink struct {
+	db        *sql.DB
+	openErr   error // error of opening the database, returned by the writes
+	table     string
+	batchSize int
+	interval  time.Duration
+
+	migrateMu sync.Mutex
+	migrated  bool
+
+	mu      sync.Mutex
+	pending *databaseBatch // batch the results are appended to
+}
+
+/*
+databaseBatch is the results inserted by a single statement, done is closed once they're committed or failed by err
+*/
+type databaseBatch struct {
+	args    []any
+	results []*data.EventProcessResult
+	sizes   []int
+	timer   *time.Timer
+	done    chan struct{}
+	err     error
+}
+
+func newDatabaseSink(logger *zerolog.Logger) *databaseSink {
+	ds := &databaseSink{table: CmdSinkDatabaseTable, batchSize: CmdSinkDatabaseBatchSize, interval: CmdSinkDatabaseBatchInterval}
+	ds.db, ds.openErr = sql.Open(databaseSinkDriver, CmdSinkDatabaseURL)
+	if ds.openErr != nil {
+		logger.Error().Err(ds.openErr).Msg("failed to open the database of the database sink")
+		return ds
+	}
+	ds.db.SetMaxOpenConns(CmdSinkDatabaseMaxConns)
+	ds.db.SetMaxIdleConns(CmdSinkDatabaseMaxConns)
+	if CmdSinkDatabasePruneInterval > 0 {
+		go ds.pruneLoop(logger)
+	}
+	return ds
+}
+
+func (ds *databaseSink) Name() string { return SinkDatabase }
+
+/*
+migrate applies the migrations of the table which aren't applied yet once, failures are retried by the next batch.
+The sinks of the replicas sharing the table apply them one at a time under an advisory lock of the transaction.
+*/
+func (ds *databaseSink) migrate(ctx context.Context) error {
+	ds.migrateMu.Lock()
+	defer ds.migrateMu.Unlock()
+	if ds.migrated {
+		return nil
+	}
+	migrationsTable := ds.table + "_schema_migrations"
+	// indexes are created in the schema of their table, so their names aren't qualified
+	name := ds.table[strings.LastIndex(ds.table, ".")+1:]
+
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to migrate the table %s: %w", ds.table, err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, migrationsTable)
+	if err != nil {
+		return fmt.Errorf("failed to lock the migrations of the table %s: %w", ds.table, err)
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version integer PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`, migrationsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create the table %s: %w", migrationsTable, err)
+	}
+	var version int
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, migrationsTable)).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version of the table %s: %w", ds.table, err)
+	}
+	for ; version < len(databaseSinkMigrations); version++ {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(databaseSinkMigrations[version], ds.table, name+"_processed_at_idx", name+"_retain_until_idx"))
+		if err == nil {
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (version) VALUES ($1)`, migrationsTable), version+1)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate the table %s to the schema version %d: %w", ds.table, version+1, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit the migrations of the table %s: %w", ds.table, err)
+	}
+	ds.migrated = true
+	return nil
+}
+
+func (ds *databaseSink) Write(ctx context.Context, result *data.EventProcessResult) error {
+	if ds.openErr != nil {
+		return ds.openErr
+	}
+	jResult, err := helpers.MarshalJson(ctx, result)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEventSerialization, err)
+	}
+
+	ds.mu.Lock()
+	batch := ds.pending
+	if batch == nil {
+		batch = &databaseBatch{done: make(chan struct{})}
+		batch.timer = time.AfterFunc(ds.interval, func() { ds.commitPending(batch) })
+		ds.pending = batch
+	}
+	// results without the retention of their event are pruned by sink-database-retention
+	var retainUntil any
+	if retention := result.Event.GetRetention(); retention > 0 {
+		retainUntil = result.ProcessedAt.Add(retention).UTC()
+	}
+	batch.args = append(batch.args, result.Event.GetEventID(), result.Event.GetEventType(), result.ProcessedAt.UTC(), result.DigestAlgorithm, result.Digest, string(jResult),
+		retainUntil, result.Event.GetLegalHold())
+	batch.results = append(batch.results, result)
+	batch.sizes = append(batch.sizes, len(jResult))
+	full := len(batch.results) >= ds.batchSize
+	if full {
+		ds.pending = nil
+	}
+	ds.mu.Unlock()
+
+	if full {
+		batch.timer.Stop()
+		ds.commit(context.WithoutCancel(ctx), batch)
+	}
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+commitPending commits the batch if it's still the pending one, i.e. it isn't already committed by filling up or the flush
+*/
+func (ds *databaseSink) commitPending(batch *databaseBatch) {
+	ds.mu.Lock()
+	if ds.pending != batch {
+		ds.mu.Unlock()
+		return
+	}
+	ds.pending = nil
+	ds.mu.Unlock()
+	ds.commit(context.Background(), batch)
+}
+
+/*
+commit inserts the rows of the batch by a single statement and releases its writers
+*/
+func (ds *databaseSink) commit(ctx context.Context, batch *databaseBatch) {
+	defer close(batch.done)
+	ctx, span := otel.Tracer("Worker.DatabaseSink.Commit.Tracer").Start(ctx, "Worker.DatabaseSink.Commit.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("db.table", ds.table), attribute.Int("db.results", len(batch.results)))
+	ctx, cancel := context.WithTimeout(ctx, CmdSinkDatabaseTimeout)
+	defer cancel()
+
+	batch.err = ds.migrate(ctx)
+	if batch.err != nil {
+		span.RecordError(batch.err)
+		span.SetStatus(codes.Error, "failed to migrate the table")
+		return
+	}
+	var values strings.Builder
+	for i := range batch.results {
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		values.WriteString("(")
+		for column := 1; column <= databaseSinkColumns; column++ {
+			if column > 1 {
+				values.WriteString(", ")
+			}
+			fmt.Fprintf(&values, "$%d", i*databaseSinkColumns+column)
+		}
+		values.WriteString(")")
+	}
+	_, batch.err = ds.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (event_id, event_type, processed_at, digest_algorithm, digest, result, retain_until, legal_hold)
+VALUES %s ON CONFLICT (event_id, processed_at) DO NOTHING`, ds.table, values.String()), batch.args...)
+	if batch.err != nil {
+		span.RecordError(batch.err)
+		span.SetStatus(codes.Error, "failed to insert the batch")
+		return
+	}
+	for i, result := range batch.results {
+		recordWrittenBytes(result.Event, batch.sizes[i])
+	}
+}
+
+/*
+Flush commits the pending batch without waiting for it to fill up or its interval
+*/
+func (ds *databaseSink) Flush(ctx context.Context) error {
+	if ds.openErr != nil {
+		return nil
+	}
+	ds.mu.Lock()
+	batch := ds.pending
+	ds.pending = nil
+	ds.mu.Unlock()
+	if batch == nil {
+		return nil
+	}
+	batch.timer.Stop()
+	ds.commit(ctx, batch)
+	return batch.err
+}
+
+/*
+pruneLoop deletes the results out of their retention every sink-database-prune-interval
+*/
+func (ds *databaseSink) pruneLoop(logger *zerolog.Logger) {
+	ticker := time.NewTicker(CmdSinkDatabasePruneInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		pruned, err := ds.prune(context.Background(), now)
+		if err != nil {
+			logger.Error().Err(err).Str("table", ds.table).Msg("failed to prune the results of the database sink, retrying by the next interval")
+			continue
+		}
+		if pruned > 0 {
+			logger.Info().Int64("results", pruned).Str("table", ds.table).Msg("pruned the results out of their retention")
+		}
+	}
+}
+
+/*
+prune deletes the results whose retention of their event has elapsed and, if sink-database-retention is set, the ones without it processed before the retention.
+Results of the events on legal hold are kept regardless of both.
+*/
+func (ds *databaseSink) prune(ctx context.Context, now time.Time) (int64, error) {
+	ctx, span := otel.Tracer("Worker.DatabaseSink.Prune.Tracer").Start(ctx, "Worker.DatabaseSink.Prune.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("db.table", ds.table))
+	ctx, cancel := context.WithTimeout(ctx, CmdSinkDatabaseTimeout)
+	defer cancel()
+
+	err := ds.migrate(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to migrate the table")
+		return 0, err
+	}
+	expired := `retain_until < $1`
+	args := []any{now.UTC()}
+	if CmdSinkDatabaseRetention > 0 {
+		expired += ` OR (retain_until IS NULL AND processed_at < $2)`
+		args = append(args, now.Add(-CmdSinkDatabaseRetention).UTC())
+	}
+	res, err := ds.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE NOT legal_hold AND (%s)`, ds.table, expired), args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to prune the results")
+		return 0, fmt.Errorf("failed to prune the results of the table %s: %w", ds.table, err)
+	}
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("db.pruned", pruned))
+	return pruned, nil
+}
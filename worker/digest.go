@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+
+	"github.com/cespare/xxhash/v2"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"lukechampine.com/blake3"
+)
+
+var (
+	CmdDigestAlgorithm string
+)
+
+// hash algorithms of the event digests
+const (
+	DigestMd5    = "md5"
+	DigestSha256 = "sha256"
+	DigestXxhash = "xxhash" // 64 bits xxh64, only detects the accidental changes
+	DigestBlake3 = "blake3"
+)
+
+var DigestAlgorithms = []string{DigestMd5, DigestSha256, DigestXxhash, DigestBlake3}
+
+func newDigestHasher(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestSha256:
+		return sha256.New()
+	case DigestXxhash:
+		return xxhash.New()
+	case DigestBlake3:
+		return blake3.New(32, nil)
+	default:
+		return md5.New()
+	}
+}
+
+/*
+digestEvent calculates the digest by the algorithm and the length of the json serialized metadata of the event, which are recorded in its process result.
+It's the canonical digest used for verifying the integrity of the results store as well.
+*/
+func digestEvent(ctx context.Context, event data.Event, algorithm string) (string, int, error) {
+	jMeta, err := helpers.MarshalJson(ctx, event.GetMetadata())
+	if err != nil {
+		return "", 0, err
+	}
+	hasher := newDigestHasher(algorithm)
+	hasher.Write(jMeta)
+	return hex.EncodeToString(hasher.Sum(nil)), len(jMeta), nil
+}
@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewDigestHasher(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		input     string
+		want      string
+	}{
+		{algorithm: DigestMd5, input: "abc", want: "900150983cd24fb0d6963f7d28e17f72"},
+		{algorithm: DigestSha256, input: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{algorithm: DigestXxhash, input: "abc", want: "44bc2cf5ad770999"},
+		// official test vectors of the blake3 specification
+		{algorithm: DigestBlake3, input: "", want: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{algorithm: DigestBlake3, input: "abc", want: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{algorithm: "unknown", input: "abc", want: "900150983cd24fb0d6963f7d28e17f72"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+" "+tt.input, func(t *testing.T) {
+			hasher := newDigestHasher(tt.algorithm)
+			hasher.Write([]byte(tt.input))
+			if got := hex.EncodeToString(hasher.Sum(nil)); got != tt.want {
+				t.Errorf("digest = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package worker
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdWorkerIdempotencySize int
+	CmdWorkerIdempotencyFile string
+)
+
+var ErrEventAlreadyProcessed = errors.New("an event with the same event_id is already processed")
+
+/*
+idempotencyStore remembers the ids of the successfully processed events, so the events enqueued again with the same event_id,
+e.g. recovered from the checkpoint after a crash or restored by the admins, aren't processed and written to the sinks twice.
+The most recently processed ids are kept up to the size. If the file is set, every processed id is appended to it and the file
+is compacted to the remembered ids once it grows to twice the size, so the ids survive the restarts.
+*/
+type idempotencyStore struct {
+	size      int
+	path      string
+	mu        sync.Mutex
+	processed map[string]*list.Element
+	order     *list.List          // processed event ids ordered from the oldest to newest
+	inFlight  map[string]struct{} // ids of the events being processed, so a duplicate taken meanwhile isn't processed concurrently
+	file      *os.File
+	appended  int // number of the ids inside the file
+}
+
+/*
+newIdempotencyStore returns nil if the size is zero which disables the idempotency checks
+*/
+func newIdempotencyStore(size int, path string) *idempotencyStore {
+	if size <= 0 {
+		return nil
+	}
+	return &idempotencyStore{
+		size:      size,
+		path:      path,
+		processed: make(map[string]*list.Element),
+		order:     list.New(),
+		inFlight:  make(map[string]struct{}),
+	}
+}
+
+/*
+load reads the ids processed by the previous runs and opens the file for appending the new ones
+*/
+func (is *idempotencyStore) load() error {
+	if is == nil || is.path == "" {
+		return nil
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	file, err := os.Open(is.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if eventID := scanner.Text(); eventID != "" {
+				is.remember(eventID)
+				is.appended++
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("invalid idempotency file %s: %w", is.path, err)
+		}
+	}
+	// the file is compacted on the startup, so the ids forgotten by the previous runs don't pile up
+	return is.compact()
+}
+
+/*
+begin reports whether the event should be processed. It returns false if the event is already processed or is being processed,
+otherwise the event id is held until it's completed or released.
+*/
+func (is *idempotencyStore) begin(eventID string) bool {
+	if is == nil {
+		return true
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if _, found := is.processed[eventID]; found {
+		return false
+	}
+	if _, found := is.inFlight[eventID]; found {
+		return false
+	}
+	is.inFlight[eventID] = struct{}{}
+	return true
+}
+
+/*
+complete remembers the id of the successfully processed event. The id is remembered in memory even if it can't be persisted.
+*/
+func (is *idempotencyStore) complete(eventID string) error {
+	if is == nil {
+		return nil
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	delete(is.inFlight, eventID)
+	if _, found := is.processed[eventID]; found {
+		return nil
+	}
+	is.remember(eventID)
+	if is.file == nil {
+		return nil
+	}
+	// a line written to the file survives a crash of the process, the file isn't synced on every id
+	_, err := is.file.WriteString(eventID + "\n")
+	if err != nil {
+		return err
+	}
+	is.appended++
+	if is.appended >= 2*is.size {
+		return is.compact()
+	}
+	return nil
+}
+
+/*
+release lets the event which isn't processed successfully be processed again, it's a no-op once the event is completed
+*/
+func (is *idempotencyStore) release(eventID string) {
+	if is == nil {
+		return
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	delete(is.inFlight, eventID)
+}
+
+/*
+remember adds the event id as the newest one, forgetting the oldest if the size is exceeded. is.mu should be held by the caller.
+*/
+func (is *idempotencyStore) remember(eventID string) {
+	if element, found := is.processed[eventID]; found {
+		is.order.MoveToBack(element)
+		return
+	}
+	is.processed[eventID] = is.order.PushBack(eventID)
+	if is.order.Len() > is.size {
+		oldest := is.order.Front()
+		is.order.Remove(oldest)
+		delete(is.processed, oldest.Value.(string))
+	}
+}
+
+/*
+compact replaces the file atomically with the remembered ids and reopens it for appending. is.mu should be held by the caller.
+*/
+func (is *idempotencyStore) compact() error {
+	if is.file != nil {
+		is.file.Close()
+		is.file = nil
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(is.path), filepath.Base(is.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	writer := bufio.NewWriter(tmpFile)
+	for element := is.order.Front(); element != nil; element = element.Next() {
+		writer.WriteString(element.Value.(string) + "\n")
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if cErr := tmpFile.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), is.path)
+	if err != nil {
+		return err
+	}
+	is.appended = is.order.Len()
+	is.file, err = os.OpenFile(is.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	return err
+}
+
+/*
+close closes the file of the processed ids
+*/
+func (is *idempotencyStore) close() error {
+	if is == nil {
+		return nil
+	}
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.file == nil {
+		return nil
+	}
+	err := is.file.Close()
+	is.file = nil
+	return err
+}
+
+/*
+LoadProcessed loads the ids of the events processed before the restart, it should be called before Recover
+so the events of the checkpoint which are already written to the sinks aren't processed again
+*/
+func (w *Worker) LoadProcessed(ctx context.Context) error {
+	_, span := otel.Tracer("Worker.LoadProcessed.Tracer").Start(ctx, "Worker.LoadProcessed.Span")
+	defer span.End()
+
+	err := w.idempotency.load()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if w.idempotency != nil {
+		span.SetAttributes(attribute.Int("idempotency.processed", w.idempotency.order.Len()))
+	}
+	return nil
+}
+
+/*
+skipProcessed reports whether the event taken out of the queue is already processed, in which case it's skipped instead of processing it again
+*/
+func (w *Worker) skipProcessed(event data.Event) bool {
+	if w.idempotency.begin(event.GetEventID()) {
+		return false
+	}
+	w.Logger.Info().
+		Str("event_id", event.GetEventID()).
+		Msg("skipping processing of the already processed event")
+	observ.PromEventAlreadyProcessed.WithLabelValues(event.GetEventType()).Inc()
+	w.recordProcessStatus(event, data.EventProcessStatusSkipped)
+	w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusSkipped, Err: ErrEventAlreadyProcessed})
+	return true
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+/*
+InFlightEvent is an event being processed by the worker, so the operators can see what a slow worker is stuck on
+*/
+type InFlightEvent struct {
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   string    `json:"elapsed"`
+	Goroutine uint64    `json:"goroutine"` // goroutine running the current attempt, it can be looked up in the goroutine dump of the support bundle
+	Attempt   int       `json:"attempt"`
+	Stage     string    `json:"stage"` // stage of the pipeline the current attempt is at, empty while the event waits for its retry
+}
+
+/*
+inFlightTracker keeps the events from the moment they're taken out of the queue until their processing is finished
+*/
+type inFlightTracker struct {
+	mu     sync.Mutex
+	events map[string]*InFlightEvent
+}
+
+func newInFlightTracker() *inFlightTracker {
+	return &inFlightTracker{events: make(map[string]*InFlightEvent)}
+}
+
+func (it *inFlightTracker) track(event data.Event) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.events[event.GetEventID()] = &InFlightEvent{EventID: event.GetEventID(), EventType: event.GetEventType(), StartedAt: time.Now()}
+	observ.PromWorkerInFlightEvents.WithLabelValues(event.GetEventType()).Inc()
+}
+
+func (it *inFlightTracker) untrack(event data.Event) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if _, found := it.events[event.GetEventID()]; !found {
+		return
+	}
+	delete(it.events, event.GetEventID())
+	observ.PromWorkerInFlightEvents.WithLabelValues(event.GetEventType()).Dec()
+}
+
+/*
+attempt records the start of a processing attempt of the event by the goroutine
+*/
+func (it *inFlightTracker) attempt(eventID string, goroutine uint64) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if inFlight, found := it.events[eventID]; found {
+		inFlight.Attempt++
+		inFlight.Goroutine = goroutine
+	}
+}
+
+func (it *inFlightTracker) stage(eventID string, stage string) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if inFlight, found := it.events[eventID]; found {
+		inFlight.Stage = stage
+	}
+}
+
+/*
+InFlightEvents returns the events currently being processed by the worker from the longest running one
+*/
+func (w *Worker) InFlightEvents() []InFlightEvent {
+	w.inFlightEvents.mu.Lock()
+	defer w.inFlightEvents.mu.Unlock()
+	now := time.Now()
+	events := make([]InFlightEvent, 0, len(w.inFlightEvents.events))
+	for _, inFlight := range w.inFlightEvents.events {
+		event := *inFlight
+		event.Elapsed = now.Sub(event.StartedAt).Round(time.Millisecond).String()
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].StartedAt.Before(events[j].StartedAt) })
+	return events
+}
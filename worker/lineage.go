@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdOpenLineageURL       string
+	CmdOpenLineageNamespace string
+)
+
+const (
+	lineageProducer       = "https://github.com/cybrarymin/behavox"
+	lineageSchemaURL      = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/definitions/RunEvent"
+	lineageErrorFacetURL  = "https://openlineage.io/spec/facets/1-0-1/ErrorMessageRunFacet.json#/$defs/ErrorMessageRunFacet"
+	lineageFacetURL       = lineageProducer + "#openlineage"
+	lineageJobName        = "behavox.pipeline"
+	lineageBufferSize     = 1000
+	lineageRequestTimeout = 5 * time.Second
+)
+
+// run states of the OpenLineage run events
+const (
+	lineageEventComplete = "COMPLETE"
+	lineageEventFail     = "FAIL"
+	lineageEventAbort    = "ABORT"
+)
+
+/*
+LineageRunEvent is the OpenLineage run event emitted when processing of an event or a batch finishes.
+job is the behavox pipeline, input dataset is the producer of the events and output dataset is the processed events sink.
+*/
+type LineageRunEvent struct {
+	EventType string           `json:"eventType"`
+	EventTime time.Time        `json:"eventTime"`
+	Producer  string           `json:"producer"`
+	SchemaURL string           `json:"schemaURL"`
+	Run       LineageRun       `json:"run"`
+	Job       LineageJob       `json:"job"`
+	Inputs    []LineageDataset `json:"inputs"`
+	Outputs   []LineageDataset `json:"outputs"`
+}
+
+type LineageRun struct {
+	RunID  string                 `json:"runId"`
+	Facets map[string]interface{} `json:"facets,omitempty"`
+}
+
+type LineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type LineageDataset struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+/*
+lineageEmitter sends the OpenLineage run events to the configured endpoint in the background,
+so the event processing isn't slowed down by the lineage backend. Events are dropped when the buffer is full.
+*/
+type lineageEmitter struct {
+	url     string
+	client  *http.Client
+	logger  *zerolog.Logger
+	events  chan *LineageRunEvent
+	pending atomic.Int64 // number of run events queued and not sent yet
+}
+
+/*
+newLineageEmitter returns nil if no OpenLineage endpoint is configured, which disables the lineage export
+*/
+func newLineageEmitter(url string, logger *zerolog.Logger) *lineageEmitter {
+	if url == "" {
+		return nil
+	}
+	emitter := &lineageEmitter{
+		url:    url,
+		client: &http.Client{Timeout: lineageRequestTimeout},
+		logger: logger,
+		events: make(chan *LineageRunEvent, lineageBufferSize),
+	}
+	go emitter.run()
+	return emitter
+}
+
+func (le *lineageEmitter) run() {
+	for runEvent := range le.events {
+		err := le.send(runEvent)
+		if err != nil {
+			le.logger.Warn().Err(err).Str("run_id", runEvent.Run.RunID).Msg("failed to emit the openlineage run event")
+			observ.PromLineageEventsEmitted.WithLabelValues("failed").Inc()
+		} else {
+			observ.PromLineageEventsEmitted.WithLabelValues("sent").Inc()
+		}
+		le.pending.Add(-1)
+	}
+}
+
+func (le *lineageEmitter) send(runEvent *LineageRunEvent) error {
+	ctx, span := otel.Tracer("Worker.LineageEmitter.Tracer").Start(context.Background(), "Worker.LineageEmitter.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("openlineage.run_id", runEvent.Run.RunID), attribute.String("openlineage.event_type", runEvent.EventType))
+
+	body, err := json.Marshal(runEvent)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to serialize the openlineage run event")
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, le.url, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := le.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to send the openlineage run event")
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("openlineage endpoint responded with status %d", res.StatusCode)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "openlineage endpoint rejected the run event")
+		return err
+	}
+	return nil
+}
+
+/*
+emit queues the run event to be sent. nil emitter ignores the events.
+*/
+func (le *lineageEmitter) emit(runEvent *LineageRunEvent) {
+	if le == nil {
+		return
+	}
+	le.pending.Add(1)
+	select {
+	case le.events <- runEvent:
+	default:
+		le.pending.Add(-1)
+		observ.PromLineageEventsEmitted.WithLabelValues("dropped").Inc()
+		le.logger.Warn().Str("run_id", runEvent.Run.RunID).Msg("openlineage emitter buffer is full, the run event is dropped")
+	}
+}
+
+/*
+flush waits until all the queued run events are sent or the context is done
+*/
+func (le *lineageEmitter) flush(ctx context.Context) error {
+	if le == nil {
+		return nil
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for le.pending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+/*
+newLineageRunEvent builds the run event of a processed event or a completed batch.
+runKey is the id of the event or the batch, which is converted to a stable uuid as required by OpenLineage run ids.
+*/
+func newLineageRunEvent(runKey string, state string, producer string, facet map[string]interface{}, processErr error) *LineageRunEvent {
+	if producer == "" {
+		producer = "anonymous"
+	}
+	facet["_producer"] = lineageProducer
+	facet["_schemaURL"] = lineageFacetURL
+	facets := map[string]interface{}{"behavox_processing": facet}
+	if processErr != nil {
+		facets["errorMessage"] = map[string]interface{}{
+			"_producer":           lineageProducer,
+			"_schemaURL":          lineageErrorFacetURL,
+			"message":             processErr.Error(),
+			"programmingLanguage": "go",
+		}
+	}
+
+	return &LineageRunEvent{
+		EventType: state,
+		EventTime: time.Now().UTC(),
+		Producer:  lineageProducer,
+		SchemaURL: lineageSchemaURL,
+		Run: LineageRun{
+			RunID:  uuid.NewSHA1(uuid.NameSpaceURL, []byte(lineageProducer+"/runs/"+runKey)).String(),
+			Facets: facets,
+		},
+		Job: LineageJob{Namespace: CmdOpenLineageNamespace, Name: lineageJobName},
+		Inputs: []LineageDataset{
+			{Namespace: CmdOpenLineageNamespace, Name: "producer." + producer},
+		},
+		Outputs: []LineageDataset{
+			{Namespace: "file", Name: CmdProcessedEventFile},
+		},
+	}
+}
+
+/*
+lineageEventState maps the processing status of an event to the state of the run
+*/
+func lineageEventState(status string) string {
+	switch status {
+	case data.EventProcessStatusSuccess:
+		return lineageEventComplete
+	case data.EventProcessStatusFailed:
+		return lineageEventFail
+	default:
+		return lineageEventAbort
+	}
+}
+
+/*
+emitLineage emits the run event of the processed event. Events of a batch are reported all together when the batch completes.
+*/
+func (w *Worker) emitLineage(processed *data.ProcessedEvent, batch *data.BatchStatus) {
+	if w.lineage == nil {
+		return
+	}
+	event := processed.Event
+
+	if batch != nil {
+		state := lineageEventComplete
+		switch {
+		case batch.Failed > 0:
+			state = lineageEventFail
+		case batch.Skipped > 0:
+			state = lineageEventAbort
+		}
+		w.lineage.emit(newLineageRunEvent(batch.BatchID, state, event.GetProducer(), map[string]interface{}{
+			"batch_id":  batch.BatchID,
+			"events":    batch.Total,
+			"succeeded": batch.Succeeded,
+			"failed":    batch.Failed,
+			"skipped":   batch.Skipped,
+		}, nil))
+		return
+	}
+	if event.GetBatchID() != "" {
+		return
+	}
+
+	facet := map[string]interface{}{
+		"event_id":   event.GetEventID(),
+		"event_type": event.GetEventType(),
+		"status":     processed.Status,
+	}
+	if event.GetCorrelationID() != "" {
+		facet["correlation_id"] = event.GetCorrelationID()
+	}
+	w.lineage.emit(newLineageRunEvent(event.GetEventID(), lineageEventState(processed.Status), event.GetProducer(), facet, processed.Err))
+}
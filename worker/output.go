@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	CmdProcessedEventFormat string
+)
+
+// output formats of the processed events file
+const (
+	OutputFormatNdjson = "ndjson"
+	OutputFormatJson   = "json" // alias of ndjson kept for the existing configurations
+	OutputFormatCsv    = "csv"
+	OutputFormatPretty = "pretty"
+)
+
+var OutputFormats = []string{OutputFormatNdjson, OutputFormatJson, OutputFormatCsv, OutputFormatPretty}
+
+// columnar format of the s3 sink objects, the results are written as a whole file so it's not an output format of the processed events file
+const OutputFormatParquet = "parquet"
+
+// framing of the pretty output, the results are the indented elements of a json array
+const (
+	prettyArrayOpen      = "[\n  "
+	prettyArraySeparator = ",\n  "
+	prettyArrayClose     = "\n]\n"
+)
+
+// common columns of the csv output. type specific fields of the events are added after these columns
+var csvCommonColumns = []string{"event_id", "event_type", "producer", "priority", "schema_version", "correlation_id", "parent_event_id", "timestamp", "thread_id", "enqueue_time", "digest_algorithm", "digest", "length", "processing_time", "processed_at"}
+
+/*
+encodeProcessResult serializes the process result in the configured output format.
+header specifies whether the result is the first one of the output, so the csv header row or the opening of the pretty json array is written as well.
+The pretty json array is left open, the sinks should close it by prettyArrayClose.
+*/
+func encodeProcessResult(ctx context.Context, result *data.EventProcessResult, header bool) ([]byte, error) {
+	ctx, span := otel.Tracer("Worker.EncodeProcessResult.Tracer").Start(ctx, "Worker.EncodeProcessResult.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("output.format", CmdProcessedEventFormat))
+
+	switch CmdProcessedEventFormat {
+	case OutputFormatCsv:
+		return encodeCsvProcessResult(result, header)
+	case OutputFormatPretty:
+		return encodePrettyProcessResult(result, header)
+	default:
+		return helpers.MarshalJson(ctx, result)
+	}
+}
+
+/*
+encodePrettyProcessResult indents the process result as an element of the json array, so the output is readable by humans and parseable as a whole
+*/
+func encodePrettyProcessResult(result *data.EventProcessResult, first bool) ([]byte, error) {
+	encoded, err := json.MarshalIndent(result, "  ", "  ")
+	if err != nil {
+		return nil, err
+	}
+	prefix := prettyArraySeparator
+	if first {
+		prefix = prettyArrayOpen
+	}
+	return append([]byte(prefix), encoded...), nil
+}
+
+/*
+encodeCsvProcessResult flattens the process result into a csv row, so it can be queried directly by the analytics tools
+*/
+func encodeCsvProcessResult(result *data.EventProcessResult, header bool) ([]byte, error) {
+	fieldColumns := data.EventFieldNames()
+	metadata := result.Event.GetMetadata()
+
+	row := []string{
+		result.Event.GetEventID(),
+		result.Event.GetEventType(),
+		result.Event.GetProducer(),
+		fmt.Sprint(result.Event.GetPriority()),
+		fmt.Sprint(result.Event.GetSchemaVersion()),
+		result.Event.GetCorrelationID(),
+		result.Event.GetParentEventID(),
+		fmt.Sprint(metadata["timestamp"]),
+		fmt.Sprint(metadata["thread_id"]),
+		result.Event.GetEnqueueTime().Format(time.RFC3339Nano),
+		result.DigestAlgorithm,
+		result.Digest,
+		fmt.Sprint(result.Length),
+		result.ProcessingTime,
+		result.ProcessedAt.Format(time.RFC3339Nano),
+	}
+	for _, column := range fieldColumns {
+		value, found := metadata[column]
+		if !found {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, fmt.Sprint(value))
+	}
+
+	nBuffer := bytes.Buffer{}
+	csvWriter := csv.NewWriter(&nBuffer)
+	if header {
+		err := csvWriter.Write(append(append([]string{}, csvCommonColumns...), fieldColumns...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := csvWriter.Write(row)
+	if err != nil {
+		return nil, err
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return nil, err
+	}
+	return nBuffer.Bytes(), nil
+}
+
+/*
+parquetResultSchema has the columns of the csv output typed by their values, the type specific fields of the events are derived from data.EventFields
+and are null for the event types which don't carry them
+*/
+var parquetResultSchema = newParquetResultSchema()
+
+func newParquetResultSchema() *parquet.Schema {
+	columns := parquet.Group{
+		"event_id":         parquet.String(),
+		"event_type":       parquet.String(),
+		"producer":         parquet.Optional(parquet.String()),
+		"priority":         parquet.Int(64),
+		"schema_version":   parquet.Int(64),
+		"correlation_id":   parquet.Optional(parquet.String()),
+		"parent_event_id":  parquet.Optional(parquet.String()),
+		"timestamp":        parquet.Timestamp(parquet.Nanosecond),
+		"thread_id":        parquet.Int(64),
+		"enqueue_time":     parquet.Timestamp(parquet.Nanosecond),
+		"digest_algorithm": parquet.Optional(parquet.String()),
+		"digest":           parquet.String(),
+		"length":           parquet.Int(64),
+		"processing_time":  parquet.Optional(parquet.String()),
+		"processed_at":     parquet.Timestamp(parquet.Nanosecond),
+	}
+	fTypes := reflect.TypeOf(data.EventFields{})
+	for i := 0; i < fTypes.NumField(); i++ {
+		name := strings.Split(fTypes.Field(i).Tag.Get("json"), ",")[0]
+		switch fTypes.Field(i).Type.Elem().Kind() {
+		case reflect.Float64:
+			columns[name] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		case reflect.Int:
+			columns[name] = parquet.Optional(parquet.Int(64))
+		default:
+			columns[name] = parquet.Optional(parquet.String())
+		}
+	}
+	return parquet.NewSchema("process_result", columns)
+}
+
+/*
+parquetProcessResultRow flattens the process result into a row of parquetResultSchema, the empty optional columns are left out to be null
+*/
+func parquetProcessResultRow(result *data.EventProcessResult) map[string]interface{} {
+	row := map[string]interface{}{
+		"event_id":       result.Event.GetEventID(),
+		"event_type":     result.Event.GetEventType(),
+		"priority":       result.Event.GetPriority(),
+		"schema_version": result.Event.GetSchemaVersion(),
+		"timestamp":      result.Event.GetTimestamp(),
+		"enqueue_time":   result.Event.GetEnqueueTime(),
+		"digest":         result.Digest,
+		"length":         result.Length,
+		"processed_at":   result.ProcessedAt,
+	}
+	metadata := result.Event.GetMetadata()
+	row["thread_id"] = metadata["thread_id"]
+	optional := map[string]string{
+		"producer":         result.Event.GetProducer(),
+		"correlation_id":   result.Event.GetCorrelationID(),
+		"parent_event_id":  result.Event.GetParentEventID(),
+		"digest_algorithm": result.DigestAlgorithm,
+		"processing_time":  result.ProcessingTime,
+	}
+	for column, value := range optional {
+		if value != "" {
+			row[column] = value
+		}
+	}
+	for _, column := range data.EventFieldNames() {
+		if value, found := metadata[column]; found {
+			row[column] = value
+		}
+	}
+	return row
+}
+
+/*
+encodeParquetResults writes the rows as a parquet file, the column chunks are compressed by gzip if the output compression is enabled
+*/
+func encodeParquetResults(ctx context.Context, rows []map[string]interface{}) ([]byte, error) {
+	_, span := otel.Tracer("Worker.EncodeParquetResults.Tracer").Start(ctx, "Worker.EncodeParquetResults.Span")
+	defer span.End()
+	span.SetAttributes(attribute.Int("output.rows", len(rows)))
+
+	var codec compress.Codec = &parquet.Uncompressed
+	if CmdOutputCompression == OutputCompressionGzip {
+		codec = &parquet.Gzip
+	}
+	nBuffer := bytes.Buffer{}
+	writer := parquet.NewWriter(&nBuffer, parquetResultSchema, parquet.Compression(codec))
+	for _, row := range rows {
+		err := writer.Write(row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return nBuffer.Bytes(), nil
+}
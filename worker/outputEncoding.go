@@ -0,0 +1,432 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	helpers "github.com/cybrarymin/behavox/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdOutputCompression        string
+	CmdOutputEncryptionKeyFile  string
+	CmdOutputKMSCiphertextFile  string
+	CmdOutputKMSRegion          string
+	CmdOutputKMSEndpoint        string
+	CmdOutputKMSAccessKeyID     string
+	CmdOutputKMSSecretAccessKey string
+	CmdOutputKMSSessionToken    string
+)
+
+// compressions of the processed events file
+const (
+	OutputCompressionNone = "none"
+	OutputCompressionGzip = "gzip"
+)
+
+var OutputCompressions = []string{OutputCompressionNone, OutputCompressionGzip}
+
+// frames longer than this aren't written by the worker, e.g. the file isn't encrypted
+const maxRecordFrame = 64 << 20
+
+/*
+outputAEAD encrypts the records of the processed events file by aes-256-gcm, nil if the encryption is disabled
+*/
+var outputAEAD cipher.AEAD
+
+/*
+ValidateOutputEncoding checks the compression and encryption options of the processed events file
+*/
+func ValidateOutputEncoding() error {
+	encrypted := CmdOutputEncryptionKeyFile != "" || CmdOutputKMSCiphertextFile != ""
+	encoded := CmdOutputCompression != OutputCompressionNone || encrypted
+	switch {
+	case CmdOutputCompression != OutputCompressionNone && CmdOutputCompression != OutputCompressionGzip:
+		return fmt.Errorf("unknown compression %s of the processed events file", CmdOutputCompression)
+	case encoded && CmdProcessedEventFormat == OutputFormatPretty:
+		return errors.New("pretty output format can't be compressed or encrypted, since its closing is overwritten by each result")
+	case encrypted && (helpers.In(SinkHTTP, CmdSinks...) || helpers.In(SinkStdout, CmdSinks...) || helpers.In(SinkDatabase, CmdSinks...) || helpers.In(SinkSQLite, CmdSinks...)):
+		return errors.New("http, stdout, database and sqlite sinks can't be encrypted, the results would leave the worker or sit in the database unencrypted besides the encrypted file and s3 objects")
+	case CmdOutputEncryptionKeyFile != "" && CmdOutputKMSCiphertextFile != "":
+		return errors.New("only one of event-processor-encryption-key-file and event-processor-kms-ciphertext-file should be set")
+	case CmdOutputKMSCiphertextFile != "" && CmdOutputKMSRegion == "":
+		return errors.New("event-processor-kms-ciphertext-file requires the event-processor-kms-region")
+	case (CmdOutputKMSAccessKeyID == "") != (CmdOutputKMSSecretAccessKey == ""):
+		return errors.New("event-processor-kms-access-key-id and event-processor-kms-secret-access-key should be set together")
+	}
+	return nil
+}
+
+/*
+LoadOutputKey loads the key encrypting the processed events file, either from the key file or by decrypting the data key with aws kms.
+It should be called before the worker starts, the encryption is disabled if neither of them is configured.
+*/
+func LoadOutputKey(ctx context.Context) error {
+	ctx, span := otel.Tracer("Worker.LoadOutputKey.Tracer").Start(ctx, "Worker.LoadOutputKey.Span")
+	defer span.End()
+
+	// the options are validated before the key is loaded, since the recovered events might be written to the file before the cmd options validation
+	err := ValidateOutputEncoding()
+	if err != nil {
+		return err
+	}
+	var key []byte
+	switch {
+	case CmdOutputEncryptionKeyFile != "":
+		key, err = helpers.ReadKeyFile(CmdOutputEncryptionKeyFile)
+	case CmdOutputKMSCiphertextFile != "":
+		key, err = decryptKMSDataKey(ctx)
+	default:
+		return nil
+	}
+	if err == nil && len(key) != helpers.KeySize {
+		err = fmt.Errorf("encryption key of the processed events file should be %d bytes, got %d", helpers.KeySize, len(key))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to load the encryption key")
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	outputAEAD, err = cipher.NewGCM(block)
+	return err
+}
+
+/*
+decryptKMSDataKey decrypts the data key, e.g. the CiphertextBlob of aws kms generate-data-key, by the Decrypt action of aws kms
+*/
+func decryptKMSDataKey(ctx context.Context) ([]byte, error) {
+	ciphertext, err := os.ReadFile(CmdOutputKMSCiphertextFile)
+	if err != nil {
+		return nil, err
+	}
+	// the blob is accepted either base64 encoded as printed by the aws cli or binary
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(ciphertext))); err == nil {
+		ciphertext = decoded
+	}
+	cfg, err := loadAWSConfig(ctx, CmdOutputKMSRegion, CmdOutputKMSAccessKeyID, CmdOutputKMSSecretAccessKey, CmdOutputKMSSessionToken)
+	if err != nil {
+		return nil, err
+	}
+	client := kms.NewFromConfig(cfg, func(o *kms.Options) {
+		if CmdOutputKMSEndpoint != "" {
+			o.BaseEndpoint = aws.String(CmdOutputKMSEndpoint)
+		}
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	res, err := client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key by kms: %w", err)
+	}
+	return res.Plaintext, nil
+}
+
+// header of the encrypted processed events files, followed by the random id of the file bound to its frames
+var encryptedResultsMagic = []byte("BXR1")
+
+const (
+	resultsFileIDSize = 16
+	resultsHeaderSize = 4 + resultsFileIDSize
+	// type and big endian length preceding the sealed frames
+	frameHeaderSize = 1 + 4
+)
+
+// types of the frames of the encrypted processed events file
+const (
+	frameTypeRecord  byte = 1
+	frameTypeTrailer byte = 2 // authenticates the number of the records, it's always the last frame of the file
+)
+
+/*
+newResultsHeader returns the header of a new encrypted processed events file and the id of the file
+*/
+func newResultsHeader() ([]byte, []byte, error) {
+	fileID := make([]byte, resultsFileIDSize)
+	_, err := rand.Read(fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(append([]byte{}, encryptedResultsMagic...), fileID...), fileID, nil
+}
+
+/*
+frameAAD binds the frame to its file, its position and its type, so the frames can't be reordered, dropped or moved between the files
+*/
+func frameAAD(fileID []byte, index uint64, frameType byte) []byte {
+	aad := append(append([]byte{}, encryptedResultsMagic...), fileID...)
+	aad = append(aad, frameType)
+	return binary.BigEndian.AppendUint64(aad, index)
+}
+
+// size of the trailer frames, they don't have a plaintext
+func trailerFrameSize() int {
+	return frameHeaderSize + outputAEAD.NonceSize() + outputAEAD.Overhead()
+}
+
+/*
+compressRecord gzips the encoded process result if the compression is enabled.
+Every record is a separate gzip member, so the compressed file without the encryption is a regular multi member gzip file.
+*/
+func compressRecord(record []byte) ([]byte, error) {
+	if CmdOutputCompression != OutputCompressionGzip {
+		return record, nil
+	}
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write(record)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+/*
+sealFrame encrypts the plaintext as the frame of the index. Frames are the type, the 4 bytes big endian length
+and the 12 bytes nonce followed by the aes-256-gcm ciphertext.
+*/
+func sealFrame(fileID []byte, index uint64, frameType byte, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, outputAEAD.NonceSize(), outputAEAD.NonceSize()+len(plaintext)+outputAEAD.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed := outputAEAD.Seal(nonce, nonce, plaintext, frameAAD(fileID, index, frameType))
+	frame := make([]byte, 0, frameHeaderSize+len(sealed))
+	frame = append(frame, frameType)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(sealed)))
+	return append(frame, sealed...), nil
+}
+
+/*
+sealRecord compresses and encrypts an encoded process result as the record frame of the index followed by the trailer of the file.
+The trailer of the previous records should be overwritten by the returned frames, so the trailer always ends the file.
+*/
+func sealRecord(fileID []byte, index uint64, record []byte) ([]byte, error) {
+	record, err := compressRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if outputAEAD == nil {
+		return record, nil
+	}
+	frame, err := sealFrame(fileID, index, frameTypeRecord, record)
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := sealFrame(fileID, index+1, frameTypeTrailer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(frame, trailer...), nil
+}
+
+/*
+encodeResultsFile encodes the records as a complete processed events file, e.g. the objects of the s3 sink
+*/
+func encodeResultsFile(records [][]byte) ([]byte, error) {
+	var encoded []byte
+	var fileID []byte
+	if outputAEAD != nil {
+		var err error
+		encoded, fileID, err = newResultsHeader()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, record := range records {
+		sealed, err := sealRecord(fileID, uint64(i), record)
+		if err != nil {
+			return nil, err
+		}
+		if outputAEAD != nil && i > 0 {
+			// the trailer of the previous record is replaced by this one
+			encoded = encoded[:len(encoded)-trailerFrameSize()]
+		}
+		encoded = append(encoded, sealed...)
+	}
+	return encoded, nil
+}
+
+/*
+scanResultsFile reads the header and the frame layout of the encrypted processed events file without decrypting it.
+It returns the id of the file, the number of its records and the offset the next record should be written at, which is the offset of the trailer.
+A file which doesn't end with a trailer, e.g. after a crash in the middle of a write, ends at its last complete record.
+*/
+func scanResultsFile(file io.ReaderAt, size int64) ([]byte, uint64, int64, error) {
+	header := make([]byte, resultsHeaderSize)
+	_, err := file.ReadAt(header, 0)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("processed events file doesn't have the header of the encrypted files: %w", err)
+	}
+	if !bytes.Equal(header[:len(encryptedResultsMagic)], encryptedResultsMagic) {
+		return nil, 0, 0, errors.New("processed events file isn't encrypted by the worker")
+	}
+	fileID := header[len(encryptedResultsMagic):]
+	var records uint64
+	offset := int64(resultsHeaderSize)
+	var frameHeader [frameHeaderSize]byte
+	for offset+frameHeaderSize <= size {
+		_, err := file.ReadAt(frameHeader[:], offset)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		end := offset + frameHeaderSize + int64(binary.BigEndian.Uint32(frameHeader[1:]))
+		if end > size || frameHeader[0] == frameTypeTrailer {
+			break
+		}
+		if frameHeader[0] != frameTypeRecord {
+			return nil, 0, 0, fmt.Errorf("unknown frame at offset %d of the processed events file", offset)
+		}
+		records++
+		offset = end
+	}
+	return fileID, records, offset, nil
+}
+
+/*
+openedRecords decrypts the frames of the encrypted processed events file one by one.
+It verifies the position of every frame and fails with io.ErrUnexpectedEOF if the file doesn't end with its trailer, so the truncated files are detected.
+*/
+type openedRecords struct {
+	reader *bufio.Reader
+	fileID []byte
+	index  uint64
+	record []byte
+	done   bool
+}
+
+func (or *openedRecords) Read(p []byte) (int, error) {
+	for len(or.record) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		err := or.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, or.record)
+	or.record = or.record[n:]
+	return n, nil
+}
+
+/*
+next decrypts the next frame into the record or marks the end of the file at the trailer
+*/
+func (or *openedRecords) next() error {
+	if or.fileID == nil {
+		header := make([]byte, resultsHeaderSize)
+		n, err := io.ReadFull(or.reader, header)
+		if n == 0 && errors.Is(err, io.EOF) {
+			// the file without any result
+			or.done = true
+			return nil
+		}
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if !bytes.Equal(header[:len(encryptedResultsMagic)], encryptedResultsMagic) {
+			return errors.New("processed events file isn't encrypted by the worker")
+		}
+		or.fileID = header[len(encryptedResultsMagic):]
+	}
+
+	var frameHeader [frameHeaderSize]byte
+	_, err := io.ReadFull(or.reader, frameHeader[:])
+	if err != nil {
+		// the file is truncated before its trailer
+		return io.ErrUnexpectedEOF
+	}
+	frameType, frameLength := frameHeader[0], binary.BigEndian.Uint32(frameHeader[1:])
+	if frameLength > maxRecordFrame || frameLength < uint32(outputAEAD.NonceSize()) {
+		return fmt.Errorf("invalid frame %d of the processed events file", or.index)
+	}
+	sealed := make([]byte, frameLength)
+	_, err = io.ReadFull(or.reader, sealed)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	nonceSize := outputAEAD.NonceSize()
+	plaintext, err := outputAEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], frameAAD(or.fileID, or.index, frameType))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the frame %d of the processed events file, it's tampered or out of order: %w", or.index, err)
+	}
+	switch frameType {
+	case frameTypeTrailer:
+		_, err := or.reader.Peek(1)
+		if !errors.Is(err, io.EOF) {
+			return errors.New("processed events file has data after its trailer")
+		}
+		or.done = true
+	case frameTypeRecord:
+		or.record = plaintext
+		or.index++
+	default:
+		return fmt.Errorf("unknown frame %d of the processed events file", or.index)
+	}
+	return nil
+}
+
+/*
+partialTail ends the stream at a partially written gzip member instead of failing it
+*/
+type partialTail struct {
+	reader io.Reader
+}
+
+func (pt *partialTail) Read(p []byte) (int, error) {
+	n, err := pt.reader.Read(p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+/*
+decodeResults returns the reader of the plain processed events out of the compressed or encrypted file.
+Truncated encrypted files fail with io.ErrUnexpectedEOF, while the partially written gzip member of the unencrypted files is ignored.
+*/
+func decodeResults(file io.Reader) (io.Reader, error) {
+	reader := file
+	if outputAEAD != nil {
+		reader = &openedRecords{reader: bufio.NewReader(file)}
+	}
+	if CmdOutputCompression == OutputCompressionGzip {
+		gzipReader, err := gzip.NewReader(reader)
+		if errors.Is(err, io.EOF) {
+			return bytes.NewReader(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+		if outputAEAD == nil {
+			reader = &partialTail{reader: gzipReader}
+		}
+	}
+	return reader, nil
+}
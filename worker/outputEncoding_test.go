@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+/*
+setTestOutputEncoding enables the encryption by a fixed key and the compression, and restores them at the end of the test
+*/
+func setTestOutputEncoding(t *testing.T, encrypted bool, compression string) {
+	t.Helper()
+	outputAEAD = nil
+	if encrypted {
+		block, err := aes.NewCipher(bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputAEAD, err = cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	CmdOutputCompression, CmdProcessedEventFormat = compression, OutputFormatNdjson
+	t.Cleanup(func() {
+		outputAEAD = nil
+		CmdOutputCompression = OutputCompressionNone
+	})
+}
+
+func testRecords(n int) [][]byte {
+	records := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		records = append(records, []byte(fmt.Sprintf("{\"record\":%d}\n", i)))
+	}
+	return records
+}
+
+func decodeAll(t *testing.T, encoded []byte) (string, error) {
+	t.Helper()
+	reader, err := decodeResults(bytes.NewReader(encoded))
+	if err != nil {
+		return "", err
+	}
+	decoded, err := io.ReadAll(reader)
+	return string(decoded), err
+}
+
+func TestSealedRecordsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		encrypted   bool
+		compression string
+		records     int
+	}{
+		{name: "plain", compression: OutputCompressionNone, records: 3},
+		{name: "gzip", compression: OutputCompressionGzip, records: 3},
+		{name: "encrypted", encrypted: true, compression: OutputCompressionNone, records: 3},
+		{name: "encrypted and gzip", encrypted: true, compression: OutputCompressionGzip, records: 3},
+		{name: "encrypted single record", encrypted: true, compression: OutputCompressionNone, records: 1},
+		{name: "encrypted empty file", encrypted: true, compression: OutputCompressionNone, records: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestOutputEncoding(t, tt.encrypted, tt.compression)
+			records := testRecords(tt.records)
+			encoded, err := encodeResultsFile(records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.records == 0 {
+				encoded = nil
+			}
+			if tt.encrypted && bytes.Contains(encoded, []byte("record")) {
+				t.Fatal("encrypted file contains the plaintext")
+			}
+			decoded, err := decodeAll(t, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := string(bytes.Join(records, nil)); decoded != want {
+				t.Errorf("decoded = %q, want %q", decoded, want)
+			}
+		})
+	}
+}
+
+/*
+frameOffsets returns the offsets of the frames of an encrypted file, the last one is the trailer
+*/
+func frameOffsets(encoded []byte) []int {
+	offsets := []int{}
+	for offset := resultsHeaderSize; offset < len(encoded); {
+		offsets = append(offsets, offset)
+		offset += frameHeaderSize + int(uint32(encoded[offset+1])<<24|uint32(encoded[offset+2])<<16|uint32(encoded[offset+3])<<8|uint32(encoded[offset+4]))
+	}
+	return offsets
+}
+
+func TestSealedRecordsTampering(t *testing.T) {
+	setTestOutputEncoding(t, true, OutputCompressionNone)
+	encoded, err := encodeResultsFile(testRecords(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := encodeResultsFile(testRecords(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := frameOffsets(encoded)
+	if len(offsets) != 4 {
+		t.Fatalf("frames = %d, want 3 records and the trailer", len(offsets))
+	}
+	record := func(i int) []byte { return encoded[offsets[i]:offsets[i+1]] }
+	trailer := encoded[offsets[3]:]
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	header := encoded[:resultsHeaderSize]
+
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr error // nil for any decoding error
+	}{
+		{name: "reordered records", file: join(header, record(1), record(0), record(2), trailer)},
+		{name: "dropped record", file: join(header, record(0), record(2), trailer)},
+		{name: "record of another file", file: join(other[:resultsHeaderSize], record(0), other[frameOffsets(other)[1]:])},
+		{name: "truncated at a frame boundary", file: join(header, record(0), record(1)), wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated in the middle of a frame", file: encoded[:len(encoded)-5], wantErr: io.ErrUnexpectedEOF},
+		{name: "header only", file: header, wantErr: io.ErrUnexpectedEOF},
+		{name: "partial header", file: header[:7], wantErr: io.ErrUnexpectedEOF},
+		{name: "data after the trailer", file: join(encoded, record(0))},
+		{name: "flipped ciphertext bit", file: func() []byte {
+			tampered := bytes.Clone(encoded)
+			tampered[offsets[1]+frameHeaderSize+20] ^= 1
+			return tampered
+		}()},
+		{name: "unencrypted file", file: []byte("{\"record\":0}\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeAll(t, tt.file)
+			if err == nil {
+				t.Fatal("tampered file is decoded without an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testProcessResult(i int) *data.EventProcessResult {
+	return &data.EventProcessResult{
+		Event:       data.NewEventLog(fmt.Sprintf("event-%d", i), "info", "secret log message"),
+		Digest:      "digest",
+		ProcessedAt: time.Now(),
+	}
+}
+
+func TestFileSinkEncrypted(t *testing.T) {
+	setTestOutputEncoding(t, true, OutputCompressionGzip)
+	CmdProcessedEventFile = filepath.Join(t.TempDir(), "events.json")
+	sink := &fileSink{path: CmdProcessedEventFile}
+	for i := 0; i < 3; i++ {
+		err := sink.Write(t.Context(), testProcessResult(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a restarted worker picks up the layout of the existing file, it's cut off at the partially written frame of a crash
+	file, err := os.OpenFile(CmdProcessedEventFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{frameTypeRecord, 0, 0, 1})
+	file.Close()
+	restarted := &fileSink{path: CmdProcessedEventFile}
+	err = restarted.Write(t.Context(), testProcessResult(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(CmdProcessedEventFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("secret log message")) {
+		t.Fatal("processed events file contains the plaintext")
+	}
+	reader, err := OpenResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if !strings.Contains(string(decoded), fmt.Sprintf("event-%d", i)) {
+			t.Errorf("result of event-%d isn't decoded", i)
+		}
+	}
+}
+
+func TestValidateOutputEncodingSinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinks   []string
+		keyFile string
+		wantErr bool
+	}{
+		{name: "encrypted file sink", sinks: []string{SinkFile, SinkS3}, keyFile: "key", wantErr: false},
+		{name: "encrypted http sink", sinks: []string{SinkFile, SinkHTTP}, keyFile: "key", wantErr: true},
+		{name: "encrypted stdout sink", sinks: []string{SinkStdout}, keyFile: "key", wantErr: true},
+		{name: "encrypted database sink", sinks: []string{SinkFile, SinkDatabase}, keyFile: "key", wantErr: true},
+		{name: "unencrypted http sink", sinks: []string{SinkHTTP}, wantErr: false},
+		{name: "unencrypted database sink", sinks: []string{SinkDatabase}, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CmdSinks, CmdOutputEncryptionKeyFile = tt.sinks, tt.keyFile
+			CmdOutputCompression, CmdProcessedEventFormat = OutputCompressionNone, OutputFormatNdjson
+			t.Cleanup(func() { CmdSinks, CmdOutputEncryptionKeyFile = nil, "" })
+			err := ValidateOutputEncoding()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOutputEncoding() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
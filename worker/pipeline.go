@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdProcessorStages []string
+)
+
+// stages of the processing pipeline
+const (
+	StageValidate = "validate"
+	StageEnrich   = "enrich"
+	StageDigest   = "digest"
+	StageProcess  = "process"
+	StageSink     = "sink"
+)
+
+var Stages = []string{StageValidate, StageEnrich, StageDigest, StageProcess, StageSink}
+
+/*
+pipelineEvent is the state of an event passed through the stages. Each stage builds up the process result of the previous ones.
+*/
+type pipelineEvent struct {
+	result        *data.EventProcessResult
+	startedAt     time.Time
+	simulatedTime float32 // seconds of the simulated processing, added to the processing time of the result
+}
+
+/*
+stage is a step of processing the events, the stages are executed in the configured order and the first failing stage fails the event
+*/
+type stage struct {
+	name    string
+	process func(ctx context.Context, pe *pipelineEvent) error
+}
+
+/*
+newPipeline builds the stages in the specified order, the stages which are not listed are skipped entirely
+*/
+func (w *Worker) newPipeline(names []string) []stage {
+	stages := map[string]func(ctx context.Context, pe *pipelineEvent) error{
+		StageValidate: w.validateStage,
+		StageEnrich:   w.enrichStage,
+		StageDigest:   w.digestStage,
+		StageProcess:  w.processStage,
+		StageSink:     w.sinkStage,
+	}
+	pipeline := make([]stage, 0, len(names))
+	for _, name := range names {
+		pipeline = append(pipeline, stage{name: name, process: stages[name]})
+	}
+	return pipeline
+}
+
+/*
+runStage executes the stage within its own span and records its duration and failures
+*/
+func (w *Worker) runStage(ctx context.Context, s stage, pe *pipelineEvent) error {
+	ctx, span := otel.Tracer("Worker.Stage.Tracer").Start(ctx, "Worker.Stage.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("stage.name", s.name), attribute.String("event.id", pe.result.Event.GetEventID()))
+
+	w.inFlightEvents.stage(pe.result.Event.GetEventID(), s.name)
+	startTime := time.Now()
+	err := w.runProtected(ctx, s, pe)
+	observ.PromWorkerStageDuration.WithLabelValues(s.name).Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, fmt.Sprintf("%s stage failed", s.name))
+		observ.PromWorkerStageFailures.WithLabelValues(s.name, failureReason(err)).Inc()
+		return err
+	}
+	return nil
+}
+
+/*
+validateStage rejects the events which can't be processed, e.g. the events of the event types unregistered since they're enqueued
+*/
+func (w *Worker) validateStage(ctx context.Context, pe *pipelineEvent) error {
+	event := pe.result.Event
+	switch {
+	case event.GetEventID() == "":
+		return fmt.Errorf("%w: event doesn't have an event_id", ErrEventValidation)
+	case event.GetTimestamp().IsZero():
+		return fmt.Errorf("%w: event doesn't have a timestamp", ErrEventValidation)
+	}
+	if _, found := data.LookupEventType(event.GetEventType()); !found {
+		return fmt.Errorf("%w: unknown event type %s", ErrEventValidation, event.GetEventType())
+	}
+	return nil
+}
+
+/*
+enrichStage records the goroutine processing the event in its metadata
+*/
+func (w *Worker) enrichStage(ctx context.Context, pe *pipelineEvent) error {
+	pe.result.Event.SetThreadID(int(helpers.GetGoroutineID(ctx)))
+	return nil
+}
+
+/*
+digestStage calculates the hash and length of the serialized metadata, it should come after the stages changing the metadata
+*/
+func (w *Worker) digestStage(ctx context.Context, pe *pipelineEvent) error {
+	start := time.Now()
+	metaHashHex, metaLength, err := digestEvent(ctx, pe.result.Event, CmdDigestAlgorithm)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEventSerialization, err)
+	}
+	observ.PromEventDigestDuration.WithLabelValues(CmdDigestAlgorithm).Observe(time.Since(start).Seconds())
+	pe.result.Digest = metaHashHex
+	pe.result.DigestAlgorithm = CmdDigestAlgorithm
+	pe.result.Length = metaLength
+	return nil
+}
+
+/*
+processStage simulates an additional processing time for the metadata
+*/
+func (w *Worker) processStage(ctx context.Context, pe *pipelineEvent) error {
+	randomTime := 0.05 + rand.Float32()*(0.2-0.05)
+	time.Sleep(time.Duration(randomTime))
+	pe.simulatedTime += randomTime
+	return nil
+}
+
+/*
+sinkStage persists the process result to all the configured sinks, it should be the last stage
+*/
+func (w *Worker) sinkStage(ctx context.Context, pe *pipelineEvent) error {
+	pe.result.ProcessingTime = fmt.Sprintf("%.4f", pe.simulatedTime+float32(time.Since(pe.startedAt).Seconds()))
+	// show the process finishing time
+	pe.result.ProcessedAt = time.Now()
+
+	var errs []error
+	for _, sink := range w.sinks {
+		err := w.writeSink(ctx, sink, pe.result)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+ValidatePipeline checks the configured stages, the results are only persisted if the sink is the last stage
+*/
+func ValidatePipeline(names []string) error {
+	for _, name := range names {
+		if !helpers.In(name, Stages...) {
+			return fmt.Errorf("unknown stage %s", name)
+		}
+	}
+	if !helpers.Unique(names) {
+		return errors.New("shouldn't contain duplicate stages")
+	}
+	if len(names) == 0 || names[len(names)-1] != StageSink {
+		return errors.New("sink should be the last stage")
+	}
+	return nil
+}
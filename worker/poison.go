@@ -0,0 +1,166 @@
+package worker
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+var (
+	CmdEventPoisonThreshold   int
+	CmdEventProcessingTimeout time.Duration
+)
+
+var (
+	ErrEventPanic   = errors.New("processor panicked while processing the event")
+	ErrEventTimeout = errors.New("processing of the event timed out")
+	ErrEventCrash   = errors.New("process crashed while processing the event")
+)
+
+// number of the events whose crashes are remembered, the crashes of the oldest ones are forgotten beyond it
+const poisonTrackedEvents = 10000
+
+/*
+poisonDetector counts the crashes of the events, i.e. the panics and timeouts of their processing and the crashes of the process while they're
+in flight. Once an event reaches the threshold it's a poison event and quarantined to the dead letter queue instead of consuming the rest of its retry budget.
+The crashes are remembered across the attempts and the replays of the event until it's processed successfully, so a replayed poison event is
+quarantined again on its first crash.
+*/
+type poisonDetector struct {
+	threshold int
+	mu        sync.Mutex
+	crashes   map[string]*list.Element
+	order     *list.List // crashed events ordered from the oldest to the newest crash
+}
+
+type poisonEntry struct {
+	eventID string
+	crashes int
+}
+
+/*
+newPoisonDetector returns nil if the threshold is zero which disables the poison detection
+*/
+func newPoisonDetector(threshold int) *poisonDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &poisonDetector{threshold: threshold, crashes: make(map[string]*list.Element), order: list.New()}
+}
+
+/*
+crashed counts the error of the event if it's a crash and reports whether the event is a poison event afterwards
+*/
+func (pd *poisonDetector) crashed(eventID string, err error) bool {
+	if pd == nil || !isCrash(err) {
+		return false
+	}
+	return pd.add(eventID, 1) >= pd.threshold
+}
+
+/*
+add adds the crashes to the event and returns its total crashes
+*/
+func (pd *poisonDetector) add(eventID string, crashes int) int {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	if element, found := pd.crashes[eventID]; found {
+		entry := element.Value.(*poisonEntry)
+		entry.crashes += crashes
+		pd.order.MoveToBack(element)
+		return entry.crashes
+	}
+	pd.crashes[eventID] = pd.order.PushBack(&poisonEntry{eventID: eventID, crashes: crashes})
+	if pd.order.Len() > poisonTrackedEvents {
+		oldest := pd.order.Front()
+		pd.order.Remove(oldest)
+		delete(pd.crashes, oldest.Value.(*poisonEntry).eventID)
+	}
+	return crashes
+}
+
+/*
+forget drops the crashes of the successfully processed event
+*/
+func (pd *poisonDetector) forget(eventID string) {
+	if pd == nil {
+		return
+	}
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	if element, found := pd.crashes[eventID]; found {
+		pd.order.Remove(element)
+		delete(pd.crashes, eventID)
+	}
+}
+
+func isCrash(err error) bool {
+	return errors.Is(err, ErrEventPanic) || errors.Is(err, ErrEventTimeout) || errors.Is(err, ErrEventCrash)
+}
+
+/*
+runProtected executes the stage turning its panic into an error, so a crashing processor only fails the event instead of the whole process
+*/
+func (w *Worker) runProtected(ctx context.Context, s stage, pe *pipelineEvent) (err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			w.Logger.Error().
+				Str("event_id", pe.result.Event.GetEventID()).
+				Str("stage", s.name).
+				Str("stack", string(debug.Stack())).
+				Msgf("stage panicked while processing the event: %v", panicErr)
+			err = fmt.Errorf("%w: %s stage: %v", ErrEventPanic, s.name, panicErr)
+		}
+	}()
+	return s.process(ctx, pe)
+}
+
+/*
+processAttempt processes the event within the processing timeout if it's set. The context of the timed out attempt is cancelled,
+but a stage which doesn't respect it keeps running in the background until it returns.
+*/
+func (w *Worker) processAttempt(ctx context.Context, event data.Event) (*data.EventProcessResult, error) {
+	if CmdEventProcessingTimeout <= 0 {
+		return w.processEventWithCost(ctx, event)
+	}
+	ctx, cancel := context.WithTimeout(ctx, CmdEventProcessingTimeout)
+	defer cancel()
+
+	type outcome struct {
+		result *data.EventProcessResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := w.processEventWithCost(ctx, event)
+		done <- outcome{result: result, err: err}
+	}()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: it took more than %s", ErrEventTimeout, CmdEventProcessingTimeout)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+/*
+quarantine moves the poison event to the dead letter queue flagged as poison, so it isn't retried any further
+*/
+func (w *Worker) quarantine(ctx context.Context, event data.Event, err error, attempts int) {
+	w.Logger.Warn().Err(err).
+		Str("event_id", event.GetEventID()).
+		Int("attempts", attempts).
+		Msg("event is quarantined to the dead letter queue as a poison event")
+	observ.PromEventPoisoned.WithLabelValues(failureReason(err), event.GetEventType()).Inc()
+	w.putDeadLetter(ctx, event, err, attempts, true)
+}
@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// interval of checking the leases of the external consumers for the expiry
+const leaseExpiryInterval = time.Second
+
+/*
+Pull takes the next event out of the queue for an external consumer, the same way the worker takes them.
+It waits up to wait for an event to be added to the queue and returns nil if none is available meanwhile or ctx is done.
+The consumer should acknowledge the leased event before the lease expires, otherwise the event is delivered again.
+*/
+func (w *Worker) Pull(ctx context.Context, consumer string, wait time.Duration) *data.EventLease {
+	ctx, span := otel.Tracer("Worker.Pull.Tracer").Start(ctx, "Worker.Pull.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("lease.consumer", consumer))
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		// taking the channel before the event, so the events added right after an empty GetEvent wake the consumer up
+		pushed := w.EventQueue.Pushed()
+		queuedEvent := w.EventQueue.GetEvent(ctx)
+		if queuedEvent != nil {
+			lease := w.lease(ctx, queuedEvent, consumer)
+			if lease != nil {
+				span.SetAttributes(attribute.String("lease.id", lease.ID), attribute.String("event.id", lease.Event.GetEventID()))
+				return lease
+			}
+			continue
+		}
+		select {
+		case <-pushed:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+/*
+lease leases the event taken out of the queue to the consumer. Cancelled events and the events which can't be decompressed aren't leased and nil is returned.
+*/
+func (w *Worker) lease(ctx context.Context, queuedEvent data.Event, consumer string) *data.EventLease {
+	if w.skipCancelled(queuedEvent) {
+		return nil
+	}
+	event, err := data.DecompressEvent(queuedEvent)
+	if err != nil {
+		w.Logger.Error().Err(err).
+			Str("event_id", queuedEvent.GetEventID()).
+			Msg("event decompression failed")
+		err = fmt.Errorf("%w: %w", ErrEventDecompression, err)
+		w.recordProcessStatus(queuedEvent, data.EventProcessStatusFailed)
+		observ.PromEventTotalProcessed.WithLabelValues().Inc()
+		w.deadLetter(ctx, queuedEvent, err, 1)
+		w.notifyProcessed(&data.ProcessedEvent{Event: queuedEvent, Status: data.EventProcessStatusFailed, Err: err})
+		return nil
+	}
+
+	if !event.GetEnqueueTime().IsZero() {
+		queueWaitTime := time.Since(event.GetEnqueueTime())
+		observ.PromEventQueueWaitTime.WithLabelValues(event.GetEventType(), strconv.Itoa(event.GetPriority())).Observe(queueWaitTime.Seconds())
+		observ.OTelRecordEventQueueWaitTime(ctx, event.GetEventType(), event.GetPriority(), queueWaitTime)
+	}
+	lease := w.EventQueue.Lease(ctx, event, consumer, data.CmdEventLeaseTimeout)
+	w.EventQueue.Index.Processing(event)
+	observ.PromEventLeases.WithLabelValues("leased").Inc()
+	w.Logger.Info().
+		Str("event_id", event.GetEventID()).
+		Str("lease_id", lease.ID).
+		Str("consumer", consumer).
+		Int("delivery", lease.Delivery).
+		Msg("consumer leased the event")
+	return lease
+}
+
+/*
+Ack finishes processing of the leased event with the outcome reported by its consumer, processErr is nil if the event is processed successfully.
+Events failed by the consumer are moved to the dead letter queue. It returns data.ErrLeaseNotFound if the lease is already expired.
+*/
+func (w *Worker) Ack(ctx context.Context, leaseID string, processErr error) (*data.EventLease, error) {
+	ctx, span := otel.Tracer("Worker.Ack.Tracer").Start(ctx, "Worker.Ack.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("lease.id", leaseID))
+
+	lease, err := w.EventQueue.Release(ctx, leaseID, false)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "lease not found")
+		return nil, err
+	}
+	event := lease.Event
+	observ.PromEventLeases.WithLabelValues("acked").Inc()
+	observ.PromEventTotalProcessed.WithLabelValues().Inc()
+
+	if processErr != nil {
+		processErr = fmt.Errorf("%w: %w", ErrEventConsumer, processErr)
+		w.Logger.Error().Err(processErr).
+			Str("event_id", event.GetEventID()).
+			Str("consumer", lease.Consumer).
+			Msg("event processing failed permanently")
+		w.recordProcessStatus(event, data.EventProcessStatusFailed)
+		w.deadLetter(ctx, event, processErr, lease.Delivery)
+		w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusFailed, Err: processErr})
+		return lease, nil
+	}
+
+	processingDuration := time.Since(lease.LeasedAt)
+	observ.PromEventProcessingDuration.WithLabelValues(event.GetEventType()).Observe(processingDuration.Seconds())
+	observ.OTelRecordEventProcessingDuration(ctx, event.GetEventType(), processingDuration)
+	w.stats.recordDuration(event.GetEventType(), processingDuration)
+	w.recordProcessStatus(event, data.EventProcessStatusSuccess)
+	// the worker doesn't process the event again if it's enqueued once more
+	err = w.idempotency.complete(event.GetEventID())
+	if err != nil {
+		w.Logger.Error().Err(err).Str("event_id", event.GetEventID()).Msg("failed to persist the id of the processed event")
+	}
+	w.Logger.Info().
+		Str("event_id", event.GetEventID()).
+		Str("consumer", lease.Consumer).
+		Msg("consumer finished processing of the event")
+	w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusSuccess})
+	return lease, nil
+}
+
+/*
+Nack gives the leased event back to the queue to be delivered again, e.g. when the consumer is shutting down.
+It returns data.ErrLeaseNotFound if the lease is already expired.
+*/
+func (w *Worker) Nack(ctx context.Context, leaseID string) (*data.EventLease, error) {
+	ctx, span := otel.Tracer("Worker.Nack.Tracer").Start(ctx, "Worker.Nack.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("lease.id", leaseID))
+
+	lease, err := w.EventQueue.Release(ctx, leaseID, true)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "lease not found")
+		return nil, err
+	}
+	observ.PromEventLeases.WithLabelValues("nacked").Inc()
+	w.requeue(ctx, lease)
+	return lease, nil
+}
+
+/*
+expireLeases puts the events of the expired leases back into the queue until the context is cancelled
+*/
+func (w *Worker) expireLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, lease := range w.EventQueue.ExpireLeases(now) {
+				observ.PromEventLeases.WithLabelValues("expired").Inc()
+				w.Logger.Warn().
+					Str("event_id", lease.Event.GetEventID()).
+					Str("lease_id", lease.ID).
+					Str("consumer", lease.Consumer).
+					Msg("lease of the event expired before the consumer acknowledged it")
+				w.requeue(ctx, lease)
+			}
+		}
+	}
+}
+
+/*
+requeue restores the event of the released lease into the queue. The event is dead lettered if the queue is already full meanwhile.
+*/
+func (w *Worker) requeue(ctx context.Context, lease *data.EventLease) {
+	err := w.EventQueue.Restore(ctx, []data.Event{lease.Event})
+	if err == nil {
+		return
+	}
+	err = fmt.Errorf("%w: %w", ErrEventRequeue, err)
+	w.Logger.Error().Err(err).
+		Str("event_id", lease.Event.GetEventID()).
+		Msg("failed to put the event of the lease back into the queue")
+	w.recordProcessStatus(lease.Event, data.EventProcessStatusFailed)
+	observ.PromEventTotalProcessed.WithLabelValues().Inc()
+	w.deadLetter(ctx, lease.Event, err, lease.Delivery)
+	w.notifyProcessed(&data.ProcessedEvent{Event: lease.Event, Status: data.EventProcessStatusFailed, Err: err})
+}
@@ -0,0 +1,337 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var ErrResultNotFound = errors.New("process result not found")
+
+/*
+ResultsContentType returns the media type of the processed events file with respect to the configured output format
+*/
+func ResultsContentType() string {
+	switch CmdProcessedEventFormat {
+	case OutputFormatCsv:
+		return "text/csv"
+	case OutputFormatPretty:
+		return "application/json"
+	}
+	return "application/x-ndjson"
+}
+
+/*
+OpenResults opens the processed events file to be exported, the compressed or encrypted records are decoded while reading. Caller should close the returned reader.
+*/
+func OpenResults() (io.ReadCloser, error) {
+	file, err := os.Open(CmdProcessedEventFile)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot io.Reader = file
+	if outputAEAD != nil {
+		snapshot, err = snapshotEncryptedResults(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	reader, err := decodeResults(snapshot)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, nil
+}
+
+/*
+snapshotEncryptedResults returns the reader of the encrypted file as it's been after the last write.
+The trailer is copied while the file sink is locked, since it's overwritten by the next result, and the frames before it don't change.
+*/
+func snapshotEncryptedResults(file *os.File) (io.Reader, error) {
+	resultsFileMu.Lock()
+	defer resultsFileMu.Unlock()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fileInfo.Size()
+	if size < int64(resultsHeaderSize+trailerFrameSize()) {
+		return io.NewSectionReader(file, 0, size), nil
+	}
+	trailer := make([]byte, trailerFrameSize())
+	_, err = file.ReadAt(trailer, size-int64(len(trailer)))
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(io.NewSectionReader(file, 0, size-int64(len(trailer))), bytes.NewReader(trailer)), nil
+}
+
+/*
+LookupResults scans the processed events file for the process results of the event.
+csv rows are returned as a map of the column names to the values. It returns ErrResultNotFound if the event isn't processed.
+*/
+func LookupResults(ctx context.Context, eventID string) ([]map[string]interface{}, error) {
+	_, span := otel.Tracer("Worker.LookupResults.Tracer").Start(ctx, "Worker.LookupResults.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", eventID), attribute.String("output.format", CmdProcessedEventFormat))
+
+	file, err := OpenResults()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to open the processed events file")
+		return nil, err
+	}
+	defer file.Close()
+
+	var results []map[string]interface{}
+	switch CmdProcessedEventFormat {
+	case OutputFormatCsv:
+		results, err = lookupCsvResults(file, eventID)
+	case OutputFormatPretty:
+		results, err = lookupPrettyResults(file, eventID)
+	default:
+		results, err = lookupJsonResults(file, eventID)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to read the processed events file")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrResultNotFound
+	}
+	return results, nil
+}
+
+func lookupJsonResults(reader io.Reader, eventID string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var processed struct {
+			Event struct {
+				EventID string `json:"EventID"`
+			} `json:"Event"`
+		}
+		// last line might be partially written by the worker
+		if json.Unmarshal(scanner.Bytes(), &processed) != nil || processed.Event.EventID != eventID {
+			continue
+		}
+		result := make(map[string]interface{})
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+/*
+lookupPrettyResults decodes the elements of the pretty json array one by one, the elements after a malformed one are ignored
+*/
+func lookupPrettyResults(reader io.Reader, eventID string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for decoder.More() {
+		result := make(map[string]interface{})
+		if decoder.Decode(&result) != nil {
+			break
+		}
+		if event, ok := result["Event"].(map[string]interface{}); ok && event["EventID"] == eventID {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func lookupCsvResults(reader io.Reader, eventID string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1 // rows written before registering new event types have less columns
+	var header []string
+	for {
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// header is written again whenever the file is recreated
+		if len(row) > 0 && row[0] == csvCommonColumns[0] {
+			header = row
+			continue
+		}
+		if header == nil || len(row) == 0 || row[0] != eventID {
+			continue
+		}
+		result := make(map[string]interface{}, len(row))
+		for i, value := range row {
+			if i < len(header) && value != "" {
+				result[header[i]] = value
+			}
+		}
+		results = append(results, result)
+	}
+}
+
+// verdicts of verifying the process results
+const (
+	VerdictIntact       = "intact"
+	VerdictTampered     = "tampered"
+	VerdictUnverifiable = "unverifiable"
+)
+
+/*
+ResultVerification compares the digest recorded in a process result with the one recomputed from the event recorded in the same result
+*/
+type ResultVerification struct {
+	ProcessedAt    interface{} `json:"processed_at,omitempty"`
+	Verdict        string      `json:"verdict"`
+	Reason         string      `json:"reason,omitempty"`
+	Algorithm      string      `json:"digest_algorithm,omitempty"`
+	RecordedDigest string      `json:"recorded_digest,omitempty"`
+	ComputedDigest string      `json:"computed_digest,omitempty"`
+	RecordedLength int         `json:"recorded_length,omitempty"`
+	ComputedLength int         `json:"computed_length,omitempty"`
+}
+
+/*
+VerifyResults recomputes the canonical digest of the event for each of its process results and compares it against the recorded digest and length.
+The digest is recomputed by the algorithm recorded in the result, md5 for the results recorded before the algorithm became configurable.
+It returns ErrResultNotFound if the event isn't processed.
+*/
+func VerifyResults(ctx context.Context, eventID string) ([]ResultVerification, error) {
+	ctx, span := otel.Tracer("Worker.VerifyResults.Tracer").Start(ctx, "Worker.VerifyResults.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", eventID))
+
+	results, err := LookupResults(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	verifications := make([]ResultVerification, 0, len(results))
+	for _, result := range results {
+		verification := ResultVerification{ProcessedAt: result["ProcessedAt"], Verdict: VerdictUnverifiable}
+		// csv rows don't keep the batch_id and the types of the digested metadata
+		if CmdProcessedEventFormat == OutputFormatCsv {
+			verification.ProcessedAt = result["processed_at"]
+			verification.Reason = "digest can only be recomputed from the json output format"
+			verifications = append(verifications, verification)
+			continue
+		}
+
+		verification.Algorithm, _ = result["DigestAlgorithm"].(string)
+		verification.RecordedDigest, _ = result["Digest"].(string)
+		if verification.Algorithm == "" {
+			verification.Algorithm = DigestMd5
+			verification.RecordedDigest, _ = result["Md5"].(string)
+		}
+		if !helpers.In(verification.Algorithm, DigestAlgorithms...) {
+			verification.Reason = fmt.Sprintf("unknown digest algorithm %s", verification.Algorithm)
+			verifications = append(verifications, verification)
+			continue
+		}
+		if length, ok := result["Length"].(float64); ok {
+			verification.RecordedLength = int(length)
+		}
+		event, err := eventFromResult(result)
+		if err != nil {
+			verification.Reason = err.Error()
+			verifications = append(verifications, verification)
+			continue
+		}
+		verification.ComputedDigest, verification.ComputedLength, err = digestEvent(ctx, event, verification.Algorithm)
+		if err != nil {
+			verification.Reason = err.Error()
+			verifications = append(verifications, verification)
+			continue
+		}
+
+		verification.Verdict = VerdictIntact
+		if verification.ComputedDigest != verification.RecordedDigest || verification.ComputedLength != verification.RecordedLength {
+			verification.Verdict = VerdictTampered
+		}
+		verifications = append(verifications, verification)
+	}
+	return verifications, nil
+}
+
+/*
+eventFromResult reconstructs the event out of a json process result. Type specific fields of the events are serialized with the
+same go field names as data.EventFields, which are mapped to their json names to restore the event from its snapshot.
+*/
+func eventFromResult(result map[string]interface{}) (data.Event, error) {
+	recorded, ok := result["Event"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("process result doesn't contain the event")
+	}
+
+	fields := make(map[string]interface{})
+	fieldsType := reflect.TypeOf(data.EventFields{})
+	for i := 0; i < fieldsType.NumField(); i++ {
+		field := fieldsType.Field(i)
+		if value, found := recorded[field.Name]; found {
+			fields[strings.Split(field.Tag.Get("json"), ",")[0]] = value
+		}
+	}
+	jSnapshot, err := json.Marshal(map[string]interface{}{
+		"event_type":      recorded["EventType"],
+		"event_id":        recorded["EventID"],
+		"timestamp":       recorded["Timestamp"],
+		"producer":        recorded["Producer"],
+		"priority":        recorded["Priority"],
+		"schema_version":  recorded["SchemaVersion"],
+		"batch_id":        recorded["BatchID"],
+		"correlation_id":  recorded["CorrelationID"],
+		"parent_event_id": recorded["ParentEventID"],
+		"enqueue_time":    recorded["EnqueueTime"],
+		"fields":          fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &data.EventSnapshot{}
+	err = json.Unmarshal(jSnapshot, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event in the process result: %w", err)
+	}
+	event, err := snapshot.Restore()
+	if err != nil {
+		return nil, err
+	}
+	if threadID, ok := recorded["ThreadID"].(float64); ok {
+		event.SetThreadID(int(threadID))
+	}
+	return event, nil
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+)
+
+var (
+	CmdEventMaxRetries        int
+	CmdEventTypeMaxRetries    map[string]int
+	CmdEventRetryBackoff      time.Duration
+	CmdEventRetryMaxBackoff   time.Duration
+	CmdEventRetryJitterFactor float64
+)
+
+/*
+retryBudget returns the number of the retries of a failed event of the event type, --event-max-retries unless the event type has its own budget
+*/
+func retryBudget(eventType string) int {
+	if retries, found := CmdEventTypeMaxRetries[eventType]; found {
+		return retries
+	}
+	return CmdEventMaxRetries
+}
+
+/*
+retryDelay returns the delay before the retry following the failed attempt. The backoff is doubled after each attempt up to the max backoff
+and randomized by the jitter factor, so the events failed together due to a broken downstream aren't retried all at once when it recovers.
+*/
+func retryDelay(attempt int) time.Duration {
+	backoff := CmdEventRetryBackoff
+	for i := 1; i < attempt && backoff < CmdEventRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, CmdEventRetryMaxBackoff)
+	if CmdEventRetryJitterFactor <= 0 {
+		return backoff
+	}
+	// uniformly spread between (1 - jitter) and (1 + jitter) of the backoff
+	jitter := (rand.Float64()*2 - 1) * CmdEventRetryJitterFactor
+	return time.Duration(float64(backoff) * (1 + jitter))
+}
@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	CmdSinkSQLitePath          string
+	CmdSinkSQLiteTimeout       time.Duration
+	CmdSinkSQLiteRetention     time.Duration
+	CmdSinkSQLitePruneInterval time.Duration
+)
+
+// table of the sqlite sink, the file is dedicated to the results so its name isn't configurable
+const sqliteSinkTable = "event_results"
+
+// times are stored as the fixed width text of utc, so they're ordered by their comparison and understood by the date functions of sqlite
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+/*
+sqliteSinkMigrations are the versioned changes of the schema of the results table, applied in order and recorded as the user_version of the database file.
+New changes are appended as the next versions, the applied ones shouldn't be edited.
+*/
+var sqliteSinkMigrations = []string{
+	// 1: results are looked up by their event id through the primary key
+	`CREATE TABLE IF NOT EXISTS ` + sqliteSinkTable + ` (
+	event_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	processed_at TEXT NOT NULL,
+	digest_algorithm TEXT NOT NULL,
+	digest TEXT NOT NULL,
+	result TEXT NOT NULL,
+	retain_until TEXT,
+	legal_hold INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (event_id, processed_at)
+)`,
+	// 2: results are queried and pruned by their processing time
+	`CREATE INDEX IF NOT EXISTS ` + sqliteSinkTable + `_processed_at_idx ON ` + sqliteSinkTable + ` (processed_at)`,
+	// 3: results pruned by the retention of their events, the ones on legal hold are never pruned
+	`CREATE INDEX IF NOT EXISTS ` + sqliteSinkTable + `_retain_until_idx ON ` + sqliteSinkTable + ` (retain_until) WHERE NOT legal_hold`,
+}
+
+/*
+sqliteSink inserts the process results as the rows of the embedded sqlite database file, so the small deployments keep a queryable history of the results
+without running a database server. The schema is migrated to the latest version on the first write. Rows are keyed by the event id and the processing time
+like the database sink, and the whole result is kept as json text for the json functions of sqlite.
+Sqlite has a single writer, so the results are inserted one at a time over a single connection and the file is journaled by wal for the concurrent readers.
+*/
+type sqliteSink struct {
+	db      *sql.DB
+	openErr error // error of opening the database, returned by the writes
+
+	migrateMu sync.Mutex
+	migrated  bool
+}
+
+func newSQLiteSink(logger *zerolog.Logger) *sqliteSink {
+	ss := &sqliteSink{}
+	// the migrations lock the file on begin, so the processes sharing it don't apply them twice
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate",
+		CmdSinkSQLitePath, CmdSinkSQLiteTimeout.Milliseconds())
+	ss.db, ss.openErr = sql.Open("sqlite", dsn)
+	if ss.openErr != nil {
+		logger.Error().Err(ss.openErr).Msg("failed to open the database file of the sqlite sink")
+		return ss
+	}
+	ss.db.SetMaxOpenConns(1)
+	if CmdSinkSQLitePruneInterval > 0 {
+		go ss.pruneLoop(logger)
+	}
+	return ss
+}
+
+func (ss *sqliteSink) Name() string { return SinkSQLite }
+
+/*
+migrate applies the migrations of the database file which aren't applied yet once, failures are retried by the next write
+*/
+func (ss *sqliteSink) migrate(ctx context.Context) error {
+	ss.migrateMu.Lock()
+	defer ss.migrateMu.Unlock()
+	if ss.migrated {
+		return nil
+	}
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to migrate the database file %s: %w", CmdSinkSQLitePath, err)
+	}
+	defer tx.Rollback()
+	var version int
+	err = tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version of the database file %s: %w", CmdSinkSQLitePath, err)
+	}
+	for ; version < len(sqliteSinkMigrations); version++ {
+		_, err = tx.ExecContext(ctx, sqliteSinkMigrations[version])
+		if err == nil {
+			// pragmas don't take the parameters
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate the database file %s to the schema version %d: %w", CmdSinkSQLitePath, version+1, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit the migrations of the database file %s: %w", CmdSinkSQLitePath, err)
+	}
+	ss.migrated = true
+	return nil
+}
+
+func (ss *sqliteSink) Write(ctx context.Context, result *data.EventProcessResult) error {
+	if ss.openErr != nil {
+		return ss.openErr
+	}
+	ctx, span := otel.Tracer("Worker.SQLiteSink.Write.Tracer").Start(ctx, "Worker.SQLiteSink.Write.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", result.Event.GetEventID()))
+	ctx, cancel := context.WithTimeout(ctx, CmdSinkSQLiteTimeout)
+	defer cancel()
+
+	jResult, err := helpers.MarshalJson(ctx, result)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEventSerialization, err)
+	}
+	err = ss.migrate(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to migrate the database file")
+		return err
+	}
+	// results without the retention of their event are pruned by sink-sqlite-retention
+	var retainUntil any
+	if retention := result.Event.GetRetention(); retention > 0 {
+		retainUntil = result.ProcessedAt.Add(retention).UTC().Format(sqliteTimeLayout)
+	}
+	_, err = ss.db.ExecContext(ctx, `INSERT INTO `+sqliteSinkTable+` (event_id, event_type, processed_at, digest_algorithm, digest, result, retain_until, legal_hold)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (event_id, processed_at) DO NOTHING`, result.Event.GetEventID(), result.Event.GetEventType(),
+		result.ProcessedAt.UTC().Format(sqliteTimeLayout), result.DigestAlgorithm, result.Digest, string(jResult), retainUntil, result.Event.GetLegalHold())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to insert the result")
+		return fmt.Errorf("failed to insert the result into the database file %s: %w", CmdSinkSQLitePath, err)
+	}
+	recordWrittenBytes(result.Event, len(jResult))
+	return nil
+}
+
+/*
+Flush returns right away, each result is committed by its write
+*/
+func (ss *sqliteSink) Flush(ctx context.Context) error { return nil }
+
+/*
+pruneLoop deletes the results out of their retention every sink-sqlite-prune-interval
+*/
+func (ss *sqliteSink) pruneLoop(logger *zerolog.Logger) {
+	ticker := time.NewTicker(CmdSinkSQLitePruneInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		pruned, err := ss.prune(context.Background(), now)
+		if err != nil {
+			logger.Error().Err(err).Str("path", CmdSinkSQLitePath).Msg("failed to prune the results of the sqlite sink, retrying by the next interval")
+			continue
+		}
+		if pruned > 0 {
+			logger.Info().Int64("results", pruned).Str("path", CmdSinkSQLitePath).Msg("pruned the results out of their retention")
+		}
+	}
+}
+
+/*
+prune deletes the results whose retention of their event has elapsed and, if sink-sqlite-retention is set, the ones without it processed before the retention.
+Results of the events on legal hold are kept regardless of both.
+*/
+func (ss *sqliteSink) prune(ctx context.Context, now time.Time) (int64, error) {
+	ctx, span := otel.Tracer("Worker.SQLiteSink.Prune.Tracer").Start(ctx, "Worker.SQLiteSink.Prune.Span")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, CmdSinkSQLiteTimeout)
+	defer cancel()
+
+	err := ss.migrate(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to migrate the database file")
+		return 0, err
+	}
+	expired := `retain_until < ?`
+	args := []any{now.UTC().Format(sqliteTimeLayout)}
+	if CmdSinkSQLiteRetention > 0 {
+		expired += ` OR (retain_until IS NULL AND processed_at < ?)`
+		args = append(args, now.Add(-CmdSinkSQLiteRetention).UTC().Format(sqliteTimeLayout))
+	}
+	res, err := ss.db.ExecContext(ctx, `DELETE FROM `+sqliteSinkTable+` WHERE NOT legal_hold AND (`+expired+`)`, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to prune the results")
+		return 0, fmt.Errorf("failed to prune the results of the database file %s: %w", CmdSinkSQLitePath, err)
+	}
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("db.pruned", pruned))
+	return pruned, nil
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+)
+
+/*
+statsCollector aggregates the processing outcomes and durations of the events per event type, to be reported by the stats endpoints
+*/
+type statsCollector struct {
+	mu     sync.Mutex
+	byType map[string]*EventTypeStats
+}
+
+/*
+EventTypeStats is the processing summary of an event type
+*/
+type EventTypeStats struct {
+	Processed            map[string]int64 `json:"processed"` // number of events by their process status
+	AvgProcessingSeconds float64          `json:"avg_processing_seconds"`
+	processingTime       time.Duration
+	succeeded            int64 // number of events with a measured processing duration
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{byType: make(map[string]*EventTypeStats)}
+}
+
+func (c *statsCollector) eventType(eventType string) *EventTypeStats {
+	stats, found := c.byType[eventType]
+	if !found {
+		stats = &EventTypeStats{Processed: map[string]int64{
+			data.EventProcessStatusSuccess:   0,
+			data.EventProcessStatusFailed:    0,
+			data.EventProcessStatusSkipped:   0,
+			data.EventProcessStatusCancelled: 0,
+		}}
+		c.byType[eventType] = stats
+	}
+	return stats
+}
+
+/*
+recordStatus counts the process status of the event
+*/
+func (c *statsCollector) recordStatus(eventType string, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eventType(eventType).Processed[status]++
+}
+
+/*
+recordDuration adds the processing duration of a successfully processed event
+*/
+func (c *statsCollector) recordDuration(eventType string, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.eventType(eventType)
+	stats.processingTime += duration
+	stats.succeeded++
+}
+
+/*
+snapshot returns the totals along with a copy of the per event type stats
+*/
+func (c *statsCollector) snapshot() (map[string]int64, float64, map[string]EventTypeStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	totals := map[string]int64{
+		data.EventProcessStatusSuccess:   0,
+		data.EventProcessStatusFailed:    0,
+		data.EventProcessStatusSkipped:   0,
+		data.EventProcessStatusCancelled: 0,
+	}
+	var processingTime time.Duration
+	var succeeded int64
+	byType := make(map[string]EventTypeStats, len(c.byType))
+	for eventType, stats := range c.byType {
+		processed := make(map[string]int64, len(stats.Processed))
+		for status, count := range stats.Processed {
+			processed[status] = count
+			totals[status] += count
+		}
+		processingTime += stats.processingTime
+		succeeded += stats.succeeded
+		byType[eventType] = EventTypeStats{Processed: processed, AvgProcessingSeconds: average(stats.processingTime, stats.succeeded)}
+	}
+	return totals, average(processingTime, succeeded), byType
+}
+
+func average(total time.Duration, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return total.Seconds() / float64(count)
+}
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+)
+
+var (
+	CmdWorkerMaxEventsPerSecond float64
+	CmdWorkerThrottleBurst      int
+)
+
+/*
+ThrottleConfig is the limit of the events taken out of the queue by the worker per second, zero rate disables the throttling
+*/
+type ThrottleConfig struct {
+	MaxEventsPerSecond float64 `json:"max_events_per_second"`
+	Burst              int     `json:"burst"` // number of the events which can be taken at once after the worker has been idle
+}
+
+/*
+throttle is a token bucket slowing down the worker deliberately, e.g. while the sink is under pressure.
+Unlike the concurrency limits it caps the events processed per second regardless of how fast they're processed. Its limit can be changed at runtime.
+*/
+type throttle struct {
+	mu      sync.Mutex
+	config  ThrottleConfig
+	tokens  float64
+	last    time.Time
+	changed chan struct{} // closed once the limit is changed, so the waiting worker re-evaluates it
+}
+
+func newThrottle(config ThrottleConfig) *throttle {
+	t := &throttle{changed: make(chan struct{})}
+	t.set(config)
+	return t
+}
+
+/*
+reserve takes a token for the next event and returns zero, otherwise it returns the time until a token is available.
+The worker should wait for that duration or until changed is closed, whichever is first.
+*/
+func (t *throttle) reserve(now time.Time) (retryAfter time.Duration, changed <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.config.MaxEventsPerSecond <= 0 {
+		return 0, nil
+	}
+	t.tokens = min(float64(t.config.Burst), t.tokens+now.Sub(t.last).Seconds()*t.config.MaxEventsPerSecond)
+	t.last = now
+	if t.tokens >= 1 {
+		t.tokens--
+		return 0, nil
+	}
+	retryAfter = time.Duration((1 - t.tokens) / t.config.MaxEventsPerSecond * float64(time.Second))
+	return max(retryAfter, time.Millisecond), t.changed
+}
+
+/*
+set replaces the limit and returns the previous one. The bucket starts full, so the new limit applies from the next burst.
+*/
+func (t *throttle) set(config ThrottleConfig) ThrottleConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous := t.config
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	t.config = config
+	t.tokens, t.last = float64(config.Burst), time.Now()
+	close(t.changed)
+	t.changed = make(chan struct{})
+	observ.PromWorkerMaxEventsPerSecond.WithLabelValues().Set(config.MaxEventsPerSecond)
+	return previous
+}
+
+func (t *throttle) get() ThrottleConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.config
+}
+
+/*
+Throttle returns the current limit of the events taken out of the queue per second
+*/
+func (w *Worker) Throttle() ThrottleConfig {
+	return w.throttle.get()
+}
+
+/*
+SetThrottle changes the limit of the events taken out of the queue per second while the worker is running and returns the previous one
+*/
+func (w *Worker) SetThrottle(config ThrottleConfig) ThrottleConfig {
+	previous := w.throttle.set(config)
+	current := w.throttle.get()
+	w.Logger.Info().
+		Float64("max_events_per_second", current.MaxEventsPerSecond).
+		Int("burst", current.Burst).
+		Msg("worker throttling is changed")
+	return previous
+}
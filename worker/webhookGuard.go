@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	helpers "github.com/cybrarymin/behavox/internal"
+)
+
+var CmdWebhookAllowedHosts []string
+
+var (
+	ErrWebhookAddressNotAllowed = errors.New("webhook url doesn't resolve to a public address")
+	ErrWebhookHostUnresolved    = errors.New("host of the webhook url can't be resolved")
+)
+
+// timeout of resolving the host of a webhook url while it's validated
+const webhookResolveTimeout = 2 * time.Second
+
+// shared address space of the carrier grade nats, not covered by netip.Addr.IsPrivate
+var carrierGradeNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+/*
+CallbackSecret returns the secret signing the callbacks of the events submitted by the producer. It's derived from --callback-secret
+by the subject of the producer, so each producer only gets its own secret and can't forge the callbacks of the others.
+*/
+func CallbackSecret(producer string) string {
+	mac := hmac.New(sha256.New, []byte(CmdCallbackSecret))
+	mac.Write([]byte("callback:"))
+	mac.Write([]byte(producer))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+publicAddress reports whether the address can be the target of the webhooks, which excludes the loopback, private, link-local
+(e.g. the cloud metadata endpoints), carrier grade nat, multicast and unspecified addresses
+*/
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() && !addr.IsUnspecified() && !carrierGradeNAT.Contains(addr)
+}
+
+/*
+webhookHostAllowed reports whether the host is one of the --webhook-allowed-hosts, which are delivered to whatever address they resolve to
+*/
+func webhookHostAllowed(host string) bool {
+	for _, allowed := range CmdWebhookAllowedHosts {
+		if strings.EqualFold(strings.TrimSuffix(host, "."), strings.TrimSuffix(allowed, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+CheckWebhookURL validates the url of a webhook subscription or an event callback, its host should be allowed
+or resolve only to the public addresses so the webhooks can't be used to reach the internal services
+*/
+func CheckWebhookURL(ctx context.Context, webhookURL *url.URL) error {
+	if !helpers.In(webhookURL.Scheme, "http", "https") || webhookURL.Host == "" {
+		return errors.New("should be an absolute http or https url")
+	}
+	host := webhookURL.Hostname()
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWebhookHostUnresolved, err)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookAddressNotAllowed, host, addr)
+		}
+	}
+	return nil
+}
+
+/*
+newWebhookClient returns the client of the webhook deliveries. Addresses are checked again as they're dialed, since the hosts might resolve
+to another address than they did while their urls were validated. Proxies aren't used because they'd dial the addresses on behalf of the client.
+*/
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: CmdWebhookTimeout}
+	guardedDialer := &net.Dialer{
+		Timeout: CmdWebhookTimeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil && webhookHostAllowed(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return guardedDialer.DialContext(ctx, network, address)
+	}
+	return &http.Client{Timeout: CmdWebhookTimeout, Transport: transport}
+}
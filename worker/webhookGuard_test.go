@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowedHosts []string
+		wantErr      error // nil for the allowed urls
+	}{
+		{name: "public address", url: "https://93.184.215.14/hook"},
+		{name: "loopback", url: "http://127.0.0.1:8080/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "localhost", url: "http://localhost/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "ipv6 loopback", url: "http://[::1]/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "private", url: "http://10.1.2.3/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "cloud metadata", url: "http://169.254.169.254/latest/meta-data", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "ipv4 mapped private", url: "http://[::ffff:192.168.1.1]/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "carrier grade nat", url: "http://100.64.0.1/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "unspecified", url: "http://0.0.0.0/hook", wantErr: ErrWebhookAddressNotAllowed},
+		{name: "allowed host", url: "http://127.0.0.1:8080/hook", allowedHosts: []string{"127.0.0.1"}},
+		{name: "unresolved host", url: "http://behavox.invalid/hook", wantErr: ErrWebhookHostUnresolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CmdWebhookAllowedHosts = tt.allowedHosts
+			t.Cleanup(func() { CmdWebhookAllowedHosts = nil })
+			webhookURL, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckWebhookURL(t.Context(), webhookURL)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("CheckWebhookURL() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckWebhookURL() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookClientDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	CmdWebhookTimeout = time.Second
+	t.Cleanup(func() { CmdWebhookTimeout, CmdWebhookAllowedHosts = 0, nil })
+
+	// urls validated while they resolved to a public address are still refused once they're dialed to a private one
+	_, err := newWebhookClient().Post(server.URL, "application/json", nil)
+	if !errors.Is(err, ErrWebhookAddressNotAllowed) {
+		t.Errorf("delivery to the loopback address error = %v, want %v", err, ErrWebhookAddressNotAllowed)
+	}
+	CmdWebhookAllowedHosts = []string{"127.0.0.1"}
+	res, err := newWebhookClient().Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("delivery to the allowed host error = %v", err)
+	}
+	res.Body.Close()
+}
+
+func TestCallbackSecret(t *testing.T) {
+	CmdCallbackSecret = "callback-master-secret"
+	t.Cleanup(func() { CmdCallbackSecret = "" })
+	if CallbackSecret("producer-a") != CallbackSecret("producer-a") {
+		t.Error("callback secret of a producer isn't stable")
+	}
+	if CallbackSecret("producer-a") == CallbackSecret("producer-b") {
+		t.Error("producers share the callback secret")
+	}
+	if CallbackSecret("producer-a") == CmdCallbackSecret {
+		t.Error("callback secret of a producer is the master secret")
+	}
+}
@@ -0,0 +1,384 @@
+package worker
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	observ "github.com/cybrarymin/behavox/api/observability"
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	CmdWebhookTimeout         time.Duration
+	CmdWebhookMaxAttempts     int
+	CmdWebhookRetryBackoff    time.Duration
+	CmdWebhookRetryMaxBackoff time.Duration
+	CmdCallbackSecret         string
+)
+
+const (
+	webhookBufferSize = 1000 // maximum number of the deliveries queued, being sent or waiting for their retry
+	webhookSenders    = 4    // number of the deliveries sent concurrently
+)
+
+// headers of the webhook requests
+const (
+	WebhookSignatureHeader = "X-Behavox-Signature" // t=<unix timestamp>,v1=<hex hmac-sha256 of "<timestamp>.<body>" using the subscription or the producer callback secret>
+	WebhookDeliveryHeader  = "X-Behavox-Delivery"  // id of the delivery, kept the same across the retries so the subscribers can deduplicate them
+	WebhookEventTypeHeader = "X-Behavox-Event-Type"
+)
+
+/*
+WebhookPayload is the body posted to the subscribers and the callback url of the event once processing of the event finishes
+*/
+type WebhookPayload struct {
+	DeliveryID     string                   `json:"delivery_id"`
+	SubscriptionID string                   `json:"subscription_id,omitempty"` // empty for the callbacks
+	Status         string                   `json:"status"`
+	Error          string                   `json:"error,omitempty"`
+	ProcessedAt    time.Time                `json:"processed_at"`
+	Event          data.Event               `json:"event"`
+	Result         *data.EventProcessResult `json:"result,omitempty"` // only set for the callbacks of the succeeded events
+}
+
+/*
+webhookDelivery is a payload posted either to a subscription or to the callback url of the event if the subscription is nil
+*/
+type webhookDelivery struct {
+	subscription *data.Subscription
+	url          string
+	secret       string
+	payload      *WebhookPayload
+	body         []byte        // encoded payload, kept across the attempts
+	attempt      int           // number of the attempts sent
+	backoff      time.Duration // delay before the next retry
+	due          time.Time     // time of the next retry
+}
+
+func (delivery *webhookDelivery) isCallback() bool {
+	return delivery.subscription == nil
+}
+
+/*
+errWebhookRejected is a delivery rejected by the subscriber which isn't going to succeed by retrying it
+*/
+type errWebhookRejected struct {
+	status int
+}
+
+func (e *errWebhookRejected) Error() string {
+	return fmt.Sprintf("subscriber rejected the delivery with status %d", e.status)
+}
+
+/*
+retryHeap implements heap.Interface ordering the deliveries waiting for their retry by their due time
+*/
+type retryHeap []*webhookDelivery
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)        { *h = append(*h, x.(*webhookDelivery)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	delivery := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return delivery
+}
+
+/*
+webhookDispatcher posts the signed payloads of the processed events to their subscribers in the background,
+retrying the failed deliveries with an exponential backoff. Failed deliveries wait for their retry in the retry heap instead of holding a sender,
+a timer hands them back to the senders once they're due. Deliveries are dropped when the buffer is full.
+*/
+type webhookDispatcher struct {
+	subscriptions *data.SubscriptionStore
+	client        *http.Client
+	logger        *zerolog.Logger
+	deliveries    chan *webhookDelivery
+	retries       chan *webhookDelivery // retries which are due
+	pending       atomic.Int64          // number of deliveries queued and not finished yet
+
+	retryMu    sync.Mutex
+	waiting    retryHeap   // deliveries waiting for their retry
+	retryTimer *time.Timer // fires when the earliest retry is due
+	retryNext  time.Time   // due time the timer is armed for, zero if no retry is waiting
+}
+
+func newWebhookDispatcher(subscriptions *data.SubscriptionStore, logger *zerolog.Logger) *webhookDispatcher {
+	dispatcher := &webhookDispatcher{
+		subscriptions: subscriptions,
+		client:        newWebhookClient(),
+		logger:        logger,
+		deliveries:    make(chan *webhookDelivery, webhookBufferSize),
+		retries:       make(chan *webhookDelivery, webhookBufferSize),
+	}
+	for range webhookSenders {
+		go dispatcher.run()
+	}
+	return dispatcher
+}
+
+/*
+run sends the due retries and the new deliveries
+*/
+func (wd *webhookDispatcher) run() {
+	for {
+		var delivery *webhookDelivery
+		select {
+		case delivery = <-wd.retries:
+		case delivery = <-wd.deliveries:
+		}
+		err := wd.deliver(delivery)
+		if err == nil || !wd.retry(delivery, err) {
+			wd.finish(delivery, err)
+		}
+	}
+}
+
+/*
+finish records the outcome of the delivery once it's delivered, rejected or out of attempts
+*/
+func (wd *webhookDispatcher) finish(delivery *webhookDelivery, err error) {
+	deliveries := observ.PromWebhookDeliveries
+	if delivery.isCallback() {
+		deliveries = observ.PromCallbackDeliveries
+	}
+	if err != nil {
+		wd.logger.Warn().Err(err).
+			Str("subscription_id", delivery.payload.SubscriptionID).
+			Str("delivery_id", delivery.payload.DeliveryID).
+			Str("event_id", delivery.payload.Event.GetEventID()).
+			Bool("callback", delivery.isCallback()).
+			Msg("failed to deliver the webhook")
+		deliveries.WithLabelValues("failed").Inc()
+	} else {
+		deliveries.WithLabelValues("delivered").Inc()
+	}
+	if !delivery.isCallback() {
+		wd.subscriptions.RecordDelivery(delivery.subscription.ID, err)
+	}
+	wd.pending.Add(-1)
+}
+
+/*
+deliver sends the next attempt of the delivery
+*/
+func (wd *webhookDispatcher) deliver(delivery *webhookDelivery) error {
+	if delivery.body == nil {
+		body, err := json.Marshal(delivery.payload)
+		if err != nil {
+			return err
+		}
+		delivery.body, delivery.backoff = body, CmdWebhookRetryBackoff
+	}
+	delivery.attempt++
+	return wd.send(delivery, delivery.body, delivery.attempt)
+}
+
+/*
+retry schedules the next attempt of the failed delivery after its backoff, it reports false if the delivery shouldn't be retried.
+Only the network errors, 429 and 5xx responses are retried up to the maximum attempts.
+*/
+func (wd *webhookDispatcher) retry(delivery *webhookDelivery, err error) bool {
+	var rejected *errWebhookRejected
+	if delivery.body == nil || errors.As(err, &rejected) || delivery.attempt >= CmdWebhookMaxAttempts {
+		return false
+	}
+	if delivery.isCallback() {
+		observ.PromCallbackRetries.Inc()
+	} else {
+		observ.PromWebhookRetries.Inc()
+	}
+	// jitter spreads the retries of the deliveries failed together
+	delivery.due = time.Now().Add(delivery.backoff + time.Duration(rand.Int63n(int64(delivery.backoff)/2+1)))
+	delivery.backoff = min(delivery.backoff*2, CmdWebhookRetryMaxBackoff)
+
+	wd.retryMu.Lock()
+	defer wd.retryMu.Unlock()
+	heap.Push(&wd.waiting, delivery)
+	if wd.retryNext.IsZero() || delivery.due.Before(wd.retryNext) {
+		wd.armRetryTimer(delivery.due)
+	}
+	return true
+}
+
+/*
+armRetryTimer arms the timer to fire at the due time. wd.retryMu should be held by the caller.
+*/
+func (wd *webhookDispatcher) armRetryTimer(due time.Time) {
+	wd.retryNext = due
+	if wd.retryTimer == nil {
+		wd.retryTimer = time.AfterFunc(time.Until(due), wd.releaseRetries)
+		return
+	}
+	wd.retryTimer.Reset(time.Until(due))
+}
+
+/*
+releaseRetries hands the due retries to the senders and rearms the timer for the earliest retry left.
+The retries channel can't be full since it's as large as the buffer of the deliveries.
+*/
+func (wd *webhookDispatcher) releaseRetries() {
+	wd.retryMu.Lock()
+	now := time.Now()
+	var due []*webhookDelivery
+	for wd.waiting.Len() > 0 && !wd.waiting[0].due.After(now) {
+		due = append(due, heap.Pop(&wd.waiting).(*webhookDelivery))
+	}
+	wd.retryNext = time.Time{}
+	if wd.waiting.Len() > 0 {
+		wd.armRetryTimer(wd.waiting[0].due)
+	}
+	wd.retryMu.Unlock()
+	for _, delivery := range due {
+		wd.retries <- delivery
+	}
+}
+
+func (wd *webhookDispatcher) send(delivery *webhookDelivery, body []byte, attempt int) error {
+	ctx, span := otel.Tracer("Worker.WebhookDispatcher.Tracer").Start(context.Background(), "Worker.WebhookDispatcher.Span")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("subscription.id", delivery.payload.SubscriptionID),
+		attribute.String("webhook.delivery_id", delivery.payload.DeliveryID),
+		attribute.Int("webhook.attempt", attempt),
+		attribute.Bool("webhook.callback", delivery.isCallback()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, "t="+timestamp+",v1="+SignWebhook(delivery.secret, timestamp, body))
+	req.Header.Set(WebhookDeliveryHeader, delivery.payload.DeliveryID)
+	req.Header.Set(WebhookEventTypeHeader, delivery.payload.Event.GetEventType())
+
+	start := time.Now()
+	res, err := wd.client.Do(req)
+	observ.PromWebhookDeliveryDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to send the webhook")
+		return err
+	}
+	defer res.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+		err = fmt.Errorf("subscriber responded with status %d", res.StatusCode)
+	case res.StatusCode >= http.StatusBadRequest:
+		err = &errWebhookRejected{status: res.StatusCode}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "subscriber didn't accept the webhook")
+		return err
+	}
+	return nil
+}
+
+/*
+SignWebhook returns the hex encoded hmac-sha256 signature of the timestamp and body of a webhook, used by the subscribers to verify the payload
+*/
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+dispatch queues a delivery of the processed event per each matching subscription, along with its callback if the producer asked for it.
+Callbacks are signed by the secret of the producer of the event.
+*/
+func (wd *webhookDispatcher) dispatch(processed *data.ProcessedEvent) {
+	subscriptions := wd.subscriptions.Matching(processed.Event.GetEventType())
+	for _, subscription := range subscriptions {
+		payload := newWebhookPayload(processed)
+		payload.SubscriptionID = subscription.ID
+		wd.enqueue(&webhookDelivery{subscription: subscription, url: subscription.URL, secret: subscription.Secret, payload: payload})
+	}
+	if callbackURL := processed.Event.GetCallbackURL(); callbackURL != "" && CmdCallbackSecret != "" {
+		payload := newWebhookPayload(processed)
+		payload.Result = processed.Result
+		wd.enqueue(&webhookDelivery{url: callbackURL, secret: CallbackSecret(processed.Event.GetProducer()), payload: payload})
+	}
+}
+
+func newWebhookPayload(processed *data.ProcessedEvent) *WebhookPayload {
+	payload := &WebhookPayload{
+		DeliveryID:  uuid.New().String(),
+		Status:      processed.Status,
+		ProcessedAt: time.Now().UTC(),
+		Event:       processed.Event,
+	}
+	if processed.Err != nil {
+		payload.Error = processed.Err.Error()
+	}
+	return payload
+}
+
+/*
+enqueue hands the delivery to the senders, it's dropped if the buffer is full
+*/
+func (wd *webhookDispatcher) enqueue(delivery *webhookDelivery) {
+	// the buffer counts the deliveries waiting for their retry too, so an unavailable subscriber doesn't pile them up
+	if wd.pending.Add(1) <= webhookBufferSize {
+		select {
+		case wd.deliveries <- delivery:
+			return
+		default:
+		}
+	}
+	wd.pending.Add(-1)
+	if delivery.isCallback() {
+		observ.PromCallbackDeliveries.WithLabelValues("dropped").Inc()
+	} else {
+		observ.PromWebhookDeliveries.WithLabelValues("dropped").Inc()
+		wd.subscriptions.RecordDelivery(delivery.subscription.ID, errors.New("webhook buffer is full, the delivery is dropped"))
+	}
+	wd.logger.Warn().
+		Str("subscription_id", delivery.payload.SubscriptionID).
+		Str("event_id", delivery.payload.Event.GetEventID()).
+		Bool("callback", delivery.isCallback()).
+		Msg("webhook buffer is full, the delivery is dropped")
+}
+
+/*
+flush waits until all the queued deliveries are finished or the context is done
+*/
+func (wd *webhookDispatcher) flush(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for wd.pending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"container/heap"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	data "github.com/cybrarymin/behavox/internal/models"
+	"github.com/rs/zerolog"
+)
+
+func testCallbackDelivery(url string) *webhookDelivery {
+	return &webhookDelivery{url: url, secret: "secret", payload: &WebhookPayload{DeliveryID: "delivery", Event: data.NewEventLog("event", "info", "message")}}
+}
+
+func TestWebhookRetriesDontHoldTheSenders(t *testing.T) {
+	CmdWebhookTimeout, CmdWebhookMaxAttempts, CmdWebhookRetryBackoff, CmdWebhookRetryMaxBackoff = time.Second, 3, 200*time.Millisecond, time.Second
+	CmdWebhookAllowedHosts = []string{"127.0.0.1"}
+	t.Cleanup(func() {
+		CmdWebhookTimeout, CmdWebhookMaxAttempts, CmdWebhookRetryBackoff, CmdWebhookRetryMaxBackoff = 0, 0, 0, 0
+		CmdWebhookAllowedHosts = nil
+	})
+
+	var failedAttempts atomic.Int64
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedAttempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	var delivered sync.WaitGroup
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { delivered.Done() }))
+	defer healthy.Close()
+
+	logger := zerolog.Nop()
+	dispatcher := newWebhookDispatcher(nil, &logger)
+	// more failing deliveries than the senders, which used to block the healthy subscriber until their retries finished
+	for i := 0; i < webhookSenders*2; i++ {
+		dispatcher.enqueue(testCallbackDelivery(failing.URL))
+	}
+	delivered.Add(1)
+	start := time.Now()
+	dispatcher.enqueue(testCallbackDelivery(healthy.URL))
+	delivered.Wait()
+	if elapsed := time.Since(start); elapsed >= CmdWebhookRetryBackoff {
+		t.Errorf("healthy delivery took %s, it waited for the retries of the failing ones", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	err := dispatcher.flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := failedAttempts.Load(), int64(webhookSenders*2*CmdWebhookMaxAttempts); got != want {
+		t.Errorf("attempts of the failing deliveries = %d, want %d", got, want)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	CmdWebhookMaxAttempts, CmdWebhookRetryBackoff, CmdWebhookRetryMaxBackoff = 3, time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { CmdWebhookMaxAttempts, CmdWebhookRetryBackoff, CmdWebhookRetryMaxBackoff = 0, 0, 0 })
+	tests := []struct {
+		name      string
+		err       error
+		attempt   int
+		wantRetry bool
+	}{
+		{name: "network error", err: context.DeadlineExceeded, attempt: 1, wantRetry: true},
+		{name: "rejected by the subscriber", err: &errWebhookRejected{status: http.StatusBadRequest}, attempt: 1, wantRetry: false},
+		{name: "out of attempts", err: context.DeadlineExceeded, attempt: 3, wantRetry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wd := &webhookDispatcher{retries: make(chan *webhookDelivery, 1)}
+			delivery := testCallbackDelivery("http://127.0.0.1")
+			delivery.body, delivery.attempt, delivery.backoff = []byte("{}"), tt.attempt, CmdWebhookRetryBackoff
+			if got := wd.retry(delivery, tt.err); got != tt.wantRetry {
+				t.Fatalf("retry() = %v, want %v", got, tt.wantRetry)
+			}
+			if !tt.wantRetry {
+				return
+			}
+			if delivery.backoff != 2*CmdWebhookRetryBackoff {
+				t.Errorf("backoff = %s, want %s", delivery.backoff, 2*CmdWebhookRetryBackoff)
+			}
+			select {
+			case released := <-wd.retries:
+				if released != delivery || time.Now().Before(delivery.due) {
+					t.Error("retry is released before it's due")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("retry isn't released once it's due")
+			}
+		})
+	}
+}
+
+func TestRetryHeapOrder(t *testing.T) {
+	wd := &webhookDispatcher{retries: make(chan *webhookDelivery, 3)}
+	now := time.Now()
+	for _, offset := range []time.Duration{3, 1, 2} {
+		delivery := testCallbackDelivery("http://127.0.0.1")
+		delivery.due = now.Add(-offset * time.Millisecond)
+		heap.Push(&wd.waiting, delivery)
+	}
+	wd.releaseRetries()
+	var previous time.Time
+	for range 3 {
+		delivery := <-wd.retries
+		if delivery.due.Before(previous) {
+			t.Errorf("retry due at %s is released after the one due at %s", delivery.due, previous)
+		}
+		previous = delivery.due
+	}
+}
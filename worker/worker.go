@@ -2,12 +2,11 @@ package worker
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/rand"
-	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	observ "github.com/cybrarymin/behavox/api/observability"
@@ -22,120 +21,373 @@ import (
 var (
 	CmdProcessedEventFile  string
 	CmdmaxWorkerGoroutines int
+	CmdWorkerStartPaused   bool
+	CmdTraceMetricServices []string
+)
+
+// service label of the trace span duration metric for the services not in --trace-metric-services
+const traceMetricOtherService = "other"
+
+var (
+	ErrEventSerialization = errors.New("failed to serialize the event")
+	ErrEventPersist       = errors.New("failed to persist the event processing information")
+	ErrEventDecompression = errors.New("failed to decompress the event")
+	ErrEventConsumer      = errors.New("consumer failed to process the event")
+	ErrEventRequeue       = errors.New("failed to put the event back into the queue")
+	ErrEventValidation    = errors.New("event is invalid")
+)
+
+// failure reasons of the dead lettered events
+const (
+	FailureReasonSerialization = "serialization_error"
+	FailureReasonPersist       = "persist_error"
+	FailureReasonDecompression = "decompression_error"
+	FailureReasonConsumer      = "consumer_error"
+	FailureReasonRequeue       = "requeue_error"
+	FailureReasonValidation    = "validation_error"
+	FailureReasonPanic         = "processor_panic"
+	FailureReasonTimeout       = "processing_timeout"
+	FailureReasonCrash         = "process_crash"
+	FailureReasonUnknown       = "unknown"
 )
 
 type Worker struct {
-	wg         sync.WaitGroup
-	Logger     *zerolog.Logger
-	EventQueue *data.EventQueue
-	Ctx        context.Context
-	Cancel     context.CancelFunc
-	fileLock   sync.Mutex
+	wg              sync.WaitGroup
+	Logger          *zerolog.Logger
+	EventQueue      *data.EventQueue
+	DeadLetterQueue *data.DeadLetterQueue
+	Ctx             context.Context
+	Cancel          context.CancelFunc
+	inFlight        atomic.Int64 // number of events currently being processed
+	inFlightEvents  *inFlightTracker
+	stats           *statsCollector
+	running         atomic.Bool
+	lineage         *lineageEmitter // nil if the OpenLineage export is disabled
+	webhooks        *webhookDispatcher
+	pauseMu         sync.Mutex
+	resumed         chan struct{} // closed when the worker is resumed, nil if the worker isn't paused
+	pausedAt        time.Time
+	pauseSignal     chan struct{} // wakes up the run loop to stop taking the events once the worker is paused
+	stages          []stage       // processing pipeline of the events
+	concurrency     *concurrencyLimiter
+	pool            *workerPool       // worker threads processing the events
+	sinks           []Sink            // destinations of the process results
+	checkpoint      *checkpointStore  // nil if the checkpointing is disabled
+	breaker         *circuitBreaker   // nil if the circuit breaker is disabled
+	idempotency     *idempotencyStore // nil if the idempotency checks are disabled
+	poison          *poisonDetector   // nil if the poison detection is disabled
+	throttle        *throttle
 }
 
-func NewWorker(logger *zerolog.Logger, eq *data.EventQueue, ctx context.Context) *Worker {
+func NewWorker(logger *zerolog.Logger, eq *data.EventQueue, dlq *data.DeadLetterQueue, subscriptions *data.SubscriptionStore, ctx context.Context) *Worker {
 	ctx, cancel := context.WithCancel(ctx)
-	return &Worker{
-		Logger:     logger,
-		EventQueue: eq,
-		Cancel:     cancel,
-		Ctx:        ctx,
+	nWorker := &Worker{
+		Logger:          logger,
+		EventQueue:      eq,
+		DeadLetterQueue: dlq,
+		Cancel:          cancel,
+		Ctx:             ctx,
+		lineage:         newLineageEmitter(CmdOpenLineageURL, logger),
+		webhooks:        newWebhookDispatcher(subscriptions, logger),
+		stats:           newStatsCollector(),
+		inFlightEvents:  newInFlightTracker(),
+		pauseSignal:     make(chan struct{}, 1),
+		concurrency:     newConcurrencyLimiter(CmdEventTypeConcurrency),
+		throttle:        newThrottle(ThrottleConfig{MaxEventsPerSecond: CmdWorkerMaxEventsPerSecond, Burst: CmdWorkerThrottleBurst}),
+	}
+	nWorker.stages = nWorker.newPipeline(CmdProcessorStages)
+	nWorker.sinks = newSinks(CmdSinks, logger)
+	nWorker.checkpoint = newCheckpointStore(CmdWorkerCheckpointFile)
+	nWorker.breaker = newCircuitBreaker(logger)
+	nWorker.idempotency = newIdempotencyStore(CmdWorkerIdempotencySize, CmdWorkerIdempotencyFile)
+	nWorker.poison = newPoisonDetector(CmdEventPoisonThreshold)
+	poolSize := CmdmaxWorkerGoroutines
+	if CmdWorkerAutoscale {
+		poolSize = CmdMinWorkerGoroutines
 	}
+	nWorker.pool = newWorkerPool(poolSize)
+	return nWorker
 }
 
 func (w *Worker) Run(ctx context.Context) {
-	w.Logger.Info().Msgf("starting the worker process in the background with %d number of threads for processing", CmdmaxWorkerGoroutines)
+	poolSize, _ := w.pool.state()
+	w.Logger.Info().Msgf("starting the worker process in the background with %d number of threads for processing", poolSize)
 
 	runCtx := w.Ctx
 	w.wg.Add(1)
 	defer w.wg.Done()
+	w.running.Store(true)
+	defer w.running.Store(false)
 
-	// make a semaphore pattern to impede having lot's of goroutines
-	semaphore := make(chan struct{}, CmdmaxWorkerGoroutines)
+	// events leased by the external consumers are put back into the queue once their lease expires
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.expireLeases(runCtx)
+	}()
+
+	if CmdWorkerAutoscale {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.autoscale(runCtx)
+		}()
+	}
+
+	// the ready signal is already taken for an event which couldn't be taken out of the queue when the worker got paused
+	pendingSignal := false
 
 	for {
+		// events stay inside the queue while the worker is paused
+		if resumed := w.resumedChan(); resumed != nil {
+			select {
+			case <-resumed:
+				continue
+			case <-runCtx.Done():
+				w.Logger.Info().Msg("worker run loop exiting due to context cancellation")
+				return
+			}
+		}
+
+		// events stay inside the queue while the circuit breaker is open
+		if admitted, changed, retryAfter := w.breaker.admit(time.Now()); !admitted {
+			var retry <-chan time.Time
+			if retryAfter > 0 {
+				retry = time.After(retryAfter)
+			}
+			select {
+			case <-changed:
+			case <-retry:
+			case <-w.pauseSignal:
+			case <-runCtx.Done():
+				w.Logger.Info().Msg("worker run loop exiting due to context cancellation")
+				return
+			}
+			continue
+		}
+
+		// events stay inside the queue until the throttling allows taking the next one
+		if retryAfter, changed := w.throttle.reserve(time.Now()); retryAfter > 0 {
+			select {
+			case <-time.After(retryAfter):
+			case <-changed:
+			case <-w.pauseSignal:
+			case <-runCtx.Done():
+				w.Logger.Info().Msg("worker run loop exiting due to context cancellation")
+				return
+			}
+			continue
+		}
+
+		ready := w.EventQueue.Ready()
+		if pendingSignal {
+			ready = closedSignal
+		}
 		select {
-		case nEvent := <-w.EventQueue.Events:
+		case <-w.pauseSignal:
+			continue
+		case <-ready:
+			pendingSignal = false
+			// if all the threads of the pool are busy processing the events this will wait until one of them frees up
+			if !w.pool.acquire(runCtx) {
+				w.Logger.Info().Msg("worker run loop exiting due to context cancellation")
+				return
+			}
+
+			// event is taken out of the queue only when a goroutine is free, so the higher priority events arrived meanwhile are picked first.
+			// event might be already removed from the queue by a purge
+			nEvent, interrupted := w.takeEvent(runCtx)
+			if interrupted {
+				w.pool.release(0)
+				if runCtx.Err() != nil {
+					w.Logger.Info().Msg("worker run loop exiting due to context cancellation")
+					return
+				}
+				pendingSignal = true
+				continue
+			}
+			if nEvent == nil {
+				w.pool.release(0)
+				continue
+			}
+			w.concurrency.acquire(nEvent.GetEventType())
+			// cancelled events are skipped without being processed
+			if w.skipCancelled(nEvent) {
+				w.concurrency.release(nEvent.GetEventType())
+				w.pool.release(0)
+				continue
+			}
+			// events enqueued again after they're processed are skipped, so they aren't written to the sinks twice
+			if w.skipProcessed(nEvent) {
+				w.concurrency.release(nEvent.GetEventType())
+				w.pool.release(0)
+				continue
+			}
+			w.breaker.begin()
+			// events are checkpointed until they're completed, so they can be recovered after a crash
+			err := w.checkpoint.begin(nEvent)
+			if err != nil {
+				w.Logger.Error().Err(err).Str("event_id", nEvent.GetEventID()).Msg("failed to checkpoint the event")
+			}
 			w.wg.Add(1)
-
-			semaphore <- struct{}{} // if the number of goroutines we are running to process each event exceeds 10 this will wait until one goroutine freeUp
-			go func(event data.Event) {
+			go func(queuedEvent data.Event) {
 				defer w.wg.Done()
-				defer func() { <-semaphore }() // read from semaphore
+				// events stopped by the shutdown stay in the checkpoint to be recovered on the next startup
+				stopped := false
+				defer func() {
+					if stopped {
+						err := w.checkpoint.stop(queuedEvent.GetEventID())
+						if err != nil {
+							w.Logger.Error().Err(err).Str("event_id", queuedEvent.GetEventID()).Msg("failed to keep the stopped event in the checkpoint")
+						}
+						return
+					}
+					err := w.checkpoint.complete(queuedEvent.GetEventID())
+					if err != nil {
+						w.Logger.Error().Err(err).Str("event_id", queuedEvent.GetEventID()).Msg("failed to remove the completed event from the checkpoint")
+					}
+				}()
+				defer func() {
+					// latency from enqueueing until the event is processed drives the autoscaling of the pool
+					var latency time.Duration
+					if enqueuedAt := queuedEvent.GetEnqueueTime(); !enqueuedAt.IsZero() {
+						latency = time.Since(enqueuedAt)
+					}
+					w.pool.release(latency)
+				}()
+				defer w.concurrency.release(queuedEvent.GetEventType())
+				defer w.idempotency.release(queuedEvent.GetEventID())
+				w.inFlight.Add(1)
+				defer w.inFlight.Add(-1)
+				w.inFlightEvents.track(queuedEvent)
+				defer w.inFlightEvents.untrack(queuedEvent)
+				w.EventQueue.Index.Processing(queuedEvent)
 
 				spanCtx, span := otel.Tracer("Worker.Tracer").Start(ctx, "Worker.Span")
-				var EventType string
-				switch event.(type) {
-				case *data.EventLog:
-					EventType = "log"
-				case *data.EventMetric:
-					EventType = "metric"
+				span.SetAttributes(
+					attribute.String("event.id", queuedEvent.GetEventID()),
+					attribute.String("event.correlation_id", queuedEvent.GetCorrelationID()),
+					attribute.String("event.parent_event_id", queuedEvent.GetParentEventID()))
+				EventType := queuedEvent.GetEventType()
+
+				// restoring the original event in case it's compressed inside the queue
+				event, err := data.DecompressEvent(queuedEvent)
+				if err != nil {
+					w.Logger.Error().Err(err).Ctx(spanCtx).
+						Str("event_id", queuedEvent.GetEventID()).
+						Msg("event decompression failed")
+					span.RecordError(err)
+					span.SetStatus(codes.Error, "event decompression failed")
+					err = fmt.Errorf("%w: %w", ErrEventDecompression, err)
+					w.breaker.record(err, time.Now())
+					w.recordProcessStatus(queuedEvent, data.EventProcessStatusFailed)
+					observ.PromEventTotalProcessed.WithLabelValues().Inc()
+					w.deadLetter(spanCtx, queuedEvent, err, 1)
+					w.notifyProcessed(&data.ProcessedEvent{Event: queuedEvent, Status: data.EventProcessStatusFailed, Err: err})
+					span.End()
+					return
 				}
 
 				// Measure queue wait time (time from enqueue to processing)
-				var queueWaitTime float64
-				if baseEvent, ok := event.(*data.EventLog); ok && !baseEvent.BaseEvent.EnqueueTime.IsZero() {
-					queueWaitTime = time.Since(baseEvent.BaseEvent.EnqueueTime).Seconds()
-					observ.PromEventQueueWaitTime.WithLabelValues(EventType).Observe(queueWaitTime)
-				} else if baseEvent, ok := event.(*data.EventMetric); ok && !baseEvent.BaseEvent.EnqueueTime.IsZero() {
-					queueWaitTime = time.Since(baseEvent.BaseEvent.EnqueueTime).Seconds()
-					observ.PromEventQueueWaitTime.WithLabelValues(EventType).Observe(queueWaitTime)
+				if !event.GetEnqueueTime().IsZero() {
+					queueWaitTime := time.Since(event.GetEnqueueTime())
+					observ.PromEventQueueWaitTime.WithLabelValues(EventType, strconv.Itoa(event.GetPriority())).Observe(queueWaitTime.Seconds())
+					observ.OTelRecordEventQueueWaitTime(spanCtx, EventType, event.GetPriority(), queueWaitTime)
 				}
 
 				// Capture the start time for event processing duration
 				eventProcessingStart := time.Now()
 
-				w.Logger.Info().
+				w.Logger.Info().Ctx(spanCtx).
 					Str("event_id", event.GetEventID()).
 					Msg("worker started processing the event")
 
-				err := w.processEvent(spanCtx, event)
-				if err != nil {
-					w.Logger.Error().Err(err).
+				result, err := w.processAttempt(spanCtx, event)
+				attempts := 1
+				poisoned := w.poison.crashed(event.GetEventID(), err)
+				// failed events are retried up to the retry budget of their event type, unless they're poison events
+				for retries := retryBudget(EventType); err != nil && !poisoned && attempts <= retries; attempts++ {
+					delay := retryDelay(attempts)
+					w.Logger.Error().Err(err).Ctx(spanCtx).
 						Str("event_id", event.GetEventID()).
+						Int("attempt", attempts).
+						Dur("retry_in", delay).
 						Msg("event processing failed")
 
-					time.Sleep(2 * time.Second) // wait for two second and reprocess the event
-					// Check if context is cancelled before retry
+					// wait for the backoff and reprocess the event unless the worker is shutting down meanwhile
 					select {
 					case <-runCtx.Done():
-						w.Logger.Info().Str("event_id", event.GetEventID()).
+						w.Logger.Info().Ctx(spanCtx).Str("event_id", event.GetEventID()).
 							Msg("skipping processing due to shutdown")
-						observ.PromEventTotalProcessStatus.WithLabelValues("skipped", EventType).Inc()
+						w.recordProcessStatus(event, data.EventProcessStatusSkipped)
+						w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusSkipped, Err: err})
+						stopped = true
+						span.End()
 						return
-					default:
-
+					case <-time.After(delay):
 					}
 
 					// Increment retry counter before retrying
 					observ.PromEventRetryCount.WithLabelValues(EventType).Inc()
 
-					err := w.processEvent(spanCtx, event)
-					if err != nil {
-						w.Logger.Error().Err(err).
-							Str("event_id", event.GetEventID()).
-							Msg("event processing failed permanently")
-
-						span.RecordError(err)
-						span.SetStatus(codes.Error, "event processing failed permanently")
-						// Add to the number of failed processed events metrics
-						observ.PromEventTotalProcessStatus.WithLabelValues("failed", EventType).Inc()
-						observ.PromEventTotalProcessed.WithLabelValues().Inc()
-						span.End()
-						return
+					result, err = w.processAttempt(spanCtx, event)
+					poisoned = w.poison.crashed(event.GetEventID(), err)
+				}
+				if err != nil {
+					w.Logger.Error().Err(err).Ctx(spanCtx).
+						Str("event_id", event.GetEventID()).
+						Int("attempts", attempts).
+						Msg("event processing failed permanently")
+
+					span.RecordError(err)
+					span.SetStatus(codes.Error, "event processing failed permanently")
+					// the downstream is broken, so the event waits inside the queue for the circuit breaker to close instead of being dead lettered
+					if w.breaker.record(err, time.Now()) {
+						// the id is released before the event is put back, so the event isn't skipped as being processed once it's taken again
+						w.idempotency.release(event.GetEventID())
+						if w.EventQueue.Restore(spanCtx, []data.Event{event}) == nil {
+							w.Logger.Warn().Ctx(spanCtx).
+								Str("event_id", event.GetEventID()).
+								Msg("event is put back into the queue since the circuit breaker is open")
+							span.End()
+							return
+						}
+					}
+					// Add to the number of failed processed events metrics
+					w.recordProcessStatus(event, data.EventProcessStatusFailed)
+					observ.PromEventTotalProcessed.WithLabelValues().Inc()
+					if poisoned {
+						w.quarantine(spanCtx, event, err, attempts)
+					} else {
+						w.deadLetter(spanCtx, event, err, attempts)
 					}
+					w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusFailed, Err: err})
+					span.End()
+					return
 				}
 
-				w.Logger.Info().
+				w.Logger.Info().Ctx(spanCtx).
 					Str("event_id", event.GetEventID()).
 					Msg("finished processing of the event")
 				// Record the event processing duration
-				processingDuration := time.Since(eventProcessingStart).Seconds()
-				observ.PromEventProcessingDuration.WithLabelValues(EventType).Observe(processingDuration)
+				processingDuration := time.Since(eventProcessingStart)
+				observ.PromEventProcessingDuration.WithLabelValues(EventType).Observe(processingDuration.Seconds())
+				observ.OTelRecordEventProcessingDuration(spanCtx, EventType, processingDuration)
+				w.stats.recordDuration(EventType, processingDuration)
+				if traceEvent, ok := event.(*data.EventTrace); ok {
+					observ.PromTraceEventSpanDuration.WithLabelValues(traceMetricService(traceEvent.Service)).Observe(traceEvent.Duration)
+				}
 
+				w.breaker.record(nil, time.Now())
+				w.poison.forget(event.GetEventID())
+				err = w.idempotency.complete(event.GetEventID())
+				if err != nil {
+					w.Logger.Error().Err(err).Ctx(spanCtx).Str("event_id", event.GetEventID()).Msg("failed to persist the id of the processed event")
+				}
 				// Add to the number of successful processed events metrics
-				observ.PromEventTotalProcessStatus.WithLabelValues("success", EventType).Inc()
+				w.recordProcessStatus(event, data.EventProcessStatusSuccess)
 				observ.PromEventTotalProcessed.WithLabelValues().Inc()
+				w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusSuccess, Result: result})
 				span.End()
 			}(nEvent)
 
@@ -146,6 +398,101 @@ func (w *Worker) Run(ctx context.Context) {
 	}
 }
 
+/*
+traceMetricService returns the service label of the span duration of a trace event. services are reported by the producers,
+so only the --trace-metric-services get their own series and the rest are counted as other to keep the cardinality of the metric bounded
+*/
+func traceMetricService(service string) string {
+	if helpers.In(service, CmdTraceMetricServices...) {
+		return service
+	}
+	return traceMetricOtherService
+}
+
+// closedSignal is received immediately, it replaces the ready channel when the signal of the next event is already taken
+var closedSignal = func() chan struct{} {
+	signal := make(chan struct{})
+	close(signal)
+	return signal
+}()
+
+/*
+takeEvent takes the event with the highest priority out of the queue whose event type has a free slot in its concurrency budget,
+so the events of the saturated event types don't block the others. It waits while the queue only has the events of the saturated event types
+and returns nil if the queue is empty. interrupted is reported if the worker is paused or shut down meanwhile.
+*/
+func (w *Worker) takeEvent(ctx context.Context) (event data.Event, interrupted bool) {
+	for {
+		// taking the channel before the event, so the events added right after an empty GetEventFunc wake the worker up
+		pushed := w.EventQueue.Pushed()
+		event = w.EventQueue.GetEventFunc(ctx, func(event data.Event) bool {
+			return w.concurrency.available(event.GetEventType())
+		})
+		if event != nil || w.EventQueue.Size(ctx) == 0 {
+			return event, false
+		}
+		select {
+		case <-w.concurrency.released:
+		case <-pushed:
+		case <-w.pauseSignal:
+			return nil, true
+		case <-ctx.Done():
+			return nil, true
+		}
+	}
+}
+
+/*
+Pause stops the worker from taking new events out of the queue while the queue keeps accepting them, e.g. during the maintenance of the downstream.
+Events already taken out of the queue are still processed. It reports whether the worker wasn't already paused.
+*/
+func (w *Worker) Pause() bool {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	if w.resumed != nil {
+		return false
+	}
+	w.resumed = make(chan struct{})
+	w.pausedAt = time.Now()
+	select {
+	case w.pauseSignal <- struct{}{}:
+	default:
+	}
+	w.Logger.Info().Msg("worker is paused")
+	return true
+}
+
+/*
+Resume lets the paused worker take the events out of the queue again. It reports whether the worker was paused.
+*/
+func (w *Worker) Resume() bool {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	if w.resumed == nil {
+		return false
+	}
+	close(w.resumed)
+	w.resumed = nil
+	w.pausedAt = time.Time{}
+	w.Logger.Info().Msg("worker is resumed")
+	return true
+}
+
+/*
+PausedAt returns the time the worker is paused at, zero if it isn't paused
+*/
+func (w *Worker) PausedAt() time.Time {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	return w.pausedAt
+}
+
+func (w *Worker) resumedChan() chan struct{} {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	return w.resumed
+}
+
 /*
 Shutdown function of the worker to shut it down gracefully
 */
@@ -167,97 +514,226 @@ func (w *Worker) Shutdown(ctx context.Context) error {
 		w.Logger.Warn().Msg("worker graceful shutdown timed out")
 		return ctx.Err()
 	case <-done:
+		err := w.idempotency.close()
+		if err != nil {
+			w.Logger.Error().Err(err).Msg("failed to close the idempotency file")
+		}
+		err = w.checkpoint.close()
+		if err != nil {
+			w.Logger.Error().Err(err).Msg("failed to close the checkpoint file")
+		}
 		w.Logger.Info().Msg("worker shutdown completed successfully")
 		return nil
 	}
 }
 
 /*
-processEvent simulate processing of an event by doing digest calculation
+InFlight returns the number of events the worker is currently processing
 */
-func (w *Worker) processEvent(ctx context.Context, event data.Event) error {
-	ctx, span := otel.Tracer("Worker.ProcessEvent.Tracer").Start(ctx, "Worker.ProcessEvent.Span")
-	defer span.End()
-	span.SetAttributes(attribute.String("event.id", event.GetEventID()))
+func (w *Worker) InFlight() int64 {
+	return w.inFlight.Load()
+}
 
-	startTime := time.Now()
+/*
+WorkerStats reports the current state of the worker and the number of events processed since it's started
+*/
+type WorkerStats struct {
+	Running              bool                      `json:"running"`
+	Paused               bool                      `json:"paused"`
+	PausedAt             *time.Time                `json:"paused_at,omitempty"`
+	MaxThreads           int                       `json:"max_threads"`
+	PoolSize             int                       `json:"pool_size"`                 // number of the threads allowed by the autoscaling, max_threads if it's disabled
+	CircuitBreaker       string                    `json:"circuit_breaker,omitempty"` // state of the circuit breaker if it's enabled
+	Throttle             ThrottleConfig            `json:"throttle"`
+	InFlight             int64                     `json:"in_flight"`
+	InFlightByType       map[string]int            `json:"in_flight_by_type"`
+	ConcurrencyLimits    map[string]int            `json:"concurrency_limits,omitempty"` // concurrency budgets of the event types, the others are only limited by max_threads
+	Utilization          float64                   `json:"utilization_percent"`          // share of the worker threads busy processing the events
+	Processed            map[string]int64          `json:"processed"`
+	AvgProcessingSeconds float64                   `json:"avg_processing_seconds"` // average processing duration of the successfully processed events
+	ByEventType          map[string]EventTypeStats `json:"by_event_type"`
+	OutputFile           string                    `json:"output_file"`
+	Format               string                    `json:"output_format"`
+}
 
-	eMeta := event.GetMetadata()
+/*
+Stats returns the current state of the worker
+*/
+func (w *Worker) Stats() *WorkerStats {
+	processed, avgProcessing, byType := w.stats.snapshot()
+	inFlight := w.inFlight.Load()
+	poolSize, _ := w.pool.state()
+	nStats := &WorkerStats{
+		Running:              w.running.Load(),
+		MaxThreads:           CmdmaxWorkerGoroutines,
+		PoolSize:             poolSize,
+		CircuitBreaker:       w.breaker.currentState(),
+		Throttle:             w.throttle.get(),
+		InFlight:             inFlight,
+		InFlightByType:       w.concurrency.snapshot(),
+		ConcurrencyLimits:    CmdEventTypeConcurrency,
+		Processed:            processed,
+		AvgProcessingSeconds: avgProcessing,
+		ByEventType:          byType,
+		OutputFile:           CmdProcessedEventFile,
+		Format:               CmdProcessedEventFormat,
+	}
+	if pausedAt := w.PausedAt(); !pausedAt.IsZero() {
+		nStats.Paused, nStats.PausedAt = true, &pausedAt
+	}
+	if poolSize > 0 {
+		nStats.Utilization = float64(inFlight) * 100 / float64(poolSize)
+	}
+	return nStats
+}
 
-	// Now serialize the metadata with the updated ThreadID
-	jMeta, err := helpers.MarshalJson(ctx, eMeta)
+/*
+Flush makes sure the process results are persisted by all the sinks and the pending lineage events and webhooks are sent
+*/
+func (w *Worker) Flush(ctx context.Context) error {
+	err := w.lineage.flush(ctx)
+	if err != nil {
+		return err
+	}
+	err = w.webhooks.flush(ctx)
 	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to serialize the event metadata to json format")
 		return err
 	}
 
-	// calculate the hash of the metadata
-	hasher := md5.New()
-	hasher.Write(jMeta)
-	metaHashHex := hex.EncodeToString(hasher.Sum(nil))
-	// calculate the length of the metadata
-	metaLength := len(jMeta)
-
-	// retrive the amount of time spent on calculating hash and length and goroutine id
-	firstPhaseProcessTime := time.Since(startTime)
-
-	// Get goroutine ID and update the event's ThreadID
-	metaGoroutineId := helpers.GetGoroutineID(ctx)
+	for _, sink := range w.sinks {
+		err = sink.Flush(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to flush the %s sink: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
 
-	if logEvent, ok := event.(*data.EventLog); ok {
-		logEvent.BaseEvent.ThreadID = int(metaGoroutineId)
-	} else if metricEvent, ok := event.(*data.EventMetric); ok {
-		metricEvent.BaseEvent.ThreadID = int(metaGoroutineId)
+/*
+notifyProcessed notifies the waiters of the event about its processing outcome and reports the completion of its batch if it's the last event of the batch.
+lineage of the processing run is emitted and the webhook subscribers are notified as well
+*/
+func (w *Worker) notifyProcessed(processed *data.ProcessedEvent) {
+	batch := w.EventQueue.NotifyProcessed(processed)
+	w.emitLineage(processed, batch)
+	w.webhooks.dispatch(processed)
+	if batch == nil {
+		return
 	}
+	result := data.EventProcessStatusSuccess
+	if batch.Succeeded != batch.Total {
+		result = data.EventProcessStatusFailed
+	}
+	observ.PromEventBatchCompleted.WithLabelValues(result).Inc()
+	w.Logger.Info().
+		Str("batch_id", batch.BatchID).
+		Int("total", batch.Total).
+		Int("succeeded", batch.Succeeded).
+		Int("failed", batch.Failed).
+		Int("skipped", batch.Skipped).
+		Dur("duration", batch.CompletedAt.Sub(batch.EnqueuedAt)).
+		Msg("finished processing of the batch")
+}
 
-	// simulate an additional processing time for the metadata
-	randomTime := 0.05 + rand.Float32()*(0.2-0.05)
-	time.Sleep(time.Duration(randomTime))
-
-	metaProcessingTime := randomTime + float32(firstPhaseProcessTime.Seconds())
-
-	// show the process finishing time
-	metaProcessAt := time.Now()
-
-	processResult := struct {
-		Event          data.Event
-		Md5            string
-		Length         int
-		ProcessingTime string
-		ProcessedAt    time.Time
-	}{
-		Event:          event,
-		Md5:            metaHashHex,
-		Length:         metaLength,
-		ProcessingTime: fmt.Sprintf("%.4f", metaProcessingTime),
-		ProcessedAt:    metaProcessAt,
+/*
+skipCancelled reports whether the event taken out of the queue is cancelled, in which case its cancellation is recorded instead of processing it
+*/
+func (w *Worker) skipCancelled(event data.Event) bool {
+	if !w.EventQueue.Cancelled(event) {
+		return false
 	}
+	w.Logger.Info().
+		Str("event_id", event.GetEventID()).
+		Msg("skipping processing of the cancelled event")
+	w.recordProcessStatus(event, data.EventProcessStatusCancelled)
+	w.notifyProcessed(&data.ProcessedEvent{Event: event, Status: data.EventProcessStatusCancelled, Err: data.ErrEventCancelled})
+	return true
+}
 
-	jResult, err := helpers.MarshalJson(ctx, processResult)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to serialize the event metadata to json format")
-		return err
+/*
+recordProcessStatus updates the process status metrics of the event
+*/
+func (w *Worker) recordProcessStatus(event data.Event, status string) {
+	w.stats.recordStatus(event.GetEventType(), status)
+	observ.PromEventTotalProcessStatus.WithLabelValues(status, event.GetEventType()).Inc()
+	observ.OTelRecordEventProcessStatus(context.Background(), event.GetEventType(), status)
+	if auditEvent, ok := event.(*data.EventAudit); ok {
+		observ.PromAuditEventTotalProcessed.WithLabelValues(status, auditEvent.Outcome).Inc()
 	}
+}
 
-	w.fileLock.Lock()
-	defer w.fileLock.Unlock()
+/*
+deadLetter moves the permanently failed event to the dead letter queue
+*/
+func (w *Worker) deadLetter(ctx context.Context, event data.Event, err error, attempts int) {
+	w.putDeadLetter(ctx, event, err, attempts, false)
+}
 
-	file, err := os.OpenFile(CmdProcessedEventFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0660)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, fmt.Sprintf("failed to open the %s to persist event processing info", CmdProcessedEventFile))
-		return err
+func (w *Worker) putDeadLetter(ctx context.Context, event data.Event, err error, attempts int, poison bool) {
+	reason := failureReason(err)
+	dropped := w.DeadLetterQueue.PutDeadLetter(ctx, &data.DeadLetter{
+		Event:    event,
+		Reason:   reason,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+		Poison:   poison,
+	})
+	observ.PromEventDeadLettered.WithLabelValues(reason, event.GetEventType()).Inc()
+	if dropped {
+		w.Logger.Warn().Msg("dead letter queue is full, the oldest dead letter is dropped")
 	}
-	defer file.Close()
+}
 
-	_, err = file.Write(jResult)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, fmt.Sprintf("failed persist the event processing information in %s", CmdProcessedEventFile))
-		return err
+/*
+failureReason classifies the event processing error to a reason with low cardinality to be used on the metrics and dead letter stats
+*/
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrEventSerialization):
+		return FailureReasonSerialization
+	case errors.Is(err, ErrEventPersist):
+		return FailureReasonPersist
+	case errors.Is(err, ErrEventDecompression):
+		return FailureReasonDecompression
+	case errors.Is(err, ErrEventConsumer):
+		return FailureReasonConsumer
+	case errors.Is(err, ErrEventRequeue):
+		return FailureReasonRequeue
+	case errors.Is(err, ErrEventValidation):
+		return FailureReasonValidation
+	case errors.Is(err, ErrEventPanic):
+		return FailureReasonPanic
+	case errors.Is(err, ErrEventTimeout):
+		return FailureReasonTimeout
+	case errors.Is(err, ErrEventCrash):
+		return FailureReasonCrash
+	default:
+		return FailureReasonUnknown
 	}
+}
 
-	return nil
+/*
+processEvent processes the event by passing it through the stages of the pipeline in order
+*/
+func (w *Worker) processEvent(ctx context.Context, event data.Event) (*data.EventProcessResult, error) {
+	ctx, span := otel.Tracer("Worker.ProcessEvent.Tracer").Start(ctx, "Worker.ProcessEvent.Span")
+	defer span.End()
+	span.SetAttributes(attribute.String("event.id", event.GetEventID()), attribute.String("event.correlation_id", event.GetCorrelationID()))
+
+	pe := &pipelineEvent{
+		result:    &data.EventProcessResult{Event: event},
+		startedAt: time.Now(),
+	}
+	w.inFlightEvents.attempt(event.GetEventID(), helpers.GetGoroutineID(ctx))
+	defer w.inFlightEvents.stage(event.GetEventID(), "")
+	for _, s := range w.stages {
+		err := w.runStage(ctx, s, pe)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, fmt.Sprintf("%s stage failed", s.name))
+			return nil, err
+		}
+	}
+	return pe.result, nil
 }
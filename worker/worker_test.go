@@ -0,0 +1,26 @@
+package worker
+
+import "testing"
+
+func TestTraceMetricService(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		service  string
+		want     string
+	}{
+		{name: "listed service", services: []string{"checkout", "payments"}, service: "payments", want: "payments"},
+		{name: "unlisted service", services: []string{"checkout"}, service: "random-1234", want: traceMetricOtherService},
+		{name: "no services listed", service: "checkout", want: traceMetricOtherService},
+		{name: "empty service", services: []string{"checkout"}, service: "", want: traceMetricOtherService},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CmdTraceMetricServices = tt.services
+			t.Cleanup(func() { CmdTraceMetricServices = nil })
+			if got := traceMetricService(tt.service); got != tt.want {
+				t.Errorf("traceMetricService(%q) = %q, want %q", tt.service, got, tt.want)
+			}
+		})
+	}
+}